@@ -0,0 +1,201 @@
+package accounts
+
+import (
+	"context"
+	"log"
+	"nx_trainee_forum/forum/imaging"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/storage"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const pollInterval = time.Minute
+
+// Policies for the posts and comments of deleted accounts.
+const (
+	PolicyAnonymize = "anonymize" //kept, attributed to the anonymous account
+	PolicyRemove    = "remove"    //removed; comments with replies are kept as tombstones
+)
+
+type Config struct {
+	Policy string
+}
+
+// Deleter deletes the accounts whose deletion was requested once their grace
+// period is over. Either way the user row is anonymized rather than removed,
+// so IDs in the audit log and in the remaining content stay valid; the
+// avatar, albums, todos, notifications, mentions and queued emails of the
+// user are removed.
+type Deleter struct {
+	db     *gorm.DB
+	store  storage.Storage
+	images *imaging.Processor
+	cfg    Config
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New(db *gorm.DB, store storage.Storage, images *imaging.Processor, cfg Config) *Deleter {
+	if cfg.Policy != PolicyRemove {
+		cfg.Policy = PolicyAnonymize
+	}
+	d := &Deleter{db: db, store: store, images: images, cfg: cfg, stop: make(chan struct{})}
+	d.wg.Add(1)
+	go d.work()
+	return d
+}
+
+// Policy returns the policy applied to the content of deleted accounts.
+func (d *Deleter) Policy() string {
+	return d.cfg.Policy
+}
+
+// Close stops deleting; due accounts are deleted after the next start.
+func (d *Deleter) Close() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+func (d *Deleter) work() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.DeleteDue(time.Now()); err != nil {
+			log.Printf("accounts: %v", err)
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeleteDue deletes the accounts whose deletion is due at now and returns
+// how many were deleted.
+func (d *Deleter) DeleteDue(now time.Time) (int, error) {
+	upr := models.UserProcess{}
+	uu, result := upr.DueDeletions(d.db, now)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	for i, u := range uu {
+		if err := d.Delete(u); err != nil {
+			return i, err
+		}
+	}
+	return len(uu), nil
+}
+
+// Delete deletes the account u right away.
+func (d *Deleter) Delete(u models.User) error {
+	apr := models.AttachmentProcess{}
+	var hashes []string
+	err := d.db.Transaction(func(tx *gorm.DB) error {
+		if d.cfg.Policy == PolicyRemove {
+			h, err := d.removeContent(tx, u.ID)
+			if err != nil {
+				return err
+			}
+			hashes = h
+		} else {
+			err := tx.Model(&models.Comment{}).Where("userId = ?", u.ID).
+				Updates(map[string]interface{}{"name": "", "email": ""}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, personal := range []interface{}{&models.Album{}, &models.Todo{}, &models.Notification{}, &models.Mention{}, &models.EmailMessage{}} {
+			if err := tx.Where("userId = ?", u.ID).Delete(personal).Error; err != nil {
+				return err
+			}
+		}
+		if u.AvatarID != nil {
+			if a, result := apr.GetAttachment(tx, map[string]interface{}{"id": *u.AvatarID}); result.Error == nil {
+				if err := apr.DeleteAttachment(tx, &a).Error; err != nil {
+					return err
+				}
+				hashes = append(hashes, a.Hash)
+			}
+		}
+		if err := u.Anonymize(tx).Error; err != nil {
+			return err
+		}
+		aupr := models.AuditProcess{}
+		return aupr.WriteEvent(tx, &models.AuditEvent{Action: models.AuditUserDelete, TargetType: "user", TargetID: u.ID,
+			Details: d.cfg.Policy}).Error
+	})
+	if err != nil {
+		return err
+	}
+	d.removeBlobs(hashes)
+	return nil
+}
+
+// removeContent removes the posts and comments of user id and returns the
+// hashes of their attachments.
+func (d *Deleter) removeContent(tx *gorm.DB, id int) ([]string, error) {
+	apr := models.AttachmentProcess{}
+	hashes := []string{}
+	postIDs := []int{}
+	if err := tx.Model(&models.Post{}).Where("userId = ?", id).Pluck("id", &postIDs).Error; err != nil {
+		return nil, err
+	}
+	ppr := models.PostProcess{}
+	for _, postID := range postIDs {
+		h, err := apr.PostHashes(tx, postID)
+		if err != nil {
+			return nil, err
+		}
+		if err = ppr.DeletePost(tx, &models.Post{ID: postID, UserID: id}).Error; err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h...)
+	}
+	//replies first, so that threads of the user leave no tombstones
+	cc := []models.Comment{}
+	if err := tx.Where("userId = ? AND deleted = ?", id, false).Order("depth DESC, id DESC").Find(&cc).Error; err != nil {
+		return nil, err
+	}
+	cpr := models.CommentProcess{}
+	for i := range cc {
+		h, err := apr.CommentHashes(tx, cc[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		if err = cpr.DeleteComment(tx, &cc[i]).Error; err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, h...)
+	}
+	return hashes, nil
+}
+
+// removeBlobs deletes the files and thumbnails of hashes no attachment
+// refers to anymore.
+func (d *Deleter) removeBlobs(hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+	apr := models.AttachmentProcess{}
+	free, err := apr.UnreferencedHashes(d.db, hashes)
+	if err != nil {
+		log.Printf("accounts: %v", err)
+		return
+	}
+	for _, h := range free {
+		if err := d.store.Delete(context.Background(), models.BlobKey(h)); err != nil {
+			log.Printf("accounts: %v", err)
+		}
+		for _, size := range d.images.Sizes() {
+			if err := d.store.Delete(context.Background(), models.ThumbnailKey(h, size)); err != nil {
+				log.Printf("accounts: %v", err)
+			}
+		}
+	}
+}
@@ -4,53 +4,123 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"nx_trainee_forum/forum/accounts"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/assets"
+	"nx_trainee_forum/forum/events"
 	"nx_trainee_forum/forum/httphandlers"
 	"nx_trainee_forum/forum/httphandlers/middleware"
+	"nx_trainee_forum/forum/imaging"
+	"nx_trainee_forum/forum/mailer"
 	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/notify"
+	"nx_trainee_forum/forum/static"
+	"nx_trainee_forum/forum/storage"
+	"nx_trainee_forum/forum/templates"
+	"nx_trainee_forum/forum/views"
+	"nx_trainee_forum/forum/webhooks"
 	"os"
+	"strings"
 	"time"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 type Application struct {
-	DB     *gorm.DB
-	Config *config.Config
-	Router *http.ServeMux
-	server *http.Server
-	ctx    context.Context
-	cancel context.CancelFunc
+	DB       *gorm.DB
+	Config   *config.Config
+	Storage  storage.Storage
+	Images   *imaging.Processor
+	Events   *events.Bus
+	Notifier *notify.Notifier
+	Webhooks *webhooks.Dispatcher
+	Mailer   *mailer.Mailer
+	Accounts *accounts.Deleter
+	Assets   *assets.Server
+	Views    *views.Manager
+	Router   http.Handler //routes with the language of the request negotiated
+	mux      *http.ServeMux
+	server   *http.Server
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
 func New() *Application {
 	app := Application{}
 	//init configuration
 	app.Config = config.New()
-	//init Router
-	app.Router = http.NewServeMux()
-	//init Server
-	app.server = &http.Server{
-		Handler: app.Router,
-		Addr:    app.Config.HostAddr,
+	if err := app.Config.Validate(); err != nil {
+		log.Fatal(err)
 	}
+	//init Router
+	app.mux = http.NewServeMux()
 	app.ctx, app.cancel = context.WithCancel(context.Background())
-	gormDialector := mysql.New(mysql.Config{
-		DSN: fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", app.Config.DB.UserDB, app.Config.DB.PassDB, app.Config.DB.HostDB, app.Config.DB.PortDB, app.Config.DB.NameDB),
-	})
 	var err error
-	app.DB, err = gorm.Open(gormDialector, &gorm.Config{})
+	app.DB, err = openDB(app.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	initAdmins(app.DB, app.Config.Admins)
+	//init attachment storage
+	app.Storage, err = initStorage(app.ctx, app.Config.Storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	//init image processing workers
+	app.Images = imaging.New(app.DB, app.Storage, imaging.Limits{MaxWidth: app.Config.ImageMaxWidth, MaxHeight: app.Config.ImageMaxHeight},
+		app.Config.ThumbnailSizes, app.Config.ImageWorkers, 100)
+	app.Images.Resume()
+	//init notifications
+	app.Events = events.New(app.Config.StreamHistory)
+	app.Notifier = notify.New(app.DB, app.Events, 1000)
+	//init webhooks
+	app.Webhooks = webhooks.New(app.DB, webhooks.Config{
+		MaxAttempts: app.Config.WebhookMaxAttempts,
+		Backoff:     time.Duration(app.Config.WebhookBackoff) * time.Second,
+		Timeout:     time.Duration(app.Config.WebhookTimeout) * time.Second,
+	})
+	app.Events.Listen(app.Webhooks.Enqueue)
+	//init email notifications
+	app.Mailer = mailer.New(app.DB, mailer.Config{
+		Host:        app.Config.SMTP.Host,
+		Port:        app.Config.SMTP.Port,
+		Username:    app.Config.SMTP.Username,
+		Password:    app.Config.SMTP.Password,
+		From:        app.Config.SMTP.From,
+		TLS:         app.Config.SMTP.TLS,
+		BaseURL:     app.Config.BaseURL,
+		Key:         app.Config.HASHKey,
+		MaxAttempts: app.Config.EmailMaxAttempts,
+		Backoff:     time.Duration(app.Config.EmailBackoff) * time.Second,
+		DigestHour:  app.Config.DigestHour,
+	})
+	app.Events.Listen(app.Mailer.Notify)
+	//init deletion of accounts after the grace period
+	app.Accounts = accounts.New(app.DB, app.Storage, app.Images, accounts.Config{Policy: app.Config.DeletionPolicy})
+	//init static files and web UI templates, embedded unless overridden on disk
+	app.Assets, err = assets.New(assets.Overlay(app.Config.StaticDir, static.FS), "/public/")
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.Views, err = views.New(assets.Overlay(app.Config.TemplatesDir, templates.FS), app.Config.TemplatesDev,
+		template.FuncMap{"asset": app.Assets.Path})
 	if err != nil {
 		log.Fatal(err)
 	}
-	//init DB tables
-	initDBTables(app.DB)
 	//init Routers
 	initRouters(&app)
+	app.Router = middleware.Locale(app.Config, app.DB, app.mux)
+	//init Server
+	app.server = &http.Server{
+		Handler: app.Router,
+		Addr:    app.Config.HostAddr,
+	}
 	return &app
 }
 
@@ -75,27 +145,79 @@ func (app *Application) Start() {
 	}
 }
 
+// Close stops the server first, so that no handler submits images, publishes
+// events or queries the database while the workers and the pool go away.
 func (app *Application) Close() {
-	sql, _ := app.DB.DB()
-	sql.Close()
 	ctxsd, cancel := context.WithTimeout(app.ctx, 5*time.Second)
 	defer cancel()
 	app.server.Shutdown(ctxsd)
+	app.Images.Close()
+	app.Notifier.Close()
+	app.Webhooks.Close()
+	app.Mailer.Close()
+	app.Accounts.Close()
+	sql, _ := app.DB.DB()
+	sql.Close()
 	app = nil
 }
 
+// openDB connects to the database and brings its tables up to date.
+func openDB(cfg *config.Config) (*gorm.DB, error) {
+	gormDialector := mysql.New(mysql.Config{
+		DSN: fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", cfg.DB.UserDB, cfg.DB.PassDB, cfg.DB.HostDB, cfg.DB.PortDB, cfg.DB.NameDB),
+	})
+	db, err := gorm.Open(gormDialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	//init DB tables
+	initDBTables(db)
+	return db, nil
+}
+
 func initRouters(app *Application) {
-	router := app.Router
-	router.Handle("/", httphandlers.MainHandler(app.DB, app.Config))
+	router := app.mux
+	router.Handle("/", httphandlers.MainHandler(app.DB, app.Config, app.Views))
 	router.Handle("/public", http.NotFoundHandler())
-	router.Handle("/public/", httphandlers.PublicHandler())
+	router.Handle("/public/", app.Assets)
 	router.Handle("/logout/", httphandlers.LogoutHandler(app.Config, app.DB))
 	router.Handle("/auth/", httphandlers.Authentication(app.Config, app.DB))
 	router.Handle("/getapikey", middleware.Authorization(app.Config, app.DB, httphandlers.GetAPIKeyHandler(app.DB, app.Config)))
-	router.Handle("/posts", middleware.Authorization(app.Config, app.DB, httphandlers.PostsHandler(app.Config, app.DB)))
-	router.Handle("/posts/", middleware.Authorization(app.Config, app.DB, httphandlers.PostsHandler(app.Config, app.DB)))
-	router.Handle("/comments", middleware.Authorization(app.Config, app.DB, httphandlers.CommentsHandler(app.Config, app.DB)))
-	router.Handle("/comments/", middleware.Authorization(app.Config, app.DB, httphandlers.CommentsHandler(app.Config, app.DB)))
+	router.Handle("/posts", middleware.Authorization(app.Config, app.DB, httphandlers.PostsHandler(app.Config, app.DB, app.Storage, app.Images, app.Notifier)))
+	router.Handle("/posts/", middleware.Authorization(app.Config, app.DB, httphandlers.PostsHandler(app.Config, app.DB, app.Storage, app.Images, app.Notifier)))
+	router.Handle("/comments", middleware.Authorization(app.Config, app.DB, httphandlers.CommentsHandler(app.Config, app.DB, app.Storage, app.Images, app.Notifier)))
+	router.Handle("/comments/", middleware.Authorization(app.Config, app.DB, httphandlers.CommentsHandler(app.Config, app.DB, app.Storage, app.Images, app.Notifier)))
+	router.Handle("/categories", middleware.Authorization(app.Config, app.DB, httphandlers.CategoriesHandler(app.Config, app.DB)))
+	router.Handle("/categories/", middleware.Authorization(app.Config, app.DB, httphandlers.CategoriesHandler(app.Config, app.DB)))
+	router.Handle("/tags", middleware.Authorization(app.Config, app.DB, httphandlers.TagsHandler(app.Config, app.DB)))
+	router.Handle("/tags/", middleware.Authorization(app.Config, app.DB, httphandlers.TagsHandler(app.Config, app.DB)))
+	router.Handle("/users", middleware.Authorization(app.Config, app.DB, httphandlers.UsersHandler(app.Config, app.DB, app.Storage)))
+	router.Handle("/users/", middleware.Authorization(app.Config, app.DB, httphandlers.UsersHandler(app.Config, app.DB, app.Storage)))
+	router.Handle("/me", middleware.RequireRole(app.Config, app.DB, models.RoleUser, httphandlers.MeHandler(app.Config, app.DB, app.Storage, app.Images, app.Accounts)))
+	router.Handle("/me/", middleware.RequireRole(app.Config, app.DB, models.RoleUser, httphandlers.MeHandler(app.Config, app.DB, app.Storage, app.Images, app.Accounts)))
+	router.Handle("/albums", middleware.Authorization(app.Config, app.DB, httphandlers.AlbumsHandler(app.Config, app.DB)))
+	router.Handle("/albums/", middleware.Authorization(app.Config, app.DB, httphandlers.AlbumsHandler(app.Config, app.DB)))
+	router.Handle("/photos", middleware.Authorization(app.Config, app.DB, httphandlers.PhotosHandler(app.Config, app.DB)))
+	router.Handle("/photos/", middleware.Authorization(app.Config, app.DB, httphandlers.PhotosHandler(app.Config, app.DB)))
+	router.Handle("/todos", middleware.Authorization(app.Config, app.DB, httphandlers.TodosHandler(app.Config, app.DB)))
+	router.Handle("/todos/", middleware.Authorization(app.Config, app.DB, httphandlers.TodosHandler(app.Config, app.DB)))
+	router.Handle("/feeds/", middleware.Authorization(app.Config, app.DB, httphandlers.FeedsHandler(app.Config, app.DB)))
+	router.Handle("/stream", middleware.Authorization(app.Config, app.DB, httphandlers.StreamHandler(app.Config, app.DB, app.Events)))
+	router.Handle("/notifications", middleware.RequireRole(app.Config, app.DB, models.RoleUser, httphandlers.NotificationsHandler(app.Config, app.DB, app.Mailer)))
+	router.Handle("/notifications/", middleware.RequireRole(app.Config, app.DB, models.RoleUser, httphandlers.NotificationsHandler(app.Config, app.DB, app.Mailer)))
+	router.Handle("/attachments/", middleware.RequireRole(app.Config, app.DB, models.RoleUser, httphandlers.AttachmentsHandler(app.Config, app.DB, app.Storage, app.Images, app.Notifier)))
+	router.Handle("/render/", middleware.Authorization(app.Config, app.DB, httphandlers.RenderHandler(app.Config, app.DB)))
+	router.Handle("/audit", middleware.RequireRole(app.Config, app.DB, models.RoleAdmin, httphandlers.AuditHandler(app.Config, app.DB)))
+	router.Handle("/audit/", middleware.RequireRole(app.Config, app.DB, models.RoleAdmin, httphandlers.AuditHandler(app.Config, app.DB)))
+	router.Handle("/export", middleware.RequireRole(app.Config, app.DB, models.RoleAdmin, httphandlers.ArchiveHandler(app.Config, app.DB)))
+	router.Handle("/import", middleware.RequireRole(app.Config, app.DB, models.RoleAdmin, httphandlers.ArchiveHandler(app.Config, app.DB)))
+	router.Handle("/language", middleware.Authorization(app.Config, app.DB, httphandlers.LanguageHandler(app.Config, app.DB)))
+	router.Handle("/web/", httphandlers.WebHandler(app.Config, app.DB, app.Views, app.Notifier))
+	router.Handle("/unsubscribe", httphandlers.UnsubscribeHandler(app.Config, app.DB))
+	router.Handle("/email/verify", httphandlers.VerifyEmailHandler(app.Config, app.DB))
+	router.Handle("/roles", middleware.RequireRole(app.Config, app.DB, models.RoleAdmin, httphandlers.RolesHandler(app.Config, app.DB, app.Notifier)))
+	router.Handle("/webhooks", middleware.RequireRole(app.Config, app.DB, models.RoleAdmin, httphandlers.WebhooksHandler(app.Config, app.DB, app.Webhooks)))
+	router.Handle("/webhooks/", middleware.RequireRole(app.Config, app.DB, models.RoleAdmin, httphandlers.WebhooksHandler(app.Config, app.DB, app.Webhooks)))
 	router.HandleFunc("/swagger/", httpSwagger.Handler(
 		httpSwagger.URL("localhost/swagger/doc.json"),
 	))
@@ -104,6 +226,13 @@ func initRouters(app *Application) {
 func initDBTables(db *gorm.DB) {
 	if !db.Migrator().HasTable(&models.Comment{}) {
 		db.Migrator().CreateTable(&models.Comment{})
+	} else {
+		addMissingColumns(db, &models.Comment{}, "ParentID", "Depth", "Deleted", "Score", "CreatedAt")
+		if !db.Migrator().HasColumn(&models.Comment{}, "UpdatedAt") {
+			db.Migrator().AddColumn(&models.Comment{}, "UpdatedAt")
+			db.Exec("UPDATE comments SET updatedAt = createdAt")
+		}
+		alterColumnTypes(db, &models.Comment{}, "Name", "Body")
 	}
 	if !db.Migrator().HasTable(&models.Post{}) {
 		db.Migrator().CreateTable(&models.Post{})
@@ -112,6 +241,34 @@ func initDBTables(db *gorm.DB) {
 		if !db.Migrator().HasConstraint(&models.Post{}, "Comments") {
 			db.Migrator().CreateConstraint(&models.Post{}, "Comments")
 		}
+		addMissingColumns(db, &models.Post{}, "CategoryID", "Score", "CreatedAt", "Type", "AcceptedID")
+		if !db.Migrator().HasColumn(&models.Post{}, "UpdatedAt") {
+			db.Migrator().AddColumn(&models.Post{}, "UpdatedAt")
+			db.Exec("UPDATE posts SET updatedAt = createdAt")
+		}
+		alterColumnTypes(db, &models.Post{}, "Title", "Body")
+	}
+	if !db.Migrator().HasTable(&models.PostTag{}) {
+		db.Migrator().CreateTable(&models.PostTag{})
+	}
+	if !db.Migrator().HasConstraint(&models.Post{}, "PostTags") {
+		db.Migrator().CreateConstraint(&models.Post{}, "PostTags")
+	}
+	if !db.Migrator().HasTable(&models.Tag{}) {
+		db.Migrator().CreateTable(&models.Tag{})
+		db.Migrator().CreateConstraint(&models.Tag{}, "PostTags")
+	} else {
+		if !db.Migrator().HasConstraint(&models.Tag{}, "PostTags") {
+			db.Migrator().CreateConstraint(&models.Tag{}, "PostTags")
+		}
+	}
+	if !db.Migrator().HasTable(&models.Category{}) {
+		db.Migrator().CreateTable(&models.Category{})
+		db.Migrator().CreateConstraint(&models.Category{}, "Posts")
+	} else {
+		if !db.Migrator().HasConstraint(&models.Category{}, "Posts") {
+			db.Migrator().CreateConstraint(&models.Category{}, "Posts")
+		}
 	}
 
 	if !db.Migrator().HasTable(&models.User{}) {
@@ -125,5 +282,182 @@ func initDBTables(db *gorm.DB) {
 		if !db.Migrator().HasConstraint(&models.User{}, "Comments") {
 			db.Migrator().CreateConstraint(&models.User{}, "Comments")
 		}
+		addMissingColumns(db, &models.User{}, "Role", "Email", "EmailNotifications", "DigestSentAt", "Language", "Bio", "AvatarID", "ProfileVisibility", "ShowEmail",
+			"Phone", "Website", "Street", "Suite", "City", "Zipcode", "Lat", "Lng", "CompanyName", "CatchPhrase", "BS", "DeleteAt", "EmailVerified")
+		//email is opt-in: the column defaulted to digest before
+		alterColumnDefaults(db, &models.User{}, "EmailNotifications")
+	}
+	if !db.Migrator().HasTable(&models.Vote{}) {
+		db.Migrator().CreateTable(&models.Vote{})
+	}
+	if !db.Migrator().HasTable(&models.Reaction{}) {
+		db.Migrator().CreateTable(&models.Reaction{})
+	}
+	if !db.Migrator().HasTable(&models.Attachment{}) {
+		db.Migrator().CreateTable(&models.Attachment{})
+	} else {
+		addMissingColumns(db, &models.Attachment{}, "Status", "ThumbnailSizes")
+	}
+	if !db.Migrator().HasConstraint(&models.Post{}, "Attachments") {
+		db.Migrator().CreateConstraint(&models.Post{}, "Attachments")
+	}
+	if !db.Migrator().HasConstraint(&models.Comment{}, "Attachments") {
+		db.Migrator().CreateConstraint(&models.Comment{}, "Attachments")
+	}
+	if !db.Migrator().HasTable(&models.Mention{}) {
+		db.Migrator().CreateTable(&models.Mention{})
+	}
+	if !db.Migrator().HasTable(&models.Notification{}) {
+		db.Migrator().CreateTable(&models.Notification{})
+	} else {
+		addMissingColumns(db, &models.Notification{}, "Details")
+	}
+	if !db.Migrator().HasConstraint(&models.Post{}, "Mentions") {
+		db.Migrator().CreateConstraint(&models.Post{}, "Mentions")
+	}
+	if !db.Migrator().HasConstraint(&models.Comment{}, "Mentions") {
+		db.Migrator().CreateConstraint(&models.Comment{}, "Mentions")
+	}
+	if !db.Migrator().HasConstraint(&models.User{}, "Mentions") {
+		db.Migrator().CreateConstraint(&models.User{}, "Mentions")
+	}
+	if !db.Migrator().HasConstraint(&models.Post{}, "Notifications") {
+		db.Migrator().CreateConstraint(&models.Post{}, "Notifications")
+	}
+	if !db.Migrator().HasConstraint(&models.Comment{}, "Notifications") {
+		db.Migrator().CreateConstraint(&models.Comment{}, "Notifications")
+	}
+	if !db.Migrator().HasConstraint(&models.User{}, "Notifications") {
+		db.Migrator().CreateConstraint(&models.User{}, "Notifications")
+	}
+	if !db.Migrator().HasTable(&models.Webhook{}) {
+		db.Migrator().CreateTable(&models.Webhook{})
+	}
+	if !db.Migrator().HasTable(&models.WebhookDelivery{}) {
+		db.Migrator().CreateTable(&models.WebhookDelivery{})
+	}
+	if !db.Migrator().HasConstraint(&models.Webhook{}, "Deliveries") {
+		db.Migrator().CreateConstraint(&models.Webhook{}, "Deliveries")
+	}
+	if !db.Migrator().HasTable(&models.EmailMessage{}) {
+		db.Migrator().CreateTable(&models.EmailMessage{})
+	}
+	if !db.Migrator().HasConstraint(&models.User{}, "EmailMessages") {
+		db.Migrator().CreateConstraint(&models.User{}, "EmailMessages")
+	}
+	if !db.Migrator().HasTable(&models.AuditEvent{}) {
+		db.Migrator().CreateTable(&models.AuditEvent{})
+	} else if !db.Migrator().HasColumn(&models.AuditEvent{}, "Provider") {
+		db.Migrator().AddColumn(&models.AuditEvent{}, "Provider")
+		db.Migrator().CreateIndex(&models.AuditEvent{}, "Provider")
+		//logins kept the provider in front of their details
+		db.Exec("UPDATE audit_events SET provider = SUBSTRING_INDEX(details, ':', 1) WHERE action IN ?",
+			[]string{models.AuditLoginSuccess, models.AuditLoginFailure})
+	}
+	if !db.Migrator().HasTable(&models.Album{}) {
+		db.Migrator().CreateTable(&models.Album{})
+	}
+	if !db.Migrator().HasTable(&models.Photo{}) {
+		db.Migrator().CreateTable(&models.Photo{})
+	}
+	if !db.Migrator().HasTable(&models.Todo{}) {
+		db.Migrator().CreateTable(&models.Todo{})
+	}
+	if !db.Migrator().HasConstraint(&models.User{}, "Albums") {
+		db.Migrator().CreateConstraint(&models.User{}, "Albums")
+	}
+	if !db.Migrator().HasConstraint(&models.Album{}, "Photos") {
+		db.Migrator().CreateConstraint(&models.Album{}, "Photos")
+	}
+	if !db.Migrator().HasConstraint(&models.User{}, "Todos") {
+		db.Migrator().CreateConstraint(&models.User{}, "Todos")
+	}
+}
+
+func addMissingColumns(db *gorm.DB, model interface{}, fields ...string) {
+	for _, field := range fields {
+		if !db.Migrator().HasColumn(model, field) {
+			db.Migrator().AddColumn(model, field)
+		}
+	}
+}
+
+// alterColumnTypes converts existing columns whose type differs from the one
+// declared in the model, e.g. bodies widened from VARCHAR to MEDIUMTEXT.
+// MySQL keeps the stored values.
+func alterColumnTypes(db *gorm.DB, model interface{}, fields ...string) {
+	alterColumns(db, model, func(c gorm.ColumnType, f *schema.Field) bool {
+		t, ok := c.ColumnType()
+		return ok && !strings.EqualFold(t, f.TagSettings["TYPE"])
+	}, fields...)
+}
+
+// alterColumnDefaults changes existing columns whose default differs from
+// the one declared in the model. Rows keep their values.
+func alterColumnDefaults(db *gorm.DB, model interface{}, fields ...string) {
+	alterColumns(db, model, func(c gorm.ColumnType, f *schema.Field) bool {
+		d, ok := c.DefaultValue()
+		return !ok || strings.Trim(d, "'") != f.DefaultValue
+	}, fields...)
+}
+
+// alterColumns alters the existing columns of fields for which differs
+// reports that the table does not match the model, so that startup does not
+// rebuild tables that are up to date.
+func alterColumns(db *gorm.DB, model interface{}, differs func(gorm.ColumnType, *schema.Field) bool, fields ...string) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		log.Print(err)
+		return
+	}
+	columns, err := db.Migrator().ColumnTypes(model)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, field := range fields {
+		f := stmt.Schema.LookUpField(field)
+		if f == nil {
+			continue
+		}
+		for _, c := range columns {
+			if c.Name() != f.DBName {
+				continue
+			}
+			if differs(c, f) {
+				if err := db.Migrator().AlterColumn(model, field); err != nil {
+					log.Print(err)
+				}
+			}
+		}
+	}
+}
+
+func initStorage(ctx context.Context, cfg config.StorageCfg) (storage.Storage, error) {
+	switch cfg.Backend {
+	case "s3":
+		return storage.NewS3(ctx, storage.S3Cfg{
+			Endpoint:  cfg.S3Endpoint,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			Bucket:    cfg.S3Bucket,
+			Region:    cfg.S3Region,
+			UseSSL:    cfg.S3UseSSL,
+		})
+	case "local", "":
+		return storage.NewLocal(cfg.Dir)
+	}
+	return nil, fmt.Errorf("unknown storage %q", cfg.Backend)
+}
+
+// initAdmins grants the admin role to the users listed in ADMIN_LOGINS, so
+// that a fresh installation has someone able to assign further roles.
+func initAdmins(db *gorm.DB, logins []string) {
+	if len(logins) == 0 {
+		return
+	}
+	result := db.Model(&models.User{}).Where("login IN ?", logins).Update("role", models.RoleAdmin)
+	if result.Error != nil {
+		log.Print(result.Error)
 	}
 }
@@ -0,0 +1,130 @@
+package application
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/archive"
+	"nx_trainee_forum/forum/importer"
+	"os"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrUsage is returned for command lines Command does not know.
+var ErrUsage = errors.New(`usage:
+  forum import jsonplaceholder <dir>
+  forum export [-resource users,posts] <ndjson|csv|json> <file|->
+  forum import [-dry-run] [-stored-refs] [-conflict skip|overwrite|fail] [-resource posts] <ndjson|csv|json> <file|->`)
+
+// Command runs the command line args (without the program name) instead of
+// the server and writes its report to out:
+//
+//	import jsonplaceholder <dir>    load the JSONPlaceholder dataset from dir
+//	export <format> <file>          write forum data to file, - for out
+//	import <format> <file>          load forum data from file, - for stdin
+func Command(args []string, out io.Writer) error {
+	switch {
+	case len(args) == 3 && args[0] == "import" && args[1] == "jsonplaceholder":
+		return withDB(func(db *gorm.DB) error {
+			counts, err := importer.JSONPlaceholder(db, args[2])
+			if err != nil {
+				return err
+			}
+			for _, c := range counts {
+				fmt.Fprintln(out, c)
+			}
+			return nil
+		})
+	case len(args) > 0 && args[0] == "export":
+		return exportCommand(args[1:], out)
+	case len(args) > 0 && args[0] == "import":
+		return importCommand(args[1:], out)
+	}
+	return ErrUsage
+}
+
+// withDB runs fn with a connection to the configured database.
+func withDB(fn func(db *gorm.DB) error) error {
+	db, err := openDB(config.New())
+	if err != nil {
+		return err
+	}
+	if sql, err := db.DB(); err == nil {
+		defer sql.Close()
+	}
+	return fn(db)
+}
+
+func exportCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	resource := fs.String("resource", "", "comma separated resources, all by default")
+	if fs.Parse(args) != nil || fs.NArg() != 2 {
+		return ErrUsage
+	}
+	var resources []string
+	if *resource != "" {
+		resources = strings.Split(*resource, ",")
+	}
+	return withDB(func(db *gorm.DB) error {
+		w := out
+		if name := fs.Arg(1); name != "-" {
+			f, err := os.Create(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		bw := bufio.NewWriter(w)
+		if err := archive.Export(db, bw, fs.Arg(0), resources); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+func importCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opt := archive.Options{}
+	fs.BoolVar(&opt.DryRun, "dry-run", false, "validate and count without storing")
+	fs.StringVar(&opt.Conflict, "conflict", archive.ConflictSkip, "skip, overwrite or fail")
+	fs.StringVar(&opt.Resource, "resource", "", "resource of a csv file")
+	fs.BoolVar(&opt.StoredRefs, "stored-refs", false, "let references to records missing from the file refer to stored rows")
+	if fs.Parse(args) != nil || fs.NArg() != 2 {
+		return ErrUsage
+	}
+	opt.Format = fs.Arg(0)
+	return withDB(func(db *gorm.DB) error {
+		var r io.Reader = os.Stdin
+		if name := fs.Arg(1); name != "-" {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		report, err := archive.Import(db, bufio.NewReader(r), opt)
+		if report != nil {
+			for _, res := range archive.Resources {
+				if c := report.Counts[res]; c != nil {
+					fmt.Fprintf(out, "%s: %d created, %d updated, %d skipped\n", res, c.Created, c.Updated, c.Skipped)
+				}
+			}
+			for _, e := range report.Errors {
+				fmt.Fprintln(out, e)
+			}
+			if report.DryRun && err == nil {
+				fmt.Fprintln(out, "dry run, nothing was imported")
+			}
+		}
+		return err
+	})
+}
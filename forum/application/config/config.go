@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 
 	"golang.org/x/oauth2"
@@ -35,6 +37,26 @@ type TwitterAuthCfg struct {
 	Access             bool
 }
 
+type StorageCfg struct {
+	Backend     string
+	Dir         string
+	S3Endpoint  string
+	S3AccessKey string
+	S3SecretKey string
+	S3Bucket    string
+	S3Region    string
+	S3UseSSL    bool
+}
+
+type SMTPCfg struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLS      string
+}
+
 type Config struct {
 	DB       DBCfg
 	Google   GoogleAuthCfg
@@ -42,8 +64,52 @@ type Config struct {
 	Twitter  TwitterAuthCfg
 	HostAddr string
 	HASHKey  string
+	Admins   []string
+
+	CommentMaxDepth int
+	Reactions       []string
+
+	PostTitleMaxLength   int
+	PostBodyMaxLength    int
+	CommentNameMaxLength int
+	CommentBodyMaxLength int
+	UserNameMaxLength    int
+	UserBioMaxLength     int
+
+	Storage           StorageCfg
+	AttachmentMaxSize int
+	ImportMaxSize     int //bytes of an import request
+	AttachmentTypes   []string
+
+	ImageMaxWidth  int
+	ImageMaxHeight int
+	ThumbnailSizes []int
+	ImageWorkers   int
+
+	StreamHistory int
+
+	WebhookMaxAttempts int
+	WebhookBackoff     int
+	WebhookTimeout     int
+
+	BaseURL          string
+	SMTP             SMTPCfg
+	EmailMaxAttempts int
+	EmailBackoff     int
+	DigestHour       int
+
+	DeletionPolicy    string //anonymize or remove the posts and comments of deleted accounts
+	DeletionGraceDays int
+
+	StaticDir    string
+	TemplatesDir string
+	TemplatesDev bool
 }
 
+// maxTitleLength is the size of the VARCHAR(512) columns holding titles and
+// comment names, which bounds their length limits.
+const maxTitleLength = 512
+
 func New() *Config {
 	return &Config{
 		DB: DBCfg{
@@ -94,7 +160,92 @@ func New() *Config {
 		},
 		HostAddr: getEnv("HOST_ADDRESS", "localhost:80"),
 		HASHKey:  getEnv("HASH_KEY", "provider"),
+		Admins:   getEnvAsSlice("ADMIN_LOGINS", []string{}, ","),
+
+		CommentMaxDepth: getEnvAsInt("COMMENT_MAX_DEPTH", 5),
+		Reactions:       getEnvAsSlice("REACTIONS", []string{"thumbsup", "thumbsdown", "thanks", "confused", "heart", "laugh"}, ","),
+
+		PostTitleMaxLength:   atMost(getEnvAsInt("POST_TITLE_MAX_LENGTH", 256), maxTitleLength),
+		PostBodyMaxLength:    getEnvAsInt("POST_BODY_MAX_LENGTH", 65535),
+		CommentNameMaxLength: atMost(getEnvAsInt("COMMENT_NAME_MAX_LENGTH", 256), maxTitleLength),
+		CommentBodyMaxLength: getEnvAsInt("COMMENT_BODY_MAX_LENGTH", 16384),
+		UserNameMaxLength:    getEnvAsInt("USER_NAME_MAX_LENGTH", 64),
+		UserBioMaxLength:     getEnvAsInt("USER_BIO_MAX_LENGTH", 2048),
+
+		Storage: StorageCfg{
+			Backend:     getEnv("STORAGE", "local"),
+			Dir:         getEnv("STORAGE_DIR", "./uploads"),
+			S3Endpoint:  getEnv("S3_ENDPOINT", ""),
+			S3AccessKey: getEnv("S3_ACCESS_KEY", ""),
+			S3SecretKey: getEnv("S3_SECRET_KEY", ""),
+			S3Bucket:    getEnv("S3_BUCKET", "forum"),
+			S3Region:    getEnv("S3_REGION", ""),
+			S3UseSSL:    getEnv("S3_USE_SSL", "false") == "true",
+		},
+		AttachmentMaxSize: getEnvAsInt("ATTACHMENT_MAX_SIZE", 10<<20),
+		ImportMaxSize:     getEnvAsInt("IMPORT_MAX_SIZE", 100<<20),
+		AttachmentTypes: getEnvAsSlice("ATTACHMENT_TYPES", []string{"image/png", "image/jpeg", "image/gif", "text/plain",
+			"application/pdf", "application/zip", "application/x-gzip"}, ","),
+
+		ImageMaxWidth:  getEnvAsInt("IMAGE_MAX_WIDTH", 8192),
+		ImageMaxHeight: getEnvAsInt("IMAGE_MAX_HEIGHT", 8192),
+		ThumbnailSizes: getEnvAsIntSlice("THUMBNAIL_SIZES", []int{160, 480, 1024}, ","),
+		ImageWorkers:   getEnvAsInt("IMAGE_WORKERS", 2),
+
+		StreamHistory: getEnvAsInt("STREAM_HISTORY", 1000),
+
+		WebhookMaxAttempts: getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 6),
+		WebhookBackoff:     getEnvAsInt("WEBHOOK_BACKOFF", 10),
+		WebhookTimeout:     getEnvAsInt("WEBHOOK_TIMEOUT", 10),
+
+		BaseURL: strings.TrimRight(getEnv("BASE_URL", "http://localhost"), "/"),
+		SMTP: SMTPCfg{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getEnvAsInt("SMTP_PORT", 587),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", "Forum <forum@localhost>"),
+			TLS:      getEnv("SMTP_TLS", "starttls"),
+		},
+		EmailMaxAttempts: getEnvAsInt("EMAIL_MAX_ATTEMPTS", 5),
+		EmailBackoff:     getEnvAsInt("EMAIL_BACKOFF", 60),
+		DigestHour:       getEnvAsInt("DIGEST_HOUR", 8),
+
+		DeletionPolicy:    getEnv("DELETION_POLICY", "anonymize"),
+		DeletionGraceDays: getEnvAsInt("DELETION_GRACE_DAYS", 30),
+
+		StaticDir:    getEnv("STATIC_DIR", ""),
+		TemplatesDir: getEnv("TEMPLATES_DIR", ""),
+		TemplatesDev: getEnv("TEMPLATES_DEV", "false") == "true",
+	}
+}
+
+// Validate reports the first setting the application cannot run with.
+func (cfg *Config) Validate() error {
+	lengths := []struct {
+		name  string
+		value int
+	}{
+		{"POST_TITLE_MAX_LENGTH", cfg.PostTitleMaxLength},
+		{"POST_BODY_MAX_LENGTH", cfg.PostBodyMaxLength},
+		{"COMMENT_NAME_MAX_LENGTH", cfg.CommentNameMaxLength},
+		{"COMMENT_BODY_MAX_LENGTH", cfg.CommentBodyMaxLength},
+		{"USER_NAME_MAX_LENGTH", cfg.UserNameMaxLength},
+		{"USER_BIO_MAX_LENGTH", cfg.UserBioMaxLength},
+	}
+	for _, l := range lengths {
+		if l.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", l.name, l.value)
+		}
 	}
+	return nil
+}
+
+func atMost(val, limit int) int {
+	if val > limit {
+		return limit
+	}
+	return val
 }
 
 func getEnv(key string, defaultVal string) string {
@@ -104,6 +255,14 @@ func getEnv(key string, defaultVal string) string {
 	return defaultVal
 }
 
+func getEnvAsInt(name string, defaultVal int) int {
+	valStr := getEnv(name, "")
+	if val, err := strconv.Atoi(valStr); err == nil {
+		return val
+	}
+	return defaultVal
+}
+
 func getEnvAsSlice(name string, defaultVal []string, sep string) []string {
 	valStr := getEnv(name, "")
 	if valStr == "" {
@@ -113,6 +272,20 @@ func getEnvAsSlice(name string, defaultVal []string, sep string) []string {
 	return val
 }
 
+func getEnvAsIntSlice(name string, defaultVal []int, sep string) []int {
+	valStr := getEnv(name, "")
+	if valStr == "" {
+		return defaultVal
+	}
+	val := []int{}
+	for _, s := range strings.Split(valStr, sep) {
+		if i, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			val = append(val, i)
+		}
+	}
+	return val
+}
+
 func accessField(args ...string) bool {
 	res := true
 	for _, arg := range args {
@@ -0,0 +1,119 @@
+package archive
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSV columns are the JSON names of the record fields. Empty cells are nil
+// for *int fields, tags are joined with commas and times are RFC 3339.
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// csvHeader returns the column names of the record v points to.
+func csvHeader(v interface{}) []string {
+	t := reflect.TypeOf(v).Elem()
+	header := make([]string, t.NumField())
+	for i := range header {
+		header[i] = strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+	}
+	return header
+}
+
+// csvRow formats the fields of the record v points to.
+func csvRow(v interface{}) []string {
+	rv := reflect.ValueOf(v).Elem()
+	row := make([]string, rv.NumField())
+	for i := range row {
+		f := rv.Field(i)
+		switch {
+		case f.Type() == timeType:
+			if t := f.Interface().(time.Time); !t.IsZero() {
+				row[i] = t.UTC().Format(time.RFC3339Nano)
+			}
+		case f.Kind() == reflect.String:
+			row[i] = f.String()
+		case f.Kind() == reflect.Int:
+			row[i] = strconv.FormatInt(f.Int(), 10)
+		case f.Kind() == reflect.Bool:
+			row[i] = strconv.FormatBool(f.Bool())
+		case f.Kind() == reflect.Ptr:
+			if !f.IsNil() {
+				row[i] = strconv.FormatInt(f.Elem().Int(), 10)
+			}
+		case f.Kind() == reflect.Slice:
+			row[i] = strings.Join(f.Interface().([]string), ",")
+		}
+	}
+	return row
+}
+
+// csvColumns maps the columns of header to the fields of the record v points
+// to; unknown columns are an error.
+func csvColumns(v interface{}, header []string) ([]int, error) {
+	index := make(map[string]int)
+	for i, name := range csvHeader(v) {
+		index[name] = i
+	}
+	columns := make([]int, len(header))
+	for i, name := range header {
+		f, ok := index[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[i] = f
+	}
+	return columns, nil
+}
+
+// csvScan parses row, whose cells belong to the fields columns, into the
+// record v points to.
+func csvScan(v interface{}, columns []int, row []string) error {
+	rv := reflect.ValueOf(v).Elem()
+	for i, cell := range row {
+		if i >= len(columns) {
+			return fmt.Errorf("more cells than columns")
+		}
+		f := rv.Field(columns[i])
+		var err error
+		switch {
+		case f.Type() == timeType:
+			if cell != "" {
+				var t time.Time
+				t, err = time.Parse(time.RFC3339Nano, cell)
+				f.Set(reflect.ValueOf(t))
+			}
+		case f.Kind() == reflect.String:
+			f.SetString(cell)
+		case f.Kind() == reflect.Int:
+			var n int
+			n, err = strconv.Atoi(cell)
+			f.SetInt(int64(n))
+		case f.Kind() == reflect.Bool:
+			var b bool
+			if cell != "" {
+				b, err = strconv.ParseBool(cell)
+			}
+			f.SetBool(b)
+		case f.Kind() == reflect.Ptr:
+			if cell != "" {
+				var n int
+				n, err = strconv.Atoi(cell)
+				f.Set(reflect.ValueOf(&n))
+			}
+		case f.Kind() == reflect.Slice:
+			tags := []string{}
+			if cell != "" {
+				tags = strings.Split(cell, ",")
+			}
+			f.Set(reflect.ValueOf(tags))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", csvHeader(v)[columns[i]], err)
+		}
+	}
+	return nil
+}
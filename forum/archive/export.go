@@ -0,0 +1,261 @@
+package archive
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"nx_trainee_forum/forum/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidFormat   = errors.New("format must be ndjson, csv or json")
+	ErrInvalidResource = errors.New("resources must be among users, categories, posts and comments")
+	ErrCSVResource     = errors.New("csv holds exactly one resource")
+)
+
+const batchSize = 500
+
+type flusher interface {
+	Flush()
+}
+
+// encoder writes the records of an export.
+type encoder interface {
+	begin(resource string) error
+	record(resource string, v interface{}) error
+	end() error
+}
+
+// Export writes resources (all if empty) to w in format, in the order of
+// Resources and by ID within each. Rows are read in batches and w is flushed
+// after each one if it can be, so exports of any size are streamed.
+// Credentials of users are never part of an export.
+func Export(db *gorm.DB, w io.Writer, format string, resources []string) error {
+	if len(resources) == 0 {
+		resources = Resources
+	}
+	want := make(map[string]bool, len(resources))
+	for _, r := range resources {
+		if !ValidResource(r) {
+			return ErrInvalidResource
+		}
+		want[r] = true
+	}
+	var enc encoder
+	switch format {
+	case FormatNDJSON:
+		enc = &ndjsonEncoder{enc: json.NewEncoder(w)}
+	case FormatJSON:
+		enc = &jsonEncoder{w: w}
+	case FormatCSV:
+		if len(want) != 1 {
+			return ErrCSVResource
+		}
+		enc = &csvEncoder{w: csv.NewWriter(w)}
+	default:
+		return ErrInvalidFormat
+	}
+	flush := func() {
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
+	}
+	for _, r := range Resources {
+		if !want[r] {
+			continue
+		}
+		if err := enc.begin(r); err != nil {
+			return err
+		}
+		err := each(db, r, func(v interface{}) error { return enc.record(r, v) }, flush)
+		if err != nil {
+			return err
+		}
+	}
+	if err := enc.end(); err != nil {
+		return err
+	}
+	flush()
+	return nil
+}
+
+// WriteError ends an NDJSON export that failed with err with an error line,
+// so that the export is not taken for a complete one; importing it fails.
+func WriteError(w io.Writer, err error) error {
+	return json.NewEncoder(w).Encode(line{Type: typeError, Error: err.Error()})
+}
+
+// each passes every record of resource to fn, calling flush after a batch.
+func each(db *gorm.DB, resource string, fn func(interface{}) error, flush func()) error {
+	switch resource {
+	case ResourceUsers:
+		uu := []models.User{}
+		return db.FindInBatches(&uu, batchSize, func(tx *gorm.DB, batch int) error {
+			for _, u := range uu {
+				rec := userRecord(u)
+				if err := fn(&rec); err != nil {
+					return err
+				}
+			}
+			flush()
+			return nil
+		}).Error
+	case ResourceCategories:
+		capr := models.CategoryProcess{}
+		cc, result := capr.ListCategories(db, map[string]interface{}{})
+		if result.Error != nil {
+			return result.Error
+		}
+		//parents before their children
+		var walk func(cc []models.Category) error
+		walk = func(cc []models.Category) error {
+			for _, c := range cc {
+				rec := categoryRecord(c)
+				if err := fn(&rec); err != nil {
+					return err
+				}
+				if err := walk(c.Children); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		err := walk(models.CategoryTree(cc))
+		flush()
+		return err
+	case ResourcePosts:
+		pp := []models.Post{}
+		return db.FindInBatches(&pp, batchSize, func(tx *gorm.DB, batch int) error {
+			if err := postTags(db, pp); err != nil {
+				return err
+			}
+			for _, p := range pp {
+				rec := postRecord(p)
+				if err := fn(&rec); err != nil {
+					return err
+				}
+			}
+			flush()
+			return nil
+		}).Error
+	case ResourceComments:
+		cc := []models.Comment{}
+		return db.FindInBatches(&cc, batchSize, func(tx *gorm.DB, batch int) error {
+			for _, c := range cc {
+				rec := commentRecord(c)
+				if err := fn(&rec); err != nil {
+					return err
+				}
+			}
+			flush()
+			return nil
+		}).Error
+	}
+	return ErrInvalidResource
+}
+
+// postTags fills the tags of pp with a single query.
+func postTags(db *gorm.DB, pp []models.Post) error {
+	ids := make([]int, len(pp))
+	index := make(map[int]int, len(pp))
+	for i := range pp {
+		ids[i] = pp[i].ID
+		index[pp[i].ID] = i
+		pp[i].Tags = []string{}
+	}
+	rows := []struct {
+		PostID int    `gorm:"column:postId"`
+		Slug   string `gorm:"column:slug"`
+	}{}
+	err := db.Session(&gorm.Session{NewDB: true}).Table("post_tags").
+		Select("post_tags.postId, tags.slug").
+		Joins("JOIN tags ON tags.id = post_tags.tagId").
+		Where("post_tags.postId IN ?", ids).Order("tags.slug").
+		Scan(&rows).Error
+	for _, row := range rows {
+		i := index[row.PostID]
+		pp[i].Tags = append(pp[i].Tags, row.Slug)
+	}
+	return err
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) begin(resource string) error { return nil }
+
+func (e *ndjsonEncoder) record(resource string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return e.enc.Encode(line{Type: recordTypes[resource], Record: raw})
+}
+
+func (e *ndjsonEncoder) end() error { return nil }
+
+// jsonEncoder writes {"version": 1, "exportedAt": ..., "users": [...], ...}
+// one record at a time.
+type jsonEncoder struct {
+	w       io.Writer
+	started bool //an array is open
+	first   bool //no record in the open array yet
+}
+
+func (e *jsonEncoder) begin(resource string) error {
+	var err error
+	if !e.started {
+		_, err = fmt.Fprintf(e.w, "{\n  \"version\": 1,\n  \"exportedAt\": %q,\n  %q: [", time.Now().UTC().Format(time.RFC3339), resource)
+	} else {
+		_, err = fmt.Fprintf(e.w, "\n  ],\n  %q: [", resource)
+	}
+	e.started, e.first = true, true
+	return err
+}
+
+func (e *jsonEncoder) record(resource string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if e.first {
+		sep = ""
+	}
+	e.first = false
+	_, err = fmt.Fprintf(e.w, "%s\n    %s", sep, raw)
+	return err
+}
+
+func (e *jsonEncoder) end() error {
+	_, err := io.WriteString(e.w, "\n  ]\n}\n")
+	return err
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) begin(resource string) error {
+	return e.w.Write(csvHeader(newRecord(resource)))
+}
+
+func (e *csvEncoder) record(resource string, v interface{}) error {
+	if err := e.w.Write(csvRow(v)); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
@@ -0,0 +1,660 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"nx_trainee_forum/forum/i18n"
+	"nx_trainee_forum/forum/markdown"
+	"nx_trainee_forum/forum/models"
+
+	"gorm.io/gorm"
+)
+
+// Conflict strategies for records matching a stored row: users match by
+// login, categories by name and parent, posts by author, title and creation
+// time, comments by post, author and creation time.
+const (
+	ConflictSkip      = "skip"      //keep the stored row and refer to it
+	ConflictOverwrite = "overwrite" //replace the stored row with the record
+	ConflictFail      = "fail"      //import nothing
+)
+
+var (
+	ErrInvalidConflict = errors.New("conflict must be skip, overwrite or fail")
+	ErrInvalid         = errors.New("invalid records, nothing was imported")
+	ErrConflict        = errors.New("record conflicts with a stored row, nothing was imported")
+
+	errDryRun = errors.New("dry run")
+)
+
+// maxErrors is the number of record errors after which an import stops.
+const maxErrors = 100
+
+// maxLine is the longest NDJSON line accepted.
+const maxLine = 16 << 20
+
+type Options struct {
+	Format   string
+	Resource string //resource of a CSV import
+	Conflict string //ConflictSkip if empty
+	DryRun   bool   //validate and count, then roll back
+	//StoredRefs lets references to records missing from the archive refer to
+	//the stored rows with those IDs. CSV imports, which hold one resource,
+	//always do.
+	StoredRefs bool
+}
+
+type Count struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+}
+
+// RecordError locates an invalid record: the line of an NDJSON or CSV file,
+// or the position in its array of a JSON archive.
+type RecordError struct {
+	Resource string `json:"resource,omitempty"`
+	Line     int    `json:"line"`
+	ID       int    `json:"id,omitempty"`
+	Error    string `json:"error"`
+}
+
+func (e RecordError) String() string {
+	switch {
+	case e.Resource == "":
+		return fmt.Sprintf("line %d: %s", e.Line, e.Error)
+	case e.ID == 0:
+		return fmt.Sprintf("%s line %d: %s", e.Resource, e.Line, e.Error)
+	}
+	return fmt.Sprintf("%s line %d (id %d): %s", e.Resource, e.Line, e.ID, e.Error)
+}
+
+type Report struct {
+	DryRun   bool              `json:"dryRun"`
+	Conflict string            `json:"conflict"`
+	Counts   map[string]*Count `json:"counts"`
+	Errors   []RecordError     `json:"errors,omitempty"`
+}
+
+type outcome int
+
+const (
+	created outcome = iota
+	updated
+	skipped
+)
+
+// accepted is an accepted answer set once every comment is imported.
+type accepted struct {
+	line, postID, commentID int
+}
+
+type importer struct {
+	tx     *gorm.DB
+	opt    Options
+	report *Report
+	stop   bool //too many errors or a conflict
+	failed bool //a conflict with ConflictFail
+
+	//IDs of the archive mapped to the stored ones
+	users, categories, posts, comments map[int]int
+	//stored posts and comments matched or created by a record
+	matchedPosts, matchedComments map[int]bool
+
+	accepted []accepted
+	deleted  []int //comments to turn into tombstones
+	//rows created, whose rendered bodies must leave the cache on rollback
+	createdPosts, createdComments []int
+}
+
+// Import stores the records read from r in one transaction. Records get new
+// IDs and references between them are remapped, so an archive can be
+// imported into a forum that already has content; records must come after
+// the ones they refer to, as they do in an export. References to records
+// missing from the archive keep their IDs if such rows are stored. If any record is invalid
+// nothing is imported, the error is ErrInvalid and the report lists the
+// invalid records. A dry run reports the same without storing anything.
+func Import(db *gorm.DB, r io.Reader, opt Options) (*Report, error) {
+	if opt.Conflict == "" {
+		opt.Conflict = ConflictSkip
+	}
+	if opt.Conflict != ConflictSkip && opt.Conflict != ConflictOverwrite && opt.Conflict != ConflictFail {
+		return nil, ErrInvalidConflict
+	}
+	if !ValidFormat(opt.Format) {
+		return nil, ErrInvalidFormat
+	}
+	if opt.Format == FormatCSV && !ValidResource(opt.Resource) {
+		return nil, ErrCSVResource
+	}
+	imp := &importer{opt: opt, report: &Report{DryRun: opt.DryRun, Conflict: opt.Conflict, Counts: map[string]*Count{}},
+		users: map[int]int{}, categories: map[int]int{}, posts: map[int]int{}, comments: map[int]int{},
+		matchedPosts: map[int]bool{}, matchedComments: map[int]bool{}}
+	src := &reader{r: r}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		imp.tx = tx
+		var err error
+		switch opt.Format {
+		case FormatNDJSON:
+			err = imp.readNDJSON(src)
+		case FormatCSV:
+			err = imp.readCSV(src)
+		case FormatJSON:
+			err = imp.readJSON(src)
+		}
+		if src.err != nil {
+			return src.err
+		}
+		if err != nil {
+			return err
+		}
+		if !imp.stop {
+			if err = imp.finish(); err != nil {
+				return err
+			}
+		}
+		switch {
+		case imp.failed:
+			return ErrConflict
+		case len(imp.report.Errors) > 0:
+			return ErrInvalid
+		case opt.DryRun:
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil {
+		for _, id := range imp.createdPosts {
+			markdown.Invalidate(markdown.PostKey(id))
+		}
+		for _, id := range imp.createdComments {
+			markdown.Invalidate(markdown.CommentKey(id))
+		}
+	}
+	switch {
+	case err == errDryRun:
+		return imp.report, nil
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrInvalid):
+		return imp.report, err
+	case err != nil:
+		return nil, err
+	}
+	return imp.report, nil
+}
+
+// reader keeps the first error of r other than io.EOF, so that a failing
+// source is not taken for a malformed file.
+type reader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (imp *importer) fail(resource string, line, id int, err error) {
+	imp.report.Errors = append(imp.report.Errors, RecordError{Resource: resource, Line: line, ID: id, Error: err.Error()})
+	imp.failed = imp.failed || errors.Is(err, ErrConflict)
+	imp.stop = imp.failed || len(imp.report.Errors) >= maxErrors
+}
+
+// add stores the record rec of resource found on line.
+func (imp *importer) add(resource string, line int, rec interface{}) {
+	var out outcome
+	var id int
+	var err error
+	switch rec := rec.(type) {
+	case *UserRecord:
+		id = rec.ID
+		out, err = imp.user(rec)
+	case *CategoryRecord:
+		id = rec.ID
+		out, err = imp.category(rec)
+	case *PostRecord:
+		id = rec.ID
+		out, err = imp.post(rec, line)
+	case *CommentRecord:
+		id = rec.ID
+		out, err = imp.comment(rec)
+	}
+	if err != nil {
+		imp.fail(resource, line, id, err)
+		return
+	}
+	c := imp.report.Counts[resource]
+	if c == nil {
+		c = &Count{}
+		imp.report.Counts[resource] = c
+	}
+	switch out {
+	case created:
+		c.Created++
+	case updated:
+		c.Updated++
+	case skipped:
+		c.Skipped++
+	}
+}
+
+// conflict applies the conflict strategy to a record matching the stored row
+// id; overwrite stores the record over it.
+func (imp *importer) conflict(id int, overwrite func() error) (outcome, error) {
+	switch imp.opt.Conflict {
+	case ConflictFail:
+		return 0, fmt.Errorf("%w (id %d)", ErrConflict, id)
+	case ConflictOverwrite:
+		return updated, overwrite()
+	}
+	return skipped, nil
+}
+
+// userColumns are stored from user records, leaving credentials and avatar
+// alone.
+var userColumns = []string{"Login", "Provider", "Name", "Role", "Email", "EmailNotifications", "Language", "Bio",
+	"ProfileVisibility", "ShowEmail", "Phone", "Website", "Street", "Suite", "City", "Zipcode", "Lat", "Lng",
+	"CompanyName", "CatchPhrase", "BS"}
+
+func (imp *importer) user(rec *UserRecord) (outcome, error) {
+	switch {
+	case strings.TrimSpace(rec.Login) == "":
+		return 0, errors.New("login must not be empty")
+	case rec.Role != "" && !models.ValidRole(rec.Role):
+		return 0, errors.New("unknown role")
+	case rec.EmailNotifications != "" && !models.ValidEmailPreference(rec.EmailNotifications):
+		return 0, errors.New("unknown email notifications")
+	case rec.ProfileVisibility != "" && !models.ValidProfileVisibility(rec.ProfileVisibility):
+		return 0, errors.New("unknown profile visibility")
+	case rec.Language != "" && !i18n.Supported(rec.Language):
+		return 0, errors.New("unknown language")
+	}
+	if rec.Role == "" {
+		rec.Role = models.RoleUser
+	}
+	if rec.EmailNotifications == "" {
+		rec.EmailNotifications = models.EmailDigest
+	}
+	if rec.ProfileVisibility == "" {
+		rec.ProfileVisibility = models.ProfilePublic
+	}
+	u := models.User{}
+	result := imp.tx.Where("login = ?", rec.Login).Limit(1).Find(&u)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected > 0 {
+		imp.users[rec.ID] = u.ID
+		return imp.conflict(u.ID, func() error {
+			rec.apply(&u)
+			return imp.tx.Model(&u).Select(userColumns).Updates(&u).Error
+		})
+	}
+	rec.apply(&u)
+	if err := imp.tx.Select(userColumns).Create(&u).Error; err != nil {
+		return 0, err
+	}
+	imp.users[rec.ID] = u.ID
+	return created, nil
+}
+
+func (imp *importer) category(rec *CategoryRecord) (outcome, error) {
+	if strings.TrimSpace(rec.Name) == "" {
+		return 0, errors.New("name must not be empty")
+	}
+	var parentID *int
+	if rec.ParentID != nil {
+		id, ok := imp.ref(imp.categories, &models.Category{}, *rec.ParentID)
+		if !ok {
+			return 0, errors.New("unknown parent category")
+		}
+		parentID = &id
+	}
+	c := models.Category{ParentID: parentID, Name: rec.Name, Description: rec.Description, Position: rec.Position,
+		ReadRole: rec.ReadRole, PostRole: rec.PostRole}
+	stored := models.Category{}
+	tx := imp.tx.Where("name = ?", rec.Name)
+	if parentID == nil {
+		tx = tx.Where("parentId IS NULL")
+	} else {
+		tx = tx.Where("parentId = ?", *parentID)
+	}
+	result := tx.Limit(1).Find(&stored)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	capr := models.CategoryProcess{}
+	if result.RowsAffected > 0 {
+		imp.categories[rec.ID] = stored.ID
+		return imp.conflict(stored.ID, func() error {
+			c.ID = stored.ID
+			return capr.UpdateCategory(imp.tx, &c).Error
+		})
+	}
+	if err := capr.CreateCategory(imp.tx, &c).Error; err != nil {
+		return 0, err
+	}
+	imp.categories[rec.ID] = c.ID
+	return created, nil
+}
+
+func (imp *importer) post(rec *PostRecord, line int) (outcome, error) {
+	userID, ok := imp.ref(imp.users, &models.User{}, rec.UserID)
+	if !ok {
+		return 0, errors.New("unknown user")
+	}
+	var categoryID *int
+	if rec.CategoryID != nil {
+		id, ok := imp.ref(imp.categories, &models.Category{}, *rec.CategoryID)
+		if !ok {
+			return 0, errors.New("unknown category")
+		}
+		categoryID = &id
+	}
+	switch {
+	case strings.TrimSpace(rec.Title) == "":
+		return 0, errors.New("title must not be empty")
+	case strings.TrimSpace(rec.Body) == "":
+		return 0, errors.New("body must not be empty")
+	case rec.Type != "" && !models.ValidPostType(rec.Type):
+		return 0, models.ErrInvalidPostType
+	}
+	if rec.Tags == nil {
+		rec.Tags = []string{}
+	}
+	if _, err := models.NormalizeTags(rec.Tags); err != nil {
+		return 0, err
+	}
+	p := models.Post{UserID: userID, CategoryID: categoryID, Type: rec.Type, Title: rec.Title, Body: rec.Body,
+		Tags: rec.Tags, CreatedAt: rec.CreatedAt, UpdatedAt: rec.UpdatedAt}
+	ppr := models.PostProcess{}
+	if !rec.CreatedAt.IsZero() {
+		storedID, err := imp.match(imp.tx.Model(&models.Post{}).
+			Where("userId = ? AND title = ? AND createdAt = ?", userID, rec.Title, rec.CreatedAt), imp.matchedPosts)
+		if err != nil {
+			return 0, err
+		}
+		if storedID != 0 {
+			imp.posts[rec.ID] = storedID
+			return imp.conflict(storedID, func() error {
+				p.ID = storedID
+				if p.Type == "" {
+					p.Type = models.PostTypeDiscussion
+				}
+				if err := ppr.UpdatePost(imp.tx, &p).Error; err != nil {
+					return err
+				}
+				update := map[string]interface{}{"categoryId": categoryID}
+				if !rec.UpdatedAt.IsZero() {
+					update["updatedAt"] = rec.UpdatedAt
+				}
+				if err := imp.tx.Model(&models.Post{}).Where("id = ?", p.ID).Updates(update).Error; err != nil {
+					return err
+				}
+				imp.accept(line, p.ID, rec.AcceptedCommentID)
+				return nil
+			})
+		}
+	}
+	if err := ppr.CreatePost(imp.tx, &p).Error; err != nil {
+		return 0, err
+	}
+	imp.posts[rec.ID] = p.ID
+	imp.createdPosts = append(imp.createdPosts, p.ID)
+	imp.matchedPosts[p.ID] = true
+	imp.accept(line, p.ID, rec.AcceptedCommentID)
+	return created, nil
+}
+
+// ref maps the archive ID id through m. With StoredRefs, IDs of records
+// missing from the archive refer to the stored row of model with that ID, if
+// any, so that a single resource can be imported into the forum it was
+// exported from; otherwise they are unknown.
+func (imp *importer) ref(m map[int]int, model interface{}, id int) (int, bool) {
+	if stored, ok := m[id]; ok {
+		return stored, true
+	}
+	if !imp.opt.StoredRefs && imp.opt.Format != FormatCSV {
+		return 0, false
+	}
+	var n int64
+	if imp.tx.Model(model).Where("id = ?", id).Count(&n); n == 0 {
+		return 0, false
+	}
+	m[id] = id
+	return id, true
+}
+
+// match returns the ID of the first row of tx, by ID, not matched by an
+// earlier record, or 0. Rows created within the same millisecond thus pair up
+// with their records in order.
+func (imp *importer) match(tx *gorm.DB, matched map[int]bool) (int, error) {
+	ids := []int{}
+	if err := tx.Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if !matched[id] {
+			matched[id] = true
+			return id, nil
+		}
+	}
+	return 0, nil
+}
+
+func (imp *importer) accept(line, postID int, commentID *int) {
+	if commentID != nil {
+		imp.accepted = append(imp.accepted, accepted{line: line, postID: postID, commentID: *commentID})
+	}
+}
+
+func (imp *importer) comment(rec *CommentRecord) (outcome, error) {
+	postID, ok := imp.ref(imp.posts, &models.Post{}, rec.PostID)
+	if !ok {
+		return 0, errors.New("unknown post")
+	}
+	userID, ok := imp.ref(imp.users, &models.User{}, rec.UserID)
+	if !ok {
+		return 0, errors.New("unknown user")
+	}
+	var parentID *int
+	if rec.ParentID != nil {
+		id, ok := imp.ref(imp.comments, &models.Comment{}, *rec.ParentID)
+		if !ok {
+			return 0, models.ErrInvalidParent
+		}
+		parentID = &id
+	}
+	if !rec.Deleted && strings.TrimSpace(rec.Body) == "" {
+		return 0, errors.New("body must not be empty")
+	}
+	c := models.Comment{PostID: postID, UserID: userID, ParentID: parentID, Name: rec.Name, Email: rec.Email,
+		Body: rec.Body, CreatedAt: rec.CreatedAt, UpdatedAt: rec.UpdatedAt}
+	cpr := models.CommentProcess{}
+	if !rec.CreatedAt.IsZero() {
+		storedID, err := imp.match(imp.tx.Model(&models.Comment{}).
+			Where("postId = ? AND userId = ? AND createdAt = ?", postID, userID, rec.CreatedAt), imp.matchedComments)
+		if err != nil {
+			return 0, err
+		}
+		if storedID != 0 {
+			imp.comments[rec.ID] = storedID
+			return imp.conflict(storedID, func() error {
+				c.ID = storedID
+				if err := cpr.UpdateComment(imp.tx, &c).Error; err != nil {
+					return err
+				}
+				if rec.Deleted {
+					imp.deleted = append(imp.deleted, c.ID)
+				}
+				return nil
+			})
+		}
+	}
+	if err := cpr.CreateComment(imp.tx, &c).Error; err != nil {
+		return 0, err
+	}
+	imp.comments[rec.ID] = c.ID
+	imp.createdComments = append(imp.createdComments, c.ID)
+	imp.matchedComments[c.ID] = true
+	//replies to deleted comments are created first
+	if rec.Deleted {
+		imp.deleted = append(imp.deleted, c.ID)
+	}
+	return created, nil
+}
+
+// finish sets accepted answers and turns deleted comments into tombstones
+// once every record is stored.
+func (imp *importer) finish() error {
+	for _, a := range imp.accepted {
+		id, ok := imp.ref(imp.comments, &models.Comment{}, a.commentID)
+		if !ok {
+			imp.fail(ResourcePosts, a.line, a.postID, errors.New("unknown accepted comment"))
+			continue
+		}
+		err := imp.tx.Model(&models.Post{}).Where("id = ?", a.postID).Update("acceptedCommentId", id).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(imp.deleted) == 0 {
+		return nil
+	}
+	return imp.tx.Model(&models.Comment{}).Where("id IN ?", imp.deleted).
+		Updates(map[string]interface{}{"deleted": true, "name": "", "email": "", "body": ""}).Error
+}
+
+func (imp *importer) readNDJSON(r io.Reader) error {
+	types := make(map[string]string, len(recordTypes))
+	for resource, t := range recordTypes {
+		types[t] = resource
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxLine)
+	for n := 1; !imp.stop && sc.Scan(); n++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var l line
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			imp.fail("", n, 0, err)
+			continue
+		}
+		if l.Type == typeError {
+			imp.fail("", n, 0, fmt.Errorf("the export failed: %s", l.Error))
+			continue
+		}
+		resource, ok := types[l.Type]
+		if !ok {
+			imp.fail("", n, 0, fmt.Errorf("unknown type %q", l.Type))
+			continue
+		}
+		rec := newRecord(resource)
+		if err := json.Unmarshal(l.Record, rec); err != nil {
+			imp.fail(resource, n, 0, err)
+			continue
+		}
+		imp.add(resource, n, rec)
+	}
+	return sc.Err()
+}
+
+func (imp *importer) readCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		imp.fail(imp.opt.Resource, 1, 0, err)
+		return nil
+	}
+	columns, err := csvColumns(newRecord(imp.opt.Resource), header)
+	if err != nil {
+		imp.fail(imp.opt.Resource, 1, 0, err)
+		return nil
+	}
+	for !imp.stop {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		n, _ := cr.FieldPos(0)
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				return err
+			}
+			imp.fail(imp.opt.Resource, perr.Line, 0, perr.Err)
+			if perr.Err != csv.ErrFieldCount {
+				break //the reader cannot resync after a quoting error
+			}
+			continue
+		}
+		rec := newRecord(imp.opt.Resource)
+		if err = csvScan(rec, columns, row); err != nil {
+			imp.fail(imp.opt.Resource, n, 0, err)
+			continue
+		}
+		imp.add(imp.opt.Resource, n, rec)
+	}
+	return nil
+}
+
+// readJSON streams the arrays of a JSON archive record by record; other keys
+// are skipped. A malformed archive stops the import.
+func (imp *importer) readJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	malformed := func(err error) error {
+		imp.fail("", 0, 0, err)
+		return nil
+	}
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return malformed(errors.New("archive must be a JSON object"))
+	}
+	for !imp.stop && dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return malformed(err)
+		}
+		resource, _ := t.(string)
+		if !ValidResource(resource) {
+			var skip json.RawMessage
+			if err = dec.Decode(&skip); err != nil {
+				return malformed(err)
+			}
+			continue
+		}
+		if t, err = dec.Token(); err != nil || t != json.Delim('[') {
+			return malformed(fmt.Errorf("%s must be an array", resource))
+		}
+		for n := 1; !imp.stop && dec.More(); n++ {
+			rec := newRecord(resource)
+			if err = dec.Decode(rec); err != nil {
+				if _, ok := err.(*json.UnmarshalTypeError); !ok {
+					return malformed(err)
+				}
+				imp.fail(resource, n, 0, err)
+				continue
+			}
+			imp.add(resource, n, rec)
+		}
+		if imp.stop {
+			break
+		}
+		if _, err = dec.Token(); err != nil {
+			return malformed(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"io"
+
+	"nx_trainee_forum/forum/models"
+
+	"gorm.io/gorm"
+)
+
+// Sections of a personal data export besides the resources.
+const (
+	SectionSessions = "sessions"
+	SectionAudit    = "audit"
+)
+
+// sessionActions are the audit events that make up the sessions of a user:
+// a session is the access token issued on login and revoked on logout.
+var sessionActions = []string{models.AuditLoginSuccess, models.AuditLogout}
+
+// ExportUser writes everything stored about user id to w as a JSON archive:
+// the profile (without credentials), posts, comments, sessions and the audit
+// events by or about the user. The archive can be imported like an export,
+// the sessions and audit sections are skipped then.
+func ExportUser(db *gorm.DB, w io.Writer, id int) error {
+	enc := &jsonEncoder{w: w}
+	flush := func() {
+		if f, ok := w.(flusher); ok {
+			f.Flush()
+		}
+	}
+	owned := map[string]*gorm.DB{
+		ResourceUsers:    db.Where("id = ?", id),
+		ResourcePosts:    db.Where("userId = ?", id),
+		ResourceComments: db.Where("userId = ?", id),
+	}
+	for _, r := range []string{ResourceUsers, ResourcePosts, ResourceComments} {
+		if err := enc.begin(r); err != nil {
+			return err
+		}
+		err := each(owned[r], r, func(v interface{}) error { return enc.record(r, v) }, flush)
+		if err != nil {
+			return err
+		}
+	}
+	apr := models.AuditProcess{}
+	f := models.AuditFilter{Params: map[string]interface{}{}}
+	sections := map[string]*gorm.DB{
+		SectionSessions: db.Where("actorId = ? AND action IN ?", id, sessionActions),
+		SectionAudit: db.Where("(actorId = ? OR (targetType = ? AND targetId = ?)) AND action NOT IN ?",
+			id, "user", id, sessionActions),
+	}
+	for _, s := range []string{SectionSessions, SectionAudit} {
+		if err := enc.begin(s); err != nil {
+			return err
+		}
+		err := apr.EachEvent(sections[s], f, func(e models.AuditEvent) error { return enc.record(s, e) })
+		if err != nil {
+			return err
+		}
+		flush()
+	}
+	return enc.end()
+}
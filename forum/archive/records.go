@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"encoding/json"
+	"time"
+
+	"nx_trainee_forum/forum/models"
+)
+
+// Formats of exports and imports.
+const (
+	FormatNDJSON = "ndjson" //one {"type": ..., "record": ...} object per line
+	FormatCSV    = "csv"    //a header row and one row per record of a single resource
+	FormatJSON   = "json"   //one object with an array per resource
+)
+
+// Resources in the order they are exported and must be imported: every
+// record refers only to records of earlier resources or earlier records.
+const (
+	ResourceUsers      = "users"
+	ResourceCategories = "categories"
+	ResourcePosts      = "posts"
+	ResourceComments   = "comments"
+)
+
+var Resources = []string{ResourceUsers, ResourceCategories, ResourcePosts, ResourceComments}
+
+// recordTypes names a single record of each resource in NDJSON lines.
+var recordTypes = map[string]string{
+	ResourceUsers: "user", ResourceCategories: "category", ResourcePosts: "post", ResourceComments: "comment",
+}
+
+func ValidFormat(f string) bool {
+	return f == FormatNDJSON || f == FormatCSV || f == FormatJSON
+}
+
+func ValidResource(r string) bool {
+	_, ok := recordTypes[r]
+	return ok
+}
+
+// typeError is the type of the last line of an NDJSON export that failed
+// after it was started.
+const typeError = "error"
+
+// line is a record of an NDJSON export.
+type line struct {
+	Type   string          `json:"type"`
+	Record json.RawMessage `json:"record,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// UserRecord is a user without credentials. Login and Provider are kept so
+// the user can sign in again after a migration.
+type UserRecord struct {
+	ID                 int    `json:"id"`
+	Login              string `json:"login"`
+	Provider           string `json:"provider"`
+	Name               string `json:"name"`
+	Role               string `json:"role"`
+	Email              string `json:"email"`
+	EmailNotifications string `json:"emailNotifications"`
+	Language           string `json:"language"`
+	Bio                string `json:"bio"`
+	ProfileVisibility  string `json:"profileVisibility"`
+	ShowEmail          bool   `json:"showEmail"`
+	Phone              string `json:"phone"`
+	Website            string `json:"website"`
+	Street             string `json:"street"`
+	Suite              string `json:"suite"`
+	City               string `json:"city"`
+	Zipcode            string `json:"zipcode"`
+	Lat                string `json:"lat"`
+	Lng                string `json:"lng"`
+	CompanyName        string `json:"companyName"`
+	CatchPhrase        string `json:"catchPhrase"`
+	BS                 string `json:"bs"`
+}
+
+type CategoryRecord struct {
+	ID          int    `json:"id"`
+	ParentID    *int   `json:"parentId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Position    int    `json:"position"`
+	ReadRole    string `json:"readRole"`
+	PostRole    string `json:"postRole"`
+}
+
+type PostRecord struct {
+	ID                int       `json:"id"`
+	UserID            int       `json:"userId"`
+	CategoryID        *int      `json:"categoryId"`
+	Type              string    `json:"type"`
+	Title             string    `json:"title"`
+	Body              string    `json:"body"`
+	Tags              []string  `json:"tags"`
+	AcceptedCommentID *int      `json:"acceptedCommentId"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+type CommentRecord struct {
+	ID        int       `json:"id"`
+	PostID    int       `json:"postId"`
+	UserID    int       `json:"userId"`
+	ParentID  *int      `json:"parentId"`
+	Deleted   bool      `json:"deleted"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// newRecord returns a pointer to an empty record of resource.
+func newRecord(resource string) interface{} {
+	switch resource {
+	case ResourceUsers:
+		return &UserRecord{}
+	case ResourceCategories:
+		return &CategoryRecord{}
+	case ResourcePosts:
+		return &PostRecord{}
+	case ResourceComments:
+		return &CommentRecord{}
+	}
+	return nil
+}
+
+func userRecord(u models.User) UserRecord {
+	return UserRecord{ID: u.ID, Login: u.Login, Provider: u.Provider, Name: u.Name, Role: u.Role, Email: u.Email,
+		EmailNotifications: u.EmailNotifications, Language: u.Language, Bio: u.Bio, ProfileVisibility: u.ProfileVisibility,
+		ShowEmail: u.ShowEmail, Phone: u.Phone, Website: u.Website, Street: u.Street, Suite: u.Suite, City: u.City,
+		Zipcode: u.Zipcode, Lat: u.Lat, Lng: u.Lng, CompanyName: u.CompanyName, CatchPhrase: u.CatchPhrase, BS: u.BS}
+}
+
+// apply copies the record over u, leaving ID, credentials and avatar alone.
+func (r *UserRecord) apply(u *models.User) {
+	u.Login, u.Provider, u.Name, u.Role, u.Email = r.Login, r.Provider, r.Name, r.Role, r.Email
+	u.EmailNotifications, u.Language, u.Bio, u.ProfileVisibility, u.ShowEmail = r.EmailNotifications, r.Language, r.Bio, r.ProfileVisibility, r.ShowEmail
+	u.Phone, u.Website, u.Street, u.Suite, u.City, u.Zipcode, u.Lat, u.Lng = r.Phone, r.Website, r.Street, r.Suite, r.City, r.Zipcode, r.Lat, r.Lng
+	u.CompanyName, u.CatchPhrase, u.BS = r.CompanyName, r.CatchPhrase, r.BS
+}
+
+func categoryRecord(c models.Category) CategoryRecord {
+	return CategoryRecord{ID: c.ID, ParentID: c.ParentID, Name: c.Name, Description: c.Description, Position: c.Position,
+		ReadRole: c.ReadRole, PostRole: c.PostRole}
+}
+
+func postRecord(p models.Post) PostRecord {
+	return PostRecord{ID: p.ID, UserID: p.UserID, CategoryID: p.CategoryID, Type: p.Type, Title: p.Title, Body: p.Body,
+		Tags: p.Tags, AcceptedCommentID: p.AcceptedID, CreatedAt: p.CreatedAt, UpdatedAt: p.UpdatedAt}
+}
+
+func commentRecord(c models.Comment) CommentRecord {
+	return CommentRecord{ID: c.ID, PostID: c.PostID, UserID: c.UserID, ParentID: c.ParentID, Deleted: c.Deleted,
+		Name: c.Name, Email: c.Email, Body: c.Body, CreatedAt: c.CreatedAt, UpdatedAt: c.UpdatedAt}
+}
@@ -0,0 +1,233 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hashLength is the number of hex digits of the content hash in URLs.
+const hashLength = 10
+
+var reHashed = regexp.MustCompile(`^(.+)\.([0-9a-f]{10})(\.[^./]+)$`)
+
+// Overlay returns fsys with the files of directory dir put over it: a file
+// present in dir is read from there, any other from fsys. An empty dir
+// returns fsys itself.
+func Overlay(dir string, fsys fs.FS) fs.FS {
+	if dir == "" {
+		return fsys
+	}
+	return overlay{top: os.DirFS(dir), bottom: fsys}
+}
+
+type overlay struct {
+	top, bottom fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.bottom.Open(name)
+	}
+	return f, err
+}
+
+// ReadDir merges the entries of both file systems, the top one winning.
+func (o overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	top, errTop := fs.ReadDir(o.top, name)
+	bottom, errBottom := fs.ReadDir(o.bottom, name)
+	if errTop != nil && errBottom != nil {
+		return nil, errBottom
+	}
+	entries := make(map[string]fs.DirEntry, len(top)+len(bottom))
+	for _, e := range bottom {
+		entries[e.Name()] = e
+	}
+	for _, e := range top {
+		entries[e.Name()] = e
+	}
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// Server serves static files with content-hashed URLs. A request for the
+// hashed name of the current content is cached by clients for a year;
+// plain names are revalidated with ETag. Precompressed sibling files with
+// the .gz extension (made by go generate for the embedded files) and the .br
+// extension (none are shipped, e.g. `brotli -k` makes them) are sent to
+// clients accepting gzip or brotli; text files without a .gz copy, such as
+// overrides on disk, are gzipped once on first use. Files are read once when
+// the server is made.
+type Server struct {
+	prefix string
+	files  map[string]*file
+}
+
+type file struct {
+	name    string
+	hash    string
+	typ     string
+	content []byte
+	br      []byte
+
+	gzOnce sync.Once
+	gz     []byte
+}
+
+// New reads the files of fsys; prefix is the URL path they are served at.
+func New(fsys fs.FS, prefix string) (*Server, error) {
+	s := &Server{prefix: prefix, files: make(map[string]*file)}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) == ".br" || path.Ext(name) == ".gz" || path.Ext(name) == ".go" {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		f := &file{name: name, hash: hex.EncodeToString(sum[:])[:hashLength], content: content}
+		if f.typ = mime.TypeByExtension(path.Ext(name)); f.typ == "" {
+			f.typ = http.DetectContentType(content)
+		}
+		if br, err := fs.ReadFile(fsys, name+".br"); err == nil {
+			f.br = br
+		}
+		if gz, err := fs.ReadFile(fsys, name+".gz"); err == nil && gunzips(gz, content) {
+			f.gzOnce.Do(func() { f.gz = gz })
+		}
+		s.files[name] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Path returns the URL of the file name with its content hash, so it
+// changes whenever the file does. Unknown files get their plain URL.
+func (s *Server) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	f, ok := s.files[name]
+	if !ok {
+		return s.prefix + name
+	}
+	ext := path.Ext(name)
+	return s.prefix + strings.TrimSuffix(name, ext) + "." + f.hash + ext
+}
+
+// ServeHTTP serves the file of the request path relative to the prefix.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, s.prefix)
+	f, ok := s.files[name]
+	immutable := false
+	if !ok {
+		if m := reHashed.FindStringSubmatch(name); m != nil {
+			if f, ok = s.files[m[1]+m[3]]; ok {
+				//an old hash gets the current content, which must not be cached for long
+				immutable = f.hash == m[2]
+			}
+		}
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if immutable {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("Content-Type", f.typ)
+	w.Header().Set("Vary", "Accept-Encoding")
+	content, etag := f.content, f.hash
+	switch accepts := r.Header.Get("Accept-Encoding"); {
+	case f.br != nil && acceptsEncoding(accepts, "br"):
+		content, etag = f.br, f.hash+"-br"
+		w.Header().Set("Content-Encoding", "br")
+	case compressible(f.typ) && acceptsEncoding(accepts, "gzip"):
+		if gz := f.gzipped(); gz != nil {
+			content, etag = gz, f.hash+"-gz"
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+}
+
+// gzipped returns the content compressed once on first use, or nil if
+// compression does not make it smaller.
+func (f *file) gzipped() []byte {
+	f.gzOnce.Do(func() {
+		var b bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&b, gzip.BestCompression)
+		zw.Write(f.content)
+		if zw.Close() == nil && b.Len() < len(f.content) {
+			f.gz = b.Bytes()
+		}
+	})
+	return f.gz
+}
+
+// gunzips reports whether gz decompresses to content, so that a copy left
+// over from a file overridden on disk is not served.
+func gunzips(gz, content []byte) bool {
+	zr, err := gzip.NewReader(bytes.NewReader(gz))
+	if err != nil {
+		return false
+	}
+	plain, err := io.ReadAll(zr)
+	return err == nil && bytes.Equal(plain, content)
+}
+
+func compressible(typ string) bool {
+	typ = strings.TrimSpace(strings.SplitN(typ, ";", 2)[0])
+	switch {
+	case strings.HasPrefix(typ, "text/"), strings.HasSuffix(typ, "javascript"), strings.HasSuffix(typ, "json"),
+		strings.HasSuffix(typ, "xml"), typ == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows coding.
+func acceptsEncoding(header, coding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if strings.TrimSpace(fields[0]) != coding && strings.TrimSpace(fields[0]) != "*" {
+			continue
+		}
+		q := ""
+		for _, p := range fields[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				q = strings.TrimPrefix(p, "q=")
+			}
+		}
+		return q == "" || strings.Trim(q, "0.") != ""
+	}
+	return false
+}
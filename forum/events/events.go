@@ -0,0 +1,189 @@
+package events
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of events.
+const (
+	PostCreated    = "post.created"
+	PostUpdated    = "post.updated"
+	PostDeleted    = "post.deleted"
+	CommentCreated = "comment.created"
+	CommentUpdated = "comment.updated"
+	CommentDeleted = "comment.deleted"
+	Notification   = "notification" //personal, only for the notified user
+)
+
+// subscriberBuffer is the number of events a subscriber may lag behind before
+// it is dropped; it can resume from the last event it received.
+const subscriberBuffer = 64
+
+// Event is published on the bus. Data is sent to subscribers as is.
+type Event struct {
+	ID         string      `json:"-"`
+	Type       string      `json:"type"`
+	ActorID    int         `json:"actorId,omitempty"`
+	PostID     int         `json:"postId,omitempty"`
+	CommentID  *int        `json:"commentId,omitempty"`
+	CategoryID *int        `json:"categoryId,omitempty"`
+	UserID     int         `json:"-"` //recipient of a personal event
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// Filter selects the events a subscriber receives: those of post PostID, of
+// the posts in category CategoryID, or all of them when both are 0. Events
+// in Hidden categories are never delivered, and personal events only to
+// UserID.
+type Filter struct {
+	UserID     int
+	PostID     int
+	CategoryID int
+	Hidden     []int
+}
+
+func (f Filter) Match(e Event) bool {
+	if e.Type == Notification {
+		return f.UserID != 0 && e.UserID == f.UserID
+	}
+	if e.CategoryID != nil {
+		for _, id := range f.Hidden {
+			if id == *e.CategoryID {
+				return false
+			}
+		}
+	}
+	if f.PostID != 0 && e.PostID != f.PostID {
+		return false
+	}
+	if f.CategoryID != 0 && (e.CategoryID == nil || *e.CategoryID != f.CategoryID) {
+		return false
+	}
+	return true
+}
+
+type Subscription struct {
+	// C receives the events; it is closed when the subscriber lags behind.
+	C      <-chan Event
+	c      chan Event
+	filter Filter
+}
+
+// Bus delivers events to subscribers and listeners within the process. It
+// keeps the last events so that subscribers can resume after reconnecting.
+// Event IDs start with the time the bus was created, so IDs of an earlier
+// process are recognized and never mistaken for recent ones.
+type Bus struct {
+	mu        sync.Mutex
+	epoch     string
+	seq       uint64
+	history   []Event
+	size      int
+	subs      map[*Subscription]bool
+	listeners []func(Event)
+}
+
+// New creates a bus remembering the last history events.
+func New(history int) *Bus {
+	return &Bus{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:  history,
+		subs:  make(map[*Subscription]bool),
+	}
+}
+
+// Listen registers f to be called with every published event, in order, on
+// the publishing goroutine. Listeners should return quickly.
+func (b *Bus) Listen(f func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, f)
+}
+
+// Publish assigns e an ID and delivers it.
+func (b *Bus) Publish(e Event) Event {
+	b.mu.Lock()
+	b.seq++
+	e.ID = b.epoch + "-" + strconv.FormatUint(b.seq, 10)
+	if b.size > 0 {
+		if len(b.history) == b.size {
+			b.history = append(b.history[:0], b.history[1:]...)
+		}
+		b.history = append(b.history, e)
+	}
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			delete(b.subs, s)
+			close(s.c)
+		}
+	}
+	listeners := b.listeners
+	b.mu.Unlock()
+	for _, f := range listeners {
+		f(e)
+	}
+	return e
+}
+
+// Subscribe starts delivering events matching f. With lastID, the remembered
+// events published after it are returned to be sent first; ok is false if
+// they are no longer all remembered, and the subscriber should reload
+// instead.
+func (b *Bus) Subscribe(f Filter, lastID string) (s *Subscription, missed []Event, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ok = true
+	if lastID != "" {
+		missed, ok = b.since(lastID)
+		matching := missed[:0]
+		for _, e := range missed {
+			if f.Match(e) {
+				matching = append(matching, e)
+			}
+		}
+		missed = matching
+	}
+	c := make(chan Event, subscriberBuffer)
+	s = &Subscription{C: c, c: c, filter: f}
+	b.subs[s] = true
+	return s, missed, ok
+}
+
+// since returns a copy of the remembered events published after lastID.
+func (b *Bus) since(lastID string) ([]Event, bool) {
+	i := strings.LastIndexByte(lastID, '-')
+	if i < 0 || lastID[:i] != b.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(lastID[i+1:], 10, 64)
+	if err != nil || seq > b.seq {
+		return nil, false
+	}
+	if seq == b.seq {
+		return nil, true
+	}
+	// history holds the events up to b.seq without gaps
+	first := b.seq - uint64(len(b.history)) + 1
+	if len(b.history) == 0 || seq+1 < first {
+		return nil, false
+	}
+	return append([]Event(nil), b.history[seq+1-first:]...), true
+}
+
+// Unsubscribe stops the delivery to s.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subs[s] {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
@@ -0,0 +1,181 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Feed is a list of entries, newest first, rendered as Atom or RSS 2.0.
+type Feed struct {
+	ID       string //URL of the feed itself
+	Title    string
+	Subtitle string
+	Link     string //page the feed is about
+	Updated  time.Time
+	Entries  []Entry
+}
+
+// Entry is a post or a comment. Content is HTML.
+type Entry struct {
+	ID         string
+	Title      string
+	Link       string
+	Author     string
+	Content    string
+	Categories []string
+	Published  time.Time
+	Updated    time.Time
+}
+
+// LastModified returns the latest update of the entries, or the zero time for
+// an empty feed.
+func LastModified(entries []Entry) time.Time {
+	var last time.Time
+	for _, e := range entries {
+		if e.Updated.After(last) {
+			last = e.Updated
+		}
+	}
+	return last
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Links    []atomLink  `xml:"link"`
+	Updated  string      `xml:"updated"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Link       atomLink       `xml:"link"`
+	Author     *atomAuthor    `xml:"author,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Content    atomContent    `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Atom renders the feed as an Atom 1.0 document.
+func (f *Feed) Atom() ([]byte, error) {
+	af := atomFeed{
+		ID:       f.ID,
+		Title:    f.Title,
+		Subtitle: f.Subtitle,
+		Links:    []atomLink{{Href: f.ID, Rel: "self"}, {Href: f.Link, Rel: "alternate"}},
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+	}
+	for _, e := range f.Entries {
+		ae := atomEntry{
+			ID:        e.ID,
+			Title:     e.Title,
+			Link:      atomLink{Href: e.Link, Rel: "alternate"},
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Updated:   e.Updated.UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "html", Body: e.Content},
+		}
+		if e.Author != "" {
+			ae.Author = &atomAuthor{Name: e.Author}
+		}
+		for _, c := range e.Categories {
+			ae.Categories = append(ae.Categories, atomCategory{Term: c})
+		}
+		af.Entries = append(af.Entries, ae)
+	}
+	return marshal(af)
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	DC      string     `xml:"xmlns:dc,attr"` //namespace of item authors
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Self          atomSelf  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type atomSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	Author      string   `xml:"dc:creator,omitempty"`
+	Categories  []string `xml:"category"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// RSS renders the feed as an RSS 2.0 document. RSS has no update time of
+// items, so edits show only in lastBuildDate.
+func (f *Feed) RSS() ([]byte, error) {
+	ch := rssChannel{
+		Title:         f.Title,
+		Link:          f.Link,
+		Self:          atomSelf{Href: f.ID, Rel: "self", Type: "application/rss+xml"},
+		Description:   f.Subtitle,
+		LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+	}
+	if ch.Description == "" {
+		ch.Description = f.Title
+	}
+	for _, e := range f.Entries {
+		ch.Items = append(ch.Items, rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			GUID:        rssGUID{IsPermaLink: e.ID == e.Link, Value: e.ID},
+			Author:      e.Author,
+			Categories:  e.Categories,
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+			Description: e.Content,
+		})
+	}
+	return marshal(rss{Version: "2.0", Atom: "http://www.w3.org/2005/Atom", DC: "http://purl.org/dc/elements/1.1/", Channel: ch})
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
@@ -0,0 +1,304 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// Albums, photos and todos are listed whole by JSONPlaceholder, so their
+// lists are long by default.
+const (
+	resourcesDefaultLimit = 500
+	resourcesMaxLimit     = 5000
+)
+
+func AlbumsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reAlbums := regexp.MustCompile(`^\/albums(\/)??$`)
+		reAlbumsID := regexp.MustCompile(`^\/albums\/\d+(\/)??$`)
+		reAlbumsPhotos := regexp.MustCompile(`^\/albums\/\d+\/photos(\/)??$`)
+
+		switch {
+		case reAlbums.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list albums
+				listAlbumsHTTP(cfg, db, w, r)
+			case http.MethodPost: // create album in:json
+				createAlbumHTTP(cfg, db, w, r)
+			case http.MethodPut: // update album in:json
+				updateAlbumHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reAlbumsID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // get albums/{id}
+				getAlbumHTTP(cfg, db, w, r)
+			case http.MethodDelete: // delete albums/{id}
+				deleteAlbumHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reAlbumsPhotos.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list photos of album
+				listAlbumPhotosHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+// intParam adds the integer query parameter name to param if it is given.
+func intParam(r *http.Request, param map[string]interface{}, name string) bool {
+	v := r.FormValue(name)
+	if v == "" {
+		return true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return false
+	}
+	param[name] = n
+	return true
+}
+
+func writeAlbums(w http.ResponseWriter, r *http.Request, aa []models.Album) {
+	if responseXML(r) {
+		xmlWrite(w, models.Albums{Albums: aa})
+	} else {
+		jsonWrite(w, aa)
+	}
+}
+
+//@Summary List albums
+//@Description list albums by ID
+//@Produce json
+//@Param userId query integer false "albums filter by user"
+//@Param limit query integer false "maximum number of albums, 500 by default"
+//@Param offset query integer false "number of albums to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /albums [get]
+//@Security ApiKeyAuth
+func listAlbumsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	param := map[string]interface{}{}
+	limit, offset, ok := pageParams(r, resourcesDefaultLimit, resourcesMaxLimit)
+	if !ok || !intParam(r, param, "userId") {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	apr := models.AlbumProcess{}
+	aa, result := apr.ListAlbums(visibleOwned(cfg, DB, r).Limit(limit).Offset(offset), param)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeAlbums(w, r, aa)
+}
+
+//@Summary Show album
+//@Description get album by ID
+//@Produce json
+//@Param id path integer true "Album ID"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /albums/{id} [get]
+//@Security ApiKeyAuth
+func getAlbumHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	apr := models.AlbumProcess{}
+	a, result := apr.GetAlbum(visibleOwned(cfg, DB, r), map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, a)
+	} else {
+		jsonWrite(w, a)
+	}
+}
+
+//@Summary List photos of album
+//@Description list photos of an album by ID
+//@Produce json
+//@Param id path integer true "Album ID"
+//@Param limit query integer false "maximum number of photos, 500 by default"
+//@Param offset query integer false "number of photos to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /albums/{id}/photos [get]
+//@Security ApiKeyAuth
+func listAlbumPhotosHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	limit, offset, ok := pageParams(r, resourcesDefaultLimit, resourcesMaxLimit)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	apr := models.AlbumProcess{}
+	if _, result := apr.GetAlbum(visibleOwned(cfg, DB, r), map[string]interface{}{"id": id}); result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	ppr := models.PhotoProcess{}
+	pp, result := ppr.ListPhotos(DB.Limit(limit).Offset(offset), map[string]interface{}{"albumId": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writePhotos(w, r, pp)
+}
+
+type albumStruct struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+//@Summary Create album
+//@Description create album of the current user
+//@Accept json
+//@Produce json
+//@Param RequestAlbum body albumStruct true "JSON structure for creating album"
+//@Success 201
+//@Failure 400
+//@Failure default
+//@Router /albums [post]
+//@Security ApiKeyAuth
+func createAlbumHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req albumStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if fe := titleFieldErrors(cfg, req.Title); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	a := models.Album{UserID: u.ID, Title: req.Title}
+	apr := models.AlbumProcess{}
+	if result := apr.CreateAlbum(DB, &a); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonA, _ := json.MarshalIndent(a, "", "  ")
+	fmt.Fprintln(w, string(jsonA))
+}
+
+//@Summary Update album
+//@Description rename album of the current user
+//@Accept json
+//@Produce json
+//@Param RequestAlbum body albumStruct true "JSON structure for updating album"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /albums [put]
+//@Security ApiKeyAuth
+func updateAlbumHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req albumStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	apr := models.AlbumProcess{}
+	a, result := apr.GetAlbum(DB, map[string]interface{}{"id": req.ID})
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if a.UserID != u.ID {
+		ResponseError(w, http.StatusForbidden, "")
+		return
+	}
+	if fe := titleFieldErrors(cfg, req.Title); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	a.Title = req.Title
+	if result = apr.UpdateAlbum(DB, &a); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	jsonWrite(w, a)
+}
+
+//@Summary Delete album
+//@Description delete album of the current user with its photos
+//@Param id path int true "ID of deleting album"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /albums/{id} [delete]
+//@Security ApiKeyAuth
+func deleteAlbumHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	apr := models.AlbumProcess{}
+	a, result := apr.GetAlbum(DB, map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if a.UserID != u.ID {
+		ResponseError(w, http.StatusForbidden, "")
+		return
+	}
+	if result = apr.DeleteAlbum(DB, &a); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
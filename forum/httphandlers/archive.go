@@ -0,0 +1,216 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/archive"
+	"nx_trainee_forum/forum/httphandlers/audit"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/i18n"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// archiveContentTypes are the content types of exports by format.
+var archiveContentTypes = map[string]string{
+	archive.FormatNDJSON: "application/x-ndjson",
+	archive.FormatCSV:    "text/csv",
+	archive.FormatJSON:   "application/json",
+}
+
+func ArchiveHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reExport := regexp.MustCompile(`^\/export(\/)??$`)
+		reImport := regexp.MustCompile(`^\/import(\/)??$`)
+
+		switch {
+		case reExport.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // export forum data
+				exportHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reImport.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // import forum data
+				importHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+// archiveResources returns the comma separated resource parameter.
+func archiveResources(r *http.Request) []string {
+	var resources []string
+	for _, s := range strings.Split(r.FormValue("resource"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			resources = append(resources, s)
+		}
+	}
+	return resources
+}
+
+//@Summary Export forum data
+//@Description export users (without credentials), categories, posts and comments as NDJSON, CSV or a JSON archive, streamed by ID (admin only)
+//@Produce application/x-ndjson,text/csv,json
+//@Param format query string false "ndjson (default), csv or json"
+//@Param resource query string false "comma separated users, categories, posts, comments; all by default, exactly one for csv"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /export [get]
+//@Security ApiKeyAuth
+func exportHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	format := r.FormValue("format")
+	if format == "" {
+		format = archive.FormatNDJSON
+	}
+	resources := archiveResources(r)
+	fe := fieldErrors{}
+	switch {
+	case !archive.ValidFormat(format):
+		fe["format"] = archive.ErrInvalidFormat.Error()
+	case format == archive.FormatCSV && len(resources) != 1:
+		fe["resource"] = archive.ErrCSVResource.Error()
+	}
+	for _, res := range resources {
+		if !archive.ValidResource(res) {
+			fe["resource"] = archive.ErrInvalidResource.Error()
+		}
+	}
+	if len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	name := "forum"
+	if len(resources) == 1 {
+		name = resources[0]
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditDataExport, ActorID: u.ID, TargetType: "archive",
+		Details: strings.Join(append([]string{format}, resources...), " ")})
+	w.Header().Set("Content-Type", archiveContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	w.WriteHeader(http.StatusOK)
+	//the status is sent already: an NDJSON export ends with an error line,
+	//the others are left incomplete and fail to import
+	if err := archive.Export(DB, w, format, resources); err != nil {
+		log.Printf("export: %v", err)
+		if format == archive.FormatNDJSON {
+			archive.WriteError(w, err)
+		}
+	}
+}
+
+type importResponse struct {
+	Error string `json:"error,omitempty"`
+	*archive.Report
+}
+
+//@Summary Import forum data
+//@Description import an export, given as the request body, in one transaction (admin only). Records get new IDs and their references are remapped. Records matching stored ones are skipped, overwritten or fail the import. If any record is invalid nothing is imported and 400 lists the invalid records; a conflict with conflict=fail answers 409.
+//@Accept application/x-ndjson,text/csv,json
+//@Produce json
+//@Param format query string false "ndjson (default), csv or json"
+//@Param resource query string false "resource of a csv file: users, categories, posts or comments"
+//@Param conflict query string false "skip (default), overwrite or fail"
+//@Param dryRun query boolean false "validate and count without storing"
+//@Param storedRefs query boolean false "let references to records missing from the archive refer to stored rows with those IDs; always on for csv"
+//@Success 200
+//@Failure 400,403,409,413
+//@Failure default
+//@Router /import [post]
+//@Security ApiKeyAuth
+func importHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	opt := archive.Options{Format: r.FormValue("format"), Resource: r.FormValue("resource"), Conflict: r.FormValue("conflict")}
+	if opt.Format == "" {
+		opt.Format = archive.FormatNDJSON
+	}
+	if opt.Conflict == "" {
+		opt.Conflict = archive.ConflictSkip
+	}
+	var err error
+	if v := r.FormValue("dryRun"); v != "" {
+		if opt.DryRun, err = strconv.ParseBool(v); err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	if v := r.FormValue("storedRefs"); v != "" {
+		if opt.StoredRefs, err = strconv.ParseBool(v); err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	fe := fieldErrors{}
+	if !archive.ValidFormat(opt.Format) {
+		fe["format"] = archive.ErrInvalidFormat.Error()
+	}
+	if opt.Format == archive.FormatCSV && !archive.ValidResource(opt.Resource) {
+		fe["resource"] = archive.ErrCSVResource.Error()
+	}
+	if opt.Conflict != archive.ConflictSkip && opt.Conflict != archive.ConflictOverwrite && opt.Conflict != archive.ConflictFail {
+		fe["conflict"] = archive.ErrInvalidConflict.Error()
+	}
+	if len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	body := http.MaxBytesReader(w, r.Body, int64(cfg.ImportMaxSize))
+	defer body.Close()
+	report, err := archive.Import(DB, body, opt)
+	var tooLarge *http.MaxBytesError
+	code := http.StatusOK
+	switch {
+	case errors.As(err, &tooLarge):
+		ResponseError(w, http.StatusRequestEntityTooLarge, "")
+		return
+	case errors.Is(err, archive.ErrInvalid):
+		code = http.StatusBadRequest
+	case errors.Is(err, archive.ErrConflict):
+		code = http.StatusConflict
+	case err != nil:
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	resp := importResponse{Report: report}
+	if err != nil {
+		resp.Error = i18n.T(i18n.LangOf(w), err.Error())
+	} else if !opt.DryRun {
+		audit.Record(DB, r, models.AuditEvent{Action: models.AuditDataImport, ActorID: u.ID, TargetType: "archive",
+			Details: importDetails(opt, report)})
+	}
+	jsonB, _ := json.MarshalIndent(resp, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, string(jsonB))
+}
+
+// importDetails sums up an import for the audit log.
+func importDetails(opt archive.Options, report *archive.Report) string {
+	details := []string{opt.Format, opt.Conflict}
+	for _, res := range archive.Resources {
+		if c := report.Counts[res]; c != nil {
+			details = append(details, fmt.Sprintf("%s %d/%d/%d", res, c.Created, c.Updated, c.Skipped))
+		}
+	}
+	return strings.Join(details, " ")
+}
@@ -0,0 +1,437 @@
+package httphandlers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/imaging"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/notify"
+	"nx_trainee_forum/forum/storage"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// multipartOverhead is allowed on top of ATTACHMENT_MAX_SIZE for part
+// headers and boundaries of an upload request.
+const multipartOverhead = 64 << 10
+
+func AttachmentsHandler(cfg *config.Config, db *gorm.DB, store storage.Storage, images *imaging.Processor, notifier *notify.Notifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rPath := r.URL.Path
+		reAttachmentsID := regexp.MustCompile(`^\/attachments\/\d+(\/)??$`)
+		reAttachmentsThumbnail := regexp.MustCompile(`^\/attachments\/\d+\/thumbnails\/\d+(\/)??$`)
+
+		switch {
+		case reAttachmentsID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // download attachment
+				downloadAttachmentHTTP(cfg, db, store, images, w, r)
+			case http.MethodDelete: // delete attachment
+				deleteAttachmentHTTP(cfg, db, store, images, notifier, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reAttachmentsThumbnail.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // download thumbnail
+				thumbnailHTTP(cfg, db, store, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+// attachmentOwner returns the author of the target if it is visible to the
+// requesting user and may carry attachments.
+func attachmentOwner(cfg *config.Config, DB *gorm.DB, targetType string, id int, r *http.Request) (int, bool, error) {
+	param := map[string]interface{}{"id": id}
+	switch targetType {
+	case models.TargetPost:
+		tx, err := visiblePosts(cfg, DB, r)
+		if err != nil {
+			return 0, false, err
+		}
+		ppr := models.PostProcess{}
+		p, result := ppr.GetPost(tx, param)
+		return p.UserID, result.Error == nil, nil
+	case models.TargetComment:
+		tx, err := visibleComments(cfg, DB, r)
+		if err != nil {
+			return 0, false, err
+		}
+		cpr := models.CommentProcess{}
+		c, result := cpr.GetComment(tx, param)
+		return c.UserID, result.Error == nil && !c.Deleted, nil
+	}
+	return 0, false, nil
+}
+
+// allowedType reports whether the detected content type is one of
+// ATTACHMENT_TYPES; parameters such as charset are ignored.
+func allowedType(cfg *config.Config, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range cfg.AttachmentTypes {
+		if strings.EqualFold(strings.TrimSpace(t), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentName keeps the base name of an uploaded file, without control
+// characters and within the column size.
+func attachmentName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		name = "file"
+	}
+	if runes := []rune(name); len(runes) > 256 {
+		name = string(runes[:256])
+	}
+	return name
+}
+
+// removeBlobs deletes from storage the blobs of hashes that no attachment
+// refers to any more, together with their thumbnails.
+func removeBlobs(store storage.Storage, images *imaging.Processor, DB *gorm.DB, hashes []string) {
+	apr := models.AttachmentProcess{}
+	free, err := apr.UnreferencedHashes(DB, hashes)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, h := range free {
+		if err := store.Delete(context.Background(), models.BlobKey(h)); err != nil {
+			log.Print(err)
+		}
+		for _, size := range images.Sizes() {
+			if err := store.Delete(context.Background(), models.ThumbnailKey(h, size)); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+//@Summary Upload attachment
+//@Description attach a file to own post (/posts/{id}/attachments) or comment (/comments/{id}/attachments); multipart/form-data with the file in the "file" field
+//@Accept mpfd
+//@Produce json
+//@Param id path int true "ID of post or comment"
+//@Param file formData file true "attached file"
+//@Success 201
+//@Failure 400,403,404,413,415
+//@Failure default
+//@Router /posts/{id}/attachments [post]
+//@Router /comments/{id}/attachments [post]
+//@Security ApiKeyAuth
+func uploadAttachmentHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, targetType string, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.ID == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	owner, ok, err := attachmentOwner(cfg, DB, targetType, id, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if !ok {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if owner != u.ID {
+		ResponseError(w, http.StatusForbidden, "")
+		return
+	}
+	a := models.Attachment{UserID: u.ID}
+	if targetType == models.TargetPost {
+		a.PostID = &id
+	} else {
+		a.CommentID = &id
+	}
+	if !receiveUpload(cfg, DB, store, images, &a, false, w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonA, _ := json.MarshalIndent(a, "", "  ")
+	fmt.Fprintln(w, string(jsonA))
+}
+
+// receiveUpload stores the file of the multipart upload as attachment a,
+// whose uploader and target are set, once its type, size and image
+// dimensions are checked; imagesOnly refuses anything but images. A refused
+// upload is answered here and false is returned.
+func receiveUpload(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, a *models.Attachment, imagesOnly bool, w http.ResponseWriter, r *http.Request) bool {
+	maxSize := int64(cfg.AttachmentMaxSize)
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return false
+	}
+	var part io.ReadCloser
+	var fileName string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return false
+		}
+		if p.FormName() == "file" {
+			part, fileName = p, p.FileName()
+			break
+		}
+		p.Close()
+	}
+	defer part.Close()
+
+	// the upload is streamed to a temporary file while hashing it, so that
+	// it can be checked and deduplicated before it reaches the storage
+	tmp, err := ioutil.TempFile("", "forum-upload-*")
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return false
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	br := bufio.NewReaderSize(part, 512)
+	head, _ := br.Peek(512)
+	contentType := http.DetectContentType(head)
+	if !allowedType(cfg, contentType) || (imagesOnly && !imaging.IsImage(contentType)) {
+		ResponseError(w, http.StatusUnsupportedMediaType, "")
+		return false
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(br, maxSize+1))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return false
+	}
+	if size > maxSize {
+		ResponseError(w, http.StatusRequestEntityTooLarge, "")
+		return false
+	}
+	if imaging.IsImage(contentType) {
+		limits := imaging.Limits{MaxWidth: cfg.ImageMaxWidth, MaxHeight: cfg.ImageMaxHeight}
+		if _, err = tmp.Seek(0, io.SeekStart); err == nil {
+			err = limits.Check(tmp)
+		}
+		if err == imaging.ErrTooLarge {
+			ResponseFieldErrors(w, fieldErrors{"file": fmt.Sprintf("image must be at most %dx%d pixels", cfg.ImageMaxWidth, cfg.ImageMaxHeight)})
+			return false
+		}
+		if err != nil {
+			ResponseError(w, http.StatusInternalServerError, "")
+			return false
+		}
+	}
+	a.Name, a.ContentType, a.Size, a.Hash = attachmentName(fileName), contentType, size, hex.EncodeToString(h.Sum(nil))
+	if imaging.IsImage(contentType) {
+		a.Status = models.AttachmentProcessing
+	}
+	exists, err := store.Exists(r.Context(), a.BlobKey())
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return false
+	}
+	if !exists {
+		if _, err = tmp.Seek(0, io.SeekStart); err == nil {
+			err = store.Put(r.Context(), a.BlobKey(), tmp, size, contentType)
+		}
+		if err != nil {
+			log.Print(err)
+			ResponseError(w, http.StatusInternalServerError, "")
+			return false
+		}
+	}
+	apr := models.AttachmentProcess{}
+	if result := apr.CreateAttachment(DB, a); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return false
+	}
+	if a.Status == models.AttachmentProcessing {
+		images.Submit(a.ID)
+	}
+	return true
+}
+
+// visibleAttachment loads the attachment of the request path if the post or
+// comment it belongs to is visible to the requesting user.
+func visibleAttachment(cfg *config.Config, DB *gorm.DB, r *http.Request) (models.Attachment, int) {
+	aID, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		return models.Attachment{}, http.StatusBadRequest
+	}
+	apr := models.AttachmentProcess{}
+	a, result := apr.GetAttachment(DB, map[string]interface{}{"id": aID})
+	if result.Error != nil {
+		return a, http.StatusNotFound
+	}
+	targetType, targetID := models.TargetPost, 0
+	if a.PostID != nil {
+		targetID = *a.PostID
+	} else if a.CommentID != nil {
+		targetType, targetID = models.TargetComment, *a.CommentID
+	}
+	_, ok, err := attachmentOwner(cfg, DB, targetType, targetID, r)
+	if err != nil {
+		return a, http.StatusInternalServerError
+	}
+	if !ok {
+		return a, http.StatusNotFound
+	}
+	return a, http.StatusOK
+}
+
+//@Summary Download attachment
+//@Description download an attached file (authenticated users only); images are available once processed
+//@Param id path int true "ID of attachment"
+//@Success 200,304
+//@Failure 404,409
+//@Failure default
+//@Router /attachments/{id} [get]
+//@Security ApiKeyAuth
+func downloadAttachmentHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, w http.ResponseWriter, r *http.Request) {
+	a, code := visibleAttachment(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	if a.Status != models.AttachmentReady {
+		ResponseError(w, http.StatusConflict, "")
+		return
+	}
+	header := http.Header{}
+	header.Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	writeBlob(store, a.BlobKey(), `"`+a.Hash+`"`, a.ContentType, header, w, r)
+}
+
+//@Summary Download thumbnail
+//@Description download a thumbnail of an image attachment, fitting into size x size pixels; available sizes are listed in "thumbnails" of the attachment
+//@Param id path int true "ID of attachment"
+//@Param size path int true "thumbnail size"
+//@Success 200,304
+//@Failure 404
+//@Failure default
+//@Router /attachments/{id}/thumbnails/{size} [get]
+//@Security ApiKeyAuth
+func thumbnailHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	a, code := visibleAttachment(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	size, err := strconv.Atoi(path.Base(path.Clean(r.URL.Path)))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if a.Status != models.AttachmentReady || !a.HasThumbnail(size) {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	writeBlob(store, models.ThumbnailKey(a.Hash, size), `"`+a.Hash+"-"+strconv.Itoa(size)+`"`, a.ThumbnailType(), nil, w, r)
+}
+
+// writeBlob answers with the stored blob of key, of type contentType, which
+// clients cache privately and revalidate with etag. header is added to the
+// answer only when the blob is sent.
+func writeBlob(store storage.Storage, key, etag, contentType string, header http.Header, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	blob, err := store.Get(r.Context(), key)
+	if err == storage.ErrNotFound {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	defer blob.Close()
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+//@Summary Delete attachment
+//@Description delete an attachment (uploader or moderator)
+//@Param id path int true "ID of attachment"
+//@Success 200
+//@Failure 403,404
+//@Failure default
+//@Router /attachments/{id} [delete]
+//@Security ApiKeyAuth
+func deleteAttachmentHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	a, code := visibleAttachment(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	if a.UserID != u.ID && !u.HasRole(models.RoleModerator) {
+		ResponseError(w, http.StatusForbidden, "")
+		return
+	}
+	apr := models.AttachmentProcess{}
+	if result := apr.DeleteAttachment(DB, &a); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	removeBlobs(store, images, DB, []string{a.Hash})
+	if a.UserID != u.ID {
+		e := notify.Event{Kind: notify.Moderation, ActorID: u.ID, CommentID: a.CommentID, UserID: a.UserID,
+			Details: "attachment " + a.Name + " removed"}
+		if a.PostID != nil {
+			e.PostID = *a.PostID
+		}
+		notifier.Publish(e)
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,163 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+func AuditHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reAuditExport := regexp.MustCompile(`^\/audit\/export(\/)??$`)
+		reAudit := regexp.MustCompile(`^\/audit(\/)??$`)
+
+		switch {
+		case reAudit.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list audit events with filters
+				listAuditHTTP(db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reAuditExport.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // export audit events as NDJSON
+				exportAuditHTTP(db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	f := models.AuditFilter{Params: make(map[string]interface{})}
+	for _, key := range []string{"action", "targetType", "ip", "provider"} {
+		if v := r.FormValue(key); v != "" {
+			f.Params[key] = v
+		}
+	}
+	for _, key := range []string{"actorId", "targetId"} {
+		if v := r.FormValue(key); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				return f, err
+			}
+			f.Params[key] = id
+		}
+	}
+	var err error
+	if v := r.FormValue("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := r.FormValue("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, err
+		}
+	}
+	if v := r.FormValue("offset"); v != "" {
+		if f.Offset, err = strconv.Atoi(v); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+//@Summary List audit events
+//@Description list audit log (admin only)
+//@Produce json
+//@Param action query string false "filter by action, e.g. login.failure"
+//@Param actorId query integer false "filter by acting user"
+//@Param targetType query string false "filter by target type"
+//@Param targetId query integer false "filter by target ID"
+//@Param provider query string false "filter login events by provider"
+//@Param from query string false "events at or after time (RFC3339)"
+//@Param to query string false "events before time (RFC3339)"
+//@Param limit query integer false "page size, 100 by default"
+//@Param offset query integer false "page offset"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /audit [get]
+//@Security ApiKeyAuth
+func listAuditHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	f.Limit = auditDefaultLimit
+	if v := r.FormValue("limit"); v != "" {
+		f.Limit, err = strconv.Atoi(v)
+		if err != nil || f.Limit <= 0 {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if f.Limit > auditMaxLimit {
+			f.Limit = auditMaxLimit
+		}
+	}
+	apr := models.AuditProcess{}
+	ee, result := apr.ListEvents(DB, f)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.AuditEvents{Events: ee})
+	} else {
+		jsonWrite(w, ee)
+	}
+}
+
+//@Summary Export audit events
+//@Description export audit log as NDJSON, one event per line (admin only)
+//@Produce application/x-ndjson
+//@Param action query string false "filter by action"
+//@Param actorId query integer false "filter by acting user"
+//@Param from query string false "events at or after time (RFC3339)"
+//@Param to query string false "events before time (RFC3339)"
+//@Success 200
+//@Failure 400,403,500
+//@Failure default
+//@Router /audit/export [get]
+//@Security ApiKeyAuth
+func exportAuditHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	f, err := parseAuditFilter(r)
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	apr := models.AuditProcess{}
+	err = attachmentWrite(w, "application/x-ndjson", "audit.ndjson", func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		return apr.EachEvent(DB, f, func(e models.AuditEvent) error {
+			return enc.Encode(e)
+		})
+	})
+	if err != nil {
+		log.Printf("audit export: %v", err)
+	}
+}
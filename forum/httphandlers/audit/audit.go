@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"nx_trainee_forum/forum/models"
+
+	"gorm.io/gorm"
+)
+
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Record appends e to the audit log with the request's client IP. Failures
+// are logged and never interrupt the request that triggered the event.
+func Record(db *gorm.DB, r *http.Request, e models.AuditEvent) {
+	e.IP = ClientIP(r)
+	apr := models.AuditProcess{}
+	if result := apr.WriteEvent(db, &e); result.Error != nil {
+		log.Printf("audit: %s: %v", e.Action, result.Error)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
 	"nx_trainee_forum/forum/models"
 	"strconv"
 	"strings"
@@ -24,6 +25,11 @@ func generateOauthStateProvider() string {
 	return state
 }
 
+func loginFailed(db *gorm.DB, provider, reason string, w http.ResponseWriter, r *http.Request) {
+	audit.Record(db, r, models.AuditEvent{Action: models.AuditLoginFailure, Provider: provider, Details: reason})
+	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+}
+
 func buildAuthHeader(cfg *config.Config, method, path string, params map[string]string) string {
 	vals := url.Values{}
 	vals.Add("oauth_consumer_key", cfg.Twitter.TwitterAPIKey)
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
 	"nx_trainee_forum/forum/models"
 	"strings"
 	"time"
@@ -40,11 +41,11 @@ func CallbackFacebook(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r
 	state := r.FormValue("state")
 	oauthstate, err := r.Cookie("oauthstate")
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "facebook", "missing state cookie", w, r)
 		return
 	}
 	if state != (oauthstate.Value) {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "facebook", "state mismatch", w, r)
 		return
 	}
 	///////////////////////////////////////////////////////////
@@ -52,7 +53,7 @@ func CallbackFacebook(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r
 	token, err := cfg.Facebook.Config.Exchange(context.Background(), code)
 	if err != nil {
 		fmt.Printf("oauthConf.Exchange() failed with '%s'\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "facebook", "token exchange", w, r)
 		return
 	}
 	vals := url.Values{}
@@ -61,26 +62,26 @@ func CallbackFacebook(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r
 	resp, err := http.Get(fmt.Sprintf("https://graph.facebook.com/%s/me?%s", cfg.Facebook.APIVersion, vals.Encode()))
 	if err != nil {
 		fmt.Printf("Get: %s\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "facebook", "userinfo request", w, r)
 		return
 	}
 	defer resp.Body.Close()
 	response, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		fmt.Printf("ReadAll: %s\n", err)
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "facebook", "userinfo read", w, r)
 		return
 	}
 	//decode answer JSON to map
 	var respMap map[string]interface{} = make(map[string]interface{})
 	err = json.Unmarshal(response, &respMap)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "facebook", "userinfo decode", w, r)
 		return
 	}
 	//check request error
 	if _, ok := respMap["error"]; ok {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "facebook", "provider error", w, r)
 		return
 	}
 	//generate new accessToken for user
@@ -92,12 +93,14 @@ func CallbackFacebook(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r
 		"login":    respMap["id"],
 		"provider": "facebook",
 	})
-	//if user not found, register new user
+	//if user not found, register new user with the email given by the provider
 	if result.Error != nil || result.RowsAffected == 0 {
+		email, _ := respMap["email"].(string)
 		u = models.User{
 			Login:       respMap["id"].(string),
 			Provider:    "facebook",
 			Name:        respMap["name"].(string),
+			Email:       email,
 			AccessToken: hashAccToken,
 			APIKey:      CalculateSignature(GenerateAccessToken(), cfg.HASHKey),
 		}
@@ -106,11 +109,14 @@ func CallbackFacebook(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r
 		u.AccessToken = hashAccToken
 		u.UpdateAccessToken(db)
 	}
-	//write cookies
-	if result.Error == nil {
-		var expiration = time.Now().Add(30 * 24 * time.Hour)
-		cookieUID := http.Cookie{Name: "UAAT", Value: accessToken, Expires: expiration, Path: "/"}
-		http.SetCookie(w, &cookieUID)
+	if result.Error != nil {
+		loginFailed(db, "facebook", "registration", w, r)
+		return
 	}
+	//write cookies
+	var expiration = time.Now().Add(30 * 24 * time.Hour)
+	cookieUID := http.Cookie{Name: "UAAT", Value: accessToken, Expires: expiration, Path: "/"}
+	http.SetCookie(w, &cookieUID)
+	audit.Record(db, r, models.AuditEvent{Action: models.AuditLoginSuccess, ActorID: u.ID, TargetType: "user", TargetID: u.ID, Provider: "facebook"})
 	http.Redirect(w, r, "/", http.StatusFound)
 }
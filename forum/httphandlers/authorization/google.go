@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
 	"nx_trainee_forum/forum/models"
 	"strings"
 	"time"
@@ -41,46 +42,47 @@ func CallbackGoogle(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *h
 	state := r.FormValue("state")
 	oauthstate, err := r.Cookie("oauthstate")
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "google", "missing state cookie", w, r)
 		return
 	}
 	//verify stateTokens
 	if state != (oauthstate.Value) {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "google", "state mismatch", w, r)
 		return
 	}
 	//exchange code to provider Access&Refresh tokens
 	code := r.FormValue("code")
 	if code == "" {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "google", "missing code", w, r)
 		return
 	}
 	token, err := cfg.Google.Config.Exchange(context.Background(), code)
 	if err != nil {
+		loginFailed(db, "google", "token exchange", w, r)
 		return
 	}
 	//get userinfo on provider resource
 	resp, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + url.QueryEscape(token.AccessToken))
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "google", "userinfo request", w, r)
 		return
 	}
 	defer resp.Body.Close()
 	response, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "google", "userinfo read", w, r)
 		return
 	}
 	//decode answer JSON to map
 	var respMap map[string]interface{} = make(map[string]interface{})
 	err = json.Unmarshal(response, &respMap)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "google", "userinfo decode", w, r)
 		return
 	}
 	//check request error
 	if _, ok := respMap["error"]; ok {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "google", "provider error", w, r)
 		return
 	}
 	//generate new accessToken for user
@@ -92,12 +94,14 @@ func CallbackGoogle(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *h
 		"login":    respMap["id"],
 		"provider": "google",
 	})
-	//if user not found, register new user
+	//if user not found, register new user with the email given by the provider
 	if result.Error != nil || result.RowsAffected == 0 {
+		email, _ := respMap["email"].(string)
 		u = models.User{
 			Login:       respMap["id"].(string),
 			Provider:    "google",
 			Name:        respMap["name"].(string),
+			Email:       email,
 			AccessToken: hashAccToken,
 			APIKey:      CalculateSignature(GenerateAccessToken(), cfg.HASHKey),
 		}
@@ -106,11 +110,14 @@ func CallbackGoogle(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *h
 		u.AccessToken = hashAccToken
 		u.UpdateAccessToken(db)
 	}
-	//write cookies
-	if result.Error == nil {
-		var expiration = time.Now().Add(30 * 24 * time.Hour)
-		cookieUID := http.Cookie{Name: "UAAT", Value: accessToken, Expires: expiration, Path: "/"}
-		http.SetCookie(w, &cookieUID)
+	if result.Error != nil {
+		loginFailed(db, "google", "registration", w, r)
+		return
 	}
+	//write cookies
+	var expiration = time.Now().Add(30 * 24 * time.Hour)
+	cookieUID := http.Cookie{Name: "UAAT", Value: accessToken, Expires: expiration, Path: "/"}
+	http.SetCookie(w, &cookieUID)
+	audit.Record(db, r, models.AuditEvent{Action: models.AuditLoginSuccess, ActorID: u.ID, TargetType: "user", TargetID: u.ID, Provider: "google"})
 	http.Redirect(w, r, "/", http.StatusFound)
 }
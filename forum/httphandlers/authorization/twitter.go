@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
 	"nx_trainee_forum/forum/models"
 	"strings"
 	"time"
@@ -57,17 +58,17 @@ func CallbackTwitter(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *
 	o_verifier := r.FormValue("oauth_verifier")
 	oauthstate, err := r.Cookie("oauthstate")
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "missing state cookie", w, r)
 		return
 	}
 	if o_token != (oauthstate.Value) {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "state mismatch", w, r)
 		return
 	}
 	reqTokUrl := cfg.Twitter.TokenURL
 	request, err := http.NewRequest(http.MethodPost, reqTokUrl, bytes.NewBuffer([]byte(fmt.Sprintf("oauth_verifier=%s", o_verifier))))
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "access token request", w, r)
 		return
 	}
 	autorize := buildAuthHeader(cfg, http.MethodPost, reqTokUrl,
@@ -75,17 +76,17 @@ func CallbackTwitter(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *
 	request.Header.Set("Authorization", autorize)
 	resp, err := http.DefaultClient.Do(request)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "access token request", w, r)
 		return
 	}
 	if resp.StatusCode != 200 {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "access token status", w, r)
 		return
 	}
 	respBody, err := ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "access token read", w, r)
 		return
 	}
 	dataMap := make(map[string]string)
@@ -98,7 +99,7 @@ func CallbackTwitter(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *
 	reqTokUrl = "https://api.twitter.com/1.1/account/verify_credentials.json"
 	request, err = http.NewRequest(http.MethodGet, reqTokUrl, nil)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "credentials request", w, r)
 		return
 	}
 	autorize = buildAuthHeader(cfg, http.MethodGet, reqTokUrl,
@@ -106,29 +107,29 @@ func CallbackTwitter(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *
 	request.Header.Set("Authorization", autorize)
 	resp, err = http.DefaultClient.Do(request)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "credentials request", w, r)
 		return
 	}
 	if resp.StatusCode != 200 {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "credentials status", w, r)
 		return
 	}
 	respBody, err = ioutil.ReadAll(resp.Body)
 	defer resp.Body.Close()
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "credentials read", w, r)
 		return
 	}
 	//decode answer JSON to map
 	var respMap map[string]interface{} = make(map[string]interface{})
 	err = json.Unmarshal(respBody, &respMap)
 	if err != nil {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "credentials decode", w, r)
 		return
 	}
 	//check request error
 	if _, ok := respMap["errors"]; ok {
-		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		loginFailed(db, "twitter", "provider error", w, r)
 		return
 	}
 	//generate new accessToken for user
@@ -154,11 +155,14 @@ func CallbackTwitter(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *
 		u.AccessToken = hashAccToken
 		u.UpdateAccessToken(db)
 	}
-	//write cookies
-	if result.Error == nil {
-		var expiration = time.Now().Add(30 * 24 * time.Hour)
-		cookieUID := http.Cookie{Name: "UAAT", Value: accessToken, Expires: expiration, Path: "/"}
-		http.SetCookie(w, &cookieUID)
+	if result.Error != nil {
+		loginFailed(db, "twitter", "registration", w, r)
+		return
 	}
+	//write cookies
+	var expiration = time.Now().Add(30 * 24 * time.Hour)
+	cookieUID := http.Cookie{Name: "UAAT", Value: accessToken, Expires: expiration, Path: "/"}
+	http.SetCookie(w, &cookieUID)
+	audit.Record(db, r, models.AuditEvent{Action: models.AuditLoginSuccess, ActorID: u.ID, TargetType: "user", TargetID: u.ID, Provider: "twitter"})
 	http.Redirect(w, r, "/", http.StatusFound)
 }
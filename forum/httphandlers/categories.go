@@ -0,0 +1,305 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+func CategoriesHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reCategoriesID := regexp.MustCompile(`^\/categories\/\d+(\/)??$`)
+		reCategories := regexp.MustCompile(`^\/categories(\/)??$`)
+
+		switch {
+		case reCategories.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list categories
+				listCategoriesHTTP(cfg, db, w, r)
+			case http.MethodPost: // create category in:json
+				createCategoryHTTP(cfg, db, w, r)
+			case http.MethodPut: // update category in:json
+				updateCategoryHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reCategoriesID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // get categories/{id}
+				getCategoryByIDHTTP(cfg, db, w, r)
+			case http.MethodDelete: // delete categories/{id}
+				deleteCategoryHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+// hiddenCategories returns the categories the requesting user may not read.
+func hiddenCategories(cfg *config.Config, DB *gorm.DB, r *http.Request) ([]int, error) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	capr := models.CategoryProcess{}
+	ids, result := capr.HiddenCategoryIDs(DB, u)
+	return ids, result.Error
+}
+
+// visiblePosts scopes DB to the posts the requesting user may read.
+func visiblePosts(cfg *config.Config, DB *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	ids, err := hiddenCategories(cfg, DB, r)
+	if err != nil || len(ids) == 0 {
+		return DB, err
+	}
+	return DB.Where("(categoryId IS NULL OR categoryId NOT IN ?)", ids), nil
+}
+
+// visibleComments scopes DB to the comments of posts the requesting user may read.
+func visibleComments(cfg *config.Config, DB *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	ids, err := hiddenCategories(cfg, DB, r)
+	if err != nil || len(ids) == 0 {
+		return DB, err
+	}
+	return DB.Where("postId NOT IN (?)", DB.Model(&models.Post{}).Select("id").Where("categoryId IN ?", ids)), nil
+}
+
+// requireRole answers 403 unless the current user holds role.
+func requireRole(cfg *config.Config, DB *gorm.DB, role string, w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if !u.HasRole(role) {
+		ResponseError(w, http.StatusForbidden, "")
+		return u, false
+	}
+	return u, true
+}
+
+//@Summary List categories
+//@Description list categories readable by the current user
+//@Produce json
+//@Param parentId query integer false "only direct subcategories of the given category"
+//@Param view query string false "flat (default) or tree"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /categories [get]
+//@Security ApiKeyAuth
+func listCategoriesHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	var param map[string]interface{} = make(map[string]interface{})
+	if parentId := r.FormValue("parentId"); parentId != "" {
+		var err error
+		param["parentId"], err = strconv.Atoi(parentId)
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	hidden, err := hiddenCategories(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	tx := DB
+	if len(hidden) > 0 {
+		tx = DB.Where("id NOT IN ?", hidden)
+	}
+	capr := models.CategoryProcess{}
+	cc, result := capr.ListCategories(tx, param)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	switch r.FormValue("view") {
+	case "", "flat":
+	case "tree":
+		cc = models.CategoryTree(cc)
+	default:
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Categories{Categories: cc})
+	} else {
+		jsonWrite(w, cc)
+	}
+}
+
+//@Summary Show category
+//@Description get category by ID
+//@Produce json
+//@Param id path integer true "Category ID"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /categories/{id} [get]
+//@Security ApiKeyAuth
+func getCategoryByIDHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	capr := models.CategoryProcess{}
+	c, result := capr.GetCategory(DB, map[string]interface{}{"id": id})
+	if result.Error != nil || !capr.CanRead(DB, c, authorization.GetCurrentUser(cfg, DB, r)) {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, c)
+	} else {
+		jsonWrite(w, c)
+	}
+}
+
+type createCategoryStruct struct {
+	ParentID    *int `json:"parentId"`
+	Name        string
+	Description string
+	Position    int
+	ReadRole    string `json:"readRole"`
+	PostRole    string `json:"postRole"`
+}
+type updateCategoryStruct struct {
+	ID          int
+	ParentID    *int `json:"parentId"`
+	Name        string
+	Description string
+	Position    int
+	ReadRole    string `json:"readRole"`
+	PostRole    string `json:"postRole"`
+}
+
+//@Summary Create category
+//@Description create category (admin only)
+//@Accept json
+//@Produce json
+//@Param RequestCategory body createCategoryStruct true "JSON structure for creating category"
+//@Success 201
+//@Failure 400,403
+//@Failure default
+//@Router /categories [post]
+//@Security ApiKeyAuth
+func createCategoryHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(cfg, DB, models.RoleAdmin, w, r)
+	if !ok {
+		return
+	}
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var c models.Category
+	err = json.Unmarshal(reqBody, &c)
+	if err != nil || c.Name == "" {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	capr := models.CategoryProcess{}
+	result := capr.CreateCategory(DB, &c)
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditCategoryCreate, ActorID: u.ID, TargetType: "category", TargetID: c.ID})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonC, _ := json.MarshalIndent(c, "", "  ")
+	fmt.Fprintln(w, string(jsonC))
+}
+
+//@Summary Update category
+//@Description update category (admin only); omitted fields keep their values
+//@Accept json
+//@Produce json
+//@Param RequestCategory body updateCategoryStruct true "JSON structure for updating category"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /categories [put]
+//@Security ApiKeyAuth
+func updateCategoryHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(cfg, DB, models.RoleAdmin, w, r)
+	if !ok {
+		return
+	}
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req struct{ ID int }
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	capr := models.CategoryProcess{}
+	c, result := capr.GetCategory(DB, map[string]interface{}{"id": req.ID})
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	//apply the given fields over the stored category
+	if err = json.Unmarshal(reqBody, &c); err != nil || c.Name == "" {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	result = capr.UpdateCategory(DB, &c)
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditCategoryUpdate, ActorID: u.ID, TargetType: "category", TargetID: c.ID})
+	jsonWrite(w, c)
+}
+
+//@Summary Delete category
+//@Description delete category by ID (admin only); subcategories move to its parent, posts stay without category
+//@Param id path int true "ID of deleting category"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /categories/{id} [delete]
+//@Security ApiKeyAuth
+func deleteCategoryHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(cfg, DB, models.RoleAdmin, w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	capr := models.CategoryProcess{}
+	c, result := capr.GetCategory(DB, map[string]interface{}{"id": id})
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	result = capr.DeleteCategory(DB, &c)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditCategoryDelete, ActorID: u.ID, TargetType: "category", TargetID: id})
+	w.WriteHeader(http.StatusOK)
+}
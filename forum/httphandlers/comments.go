@@ -6,30 +6,38 @@ import (
 	"io/ioutil"
 	"net/http"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
 	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/imaging"
 	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/notify"
+	"nx_trainee_forum/forum/storage"
 	"regexp"
 	"strconv"
+	"time"
 
 	"gorm.io/gorm"
 )
 
-func CommentsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+func CommentsHandler(cfg *config.Config, db *gorm.DB, store storage.Storage, images *imaging.Processor, notifier *notify.Notifier) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		rPath := r.URL.Path
 		reCommentsID := regexp.MustCompile(`^\/comments\/\d+(\/)??$`)
+		reCommentsVote := regexp.MustCompile(`^\/comments\/\d+\/vote(\/)??$`)
+		reCommentsReactions := regexp.MustCompile(`^\/comments\/\d+\/reactions(\/)??$`)
+		reCommentsAttachments := regexp.MustCompile(`^\/comments\/\d+\/attachments(\/)??$`)
 		reComments := regexp.MustCompile(`^\/comments(\/)??$`)
 
 		switch {
 		case reComments.Match([]byte(rPath)):
 			switch r.Method {
 			case http.MethodGet: // list comments with filters
-				listCommentsHTTP(db, w, r)
+				listCommentsHTTP(cfg, db, w, r)
 			case http.MethodPost: // create comment in:json
-				createCommentHTTP(cfg, db, w, r)
+				createCommentHTTP(cfg, db, notifier, w, r)
 			case http.MethodPut: // update comment in:json
-				updateCommentHTTP(cfg, db, w, r)
+				updateCommentHTTP(cfg, db, notifier, w, r)
 			default:
 				ResponseError(w, http.StatusMethodNotAllowed, "")
 				return
@@ -37,9 +45,37 @@ func CommentsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 		case reCommentsID.Match([]byte(rPath)):
 			switch r.Method {
 			case http.MethodGet: // get comments/{id}
-				getCommentByIDHTTP(db, w, r)
+				getCommentByIDHTTP(cfg, db, w, r)
 			case http.MethodDelete: // delete comments/{id}
-				deleteCommentHTTP(cfg, db, w, r)
+				deleteCommentHTTP(cfg, db, store, images, notifier, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reCommentsVote.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPut: // vote for comment in:json
+				voteHTTP(cfg, db, models.TargetComment, w, r)
+			case http.MethodDelete: // retract vote
+				voteHTTP(cfg, db, models.TargetComment, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reCommentsReactions.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // add reaction in:json
+				reactionHTTP(cfg, db, models.TargetComment, w, r)
+			case http.MethodDelete: // remove reaction ?emoji=
+				reactionHTTP(cfg, db, models.TargetComment, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reCommentsAttachments.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // upload attachment in:multipart
+				uploadAttachmentHTTP(cfg, db, store, images, models.TargetComment, w, r)
 			default:
 				ResponseError(w, http.StatusMethodNotAllowed, "")
 				return
@@ -54,12 +90,13 @@ func CommentsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 //@Summary List comments
 //@description list comments with filtering
 //@Param postId query int false "ID of post"
+//@Param sort query string false "new, score or hot; by ID if omitted"
 //@Param xml query string false "show data like XML"
 //@Success 200
 //@Failure default
 //@Router /comments/ [get]
 //@Security ApiKeyAuth
-func listCommentsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func listCommentsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 
 	var param map[string]interface{} = make(map[string]interface{})
 	postId := r.FormValue("postId")
@@ -71,8 +108,18 @@ func listCommentsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	tx, err := visibleComments(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	tx, ok := applySort(tx, "comments", r)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
 	cpr := new(models.CommentProcess)
-	cc, result := cpr.ListComments(DB, param)
+	cc, result := cpr.ListComments(tx, param)
 	if result.Error != nil {
 		ResponseError(w, http.StatusInternalServerError, "")
 		return
@@ -92,7 +139,7 @@ func listCommentsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 //@Failure default
 //@Router /comments/{id} [get]
 //@Security ApiKeyAuth
-func getCommentByIDHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func getCommentByIDHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	var param map[string]interface{} = make(map[string]interface{})
 	var err error
 	param["id"], err = strconv.Atoi(reNum.FindString(r.URL.Path))
@@ -100,8 +147,13 @@ func getCommentByIDHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	tx, err := visibleComments(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
 	cpr := models.CommentProcess{}
-	cmnt, result := cpr.GetComment(DB, param)
+	cmnt, result := cpr.GetComment(tx, param)
 	if result.Error != nil {
 		ResponseError(w, http.StatusNotFound, "")
 		return
@@ -115,10 +167,11 @@ func getCommentByIDHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 }
 
 type createCommentStruct struct {
-	PostID int `json:"postId"`
-	Name   string
-	Email  string
-	Body   string
+	PostID   int  `json:"postId"`
+	ParentID *int `json:"parentId"`
+	Name     string
+	Email    string
+	Body     string
 }
 type updateCommentStruct struct {
 	ID    int
@@ -133,11 +186,11 @@ type updateCommentStruct struct {
 //@Produce json
 //@Param RequestPost body createCommentStruct true "JSON structure for creating post"
 //@Success 200,201
-//@Failure 400
+//@Failure 400,404
 //@Failure default
 //@Router /comments/ [post]
 //@Security ApiKeyAuth
-func createCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func createCommentHTTP(cfg *config.Config, DB *gorm.DB, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
 	u := authorization.GetCurrentUser(cfg, DB, r)
 	if u.ID == 0 {
 		ResponseError(w, http.StatusInternalServerError, "")
@@ -156,17 +209,40 @@ func createCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r
 		ResponseError(w, http.StatusInternalServerError, "")
 		return
 	}
+	c.Attachments = nil
+	c.CreatedAt, c.UpdatedAt = time.Time{}, time.Time{} //stamped on insert; only imports keep their own
 	if c.Name == "" || c.Email == "" || c.Body == "" || c.PostID == 0 {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	if fe := commentFieldErrors(cfg, &c); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	//posts in categories the user cannot read do not exist for them
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	var n int64
+	if err = tx.Model(&models.Post{}).Where("id = ?", c.PostID).Count(&n).Error; err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if n == 0 {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
 	c.UserID = u.ID
-	cpr := models.CommentProcess{}
+	cpr := models.CommentProcess{MaxDepth: cfg.CommentMaxDepth}
 	result := cpr.CreateComment(DB, &c)
 	if result.Error != nil {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditCommentCreate, ActorID: u.ID, TargetType: "comment", TargetID: c.ID})
+	notifier.Publish(notify.Event{Kind: notify.CommentCreated, ActorID: u.ID, PostID: c.PostID, CommentID: &c.ID, Mentions: c.Mentions})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	jsonC, _ := json.MarshalIndent(c, "", "  ")
@@ -183,7 +259,7 @@ func createCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r
 //@Failure default
 //@Router /comments/ [put]
 //@Security ApiKeyAuth
-func updateCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func updateCommentHTTP(cfg *config.Config, DB *gorm.DB, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
 	u := authorization.GetCurrentUser(cfg, DB, r)
 	if u.ID == 0 {
 		ResponseError(w, http.StatusInternalServerError, "")
@@ -201,21 +277,29 @@ func updateCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r
 		ResponseError(w, http.StatusInternalServerError, "")
 		return
 	}
+	c.Attachments = nil
+	if fe := commentFieldErrors(cfg, &c); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
 	cpr := models.CommentProcess{}
 	cUpd, result := cpr.GetComment(DB, map[string]interface{}{"id": c.ID})
 	if result.Error != nil || result.RowsAffected == 0 {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
-	if cUpd.UserID != u.ID {
+	if cUpd.UserID != u.ID || cUpd.Deleted {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	c.UserID, c.PostID = cUpd.UserID, cUpd.PostID
 	result = cpr.UpdateComment(DB, &c)
 	if result.Error != nil {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditCommentUpdate, ActorID: u.ID, TargetType: "comment", TargetID: c.ID})
+	notifier.Publish(notify.Event{Kind: notify.CommentUpdated, ActorID: u.ID, PostID: c.PostID, CommentID: &c.ID, Mentions: c.Mentions})
 	w.Header().Set("Content-Type", "application/json")
 	jsonC, _ := json.MarshalIndent(c, "", "  ")
 	fmt.Fprintln(w, string(jsonC))
@@ -228,7 +312,7 @@ func updateCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r
 //@Failure default
 //@Router /comments/{id} [delete]
 //@Security ApiKeyAuth
-func deleteCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func deleteCommentHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
 	u := authorization.GetCurrentUser(cfg, DB, r)
 	if u.ID == 0 {
 		ResponseError(w, http.StatusInternalServerError, "")
@@ -250,11 +334,20 @@ func deleteCommentHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	apr := models.AttachmentProcess{}
+	hashes, err := apr.CommentHashes(DB, cID)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
 	var c models.Comment = models.Comment{ID: cID, UserID: u.ID}
 	result = cpr.DeleteComment(DB, &c)
 	if result.Error != nil {
 		ResponseError(w, http.StatusInternalServerError, "")
 		return
 	}
+	removeBlobs(store, images, DB, hashes)
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditCommentDelete, ActorID: u.ID, TargetType: "comment", TargetID: cID})
+	notifier.Publish(notify.Event{Kind: notify.CommentDeleted, ActorID: u.ID, PostID: cDel.PostID, CommentID: &cID})
 	w.WriteHeader(http.StatusOK)
 }
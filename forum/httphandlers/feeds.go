@@ -0,0 +1,273 @@
+package httphandlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/feeds"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// feedLimit is the number of newest posts or comments in a feed.
+const feedLimit = 50
+
+func FeedsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reFeedsPosts := regexp.MustCompile(`^\/feeds\/posts\.(atom|rss)$`)
+		reFeedsPostsIDComments := regexp.MustCompile(`^\/feeds\/posts\/\d+\/comments\.(atom|rss)$`)
+		reFeedsUsersIDPosts := regexp.MustCompile(`^\/feeds\/users\/\d+\/posts\.(atom|rss)$`)
+		reFeedsCategoriesIDPosts := regexp.MustCompile(`^\/feeds\/categories\/\d+\/posts\.(atom|rss)$`)
+
+		if r.Method != http.MethodGet {
+			ResponseError(w, http.StatusMethodNotAllowed, "")
+			return
+		}
+		switch {
+		case reFeedsPosts.Match([]byte(rPath)): // feed of all posts
+			postsFeedHTTP(cfg, db, "", w, r)
+		case reFeedsUsersIDPosts.Match([]byte(rPath)): // feed of posts by user
+			postsFeedHTTP(cfg, db, "userId", w, r)
+		case reFeedsCategoriesIDPosts.Match([]byte(rPath)): // feed of posts in category
+			postsFeedHTTP(cfg, db, "categoryId", w, r)
+		case reFeedsPostsIDComments.Match([]byte(rPath)): // feed of comments on post
+			commentsFeedHTTP(cfg, db, w, r)
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+// userNames maps the IDs of users to their names.
+func userNames(DB *gorm.DB, ids []int) (map[int]string, error) {
+	uu := []models.User{}
+	names := make(map[int]string)
+	if len(ids) == 0 {
+		return names, nil
+	}
+	if err := DB.Where("id IN ?", ids).Find(&uu).Error; err != nil {
+		return nil, err
+	}
+	for _, u := range uu {
+		names[u.ID] = u.Name
+	}
+	return names, nil
+}
+
+// feedSeenMax bounds the feeds remembered by feedChanged. Forgetting them
+// is safe: a feed not seen before counts as changed now.
+const feedSeenMax = 10000
+
+type feedState struct {
+	etag    string
+	changed time.Time
+}
+
+// feedSeen holds, by feed and viewer, the ETag last served and when it first
+// was.
+var feedSeen = struct {
+	sync.Mutex
+	m map[string]feedState
+}{m: map[string]feedState{}}
+
+// feedChanged returns when the entries of the feed key last changed, etag
+// digesting them now. A deleted or hidden entry leaves no newer update time
+// behind, so this is what moves Last-Modified then.
+func feedChanged(key, etag string) time.Time {
+	feedSeen.Lock()
+	defer feedSeen.Unlock()
+	s, ok := feedSeen.m[key]
+	if !ok || s.etag != etag {
+		if !ok && len(feedSeen.m) >= feedSeenMax {
+			feedSeen.m = map[string]feedState{}
+		}
+		s = feedState{etag: etag, changed: time.Now()}
+		feedSeen.m[key] = s
+	}
+	return s.changed
+}
+
+// feedETag digests the title and the entries of f, so that it changes when
+// an entry is added, edited, deleted or hidden.
+func feedETag(f feeds.Feed) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", f.Title, f.Subtitle)
+	for _, e := range f.Entries {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%d\x00", e.ID, e.Title, e.Author, strings.Join(e.Categories, ","), e.Updated.UnixNano())
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// writeFeed answers with the feed as Atom or RSS by the extension of the
+// path. The feed depends on the viewer, so it is private to them. The ETag
+// digests the entries; Last-Modified is the latest update of the entries or
+// the latest change of which entries the viewer gets, whichever is later. A
+// request with the ETag in If-None-Match or, without If-None-Match, with
+// If-Modified-Since not older than Last-Modified gets 304.
+func writeFeed(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request, f feeds.Feed) {
+	w.Header().Set("Vary", "Cookie, APIKey")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	etag := feedETag(f)
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	last := feedChanged(r.URL.Path+"|"+strconv.Itoa(u.ID), etag)
+	f.Updated = last
+	if updated := feeds.LastModified(f.Entries); !updated.IsZero() {
+		f.Updated = updated
+		if updated.After(last) {
+			last = updated
+		}
+	}
+	last = last.UTC().Truncate(time.Second)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", last.Format(http.TimeFormat))
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !last.After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	var body []byte
+	var err error
+	if path.Ext(r.URL.Path) == ".rss" {
+		body, err = f.RSS()
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	} else {
+		body, err = f.Atom()
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	}
+	if err != nil {
+		w.Header().Del("ETag")
+		w.Header().Del("Last-Modified")
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+//@Summary Feed of posts
+//@Description Atom or RSS feed of the newest posts readable by the current user: all of them, by a user or in a category; supports If-None-Match and If-Modified-Since
+//@Produce xml
+//@Param id path integer true "User or category ID, for their feeds"
+//@Success 200
+//@Success 304
+//@Failure 400,404
+//@Failure default
+//@Router /feeds/posts.atom [get]
+//@Router /feeds/posts.rss [get]
+//@Router /feeds/users/{id}/posts.atom [get]
+//@Router /feeds/categories/{id}/posts.atom [get]
+func postsFeedHTTP(cfg *config.Config, DB *gorm.DB, by string, w http.ResponseWriter, r *http.Request) {
+	f := feeds.Feed{ID: cfg.BaseURL + r.URL.Path, Title: "Posts", Link: cfg.BaseURL + "/posts"}
+	param := make(map[string]interface{})
+	if by != "" {
+		id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		param[by] = id
+		f.Link += "?" + by + "=" + strconv.Itoa(id)
+		if by == "userId" {
+			u, code := visibleUser(cfg, DB, r)
+			if code != http.StatusOK {
+				ResponseError(w, code, "")
+				return
+			}
+			f.Title = "Posts by " + u.Name
+		} else {
+			capr := models.CategoryProcess{}
+			c, result := capr.GetCategory(DB, map[string]interface{}{"id": id})
+			if result.Error != nil || !capr.CanRead(DB, c, authorization.GetCurrentUser(cfg, DB, r)) {
+				ResponseError(w, http.StatusNotFound, "")
+				return
+			}
+			f.Title, f.Subtitle = "Posts in "+c.Name, c.Description
+		}
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	ppr := models.PostProcess{}
+	pp, result := ppr.ListPosts(tx.Order("createdAt DESC, id DESC").Limit(feedLimit), param)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	ids := []int{}
+	for _, p := range pp {
+		ids = append(ids, p.UserID)
+	}
+	names, err := userNames(DB, ids)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	for _, p := range pp {
+		link := cfg.BaseURL + "/posts/" + strconv.Itoa(p.ID)
+		f.Entries = append(f.Entries, feeds.Entry{ID: link, Title: p.Title, Link: link, Author: names[p.UserID],
+			Content: p.BodyHTML, Categories: p.Tags, Published: p.CreatedAt, Updated: p.UpdatedAt})
+	}
+	writeFeed(cfg, DB, w, r, f)
+}
+
+//@Summary Feed of comments
+//@Description Atom or RSS feed of the newest comments on a post; supports If-None-Match and If-Modified-Since
+//@Produce xml
+//@Param id path integer true "Post ID"
+//@Success 200
+//@Success 304
+//@Failure 400,404
+//@Failure default
+//@Router /feeds/posts/{id}/comments.atom [get]
+//@Router /feeds/posts/{id}/comments.rss [get]
+func commentsFeedHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	ppr := models.PostProcess{}
+	p, result := ppr.GetPost(tx, map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	cpr := models.CommentProcess{}
+	cc, result := cpr.ListComments(DB.Where("deleted = ?", false).Order("createdAt DESC, id DESC").Limit(feedLimit),
+		map[string]interface{}{"postId": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	postLink := cfg.BaseURL + "/posts/" + strconv.Itoa(p.ID)
+	f := feeds.Feed{ID: cfg.BaseURL + r.URL.Path, Title: "Comments on “" + p.Title + "”", Link: postLink}
+	for _, c := range cc {
+		link := cfg.BaseURL + "/comments/" + strconv.Itoa(c.ID)
+		f.Entries = append(f.Entries, feeds.Entry{ID: link, Title: "Re: " + p.Title, Link: link, Author: c.Name,
+			Content: c.BodyHTML, Published: c.CreatedAt, Updated: c.UpdatedAt})
+	}
+	writeFeed(cfg, DB, w, r, f)
+}
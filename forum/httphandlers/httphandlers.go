@@ -1,15 +1,20 @@
 package httphandlers
 
 import (
+	"bufio"
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"html/template"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
 	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/i18n"
 	"nx_trainee_forum/forum/models"
-	"path/filepath"
+	"nx_trainee_forum/forum/views"
+	"os"
 	"regexp"
 
 	"gorm.io/gorm"
@@ -69,18 +74,26 @@ func oauthCallback(cfg *config.Config, db *gorm.DB, w http.ResponseWriter, r *ht
 	}
 }
 
-func MainHandler(db *gorm.DB, cfg *config.Config) http.Handler {
-	type templ struct {
-		Config *config.Config
-		User   models.User
-	}
+func MainHandler(db *gorm.DB, cfg *config.Config, pages *views.Manager) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		u := authorization.GetCurrentUser(cfg, db, r)
-		t, err := template.ParseFiles("./templates/index.html")
-		if err != nil {
-			fmt.Println(err)
+		if r.URL.Path != "/" {
+			renderError(cfg, db, pages, http.StatusNotFound, w, r)
+			return
+		}
+		pages.Render(w, http.StatusOK, "index", newWebPage(cfg, db, r))
+	})
+}
+
+func GetAPIKeyHandler(db *gorm.DB, cfg *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			issueAPIKeyHTTP(cfg, db, w, r)
+		case http.MethodDelete:
+			revokeAPIKeyHTTP(cfg, db, w, r)
+		default:
+			ResponseError(w, http.StatusMethodNotAllowed, "")
 		}
-		t.Execute(w, templ{Config: cfg, User: u})
 	})
 }
 
@@ -91,55 +104,47 @@ func MainHandler(db *gorm.DB, cfg *config.Config) http.Handler {
 //@Failure default
 //@Router /getapikey [get]
 //@Security ApiKeyAuth
-func GetAPIKeyHandler(db *gorm.DB, cfg *config.Config) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		u := authorization.GetCurrentUser(cfg, db, r)
-		if u.ID == 0 {
-			ResponseError(w, http.StatusInternalServerError, "")
-			return
-		}
-		apiKey := authorization.GenerateAccessToken()
-		hashApiKey := authorization.CalculateSignature(apiKey, cfg.HASHKey)
-		u.APIKey = hashApiKey
-		result := u.UpdAPIKey(db)
-		if result.Error != nil || result.RowsAffected == 0 {
-			ResponseError(w, http.StatusInternalServerError, "")
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(fmt.Sprintf(`{"APIKey": "%s"}`, apiKey)))
-	})
-}
-
-type myFileSystem struct {
-	fs http.FileSystem
-}
-
-func (nfs myFileSystem) Open(path string) (http.File, error) {
-	f, err := nfs.fs.Open(path)
-	if err != nil {
-		return nil, err
+func issueAPIKeyHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.ID == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
 	}
-
-	s, _ := f.Stat()
-	if s.IsDir() {
-		index := filepath.Join(path, "index.html")
-		if _, err := nfs.fs.Open(index); err != nil {
-			closeErr := f.Close()
-			if closeErr != nil {
-				return nil, closeErr
-			}
-
-			return nil, err
-		}
+	apiKey := authorization.GenerateAccessToken()
+	hashApiKey := authorization.CalculateSignature(apiKey, cfg.HASHKey)
+	u.APIKey = hashApiKey
+	result := u.UpdAPIKey(DB)
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
 	}
-
-	return f, nil
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditAPIKeyIssue, ActorID: u.ID, TargetType: "user", TargetID: u.ID})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf(`{"APIKey": "%s"}`, apiKey)))
 }
 
-func PublicHandler() http.Handler {
-	return http.StripPrefix("/public/", http.FileServer(myFileSystem{fs: http.Dir("./static")}))
+//@Summary Revoke API key
+//@description revoke current api key; a new one can be generated with GET
+//@Success 200
+//@Failure default
+//@Router /getapikey [delete]
+//@Security ApiKeyAuth
+func revokeAPIKeyHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.ID == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	//replace the key with the hash of a key nobody knows
+	u.APIKey = authorization.CalculateSignature(authorization.GenerateAccessToken(), cfg.HASHKey)
+	result := u.UpdAPIKey(DB)
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditAPIKeyRevoke, ActorID: u.ID, TargetType: "user", TargetID: u.ID})
+	w.WriteHeader(http.StatusOK)
 }
 
 func LogoutHandler(cfg *config.Config, db *gorm.DB) http.Handler {
@@ -151,6 +156,7 @@ func LogoutHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 		}
 		u.AccessToken = authorization.CalculateSignature(authorization.GenerateAccessToken(), cfg.HASHKey)
 		u.UpdateAccessToken(db)
+		audit.Record(db, r, models.AuditEvent{Action: models.AuditLogout, ActorID: u.ID, TargetType: "user", TargetID: u.ID})
 		cookie := http.Cookie{Name: "UAAT", Path: "/", MaxAge: -1}
 		http.SetCookie(w, &cookie)
 		http.Redirect(w, r, "/", http.StatusFound)
@@ -166,7 +172,7 @@ func responseXML(r *http.Request) bool {
 
 func xmlWrite(w http.ResponseWriter, data interface{}) error {
 	xmlB, err := xml.MarshalIndent(data, "", " ")
-	
+
 	if err != nil {
 		ResponseError(w, http.StatusInternalServerError, "")
 		return err
@@ -189,8 +195,58 @@ func jsonWrite(w http.ResponseWriter, data interface{}) error {
 	return nil
 }
 
+// attachmentWrite builds a download into a temporary file and sends it as
+// filename once it is complete, so that a failed build answers 500 instead
+// of a truncated 200.
+func attachmentWrite(w http.ResponseWriter, contentType, filename string, build func(io.Writer) error) error {
+	f, err := ioutil.TempFile("", "forum-download-")
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	if err = build(bw); err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return err
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ResponseError answers code with msg, or the status text when msg is empty,
+// translated into the language of the request.
 func ResponseError(w http.ResponseWriter, code int, msg string) {
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	jsonB, _ := json.Marshal(map[string]string{"error": i18n.T(i18n.LangOf(w), msg)})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	fmt.Fprintf(w, `{"error": "%s"}`, msg)
+	w.Write(jsonB)
+}
+
+// ResponseFieldErrors answers 400 naming every invalid field of the request:
+// {"error": "invalid fields", "fields": {"body": "must be at most 100 characters"}}
+// Messages are translated into the language of the request.
+func ResponseFieldErrors(w http.ResponseWriter, fields map[string]string) {
+	lang := i18n.LangOf(w)
+	translated := make(map[string]string, len(fields))
+	for field, msg := range fields {
+		translated[field] = i18n.T(lang, msg)
+	}
+	jsonB, _ := json.MarshalIndent(map[string]interface{}{"error": i18n.T(lang, "invalid fields"), "fields": translated}, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprint(w, string(jsonB))
 }
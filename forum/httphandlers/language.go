@@ -0,0 +1,93 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/i18n"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+func LanguageHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rPath := r.URL.Path
+		reLanguage := regexp.MustCompile(`^\/language(\/)??$`)
+
+		switch {
+		case reLanguage.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // language of the request and preference of current user
+				getLanguageHTTP(cfg, db, w, r)
+			case http.MethodPut: // change language preference of current user in:json
+				updateLanguageHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+type languageStruct struct {
+	Language   string   `json:"language"`            //of this request
+	Preference string   `json:"preference"`          //of the current user, empty to negotiate
+	Available  []string `json:"available,omitempty"` //shipped languages
+}
+
+//@Summary Show language
+//@Description language of the request (the lang query parameter, else the preference of the current user, else the Accept-Language header), the preference of the current user and the available languages
+//@Produce json
+//@Param lang query string false "language override"
+//@Success 200
+//@Failure default
+//@Router /language [get]
+func getLanguageHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	jsonWrite(w, languageStruct{Language: i18n.FromContext(r.Context()), Preference: u.Language, Available: i18n.Languages()})
+}
+
+//@Summary Change language
+//@Description change the preferred language of the current user used for the web UI and API messages; an empty language negotiates it from Accept-Language again
+//@Accept json
+//@Produce json
+//@Param RequestLanguage body languageStruct true "JSON structure with the language"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /language [put]
+//@Security ApiKeyAuth
+func updateLanguageHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req languageStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if req.Language != "" && !i18n.Supported(req.Language) {
+		ResponseFieldErrors(w, fieldErrors{"language": "must be among " + strings.Join(i18n.Languages(), ", ")})
+		return
+	}
+	u.Language = req.Language
+	if result := u.UpdateLanguage(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	lang := u.Language
+	if lang == "" {
+		lang = i18n.Match(r.Header.Get("Accept-Language"))
+	}
+	jsonWrite(w, languageStruct{Language: lang, Preference: u.Language})
+}
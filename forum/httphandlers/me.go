@@ -0,0 +1,347 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"nx_trainee_forum/forum/accounts"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/archive"
+	"nx_trainee_forum/forum/httphandlers/audit"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/imaging"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/storage"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func MeHandler(cfg *config.Config, db *gorm.DB, store storage.Storage, images *imaging.Processor, deleter *accounts.Deleter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reMe := regexp.MustCompile(`^\/me(\/)??$`)
+		reMeAvatar := regexp.MustCompile(`^\/me\/avatar(\/)??$`)
+		reMeExport := regexp.MustCompile(`^\/me\/export(\/)??$`)
+		reMeDelete := regexp.MustCompile(`^\/me\/delete(\/)??$`)
+
+		switch {
+		case reMe.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // own profile and settings
+				getMeHTTP(cfg, db, w, r)
+			case http.MethodPut: // change own profile and privacy settings in:json
+				updateMeHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reMeAvatar.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPut: // upload avatar
+				uploadAvatarHTTP(cfg, db, store, images, w, r)
+			case http.MethodDelete: // remove avatar
+				deleteAvatarHTTP(cfg, db, store, images, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reMeExport.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // download everything stored about the user
+				exportMeHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reMeDelete.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // scheduled deletion of the account
+				getDeletionHTTP(cfg, db, deleter, w, r)
+			case http.MethodPost: // request deletion of the account
+				requestDeletionHTTP(cfg, db, deleter, w, r)
+			case http.MethodDelete: // cancel the requested deletion
+				cancelDeletionHTTP(cfg, db, deleter, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+// meStruct is the profile of the current user with their settings.
+type meStruct struct {
+	XMLName xml.Name `xml:"me" json:"-"`
+	models.Profile
+	Email              string `json:"email" xml:"email"`
+	ProfileVisibility  string `json:"profileVisibility" xml:"profileVisibility"`
+	ShowEmail          bool   `json:"showEmail" xml:"showEmail"`
+	EmailNotifications string `json:"emailNotifications" xml:"emailNotifications"`
+	Language           string `json:"language" xml:"language"`
+}
+
+type profileStruct struct {
+	Name              *string         `json:"name"`
+	Bio               *string         `json:"bio"`
+	ProfileVisibility string          `json:"profileVisibility"`
+	ShowEmail         *bool           `json:"showEmail"`
+	Phone             *string         `json:"phone"`
+	Website           *string         `json:"website"`
+	Address           *models.Address `json:"address"` //{} clears the address
+	Company           *models.Company `json:"company"` //{} clears the company
+}
+
+// writeMe answers with the profile and settings of u.
+func writeMe(cfg *config.Config, DB *gorm.DB, u models.User, w http.ResponseWriter, r *http.Request) {
+	pp, err := profiles(cfg, DB, r, []models.User{u})
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	me := meStruct{Profile: pp[0], Email: u.Email, ProfileVisibility: u.ProfileVisibility, ShowEmail: u.ShowEmail,
+		EmailNotifications: u.EmailNotifications, Language: u.Language}
+	if responseXML(r) {
+		xmlWrite(w, me)
+	} else {
+		jsonWrite(w, me)
+	}
+}
+
+//@Summary Show own profile
+//@Description profile of the current user with the email address and the privacy, notification and language settings
+//@Produce json
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure default
+//@Router /me [get]
+//@Security ApiKeyAuth
+func getMeHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	writeMe(cfg, DB, authorization.GetCurrentUser(cfg, DB, r), w, r)
+}
+
+//@Summary Change own profile
+//@Description change display name, bio, contact details and privacy settings of the current user; omitted fields keep their values, and an empty address or company object clears it. profileVisibility is public (everyone), members (logged in users) or private (only the user and moderators)
+//@Accept json
+//@Produce json
+//@Param RequestProfile body profileStruct true "JSON structure for changing the profile"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /me [put]
+//@Security ApiKeyAuth
+func updateMeHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req profileStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if req.Name != nil {
+		u.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Bio != nil {
+		u.Bio = *req.Bio
+	}
+	if req.ProfileVisibility != "" {
+		u.ProfileVisibility = req.ProfileVisibility
+	}
+	if req.ShowEmail != nil {
+		u.ShowEmail = *req.ShowEmail
+	}
+	if req.Phone != nil {
+		u.Phone = strings.TrimSpace(*req.Phone)
+	}
+	if req.Website != nil {
+		u.Website = strings.TrimSpace(*req.Website)
+	}
+	if req.Address != nil {
+		u.SetAddress(req.Address)
+	}
+	if req.Company != nil {
+		u.SetCompany(req.Company)
+	}
+	if fe := profileFieldErrors(cfg, &u); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	if result := u.UpdateProfile(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeMe(cfg, DB, u, w, r)
+}
+
+//@Summary Upload avatar
+//@Description set the avatar of the current user; multipart/form-data with an image in the "file" field, which is stripped of metadata and thumbnailed like image attachments. The previous avatar is removed
+//@Accept mpfd
+//@Produce json
+//@Param file formData file true "avatar image"
+//@Success 200
+//@Failure 400,413,415
+//@Failure default
+//@Router /me/avatar [put]
+//@Security ApiKeyAuth
+func uploadAvatarHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	old := u.AvatarID
+	a := models.Attachment{UserID: u.ID}
+	if !receiveUpload(cfg, DB, store, images, &a, true, w, r) {
+		return
+	}
+	u.AvatarID = &a.ID
+	if result := u.UpdateAvatar(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	removeAvatar(store, images, DB, old)
+	writeMe(cfg, DB, u, w, r)
+}
+
+//@Summary Remove avatar
+//@Description remove the avatar of the current user
+//@Success 200
+//@Failure default
+//@Router /me/avatar [delete]
+//@Security ApiKeyAuth
+func deleteAvatarHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	old := u.AvatarID
+	u.AvatarID = nil
+	if result := u.UpdateAvatar(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	removeAvatar(store, images, DB, old)
+	w.WriteHeader(http.StatusOK)
+}
+
+// removeAvatar deletes the attachment of a replaced avatar and its blobs
+// unless something else refers to them.
+func removeAvatar(store storage.Storage, images *imaging.Processor, DB *gorm.DB, id *int) {
+	if id == nil {
+		return
+	}
+	apr := models.AttachmentProcess{}
+	a, result := apr.GetAttachment(DB, map[string]interface{}{"id": *id})
+	if result.Error != nil || apr.DeleteAttachment(DB, &a).Error != nil {
+		return
+	}
+	removeBlobs(store, images, DB, []string{a.Hash})
+}
+
+//@Summary Export own data
+//@Description download everything the forum stores about the current user as a JSON archive: the profile (without credentials), posts, comments, sessions (logins and logouts) and the audit events by or about the user
+//@Produce json
+//@Success 200
+//@Failure 500
+//@Failure default
+//@Router /me/export [get]
+//@Security ApiKeyAuth
+func exportMeHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditUserExport, ActorID: u.ID, TargetType: "user", TargetID: u.ID})
+	err := attachmentWrite(w, "application/json", "me.json", func(w io.Writer) error {
+		return archive.ExportUser(DB, w, u.ID)
+	})
+	if err != nil {
+		log.Printf("export of user %d: %v", u.ID, err)
+	}
+}
+
+// deletionStruct is the scheduled deletion of an account.
+type deletionStruct struct {
+	XMLName  xml.Name   `xml:"deletion" json:"-"`
+	DeleteAt *time.Time `json:"deleteAt" xml:"deleteAt,omitempty"` //null if no deletion is pending
+	Policy   string     `json:"policy" xml:"policy"`               //anonymize or remove the posts and comments
+}
+
+func writeDeletion(u models.User, deleter *accounts.Deleter, code int, w http.ResponseWriter, r *http.Request) {
+	d := deletionStruct{DeleteAt: u.DeleteAt, Policy: deleter.Policy()}
+	if responseXML(r) {
+		xmlB, _ := xml.MarshalIndent(d, "", " ")
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(code)
+		w.Write(xmlB)
+		return
+	}
+	jsonB, _ := json.MarshalIndent(d, "", "  ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(jsonB)
+}
+
+//@Summary Show the deletion of own account
+//@Description when the account of the current user will be deleted, null if no deletion is pending, and what happens to the posts and comments then
+//@Produce json
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure default
+//@Router /me/delete [get]
+//@Security ApiKeyAuth
+func getDeletionHTTP(cfg *config.Config, DB *gorm.DB, deleter *accounts.Deleter, w http.ResponseWriter, r *http.Request) {
+	writeDeletion(authorization.GetCurrentUser(cfg, DB, r), deleter, http.StatusOK, w, r)
+}
+
+//@Summary Delete own account
+//@Description schedule the deletion of the current user's account after the grace period and sign out everywhere: the API key and the access token are revoked right away. Once the period is over the profile, avatar, albums, todos and notifications are removed and the posts and comments are anonymized or removed, per policy. Signing in again during the grace period allows to cancel the deletion
+//@Produce json
+//@Param xml query string false "show data like XML"
+//@Success 202
+//@Failure default
+//@Router /me/delete [post]
+//@Security ApiKeyAuth
+func requestDeletionHTTP(cfg *config.Config, DB *gorm.DB, deleter *accounts.Deleter, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.DeleteAt == nil {
+		deleteAt := time.Now().AddDate(0, 0, cfg.DeletionGraceDays)
+		u.DeleteAt = &deleteAt
+	}
+	//replace the credentials with hashes of ones nobody knows
+	u.AccessToken = authorization.CalculateSignature(authorization.GenerateAccessToken(), cfg.HASHKey)
+	u.APIKey = authorization.CalculateSignature(authorization.GenerateAccessToken(), cfg.HASHKey)
+	if result := u.UpdateDeletion(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditDeleteRequest, ActorID: u.ID, TargetType: "user", TargetID: u.ID,
+		Details: u.DeleteAt.UTC().Format(time.RFC3339)})
+	cookie := http.Cookie{Name: "UAAT", Path: "/", MaxAge: -1}
+	http.SetCookie(w, &cookie)
+	writeDeletion(u, deleter, http.StatusAccepted, w, r)
+}
+
+//@Summary Cancel the deletion of own account
+//@Description cancel the pending deletion of the current user's account
+//@Produce json
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure default
+//@Router /me/delete [delete]
+//@Security ApiKeyAuth
+func cancelDeletionHTTP(cfg *config.Config, DB *gorm.DB, deleter *accounts.Deleter, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.DeleteAt != nil {
+		u.DeleteAt = nil
+		if result := u.UpdateDeletion(DB); result.Error != nil {
+			ResponseError(w, http.StatusInternalServerError, "")
+			return
+		}
+		audit.Record(DB, r, models.AuditEvent{Action: models.AuditDeleteCancel, ActorID: u.ID, TargetType: "user", TargetID: u.ID})
+	}
+	writeDeletion(u, deleter, http.StatusOK, w, r)
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"encoding/json"
 	"net/http"
 	"nx_trainee_forum/forum/application/config"
 	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/i18n"
 
 	"gorm.io/gorm"
 )
@@ -16,10 +18,60 @@ func Authorization(cfg *config.Config, db *gorm.DB, next http.Handler) http.Hand
 		}
 		u := authorization.GetCurrentUser(cfg, db, r)
 		if u.ID == 0 {
-			w.WriteHeader(http.StatusNetworkAuthenticationRequired)
-			w.Write([]byte(`{"error":""}`))
+			writeError(w, http.StatusNetworkAuthenticationRequired)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireRole lets through only authenticated users holding role (or a higher
+// one), for every method including GET.
+func RequireRole(cfg *config.Config, db *gorm.DB, role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := authorization.GetCurrentUser(cfg, db, r)
+		if u.ID == 0 {
+			writeError(w, http.StatusNetworkAuthenticationRequired)
+			return
+		}
+		if !u.HasRole(role) {
+			writeError(w, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Locale picks the language of the request: the lang query parameter, else
+// the preference of the current user, else the language cookie set by the web
+// UI, else the Accept-Language header. It is stored in the request context
+// and carried by the response writer for error responses.
+func Locale(cfg *config.Config, db *gorm.DB, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := r.URL.Query().Get("lang")
+		if !i18n.Supported(lang) {
+			lang = ""
+			if _, err := r.Cookie("UAAT"); err == nil || r.Header.Get("APIKey") != "" {
+				if u := authorization.GetCurrentUser(cfg, db, r); i18n.Supported(u.Language) {
+					lang = u.Language
+				}
+			}
+		}
+		if c, err := r.Cookie(i18n.Cookie); err == nil && lang == "" && i18n.Supported(c.Value) {
+			lang = c.Value
+		}
+		if lang == "" {
+			lang = i18n.Match(r.Header.Get("Accept-Language"))
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", lang)
+		next.ServeHTTP(i18n.NewResponseWriter(w, lang), r.WithContext(i18n.WithLang(r.Context(), lang)))
+	})
+}
+
+func writeError(w http.ResponseWriter, code int) {
+	jsonB, _ := json.Marshal(map[string]string{"error": i18n.T(i18n.LangOf(w), http.StatusText(code))})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(jsonB)
+}
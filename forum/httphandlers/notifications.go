@@ -0,0 +1,274 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/mail"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/mailer"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	notificationsDefaultLimit = 50
+	notificationsMaxLimit     = 500
+)
+
+func NotificationsHandler(cfg *config.Config, db *gorm.DB, m *mailer.Mailer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reNotifications := regexp.MustCompile(`^\/notifications(\/)??$`)
+		reNotificationsCount := regexp.MustCompile(`^\/notifications\/count(\/)??$`)
+		reNotificationsReadAll := regexp.MustCompile(`^\/notifications\/read(\/)??$`)
+		reNotificationsIDRead := regexp.MustCompile(`^\/notifications\/\d+\/read(\/)??$`)
+		reNotificationsEmail := regexp.MustCompile(`^\/notifications\/email(\/)??$`)
+
+		switch {
+		case reNotifications.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list notifications of current user
+				listNotificationsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reNotificationsCount.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // count unread notifications
+				countNotificationsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reNotificationsReadAll.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // mark all notifications read
+				readAllNotificationsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reNotificationsIDRead.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // mark notification read
+				readNotificationHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reNotificationsEmail.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // get email settings of current user
+				getEmailSettingsHTTP(cfg, db, w, r)
+			case http.MethodPut: // change email settings of current user in:json
+				updateEmailSettingsHTTP(cfg, db, m, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+type emailSettingsStruct struct {
+	Email              *string `json:"email"`
+	EmailVerified      bool    `json:"emailVerified"` //read-only, set by the link mailed to the address
+	EmailNotifications string  `json:"emailNotifications"`
+}
+
+//@Summary List notifications
+//@Description list notifications of the current user, newest first, with the number of unread ones
+//@Produce json
+//@Param unread query string false "only unread notifications"
+//@Param limit query integer false "maximum number of notifications, 50 by default"
+//@Param offset query integer false "number of notifications to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /notifications [get]
+//@Security ApiKeyAuth
+func listNotificationsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	limit, offset := notificationsDefaultLimit, 0
+	var err error
+	if v := r.FormValue("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if limit > notificationsMaxLimit {
+			limit = notificationsMaxLimit
+		}
+	}
+	if v := r.FormValue("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	unreadOnly := false
+	if _, ok := r.Form["unread"]; ok {
+		unreadOnly = r.FormValue("unread") != "false"
+	}
+	npr := models.NotificationProcess{}
+	nn, result := npr.ListNotifications(DB, u.ID, unreadOnly, limit, offset)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	unread, result := npr.CountUnread(DB, u.ID)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	resp := models.Notifications{Unread: unread, Notifications: nn}
+	if responseXML(r) {
+		xmlWrite(w, resp)
+	} else {
+		jsonWrite(w, resp)
+	}
+}
+
+//@Summary Count unread notifications
+//@Description number of unread notifications of the current user
+//@Produce json
+//@Success 200
+//@Failure default
+//@Router /notifications/count [get]
+//@Security ApiKeyAuth
+func countNotificationsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	npr := models.NotificationProcess{}
+	unread, result := npr.CountUnread(DB, u.ID)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	jsonWrite(w, map[string]int64{"unread": unread})
+}
+
+//@Summary Mark notification read
+//@Description mark a notification of the current user read
+//@Param id path int true "ID of notification"
+//@Success 200
+//@Failure 404
+//@Failure default
+//@Router /notifications/{id}/read [post]
+//@Security ApiKeyAuth
+func readNotificationHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	npr := models.NotificationProcess{}
+	result := npr.MarkRead(DB, u.ID, id)
+	if result.Error == gorm.ErrRecordNotFound {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+//@Summary Mark all notifications read
+//@Description mark every notification of the current user read
+//@Success 200
+//@Failure default
+//@Router /notifications/read [post]
+//@Security ApiKeyAuth
+func readAllNotificationsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	npr := models.NotificationProcess{}
+	if result := npr.MarkAllRead(DB, u.ID); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+//@Summary Show email settings
+//@Description email address, whether it is verified, and email notification preference (immediate, digest or off) of the current user
+//@Produce json
+//@Success 200
+//@Failure default
+//@Router /notifications/email [get]
+//@Security ApiKeyAuth
+func getEmailSettingsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	jsonWrite(w, emailSettingsStruct{Email: &u.Email, EmailVerified: u.EmailVerified, EmailNotifications: u.EmailNotifications})
+}
+
+//@Summary Change email settings
+//@Description change email address and email notification preference (immediate, digest or off) of the current user; omitted fields keep their values, an empty email stops emails. A new address gets a message with a link confirming it, and no other email before
+//@Accept json
+//@Produce json
+//@Param RequestEmailSettings body emailSettingsStruct true "JSON structure for changing email settings"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /notifications/email [put]
+//@Security ApiKeyAuth
+func updateEmailSettingsHTTP(cfg *config.Config, DB *gorm.DB, m *mailer.Mailer, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req emailSettingsStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	errs := fieldErrors{}
+	//an unverified address sent again gets a new link
+	changed := req.Email != nil && (*req.Email != u.Email || !u.EmailVerified)
+	if changed {
+		u.Email, u.EmailVerified = *req.Email, false
+		if u.Email != "" {
+			addr, err := mail.ParseAddress(u.Email)
+			if err != nil || addr.Address != u.Email || len(u.Email) > 320 {
+				errs["email"] = "must be a valid email address"
+			}
+		}
+	}
+	if req.EmailNotifications != "" {
+		u.EmailNotifications = req.EmailNotifications
+		if !models.ValidEmailPreference(u.EmailNotifications) {
+			errs["emailNotifications"] = "must be immediate, digest or off"
+		}
+	}
+	if len(errs) > 0 {
+		ResponseFieldErrors(w, errs)
+		return
+	}
+	if result := u.UpdateEmailSettings(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if changed {
+		if err = m.SendVerification(u); err != nil {
+			log.Printf("email verification of user %d: %v", u.ID, err)
+		}
+	}
+	jsonWrite(w, emailSettingsStruct{Email: &u.Email, EmailVerified: u.EmailVerified, EmailNotifications: u.EmailNotifications})
+}
@@ -0,0 +1,263 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+func PhotosHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		rePhotos := regexp.MustCompile(`^\/photos(\/)??$`)
+		rePhotosID := regexp.MustCompile(`^\/photos\/\d+(\/)??$`)
+
+		switch {
+		case rePhotos.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list photos
+				listPhotosHTTP(cfg, db, w, r)
+			case http.MethodPost: // create photo in:json
+				createPhotoHTTP(cfg, db, w, r)
+			case http.MethodPut: // update photo in:json
+				updatePhotoHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case rePhotosID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // get photos/{id}
+				getPhotoHTTP(cfg, db, w, r)
+			case http.MethodDelete: // delete photos/{id}
+				deletePhotoHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+func writePhotos(w http.ResponseWriter, r *http.Request, pp []models.Photo) {
+	if responseXML(r) {
+		xmlWrite(w, models.Photos{Photos: pp})
+	} else {
+		jsonWrite(w, pp)
+	}
+}
+
+// ownAlbum answers 400 unless album id exists and 403 unless it belongs to u.
+func ownAlbum(DB *gorm.DB, u models.User, id int, w http.ResponseWriter) bool {
+	apr := models.AlbumProcess{}
+	a, result := apr.GetAlbum(DB, map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return false
+	}
+	if a.UserID != u.ID {
+		ResponseError(w, http.StatusForbidden, "")
+		return false
+	}
+	return true
+}
+
+//@Summary List photos
+//@Description list photos by ID
+//@Produce json
+//@Param albumId query integer false "photos filter by album"
+//@Param limit query integer false "maximum number of photos, 500 by default"
+//@Param offset query integer false "number of photos to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /photos [get]
+//@Security ApiKeyAuth
+func listPhotosHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	param := map[string]interface{}{}
+	limit, offset, ok := pageParams(r, resourcesDefaultLimit, resourcesMaxLimit)
+	if !ok || !intParam(r, param, "albumId") {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	ppr := models.PhotoProcess{}
+	pp, result := ppr.ListPhotos(visiblePhotos(cfg, DB, r).Limit(limit).Offset(offset), param)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writePhotos(w, r, pp)
+}
+
+//@Summary Show photo
+//@Description get photo by ID
+//@Produce json
+//@Param id path integer true "Photo ID"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /photos/{id} [get]
+//@Security ApiKeyAuth
+func getPhotoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	ppr := models.PhotoProcess{}
+	p, result := ppr.GetPhoto(visiblePhotos(cfg, DB, r), map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, p)
+	} else {
+		jsonWrite(w, p)
+	}
+}
+
+type photoStruct struct {
+	ID           int    `json:"id"`
+	AlbumID      int    `json:"albumId"`
+	Title        string `json:"title"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+}
+
+//@Summary Create photo
+//@Description add a photo to an album of the current user; url and thumbnailUrl link to the images, which are not uploaded
+//@Accept json
+//@Produce json
+//@Param RequestPhoto body photoStruct true "JSON structure for creating photo"
+//@Success 201
+//@Failure 400,403
+//@Failure default
+//@Router /photos [post]
+//@Security ApiKeyAuth
+func createPhotoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req photoStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if !ownAlbum(DB, u, req.AlbumID, w) {
+		return
+	}
+	p := models.Photo{AlbumID: req.AlbumID, Title: req.Title, URL: req.URL, ThumbnailURL: req.ThumbnailURL}
+	if fe := photoFieldErrors(cfg, &p); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	ppr := models.PhotoProcess{}
+	if result := ppr.CreatePhoto(DB, &p); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonP, _ := json.MarshalIndent(p, "", "  ")
+	fmt.Fprintln(w, string(jsonP))
+}
+
+//@Summary Update photo
+//@Description update photo in an album of the current user; omitted fields keep their values, and albumId moves the photo to another album of the user
+//@Accept json
+//@Produce json
+//@Param RequestPhoto body photoStruct true "JSON structure for updating photo"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /photos [put]
+//@Security ApiKeyAuth
+func updatePhotoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req struct{ ID int }
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	ppr := models.PhotoProcess{}
+	p, result := ppr.GetPhoto(DB, map[string]interface{}{"id": req.ID})
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if !ownAlbum(DB, u, p.AlbumID, w) {
+		return
+	}
+	//apply the given fields over the stored photo
+	if err = json.Unmarshal(reqBody, &p); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if !ownAlbum(DB, u, p.AlbumID, w) {
+		return
+	}
+	if fe := photoFieldErrors(cfg, &p); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	if result = ppr.UpdatePhoto(DB, &p); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	jsonWrite(w, p)
+}
+
+//@Summary Delete photo
+//@Description delete photo from an album of the current user
+//@Param id path int true "ID of deleting photo"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /photos/{id} [delete]
+//@Security ApiKeyAuth
+func deletePhotoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	ppr := models.PhotoProcess{}
+	p, result := ppr.GetPhoto(DB, map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if !ownAlbum(DB, u, p.AlbumID, w) {
+		return
+	}
+	if result = ppr.DeletePhoto(DB, &p); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -6,19 +6,28 @@ import (
 	"io/ioutil"
 	"net/http"
 	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
 	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/imaging"
 	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/notify"
+	"nx_trainee_forum/forum/storage"
 	"regexp"
 	"strconv"
+	"time"
 
 	"gorm.io/gorm"
 )
 
-func PostsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+func PostsHandler(cfg *config.Config, db *gorm.DB, store storage.Storage, images *imaging.Processor, notifier *notify.Notifier) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
 		rPath := r.URL.Path
 		rePostsComments := regexp.MustCompile(`^\/posts\/\d+\/comments(\/)??$`)
+		rePostsVote := regexp.MustCompile(`^\/posts\/\d+\/vote(\/)??$`)
+		rePostsReactions := regexp.MustCompile(`^\/posts\/\d+\/reactions(\/)??$`)
+		rePostsAttachments := regexp.MustCompile(`^\/posts\/\d+\/attachments(\/)??$`)
+		rePostsAnswer := regexp.MustCompile(`^\/posts\/\d+\/answer(\/)??$`)
 		rePostsID := regexp.MustCompile(`^\/posts\/\d+(\/)??$`)
 		rePosts := regexp.MustCompile(`^\/posts(\/)??$`)
 
@@ -26,11 +35,11 @@ func PostsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 		case rePosts.Match([]byte(rPath)):
 			switch r.Method {
 			case http.MethodGet: //list posts with filters
-				listPostsHTTP(db, w, r)
+				listPostsHTTP(cfg, db, w, r)
 			case http.MethodPost: //create post in:json
-				createPostHTTP(cfg, db, w, r)
+				createPostHTTP(cfg, db, notifier, w, r)
 			case http.MethodPut: //update post  in:json
-				updatePostHTTP(cfg, db, w, r)
+				updatePostHTTP(cfg, db, notifier, w, r)
 			default:
 				ResponseError(w, http.StatusMethodNotAllowed, "")
 				return
@@ -38,9 +47,9 @@ func PostsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 		case rePostsID.Match([]byte(rPath)):
 			switch r.Method {
 			case http.MethodGet: // get posts/{id}
-				getPostByIDHTTP(db, w, r)
+				getPostByIDHTTP(cfg, db, w, r)
 			case http.MethodDelete: // delete posts/{id}
-				deletePostHTTP(cfg, db, w, r)
+				deletePostHTTP(cfg, db, store, images, notifier, w, r)
 			default:
 				ResponseError(w, http.StatusMethodNotAllowed, "")
 				return
@@ -48,7 +57,45 @@ func PostsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 		case rePostsComments.Match([]byte(rPath)):
 			switch r.Method {
 			case http.MethodGet: // list comments like->/comments?postId={id}
-				listPostCommentsHTTP(db, w, r)
+				listPostCommentsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case rePostsVote.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPut: // vote for post in:json
+				voteHTTP(cfg, db, models.TargetPost, w, r)
+			case http.MethodDelete: // retract vote
+				voteHTTP(cfg, db, models.TargetPost, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case rePostsAnswer.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPut: // accept answer in:json
+				acceptAnswerHTTP(cfg, db, notifier, w, r)
+			case http.MethodDelete: // clear accepted answer
+				acceptAnswerHTTP(cfg, db, notifier, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case rePostsReactions.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // add reaction in:json
+				reactionHTTP(cfg, db, models.TargetPost, w, r)
+			case http.MethodDelete: // remove reaction ?emoji=
+				reactionHTTP(cfg, db, models.TargetPost, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case rePostsAttachments.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // upload attachment in:multipart
+				uploadAttachmentHTTP(cfg, db, store, images, models.TargetPost, w, r)
 			default:
 				ResponseError(w, http.StatusMethodNotAllowed, "")
 				return
@@ -64,6 +111,10 @@ func PostsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 //@Description get posts
 //@Produce json
 //@Param userId query integer false "posts filter by user"
+//@Param categoryId query integer false "posts filter by category"
+//@Param type query string false "posts filter by type: discussion or question"
+//@Param answered query boolean false "true - questions with an accepted answer, false - questions without one"
+//@Param sort query string false "new, score or hot; by ID if omitted"
 //@Param xml query string false "show data like XML"
 //@success 200
 //@Failure 400,404
@@ -71,7 +122,7 @@ func PostsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
 //@Failure default
 //@Router /posts/ [get]
 //@Security ApiKeyAuth
-func listPostsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func listPostsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	var param map[string]interface{} = make(map[string]interface{})
 	userId := r.FormValue("userId")
 	if userId != "" {
@@ -82,8 +133,48 @@ func listPostsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	categoryId := r.FormValue("categoryId")
+	if categoryId != "" {
+		var err error
+		param["categoryId"], err = strconv.Atoi(categoryId)
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	postType := r.FormValue("type")
+	if postType != "" {
+		if !models.ValidPostType(postType) {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		param["type"] = postType
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if v := r.FormValue("answered"); v != "" {
+		answered, err := strconv.ParseBool(v)
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		param["type"] = models.PostTypeQuestion
+		if answered {
+			tx = tx.Where("acceptedCommentId IS NOT NULL")
+		} else {
+			tx = tx.Where("acceptedCommentId IS NULL")
+		}
+	}
+	tx, ok := applySort(tx, "posts", r)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
 	ppr := new(models.PostProcess)
-	pp, result := ppr.ListPosts(DB, param)
+	pp, result := ppr.ListPosts(tx, param)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			ResponseError(w, http.StatusNotFound, "")
@@ -110,7 +201,7 @@ func listPostsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 //@Failure default
 //@Router /posts/{id} [get]
 //@Security ApiKeyAuth
-func getPostByIDHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func getPostByIDHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	var param map[string]interface{} = make(map[string]interface{})
 	var err error
 	param["id"], err = strconv.Atoi(reNum.FindString(r.URL.Path))
@@ -118,8 +209,13 @@ func getPostByIDHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
 	ppr := models.PostProcess{}
-	p, result := ppr.GetPost(DB, param)
+	p, result := ppr.GetPost(tx, param)
 	if result.Error != nil {
 		ResponseError(w, http.StatusNotFound, "")
 		return
@@ -132,13 +228,22 @@ func getPostByIDHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 }
 
 type createPostStruct struct {
-	Title string
-	Body  string
+	CategoryID *int   `json:"categoryId"`
+	Type       string `json:"type"`
+	Title      string
+	Body       string
+	Tags       []string
 }
 type updatePostStruct struct {
-	ID    int
-	Title string
-	Body  string
+	ID         int
+	CategoryID *int   `json:"categoryId"`
+	Type       string `json:"type"`
+	Title      string
+	Body       string
+	Tags       []string
+}
+type acceptAnswerStruct struct {
+	CommentID *int `json:"commentId"`
 }
 
 //@Summary Create post
@@ -151,7 +256,7 @@ type updatePostStruct struct {
 //@Failure default
 //@Router /posts/ [POST]
 //@Security ApiKeyAuth
-func createPostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func createPostHTTP(cfg *config.Config, DB *gorm.DB, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
 	u := authorization.GetCurrentUser(cfg, DB, r)
 	if u.ID == 0 {
 		ResponseError(w, http.StatusInternalServerError, "")
@@ -174,13 +279,25 @@ func createPostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *h
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	if fe := postFieldErrors(cfg, &p); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	if !canPostInCategory(DB, u, p.CategoryID, w) {
+		return
+	}
 	p.UserID = u.ID
+	p.AcceptedID = nil
+	p.Attachments = nil
+	p.CreatedAt, p.UpdatedAt = time.Time{}, time.Time{} //stamped on insert; only imports keep their own
 	ppr := models.PostProcess{}
 	result := ppr.CreatePost(DB, &p)
 	if result.Error != nil {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditPostCreate, ActorID: u.ID, TargetType: "post", TargetID: p.ID})
+	notifier.Publish(notify.Event{Kind: notify.PostCreated, ActorID: u.ID, PostID: p.ID, Mentions: p.Mentions})
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	jsonP, _ := json.MarshalIndent(p, "", "  ")
@@ -197,7 +314,7 @@ func createPostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *h
 //@Failure default
 //@Router /posts/ [put]
 //@Security ApiKeyAuth
-func updatePostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func updatePostHTTP(cfg *config.Config, DB *gorm.DB, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
 	u := authorization.GetCurrentUser(cfg, DB, r)
 	if u.ID == 0 {
 		ResponseError(w, http.StatusInternalServerError, "")
@@ -216,6 +333,11 @@ func updatePostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *h
 		ResponseError(w, http.StatusInternalServerError, "")
 		return
 	}
+	if fe := postFieldErrors(cfg, &p); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	p.AcceptedID = nil
 	ppr := models.PostProcess{}
 	pUpd, result := ppr.GetPost(DB, map[string]interface{}{"id": p.ID})
 	if result.Error != nil || result.RowsAffected == 0 {
@@ -226,11 +348,17 @@ func updatePostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *h
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	if p.CategoryID != nil && !canPostInCategory(DB, u, p.CategoryID, w) {
+		return
+	}
+	p.UserID = u.ID
 	result = ppr.UpdatePost(DB, &p)
 	if result.Error != nil {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditPostUpdate, ActorID: u.ID, TargetType: "post", TargetID: p.ID})
+	notifier.Publish(notify.Event{Kind: notify.PostUpdated, ActorID: u.ID, PostID: p.ID, Mentions: p.Mentions})
 	w.Header().Set("Content-Type", "application/json")
 	jsonP, _ := json.MarshalIndent(p, "", "  ")
 	fmt.Fprintln(w, string(jsonP))
@@ -243,7 +371,7 @@ func updatePostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *h
 //@Failure default
 //@Router /posts/{id} [delete]
 //@Security ApiKeyAuth
-func deletePostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func deletePostHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, images *imaging.Processor, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
 	u := authorization.GetCurrentUser(cfg, DB, r)
 	if u.ID == 0 {
 		ResponseError(w, http.StatusInternalServerError, "")
@@ -266,24 +394,106 @@ func deletePostHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *h
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	apr := models.AttachmentProcess{}
+	hashes, err := apr.PostHashes(DB, pID)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
 	var p models.Post = models.Post{ID: pID, UserID: u.ID}
 	result = ppr.DeletePost(DB, &p)
 	if result.Error != nil {
 		ResponseError(w, http.StatusInternalServerError, "")
 		return
 	}
+	removeBlobs(store, images, DB, hashes)
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditPostDelete, ActorID: u.ID, TargetType: "post", TargetID: pID})
+	notifier.Publish(notify.Event{Kind: notify.PostDeleted, ActorID: u.ID, PostID: pID, CategoryID: pDel.CategoryID})
 	w.WriteHeader(http.StatusOK)
 }
 
+//@Summary Accept answer
+//@Description mark a top-level comment as the answer to a question (post author or mentor); DELETE clears it
+//@Accept json
+//@Produce json
+//@Param id path int true "ID of question"
+//@Param RequestAnswer body acceptAnswerStruct true "JSON structure with ID of accepted comment"
+//@Success 200
+//@Failure 400,403,404
+//@Failure default
+//@Router /posts/{id}/answer [put]
+//@Security ApiKeyAuth
+func acceptAnswerHTTP(cfg *config.Config, DB *gorm.DB, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.ID == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	pID, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req acceptAnswerStruct
+	if r.Method != http.MethodDelete {
+		reqBody, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if err = json.Unmarshal(reqBody, &req); err != nil || req.CommentID == nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	ppr := models.PostProcess{}
+	p, result := ppr.GetPost(tx, map[string]interface{}{"id": pID})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if p.UserID != u.ID && !u.HasRole(models.RoleMentor) {
+		ResponseError(w, http.StatusForbidden, "")
+		return
+	}
+	result = ppr.AcceptAnswer(DB, &p, req.CommentID)
+	if result.Error == models.ErrNotQuestion || result.Error == models.ErrInvalidAnswer {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	details := "cleared"
+	if req.CommentID != nil {
+		details = "comment " + strconv.Itoa(*req.CommentID)
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditPostAnswer, ActorID: u.ID, TargetType: "post", TargetID: p.ID,
+		Details: details})
+	if req.CommentID != nil {
+		notifier.Publish(notify.Event{Kind: notify.AnswerAccepted, ActorID: u.ID, PostID: p.ID, CommentID: req.CommentID})
+	}
+	jsonWrite(w, p)
+}
+
 //@Summary List comments of post
 //@Description List comments like request /comments?postId={id}
 //@Param id path int true "ID of post"
+//@Param view query string false "flat (default) or tree to nest replies under their parents"
+//@Param sort query string false "new, score or hot; by ID if omitted"
 //@Param xml query string false "show data like XML"
 //@Router /posts/{id}/comments [get]
 //@Success 200
 //@Failure default
 //@Security ApiKeyAuth
-func listPostCommentsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+func listPostCommentsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 	var param map[string]interface{} = make(map[string]interface{})
 	var err error
 	param["postId"], err = strconv.Atoi(reNum.FindString(r.URL.Path))
@@ -291,15 +501,58 @@ func listPostCommentsHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
 		ResponseError(w, http.StatusBadRequest, "")
 		return
 	}
+	tx, err := visibleComments(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	tx, ok := applySort(tx, "comments", r)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
 	cpr := models.CommentProcess{}
-	cc, result := cpr.ListComments(DB, param)
+	cc, result := cpr.ListComments(tx, param)
 	if result.Error != nil {
 		ResponseError(w, http.StatusInternalServerError, "")
 		return
 	}
+	p := models.Post{}
+	if err = DB.Session(&gorm.Session{NewDB: true}).Select("acceptedCommentId").Where("id = ?", param["postId"]).Limit(1).Find(&p).Error; err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	cc = models.AcceptedFirst(cc, p.AcceptedID)
+	switch r.FormValue("view") {
+	case "", "flat":
+	case "tree":
+		cc = models.CommentTree(cc)
+	default:
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
 	if responseXML(r) {
 		xmlWrite(w, models.Comments{Comments: cc})
 	} else {
 		jsonWrite(w, cc)
 	}
 }
+
+// canPostInCategory checks that categoryID (if any) exists and that u may
+// start posts in it, answering the request otherwise.
+func canPostInCategory(DB *gorm.DB, u models.User, categoryID *int, w http.ResponseWriter) bool {
+	if categoryID == nil {
+		return true
+	}
+	capr := models.CategoryProcess{}
+	c, result := capr.GetCategory(DB, map[string]interface{}{"id": *categoryID})
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusBadRequest, "")
+		return false
+	}
+	if !capr.CanPost(DB, c, u) {
+		ResponseError(w, http.StatusForbidden, "")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+type reactionStruct struct {
+	Emoji string
+}
+
+//@Summary React
+//@Description add a reaction to a post (/posts/{id}/reactions) or a comment (/comments/{id}/reactions); DELETE with ?emoji= removes it
+//@Accept json
+//@Produce json
+//@Param id path int true "ID of post or comment"
+//@Param RequestReaction body reactionStruct true "JSON structure with one of the allowed reactions"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /posts/{id}/reactions [post]
+//@Router /comments/{id}/reactions [post]
+//@Security ApiKeyAuth
+func reactionHTTP(cfg *config.Config, DB *gorm.DB, targetType string, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.ID == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req reactionStruct
+	if r.Method == http.MethodDelete {
+		req.Emoji = r.FormValue("emoji")
+	} else {
+		reqBody, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if err = json.Unmarshal(reqBody, &req); err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	if req.Emoji == "" {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	exists, err := targetExists(cfg, DB, targetType, id, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if !exists {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	rpr := models.ReactionProcess{Allowed: cfg.Reactions}
+	re := models.Reaction{UserID: u.ID, TargetType: targetType, TargetID: id, Emoji: req.Emoji}
+	var result *gorm.DB
+	if r.Method == http.MethodDelete {
+		result = rpr.RemoveReaction(DB, &re)
+	} else {
+		result = rpr.AddReaction(DB, &re)
+	}
+	if result.Error == models.ErrInvalidReaction {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	counts, err := rpr.CountReactions(DB, targetType, []int{id})
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	rc := counts[id]
+	if rc == nil {
+		rc = []models.ReactionCount{}
+	}
+	jsonWrite(w, rc)
+}
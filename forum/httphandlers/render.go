@@ -0,0 +1,64 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/markdown"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+// renderMaxBody bounds the markdown accepted by the preview endpoint.
+const renderMaxBody = 64 << 10
+
+type renderStruct struct {
+	Body string
+}
+
+func RenderHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rPath := r.URL.Path
+		reRenderMarkdown := regexp.MustCompile(`^\/render\/markdown(\/)??$`)
+
+		switch {
+		case reRenderMarkdown.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // preview markdown in:json
+				renderMarkdownHTTP(w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+//@Summary Preview markdown
+//@Description render markdown the way post and comment bodies are rendered into bodyHtml
+//@Accept json
+//@Produce json
+//@Param RequestRender body renderStruct true "JSON structure with markdown body"
+//@Success 200
+//@Failure 400,413
+//@Failure default
+//@Router /render/markdown [post]
+//@Security ApiKeyAuth
+func renderMarkdownHTTP(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, renderMaxBody))
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusRequestEntityTooLarge, "")
+		return
+	}
+	var req renderStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	jsonWrite(w, map[string]string{"bodyHtml": markdown.Render(req.Body)})
+}
@@ -0,0 +1,109 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/notify"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+func RolesHandler(cfg *config.Config, db *gorm.DB, notifier *notify.Notifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		reRoles := regexp.MustCompile(`^\/roles(\/)??$`)
+		if !reRoles.Match([]byte(r.URL.Path)) {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet: // list users with elevated roles
+			listRolesHTTP(db, w, r)
+		case http.MethodPut: // change role of user in:json
+			updateRoleHTTP(cfg, db, notifier, w, r)
+		default:
+			ResponseError(w, http.StatusMethodNotAllowed, "")
+		}
+	})
+}
+
+type updateRoleStruct struct {
+	UserID int `json:"userId"`
+	Role   string
+}
+
+//@Summary List roles
+//@Description list users holding a role other than "user" (admin only)
+//@Produce json
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 403
+//@Failure default
+//@Router /roles [get]
+//@Security ApiKeyAuth
+func listRolesHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	uu := []models.User{}
+	result := DB.Where("role <> ?", models.RoleUser).Find(&uu)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Users{Users: uu})
+	} else {
+		jsonWrite(w, uu)
+	}
+}
+
+//@Summary Change role
+//@Description grant a role (user, mentor, moderator, admin) to a user (admin only)
+//@Accept json
+//@Produce json
+//@Param RequestRole body updateRoleStruct true "JSON structure for changing role"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /roles [put]
+//@Security ApiKeyAuth
+func updateRoleHTTP(cfg *config.Config, DB *gorm.DB, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
+	admin := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req updateRoleStruct
+	err = json.Unmarshal(reqBody, &req)
+	if err != nil || req.UserID == 0 || !models.ValidRole(req.Role) {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var u models.User
+	result := u.GetUser(DB, map[string]interface{}{"id": req.UserID})
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	oldRole := u.Role
+	u.Role = req.Role
+	result = u.UpdateRole(DB)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditRoleChange, ActorID: admin.ID, TargetType: "user", TargetID: u.ID,
+		Details: fmt.Sprintf("%s -> %s", oldRole, u.Role)})
+	if oldRole != u.Role {
+		notifier.Publish(notify.Event{Kind: notify.Moderation, ActorID: admin.ID, UserID: u.ID,
+			Details: fmt.Sprintf("role changed from %s to %s", oldRole, u.Role)})
+	}
+	jsonWrite(w, u)
+}
@@ -0,0 +1,114 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/events"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// streamHeartbeat is how often an idle stream sends a comment, so that
+// proxies keep the connection open.
+const streamHeartbeat = 30 * time.Second
+
+func StreamHandler(cfg *config.Config, db *gorm.DB, bus *events.Bus) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		reStream := regexp.MustCompile(`^\/stream(\/)??$`)
+		if !reStream.Match([]byte(r.URL.Path)) {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet: // stream events
+			streamHTTP(cfg, db, bus, w, r)
+		default:
+			ResponseError(w, http.StatusMethodNotAllowed, "")
+		}
+	})
+}
+
+//@Summary Stream events
+//@Description Server-Sent Events of created, updated and deleted posts and comments, and notifications of the current user; resumes after the Last-Event-ID header
+//@Produce text/event-stream
+//@Param postId query integer false "only events of the post"
+//@Param categoryId query integer false "only events of posts in the category"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /stream [get]
+//@Security ApiKeyAuth
+func streamHTTP(cfg *config.Config, DB *gorm.DB, bus *events.Bus, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	var f events.Filter
+	var err error
+	if v := r.FormValue("postId"); v != "" {
+		if f.PostID, err = strconv.Atoi(v); err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	if v := r.FormValue("categoryId"); v != "" {
+		if f.CategoryID, err = strconv.Atoi(v); err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	f.UserID = authorization.GetCurrentUser(cfg, DB, r).ID
+	if f.Hidden, err = hiddenCategories(cfg, DB, r); err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.FormValue("lastEventId")
+	}
+	sub, missed, resumed := bus.Subscribe(f, lastID)
+	defer bus.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if !resumed {
+		// the events since Last-Event-ID are gone, the client has to reload
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return // too slow, the client reconnects with Last-Event-ID
+			}
+			writeEvent(w, e)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
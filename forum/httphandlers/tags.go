@@ -0,0 +1,268 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	tagsDefaultLimit = 50
+	tagsMaxLimit     = 500
+)
+
+var reTagSlug = regexp.MustCompile(`^\/tags\/([^\/]+)`)
+
+func TagsHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reTagsPosts := regexp.MustCompile(`^\/tags\/[^\/]+\/posts(\/)??$`)
+		reTagsMerge := regexp.MustCompile(`^\/tags\/[^\/]+\/merge(\/)??$`)
+		reTagsSlug := regexp.MustCompile(`^\/tags\/[^\/]+(\/)??$`)
+		reTags := regexp.MustCompile(`^\/tags(\/)??$`)
+
+		switch {
+		case reTags.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list tags with usage counts, autocomplete by prefix
+				listTagsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reTagsPosts.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list posts of tag
+				listTagPostsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reTagsMerge.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // merge tag into another in:json
+				mergeTagHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reTagsSlug.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPut: // rename tag in:json
+				renameTagHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+// tagSlugFromPath returns the normalized slug of the tag in the path, so
+// /tags/Go addresses the tag go.
+func tagSlugFromPath(r *http.Request) string {
+	m := reTagSlug.FindStringSubmatch(r.URL.Path)
+	if m == nil {
+		return ""
+	}
+	return models.NormalizeTag(m[1])
+}
+
+//@Summary List tags
+//@Description list tags with the number of readable posts using them, most used first
+//@Produce json
+//@Param prefix query string false "only tags starting with prefix (autocomplete)"
+//@Param limit query integer false "maximum number of tags, 50 by default"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /tags [get]
+//@Security ApiKeyAuth
+func listTagsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	limit := tagsDefaultLimit
+	if v := r.FormValue("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if limit > tagsMaxLimit {
+			limit = tagsMaxLimit
+		}
+	}
+	prefix := ""
+	if v := r.FormValue("prefix"); v != "" {
+		prefix = models.NormalizeTag(v)
+	}
+	hidden, err := hiddenCategories(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	tpr := models.TagProcess{}
+	tt, result := tpr.ListTags(DB, prefix, limit, hidden)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Tags{Tags: tt})
+	} else {
+		jsonWrite(w, tt)
+	}
+}
+
+//@Summary List posts of tag
+//@Description list posts carrying the tag
+//@Produce json
+//@Param slug path string true "Tag slug"
+//@Param sort query string false "new, score or hot; by ID if omitted"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure default
+//@Router /tags/{slug}/posts [get]
+//@Security ApiKeyAuth
+func listTagPostsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	tx, ok := applySort(tx, "posts", r)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	ppr := models.PostProcess{}
+	pp, result := ppr.ListTaggedPosts(tx, tagSlugFromPath(r))
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Posts{Posts: pp})
+	} else {
+		jsonWrite(w, pp)
+	}
+}
+
+type renameTagStruct struct {
+	Slug string
+}
+type mergeTagStruct struct {
+	Into string
+}
+
+//@Summary Rename tag
+//@Description rename tag (moderator only); use merge when the new slug already exists
+//@Accept json
+//@Produce json
+//@Param slug path string true "Tag slug"
+//@Param RequestTag body renameTagStruct true "JSON structure with the new slug"
+//@Success 200
+//@Failure 400,403,404,409
+//@Failure default
+//@Router /tags/{slug} [put]
+//@Security ApiKeyAuth
+func renameTagHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(cfg, DB, models.RoleModerator, w, r)
+	if !ok {
+		return
+	}
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req renameTagStruct
+	err = json.Unmarshal(reqBody, &req)
+	slug := models.NormalizeTag(req.Slug)
+	if err != nil || slug == "" {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tpr := models.TagProcess{}
+	t, result := tpr.GetTag(DB, map[string]interface{}{"slug": tagSlugFromPath(r)})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	oldSlug := t.Slug
+	result = tpr.RenameTag(DB, &t, slug)
+	if result.Error == models.ErrTagExists {
+		ResponseError(w, http.StatusConflict, "")
+		return
+	}
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditTagRename, ActorID: u.ID, TargetType: "tag", TargetID: t.ID,
+		Details: oldSlug + " -> " + t.Slug})
+	jsonWrite(w, t)
+}
+
+//@Summary Merge tags
+//@Description move all posts of the tag to another one and delete it (moderator only)
+//@Accept json
+//@Produce json
+//@Param slug path string true "Slug of the tag to merge"
+//@Param RequestTag body mergeTagStruct true "JSON structure with the target slug"
+//@Success 200
+//@Failure 400,403,404
+//@Failure default
+//@Router /tags/{slug}/merge [post]
+//@Security ApiKeyAuth
+func mergeTagHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, ok := requireRole(cfg, DB, models.RoleModerator, w, r)
+	if !ok {
+		return
+	}
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req mergeTagStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tpr := models.TagProcess{}
+	from, result := tpr.GetTag(DB, map[string]interface{}{"slug": tagSlugFromPath(r)})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	into, result := tpr.GetTag(DB, map[string]interface{}{"slug": models.NormalizeTag(req.Into)})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if from.ID == into.ID {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	result = tpr.MergeTags(DB, &from, &into)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditTagMerge, ActorID: u.ID, TargetType: "tag", TargetID: into.ID,
+		Details: from.Slug + " -> " + into.Slug})
+	jsonWrite(w, into)
+}
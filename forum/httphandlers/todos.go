@@ -0,0 +1,256 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+func TodosHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reTodos := regexp.MustCompile(`^\/todos(\/)??$`)
+		reTodosID := regexp.MustCompile(`^\/todos\/\d+(\/)??$`)
+
+		switch {
+		case reTodos.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list todos
+				listTodosHTTP(cfg, db, w, r)
+			case http.MethodPost: // create todo in:json
+				createTodoHTTP(cfg, db, w, r)
+			case http.MethodPut: // update todo in:json
+				updateTodoHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reTodosID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // get todos/{id}
+				getTodoHTTP(cfg, db, w, r)
+			case http.MethodDelete: // delete todos/{id}
+				deleteTodoHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+func writeTodos(w http.ResponseWriter, r *http.Request, tt []models.Todo) {
+	if responseXML(r) {
+		xmlWrite(w, models.Todos{Todos: tt})
+	} else {
+		jsonWrite(w, tt)
+	}
+}
+
+// todosParam adds the completed filter of the request to param.
+func todosParam(r *http.Request, param map[string]interface{}) bool {
+	if v := r.FormValue("completed"); v != "" {
+		completed, err := strconv.ParseBool(v)
+		if err != nil {
+			return false
+		}
+		param["completed"] = completed
+	}
+	return true
+}
+
+//@Summary List todos
+//@Description list todos by ID
+//@Produce json
+//@Param userId query integer false "todos filter by user"
+//@Param completed query boolean false "todos filter by state"
+//@Param limit query integer false "maximum number of todos, 500 by default"
+//@Param offset query integer false "number of todos to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /todos [get]
+//@Security ApiKeyAuth
+func listTodosHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	param := map[string]interface{}{}
+	limit, offset, ok := pageParams(r, resourcesDefaultLimit, resourcesMaxLimit)
+	if !ok || !intParam(r, param, "userId") || !todosParam(r, param) {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tpr := models.TodoProcess{}
+	tt, result := tpr.ListTodos(visibleOwned(cfg, DB, r).Limit(limit).Offset(offset), param)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeTodos(w, r, tt)
+}
+
+//@Summary Show todo
+//@Description get todo by ID
+//@Produce json
+//@Param id path integer true "Todo ID"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /todos/{id} [get]
+//@Security ApiKeyAuth
+func getTodoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tpr := models.TodoProcess{}
+	t, result := tpr.GetTodo(visibleOwned(cfg, DB, r), map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, t)
+	} else {
+		jsonWrite(w, t)
+	}
+}
+
+type todoStruct struct {
+	ID        int    `json:"id"`
+	Title     string `json:"title"`
+	Completed bool   `json:"completed"`
+}
+
+//@Summary Create todo
+//@Description create todo of the current user
+//@Accept json
+//@Produce json
+//@Param RequestTodo body todoStruct true "JSON structure for creating todo"
+//@Success 201
+//@Failure 400
+//@Failure default
+//@Router /todos [post]
+//@Security ApiKeyAuth
+func createTodoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req todoStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if fe := titleFieldErrors(cfg, req.Title); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	t := models.Todo{UserID: u.ID, Title: req.Title, Completed: req.Completed}
+	tpr := models.TodoProcess{}
+	if result := tpr.CreateTodo(DB, &t); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonT, _ := json.MarshalIndent(t, "", "  ")
+	fmt.Fprintln(w, string(jsonT))
+}
+
+//@Summary Update todo
+//@Description update todo of the current user; omitted fields keep their values
+//@Accept json
+//@Produce json
+//@Param RequestTodo body todoStruct true "JSON structure for updating todo"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /todos [put]
+//@Security ApiKeyAuth
+func updateTodoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req struct{ ID int }
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tpr := models.TodoProcess{}
+	t, result := tpr.GetTodo(DB, map[string]interface{}{"id": req.ID})
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if t.UserID != u.ID {
+		ResponseError(w, http.StatusForbidden, "")
+		return
+	}
+	//apply the given fields over the stored todo
+	if err = json.Unmarshal(reqBody, &t); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	t.UserID = u.ID
+	if fe := titleFieldErrors(cfg, t.Title); len(fe) > 0 {
+		ResponseFieldErrors(w, fe)
+		return
+	}
+	if result = tpr.UpdateTodo(DB, &t); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	jsonWrite(w, t)
+}
+
+//@Summary Delete todo
+//@Description delete todo of the current user
+//@Param id path int true "ID of deleting todo"
+//@Success 200
+//@Failure 400,403
+//@Failure default
+//@Router /todos/{id} [delete]
+//@Security ApiKeyAuth
+func deleteTodoHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tpr := models.TodoProcess{}
+	t, result := tpr.GetTodo(DB, map[string]interface{}{"id": id})
+	if result.Error != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if t.UserID != u.ID {
+		ResponseError(w, http.StatusForbidden, "")
+		return
+	}
+	if result = tpr.DeleteTodo(DB, &t); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
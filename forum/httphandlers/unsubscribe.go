@@ -0,0 +1,138 @@
+package httphandlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/mailer"
+	"nx_trainee_forum/forum/models"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+func UnsubscribeHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		reUnsubscribe := regexp.MustCompile(`^\/unsubscribe(\/)??$`)
+		if !reUnsubscribe.Match([]byte(r.URL.Path)) {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet: // confirm unsubscribing by the link in emails
+			unsubscribeFormHTTP(cfg, db, w, r)
+		case http.MethodPost: // unsubscribe from emails
+			unsubscribeHTTP(cfg, db, w, r)
+		default:
+			ResponseError(w, http.StatusMethodNotAllowed, "")
+		}
+	})
+}
+
+func VerifyEmailHandler(cfg *config.Config, db *gorm.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		reVerify := regexp.MustCompile(`^\/email\/verify(\/)??$`)
+		if !reVerify.Match([]byte(r.URL.Path)) {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet: // confirm the email address by the link mailed to it
+			verifyEmailHTTP(cfg, db, w, r)
+		default:
+			ResponseError(w, http.StatusMethodNotAllowed, "")
+		}
+	})
+}
+
+//@Summary Verify email address
+//@Description confirm the email address the token of a verification link was made for; emails are only sent to verified addresses. Opening the link is enough, as only the owner of the mailbox has it
+//@Produce html
+//@Param token query string true "token from the verification link"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /email/verify [get]
+func verifyEmailHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	id, ok := mailer.ParseVerifyToken(token)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var u models.User
+	result := u.GetUser(DB, map[string]interface{}{"id": id})
+	if result.Error != nil || result.RowsAffected == 0 || !mailer.CheckVerifyToken(cfg.HASHKey, token, u) {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	u.EmailVerified = true
+	if result = u.UpdateEmailSettings(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "<!DOCTYPE html><html><body><p>Your email address is confirmed. "+
+		"Notification emails are sent as set in your notification settings.</p></body></html>")
+}
+
+// unsubscribeUser returns the user the token of the request was made for.
+func unsubscribeUser(cfg *config.Config, DB *gorm.DB, r *http.Request) (models.User, bool) {
+	var u models.User
+	id, ok := mailer.ParseUnsubscribeToken(cfg.HASHKey, r.FormValue("token"))
+	if !ok {
+		return u, false
+	}
+	result := u.GetUser(DB, map[string]interface{}{"id": id})
+	return u, result.Error == nil && result.RowsAffected > 0
+}
+
+//@Summary Confirm unsubscribing from emails
+//@Description page with a button that unsubscribes; opening the link alone changes nothing, so that link scanners and mail prefetchers do not unsubscribe anybody
+//@Produce html
+//@Param token query string true "token from the unsubscribe link"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /unsubscribe [get]
+func unsubscribeFormHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	if _, ok := unsubscribeUser(cfg, DB, r); !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "<!DOCTYPE html><html><body><form method=\"post\" action=\"/unsubscribe?token=%s\">"+
+		"<p>Stop receiving notification emails?</p><button type=\"submit\">Unsubscribe</button></form></body></html>",
+		url.QueryEscape(r.FormValue("token")))
+}
+
+//@Summary Unsubscribe from emails
+//@Description turn email notifications off for the user the token of an email link was made for; also the one-click unsubscribe of mail clients (RFC 8058)
+//@Produce html
+//@Param token query string true "token from the unsubscribe link"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /unsubscribe [post]
+func unsubscribeHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, ok := unsubscribeUser(cfg, DB, r)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var result *gorm.DB
+	u.EmailNotifications = models.EmailOff
+	if result = u.UpdateEmailSettings(DB); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "<!DOCTYPE html><html><body><p>You will not receive notification emails anymore. "+
+		"You can turn them on again in your notification settings.</p></body></html>")
+}
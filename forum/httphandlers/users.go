@@ -0,0 +1,540 @@
+package httphandlers
+
+import (
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/storage"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	mentionsDefaultLimit = 50
+	mentionsMaxLimit     = 500
+)
+
+const (
+	usersDefaultLimit = 50
+	usersMaxLimit     = 500
+)
+
+func UsersHandler(cfg *config.Config, db *gorm.DB, store storage.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reUsers := regexp.MustCompile(`^\/users(\/)??$`)
+		reUsersID := regexp.MustCompile(`^\/users\/\d+(\/)??$`)
+		reUsersPosts := regexp.MustCompile(`^\/users\/\d+\/posts(\/)??$`)
+		reUsersComments := regexp.MustCompile(`^\/users\/\d+\/comments(\/)??$`)
+		reUsersAvatar := regexp.MustCompile(`^\/users\/\d+\/avatar(\/\d+)??(\/)??$`)
+		reUsersMentions := regexp.MustCompile(`^\/users\/\d+\/mentions(\/)??$`)
+		reUsersAlbums := regexp.MustCompile(`^\/users\/\d+\/albums(\/)??$`)
+		reUsersTodos := regexp.MustCompile(`^\/users\/\d+\/todos(\/)??$`)
+
+		switch {
+		case reUsers.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list profiles
+				listUsersHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reUsersID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // show profile
+				getUserHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reUsersPosts.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list posts of user
+				listUserPostsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reUsersComments.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list comments of user
+				listUserCommentsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reUsersAvatar.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // download avatar or its thumbnail
+				avatarHTTP(cfg, db, store, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reUsersMentions.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list mentions of user
+				listUserMentionsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reUsersAlbums.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list albums of user
+				listUserAlbumsHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reUsersTodos.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list todos of user
+				listUserTodosHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+//@Summary List mentions of user
+//@Description list posts and comments that mention the user by @login, newest first
+//@Produce json
+//@Param id path int true "ID of user"
+//@Param limit query integer false "maximum number of mentions, 50 by default"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /users/{id}/mentions [get]
+//@Security ApiKeyAuth
+func listUserMentionsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	limit := mentionsDefaultLimit
+	if v := r.FormValue("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if limit > mentionsMaxLimit {
+			limit = mentionsMaxLimit
+		}
+	}
+	posts, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	mpr := models.MentionProcess{}
+	mm, result := mpr.ListUserMentions(DB, posts, id, limit)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Mentions{Mentions: mm})
+	} else {
+		jsonWrite(w, mm)
+	}
+}
+
+// pageParams reads the limit and offset parameters of a list; limit is def
+// if omitted and at most max.
+func pageParams(r *http.Request, def, max int) (int, int, bool) {
+	limit, offset := def, 0
+	var err error
+	if v := r.FormValue("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, false
+		}
+		if limit > max {
+			limit = max
+		}
+	}
+	if v := r.FormValue("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// visibleUser loads the user of the request path if the requesting user may
+// see their profile.
+func visibleUser(cfg *config.Config, DB *gorm.DB, r *http.Request) (models.User, int) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		return models.User{}, http.StatusBadRequest
+	}
+	var u models.User
+	result := u.GetUser(DB, map[string]interface{}{"id": id})
+	if result.Error != nil || result.RowsAffected == 0 || !u.ProfileVisibleTo(authorization.GetCurrentUser(cfg, DB, r)) {
+		return models.User{}, http.StatusNotFound
+	}
+	return u, http.StatusOK
+}
+
+// hiddenOwners returns the IDs of the users whose profiles the requesting
+// user may not see as a subquery, nil if they see every profile.
+func hiddenOwners(cfg *config.Config, DB *gorm.DB, r *http.Request) *gorm.DB {
+	viewer := authorization.GetCurrentUser(cfg, DB, r)
+	hidden := models.HiddenVisibilities(viewer)
+	if len(hidden) == 0 {
+		return nil
+	}
+	return DB.Model(&models.User{}).Select("id").Where("profileVisibility IN ? AND id <> ?", hidden, viewer.ID)
+}
+
+// visibleOwned scopes DB to the rows, such as albums and todos, of users
+// whose profiles the requesting user may see.
+func visibleOwned(cfg *config.Config, DB *gorm.DB, r *http.Request) *gorm.DB {
+	hidden := hiddenOwners(cfg, DB, r)
+	if hidden == nil {
+		return DB
+	}
+	return DB.Where("userId NOT IN (?)", hidden)
+}
+
+// visiblePhotos scopes DB to the photos in albums of users whose profiles
+// the requesting user may see.
+func visiblePhotos(cfg *config.Config, DB *gorm.DB, r *http.Request) *gorm.DB {
+	hidden := hiddenOwners(cfg, DB, r)
+	if hidden == nil {
+		return DB
+	}
+	return DB.Where("albumId NOT IN (?)", DB.Model(&models.Album{}).Select("id").Where("userId IN (?)", hidden))
+}
+
+// profiles returns the profiles of uu with their activity visible to the
+// requesting user and the thumbnails of their avatars.
+func profiles(cfg *config.Config, DB *gorm.DB, r *http.Request, uu []models.User) ([]models.Profile, error) {
+	pp := make([]models.Profile, len(uu))
+	avatars := []int{}
+	for i := range uu {
+		pp[i] = uu[i].Profile()
+		if uu[i].AvatarID != nil {
+			avatars = append(avatars, *uu[i].AvatarID)
+		}
+	}
+	posts, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := visibleComments(cfg, DB, r)
+	if err != nil {
+		return nil, err
+	}
+	upr := models.UserProcess{}
+	if err = upr.CountActivity(posts, comments, pp); err != nil {
+		return nil, err
+	}
+	if len(avatars) == 0 {
+		return pp, nil
+	}
+	aa := []models.Attachment{}
+	if err = DB.Where("id IN ?", avatars).Find(&aa).Error; err != nil {
+		return nil, err
+	}
+	thumbnails := make(map[int][]models.Thumbnail, len(aa))
+	for _, a := range aa {
+		thumbnails[a.ID] = a.Thumbnails
+	}
+	for i := range pp {
+		if uu[i].AvatarID == nil {
+			continue
+		}
+		for _, t := range thumbnails[*uu[i].AvatarID] {
+			pp[i].Avatar.Thumbnails = append(pp[i].Avatar.Thumbnails,
+				models.Thumbnail{Size: t.Size, URL: pp[i].Avatar.URL + "/" + strconv.Itoa(t.Size)})
+		}
+	}
+	return pp, nil
+}
+
+//@Summary List users
+//@Description list profiles the current user may see, by ID
+//@Produce json
+//@Param limit query integer false "maximum number of users, 50 by default"
+//@Param offset query integer false "number of users to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /users [get]
+//@Security ApiKeyAuth
+func listUsersHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	limit, offset, ok := pageParams(r, usersDefaultLimit, usersMaxLimit)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	upr := models.UserProcess{}
+	uu, result := upr.ListProfiles(DB.Limit(limit).Offset(offset), authorization.GetCurrentUser(cfg, DB, r))
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	pp, err := profiles(cfg, DB, r, uu)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Profiles{Profiles: pp})
+	} else {
+		jsonWrite(w, pp)
+	}
+}
+
+//@Summary Show user
+//@Description profile of a user with the number of their posts and comments; 404 if the privacy settings hide it from the current user
+//@Produce json
+//@Param id path int true "ID of user"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /users/{id} [get]
+//@Security ApiKeyAuth
+func getUserHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, code := visibleUser(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	pp, err := profiles(cfg, DB, r, []models.User{u})
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, pp[0])
+	} else {
+		jsonWrite(w, pp[0])
+	}
+}
+
+//@Summary List posts of user
+//@Description posts of a user readable by the current user, newest first
+//@Produce json
+//@Param id path int true "ID of user"
+//@Param sort query string false "new (default), score or hot"
+//@Param limit query integer false "maximum number of posts, 50 by default"
+//@Param offset query integer false "number of posts to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /users/{id}/posts [get]
+//@Security ApiKeyAuth
+func listUserPostsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, code := visibleUser(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	limit, offset, ok := pageParams(r, usersDefaultLimit, usersMaxLimit)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if r.FormValue("sort") == "" {
+		r.Form.Set("sort", "new")
+	}
+	if tx, ok = applySort(tx, "posts", r); !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	ppr := models.PostProcess{}
+	pp, result := ppr.ListPosts(tx.Limit(limit).Offset(offset), map[string]interface{}{"userId": u.ID})
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Posts{Posts: pp})
+	} else {
+		jsonWrite(w, pp)
+	}
+}
+
+//@Summary List comments of user
+//@Description comments of a user on posts readable by the current user, newest first; deleted comments are left out
+//@Produce json
+//@Param id path int true "ID of user"
+//@Param sort query string false "new (default), score or hot"
+//@Param limit query integer false "maximum number of comments, 50 by default"
+//@Param offset query integer false "number of comments to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /users/{id}/comments [get]
+//@Security ApiKeyAuth
+func listUserCommentsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, code := visibleUser(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	limit, offset, ok := pageParams(r, usersDefaultLimit, usersMaxLimit)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tx, err := visibleComments(cfg, DB, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if r.FormValue("sort") == "" {
+		r.Form.Set("sort", "new")
+	}
+	if tx, ok = applySort(tx, "comments", r); !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	cpr := models.CommentProcess{}
+	cc, result := cpr.ListComments(tx.Limit(limit).Offset(offset), map[string]interface{}{"userId": u.ID, "deleted": false})
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Comments{Comments: cc})
+	} else {
+		jsonWrite(w, cc)
+	}
+}
+
+//@Summary Download avatar
+//@Description avatar image of a user (/users/{id}/avatar) or its thumbnail fitting into size x size pixels (/users/{id}/avatar/{size}); 404 if the user has none or the privacy settings hide the profile
+//@Param id path int true "ID of user"
+//@Param size path int false "thumbnail size"
+//@Success 200,304
+//@Failure 400,404,409
+//@Failure default
+//@Router /users/{id}/avatar [get]
+//@Router /users/{id}/avatar/{size} [get]
+func avatarHTTP(cfg *config.Config, DB *gorm.DB, store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	u, code := visibleUser(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	if u.AvatarID == nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	apr := models.AttachmentProcess{}
+	a, result := apr.GetAttachment(DB, map[string]interface{}{"id": *u.AvatarID})
+	if result.Error != nil {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	if a.Status != models.AttachmentReady {
+		ResponseError(w, http.StatusConflict, "")
+		return
+	}
+	if p := strings.TrimSuffix(r.URL.Path, "/"); path.Base(p) != "avatar" {
+		size, err := strconv.Atoi(path.Base(p))
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if !a.HasThumbnail(size) {
+			ResponseError(w, http.StatusNotFound, "")
+			return
+		}
+		writeBlob(store, models.ThumbnailKey(a.Hash, size), `"`+a.Hash+"-"+strconv.Itoa(size)+`"`, a.ThumbnailType(), nil, w, r)
+		return
+	}
+	writeBlob(store, a.BlobKey(), `"`+a.Hash+`"`, a.ContentType, nil, w, r)
+}
+
+//@Summary List albums of user
+//@Description albums of a user by ID; 404 if the privacy settings hide the profile
+//@Produce json
+//@Param id path int true "ID of user"
+//@Param limit query integer false "maximum number of albums, 500 by default"
+//@Param offset query integer false "number of albums to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /users/{id}/albums [get]
+//@Security ApiKeyAuth
+func listUserAlbumsHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, code := visibleUser(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	limit, offset, ok := pageParams(r, resourcesDefaultLimit, resourcesMaxLimit)
+	if !ok {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	apr := models.AlbumProcess{}
+	aa, result := apr.ListAlbums(DB.Limit(limit).Offset(offset), map[string]interface{}{"userId": u.ID})
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeAlbums(w, r, aa)
+}
+
+//@Summary List todos of user
+//@Description todos of a user by ID; 404 if the privacy settings hide the profile
+//@Produce json
+//@Param id path int true "ID of user"
+//@Param completed query boolean false "todos filter by state"
+//@Param limit query integer false "maximum number of todos, 500 by default"
+//@Param offset query integer false "number of todos to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /users/{id}/todos [get]
+//@Security ApiKeyAuth
+func listUserTodosHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u, code := visibleUser(cfg, DB, r)
+	if code != http.StatusOK {
+		ResponseError(w, code, "")
+		return
+	}
+	param := map[string]interface{}{"userId": u.ID}
+	limit, offset, ok := pageParams(r, resourcesDefaultLimit, resourcesMaxLimit)
+	if !ok || !todosParam(r, param) {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	tpr := models.TodoProcess{}
+	tt, result := tpr.ListTodos(DB.Limit(limit).Offset(offset), param)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	writeTodos(w, r, tt)
+}
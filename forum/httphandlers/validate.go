@@ -0,0 +1,95 @@
+package httphandlers
+
+import (
+	"fmt"
+	"net/url"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/models"
+	"strings"
+	"unicode/utf8"
+)
+
+// Lengths of the contact details of a profile, as wide as their columns.
+const (
+	contactMaxLength  = 256
+	codeMaxLength     = 64 //phone, zipcode and coordinates
+	photoURLMaxLength = 2048
+)
+
+// fieldErrors maps JSON field names to what is wrong with them.
+type fieldErrors map[string]string
+
+// maxLength records an error for field when value is longer than max
+// characters; max <= 0 disables the check.
+func (fe fieldErrors) maxLength(field, value string, max int) {
+	if max > 0 && utf8.RuneCountInString(value) > max {
+		fe[field] = fmt.Sprintf("must be at most %d characters", max)
+	}
+}
+
+func postFieldErrors(cfg *config.Config, p *models.Post) fieldErrors {
+	fe := fieldErrors{}
+	fe.maxLength("title", p.Title, cfg.PostTitleMaxLength)
+	fe.maxLength("body", p.Body, cfg.PostBodyMaxLength)
+	return fe
+}
+
+func commentFieldErrors(cfg *config.Config, c *models.Comment) fieldErrors {
+	fe := fieldErrors{}
+	fe.maxLength("name", c.Name, cfg.CommentNameMaxLength)
+	fe.maxLength("body", c.Body, cfg.CommentBodyMaxLength)
+	return fe
+}
+
+func profileFieldErrors(cfg *config.Config, u *models.User) fieldErrors {
+	fe := fieldErrors{}
+	if strings.TrimSpace(u.Name) == "" {
+		fe["name"] = "must not be empty"
+	}
+	fe.maxLength("name", u.Name, cfg.UserNameMaxLength)
+	fe.maxLength("bio", u.Bio, cfg.UserBioMaxLength)
+	if !models.ValidProfileVisibility(u.ProfileVisibility) {
+		fe["profileVisibility"] = "must be public, members or private"
+	}
+	fe.maxLength("phone", u.Phone, codeMaxLength)
+	fe.maxLength("website", u.Website, contactMaxLength)
+	fe.maxLength("address.street", u.Street, contactMaxLength)
+	fe.maxLength("address.suite", u.Suite, contactMaxLength)
+	fe.maxLength("address.city", u.City, contactMaxLength)
+	fe.maxLength("address.zipcode", u.Zipcode, codeMaxLength)
+	fe.maxLength("address.geo.lat", u.Lat, codeMaxLength)
+	fe.maxLength("address.geo.lng", u.Lng, codeMaxLength)
+	fe.maxLength("company.name", u.CompanyName, contactMaxLength)
+	fe.maxLength("company.catchPhrase", u.CatchPhrase, contactMaxLength)
+	fe.maxLength("company.bs", u.BS, contactMaxLength)
+	return fe
+}
+
+// titleFieldErrors checks the title of an album, a photo or a todo, which
+// may be as long as a post title.
+func titleFieldErrors(cfg *config.Config, title string) fieldErrors {
+	fe := fieldErrors{}
+	if strings.TrimSpace(title) == "" {
+		fe["title"] = "must not be empty"
+	}
+	fe.maxLength("title", title, cfg.PostTitleMaxLength)
+	return fe
+}
+
+func photoFieldErrors(cfg *config.Config, p *models.Photo) fieldErrors {
+	fe := titleFieldErrors(cfg, p.Title)
+	fe.url("url", p.URL)
+	fe.url("thumbnailUrl", p.ThumbnailURL)
+	return fe
+}
+
+// url records an error for field unless value is an absolute http or https
+// URL of at most photoURLMaxLength characters.
+func (fe fieldErrors) url(field, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fe[field] = "must be an absolute http or https URL"
+		return
+	}
+	fe.maxLength(field, value, photoURLMaxLength)
+}
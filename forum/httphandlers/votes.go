@@ -0,0 +1,117 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+type voteStruct struct {
+	Value int
+}
+
+// applySort orders a listing of table (posts or comments) by the sort query
+// parameter.
+func applySort(DB *gorm.DB, table string, r *http.Request) (*gorm.DB, bool) {
+	switch r.FormValue("sort") {
+	case "":
+		return DB, true
+	case "new":
+		return DB.Order(table + ".createdAt DESC").Order(table + ".id DESC"), true
+	case "score":
+		return DB.Order(table + ".score DESC").Order(table + ".id"), true
+	case "hot":
+		return DB.Order(models.HotOrder(table)).Order(table + ".id"), true
+	}
+	return DB, false
+}
+
+// targetExists checks that the target is visible to the requesting user
+// and can be voted or reacted on.
+func targetExists(cfg *config.Config, DB *gorm.DB, targetType string, id int, r *http.Request) (bool, error) {
+	param := map[string]interface{}{"id": id}
+	switch targetType {
+	case models.TargetPost:
+		tx, err := visiblePosts(cfg, DB, r)
+		if err != nil {
+			return false, err
+		}
+		ppr := models.PostProcess{}
+		_, result := ppr.GetPost(tx, param)
+		return result.Error == nil, nil
+	case models.TargetComment:
+		tx, err := visibleComments(cfg, DB, r)
+		if err != nil {
+			return false, err
+		}
+		cpr := models.CommentProcess{}
+		c, result := cpr.GetComment(tx, param)
+		return result.Error == nil && !c.Deleted, nil
+	}
+	return false, nil
+}
+
+//@Summary Vote
+//@Description vote for a post (/posts/{id}/vote) or a comment (/comments/{id}/vote): 1 up, -1 down, 0 retract
+//@Accept json
+//@Produce json
+//@Param id path int true "ID of post or comment"
+//@Param RequestVote body voteStruct true "JSON structure for voting"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /posts/{id}/vote [put]
+//@Router /comments/{id}/vote [put]
+//@Security ApiKeyAuth
+func voteHTTP(cfg *config.Config, DB *gorm.DB, targetType string, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.ID == 0 {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req voteStruct
+	if r.Method != http.MethodDelete {
+		reqBody, err := ioutil.ReadAll(r.Body)
+		defer r.Body.Close()
+		if err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if err = json.Unmarshal(reqBody, &req); err != nil {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	exists, err := targetExists(cfg, DB, targetType, id, r)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if !exists {
+		ResponseError(w, http.StatusNotFound, "")
+		return
+	}
+	vpr := models.VoteProcess{}
+	v := models.Vote{UserID: u.ID, TargetType: targetType, TargetID: id, Value: req.Value}
+	score, result := vpr.CastVote(DB, &v)
+	if result.Error == models.ErrInvalidVote {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	jsonWrite(w, map[string]int{"score": score, "vote": v.Value})
+}
@@ -0,0 +1,623 @@
+package httphandlers
+
+import (
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"net/url"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/i18n"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/notify"
+	"nx_trainee_forum/forum/views"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	webPageSize     = 20 //posts on a page of the post list
+	webProfilePosts = 10 //latest posts on a user profile
+)
+
+// webPage is the data every page shares with the layout.
+type webPage struct {
+	Config    *config.Config
+	User      models.User
+	CSRF      string //token of the forms on the page
+	Lang      string
+	Languages []string
+	Path      string //of the page, to come back to after switching language
+}
+
+func newWebPage(cfg *config.Config, DB *gorm.DB, r *http.Request) webPage {
+	return webPage{Config: cfg, User: authorization.GetCurrentUser(cfg, DB, r), CSRF: csrfToken(cfg, r),
+		Lang: i18n.FromContext(r.Context()), Languages: i18n.Languages(), Path: pagePath(r)}
+}
+
+// pagePath returns the path and query of a page shown by GET, without the
+// lang parameter, or "" for a page answering a form.
+func pagePath(r *http.Request) string {
+	if r.Method != http.MethodGet {
+		return ""
+	}
+	u := *r.URL
+	q := u.Query()
+	q.Del("lang")
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// T translates message into the language of the page.
+func (p webPage) T(message string, args ...interface{}) string {
+	return i18n.T(p.Lang, message, args...)
+}
+
+// N translates message about n things, as in "3 posts", into the language
+// of the page.
+func (p webPage) N(message string, n interface{}) (string, error) {
+	v := reflect.ValueOf(n)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return i18n.N(p.Lang, message, int(v.Int())), nil
+	}
+	return "", fmt.Errorf("N: %T is not an integer", n)
+}
+
+// LanguageName returns the name of lang in that language.
+func (p webPage) LanguageName(lang string) string {
+	return i18n.Name(lang)
+}
+
+// Thread returns the data of the comments partial for a level of the
+// comment tree.
+func (p webPage) Thread(cc []models.Comment) commentThread {
+	return commentThread{webPage: p, Comments: cc}
+}
+
+type commentThread struct {
+	webPage
+	Comments []models.Comment
+}
+
+// renderError renders the error page with the status text.
+func renderError(cfg *config.Config, DB *gorm.DB, pages *views.Manager, status int, w http.ResponseWriter, r *http.Request) {
+	page := newWebPage(cfg, DB, r)
+	pages.Render(w, status, "error", struct {
+		webPage
+		Status  int
+		Message string
+	}{page, status, page.T(http.StatusText(status))})
+}
+
+// csrfToken returns the token forms of the session carry. It is bound to
+// the session cookie, so it changes on every login and a page of another
+// site cannot know it.
+func csrfToken(cfg *config.Config, r *http.Request) string {
+	c, err := r.Cookie("UAAT")
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	return authorization.CalculateSignature("csrf:"+c.Value, cfg.HASHKey)
+}
+
+// checkCSRF reports whether the submitted form carries the token of the
+// session.
+func checkCSRF(cfg *config.Config, r *http.Request) bool {
+	token := csrfToken(cfg, r)
+	return token != "" && hmac.Equal([]byte(r.PostFormValue("csrf")), []byte(token))
+}
+
+// webQuery builds links of the post list keeping its other filters.
+type webQuery url.Values
+
+func (q webQuery) with(key, value string) string {
+	v := url.Values{}
+	for k, vv := range q {
+		v[k] = vv
+	}
+	v.Set(key, value)
+	if key != "page" {
+		v.Del("page")
+	}
+	return "/web/posts?" + v.Encode()
+}
+
+func (q webQuery) Sort(sort string) string { return q.with("sort", sort) }
+
+func (q webQuery) Page(page int) string { return q.with("page", strconv.Itoa(page)) }
+
+func WebHandler(cfg *config.Config, db *gorm.DB, pages *views.Manager, notifier *notify.Notifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reWebPosts := regexp.MustCompile(`^\/web\/posts(\/)??$`)
+		reWebPostsNew := regexp.MustCompile(`^\/web\/posts\/new(\/)??$`)
+		reWebPostsID := regexp.MustCompile(`^\/web\/posts\/\d+(\/)??$`)
+		reWebPostsIDEdit := regexp.MustCompile(`^\/web\/posts\/\d+\/edit(\/)??$`)
+		reWebPostsIDComments := regexp.MustCompile(`^\/web\/posts\/\d+\/comments(\/)??$`)
+		reWebUsersID := regexp.MustCompile(`^\/web\/users\/\d+(\/)??$`)
+		reWebLanguage := regexp.MustCompile(`^\/web\/language(\/)??$`)
+
+		switch {
+		case reWebPosts.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // page of post list
+				webPostsHTTP(cfg, db, pages, w, r)
+			default:
+				renderError(cfg, db, pages, http.StatusMethodNotAllowed, w, r)
+			}
+		case reWebPostsNew.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet, http.MethodPost: // form of new post
+				webPostFormHTTP(cfg, db, pages, notifier, w, r)
+			default:
+				renderError(cfg, db, pages, http.StatusMethodNotAllowed, w, r)
+			}
+		case reWebPostsIDEdit.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet, http.MethodPost: // form of editing post
+				webPostFormHTTP(cfg, db, pages, notifier, w, r)
+			default:
+				renderError(cfg, db, pages, http.StatusMethodNotAllowed, w, r)
+			}
+		case reWebPostsID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // page of post with comments
+				webPostHTTP(cfg, db, pages, w, r)
+			default:
+				renderError(cfg, db, pages, http.StatusMethodNotAllowed, w, r)
+			}
+		case reWebPostsIDComments.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // comment form of post page
+				webCommentHTTP(cfg, db, pages, notifier, w, r)
+			default:
+				renderError(cfg, db, pages, http.StatusMethodNotAllowed, w, r)
+			}
+		case reWebUsersID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // user profile
+				webUserHTTP(cfg, db, pages, w, r)
+			default:
+				renderError(cfg, db, pages, http.StatusMethodNotAllowed, w, r)
+			}
+		case reWebLanguage.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // language switcher of the layout
+				webLanguageHTTP(cfg, db, pages, w, r)
+			default:
+				renderError(cfg, db, pages, http.StatusMethodNotAllowed, w, r)
+			}
+		default:
+			renderError(cfg, db, pages, http.StatusNotFound, w, r)
+		}
+	})
+}
+
+//@Summary Page of posts
+//@Description HTML list of posts readable by the current user, 20 per page
+//@Produce html
+//@Param userId query integer false "posts filter by user"
+//@Param categoryId query integer false "posts filter by category"
+//@Param sort query string false "new (default), score or hot"
+//@Param page query integer false "page number, from 1"
+//@Success 200
+//@Failure 400
+//@Failure default
+//@Router /web/posts [get]
+func webPostsHTTP(cfg *config.Config, DB *gorm.DB, pages *views.Manager, w http.ResponseWriter, r *http.Request) {
+	param := make(map[string]interface{})
+	lang := i18n.FromContext(r.Context())
+	heading, description := i18n.T(lang, "Posts"), ""
+	for _, key := range []string{"userId", "categoryId"} {
+		if v := r.FormValue(key); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+				return
+			}
+			param[key] = id
+		}
+	}
+	page := 1
+	if v := r.FormValue("page"); v != "" {
+		var err error
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+			return
+		}
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	if r.FormValue("sort") == "" {
+		r.Form.Set("sort", "new")
+	}
+	tx, ok := applySort(tx, "posts", r)
+	if !ok {
+		renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+		return
+	}
+	if id, ok := param["categoryId"]; ok {
+		capr := models.CategoryProcess{}
+		c, result := capr.GetCategory(DB, map[string]interface{}{"id": id})
+		if result.Error != nil || !capr.CanRead(DB, c, authorization.GetCurrentUser(cfg, DB, r)) {
+			renderError(cfg, DB, pages, http.StatusNotFound, w, r)
+			return
+		}
+		heading, description = c.Name, c.Description
+	}
+	ppr := models.PostProcess{}
+	pp, result := ppr.ListPosts(tx.Limit(webPageSize+1).Offset((page-1)*webPageSize), param)
+	if result.Error != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	more := len(pp) > webPageSize
+	if more {
+		pp = pp[:webPageSize]
+	}
+	names, err := postAuthors(DB, pp)
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	if id, ok := param["userId"]; ok {
+		heading = i18n.T(lang, "Posts by %s", names[id.(int)])
+	}
+	pages.Render(w, http.StatusOK, "posts", struct {
+		webPage
+		Heading     string
+		Description string
+		Posts       []models.Post
+		Names       map[int]string
+		Query       webQuery
+		Page        int
+		More        bool
+	}{newWebPage(cfg, DB, r), heading, description, pp, names, webQuery(r.URL.Query()), page, more})
+}
+
+// postAuthors maps the authors of pp to their names.
+func postAuthors(DB *gorm.DB, pp []models.Post) (map[int]string, error) {
+	ids := []int{}
+	for _, p := range pp {
+		ids = append(ids, p.UserID)
+	}
+	return userNames(DB, ids)
+}
+
+type webPostPage struct {
+	webPage
+	Post     models.Post
+	Category *models.Category
+	Names    map[int]string
+	Comments []models.Comment
+	Comment  models.Comment //comment form
+	Errors   fieldErrors
+}
+
+//@Summary Page of post
+//@Description HTML post with its comment tree and, for logged in users, a comment form
+//@Produce html
+//@Param id path integer true "Post ID"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /web/posts/{id} [get]
+func webPostHTTP(cfg *config.Config, DB *gorm.DB, pages *views.Manager, w http.ResponseWriter, r *http.Request) {
+	page := webPostPage{webPage: newWebPage(cfg, DB, r)}
+	page.Comment = models.Comment{Name: page.User.Name}
+	//comment emails are public, so only an address the user shows anyway
+	if page.User.ShowEmail {
+		page.Comment.Email = page.User.Email
+	}
+	renderPostPage(cfg, DB, pages, http.StatusOK, page, w, r)
+}
+
+// renderPostPage loads the post of the path with its comments into page and
+// renders it.
+func renderPostPage(cfg *config.Config, DB *gorm.DB, pages *views.Manager, status int, page webPostPage, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+		return
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	ppr := models.PostProcess{}
+	var result *gorm.DB
+	if page.Post, result = ppr.GetPost(tx, map[string]interface{}{"id": id}); result.Error != nil {
+		renderError(cfg, DB, pages, http.StatusNotFound, w, r)
+		return
+	}
+	if page.Post.CategoryID != nil {
+		capr := models.CategoryProcess{}
+		if c, result := capr.GetCategory(DB, map[string]interface{}{"id": *page.Post.CategoryID}); result.Error == nil {
+			page.Category = &c
+		}
+	}
+	cpr := models.CommentProcess{}
+	cc, result := cpr.ListComments(DB.Order("createdAt, id"), map[string]interface{}{"postId": id})
+	if result.Error != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	page.Comments = models.CommentTree(models.AcceptedFirst(cc, page.Post.AcceptedID))
+	if page.Names, err = postAuthors(DB, []models.Post{page.Post}); err != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	pages.Render(w, status, "post", page)
+}
+
+//@Summary Comment from post page
+//@Description create a comment from the form of the post page and go back to it
+//@Accept x-www-form-urlencoded
+//@Produce html
+//@Param id path integer true "Post ID"
+//@Success 303
+//@Failure 400,403,404
+//@Failure default
+//@Router /web/posts/{id}/comments [post]
+func webCommentHTTP(cfg *config.Config, DB *gorm.DB, pages *views.Manager, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
+	page := webPostPage{webPage: newWebPage(cfg, DB, r), Errors: fieldErrors{}}
+	if page.User.ID == 0 {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if !checkCSRF(cfg, r) {
+		renderError(cfg, DB, pages, http.StatusForbidden, w, r)
+		return
+	}
+	postID, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+		return
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	ppr := models.PostProcess{}
+	if _, result := ppr.GetPost(tx, map[string]interface{}{"id": postID}); result.Error != nil {
+		renderError(cfg, DB, pages, http.StatusNotFound, w, r)
+		return
+	}
+	c := models.Comment{PostID: postID, UserID: page.User.ID, Name: strings.TrimSpace(r.PostFormValue("name")),
+		Email: strings.TrimSpace(r.PostFormValue("email")), Body: r.PostFormValue("body")}
+	page.Comment = c
+	if c.Name == "" || c.Email == "" || strings.TrimSpace(c.Body) == "" {
+		page.Errors["form"] = "Name, email and comment are required."
+	}
+	for k, v := range commentFieldErrors(cfg, &c) {
+		page.Errors[k] = v
+	}
+	if len(page.Errors) > 0 {
+		renderPostPage(cfg, DB, pages, http.StatusBadRequest, page, w, r)
+		return
+	}
+	cpr := models.CommentProcess{MaxDepth: cfg.CommentMaxDepth}
+	if result := cpr.CreateComment(DB, &c); result.Error != nil {
+		page.Errors["form"] = result.Error.Error()
+		renderPostPage(cfg, DB, pages, http.StatusBadRequest, page, w, r)
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditCommentCreate, ActorID: page.User.ID, TargetType: "comment", TargetID: c.ID})
+	notifier.Publish(notify.Event{Kind: notify.CommentCreated, ActorID: page.User.ID, PostID: c.PostID, CommentID: &c.ID, Mentions: c.Mentions})
+	http.Redirect(w, r, "/web/posts/"+strconv.Itoa(postID)+"#comment-"+strconv.Itoa(c.ID), http.StatusSeeOther)
+}
+
+//@Summary Form of post
+//@Description HTML form creating a post (/web/posts/new) or editing one of the current user; POST submits it and redirects to the post page
+//@Accept x-www-form-urlencoded
+//@Produce html
+//@Param id path integer true "Post ID, for editing"
+//@Success 200,303
+//@Failure 400,403,404
+//@Failure default
+//@Router /web/posts/new [get]
+//@Router /web/posts/new [post]
+//@Router /web/posts/{id}/edit [get]
+//@Router /web/posts/{id}/edit [post]
+func webPostFormHTTP(cfg *config.Config, DB *gorm.DB, pages *views.Manager, notifier *notify.Notifier, w http.ResponseWriter, r *http.Request) {
+	page := struct {
+		webPage
+		Post       models.Post
+		Categories []models.Category
+		Errors     fieldErrors
+	}{webPage: newWebPage(cfg, DB, r), Errors: fieldErrors{}}
+	if page.User.ID == 0 {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if r.Method == http.MethodPost && !checkCSRF(cfg, r) {
+		renderError(cfg, DB, pages, http.StatusForbidden, w, r)
+		return
+	}
+	ppr := models.PostProcess{}
+	if s := reNum.FindString(r.URL.Path); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+			return
+		}
+		var result *gorm.DB
+		page.Post, result = ppr.GetPost(DB, map[string]interface{}{"id": id})
+		if result.Error != nil {
+			renderError(cfg, DB, pages, http.StatusNotFound, w, r)
+			return
+		}
+		if page.Post.UserID != page.User.ID {
+			renderError(cfg, DB, pages, http.StatusForbidden, w, r)
+			return
+		}
+	}
+	capr := models.CategoryProcess{}
+	cc, result := capr.ListCategories(DB, map[string]interface{}{})
+	if result.Error != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	hidden, result := capr.HiddenCategoryIDs(DB, page.User)
+	if result.Error != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	unreadable := make(map[int]bool, len(hidden))
+	for _, id := range hidden {
+		unreadable[id] = true
+	}
+	for _, c := range cc {
+		if !unreadable[c.ID] && c.CanPost(page.User) {
+			page.Categories = append(page.Categories, c)
+		}
+	}
+	if r.Method == http.MethodGet {
+		pages.Render(w, http.StatusOK, "post_form", page)
+		return
+	}
+
+	p := &page.Post
+	p.Title = strings.TrimSpace(r.PostFormValue("title"))
+	p.Body = r.PostFormValue("body")
+	p.Type = r.PostFormValue("type")
+	p.CategoryID = nil
+	if v := r.PostFormValue("categoryId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+			return
+		}
+		p.CategoryID = &id
+	}
+	p.Tags = []string{}
+	for _, t := range strings.Split(r.PostFormValue("tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			p.Tags = append(p.Tags, t)
+		}
+	}
+	if p.Title == "" || strings.TrimSpace(p.Body) == "" {
+		page.Errors["form"] = "Title and body are required."
+	}
+	for k, v := range postFieldErrors(cfg, p) {
+		page.Errors[k] = v
+	}
+	if p.CategoryID != nil {
+		c, result := capr.GetCategory(DB, map[string]interface{}{"id": *p.CategoryID})
+		if result.Error != nil || !capr.CanPost(DB, c, page.User) {
+			page.Errors["form"] = "You may not post in this category."
+		}
+	}
+	if len(page.Errors) > 0 {
+		pages.Render(w, http.StatusBadRequest, "post_form", page)
+		return
+	}
+	action, kind := models.AuditPostCreate, notify.PostCreated
+	if p.ID == 0 {
+		p.UserID = page.User.ID
+		result = ppr.CreatePost(DB, p)
+	} else {
+		action, kind = models.AuditPostUpdate, notify.PostUpdated
+		result = ppr.UpdatePost(DB, p)
+	}
+	if result.Error != nil {
+		if result.Error == models.ErrTooManyTags {
+			page.Errors["tags"] = result.Error.Error()
+		} else {
+			page.Errors["form"] = result.Error.Error()
+		}
+		pages.Render(w, http.StatusBadRequest, "post_form", page)
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: action, ActorID: page.User.ID, TargetType: "post", TargetID: p.ID})
+	notifier.Publish(notify.Event{Kind: kind, ActorID: page.User.ID, PostID: p.ID, Mentions: p.Mentions})
+	http.Redirect(w, r, "/web/posts/"+strconv.Itoa(p.ID), http.StatusSeeOther)
+}
+
+//@Summary Profile page
+//@Description HTML profile of a user with their latest posts readable by the current user; 404 if the privacy settings hide it
+//@Produce html
+//@Param id path integer true "User ID"
+//@Success 200
+//@Failure 400,404
+//@Failure default
+//@Router /web/users/{id} [get]
+func webUserHTTP(cfg *config.Config, DB *gorm.DB, pages *views.Manager, w http.ResponseWriter, r *http.Request) {
+	u, code := visibleUser(cfg, DB, r)
+	if code != http.StatusOK {
+		renderError(cfg, DB, pages, code, w, r)
+		return
+	}
+	profile, err := profiles(cfg, DB, r, []models.User{u})
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	tx, err := visiblePosts(cfg, DB, r)
+	if err != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	ppr := models.PostProcess{}
+	pp, result := ppr.ListPosts(tx.Order("createdAt DESC, id DESC").Limit(webProfilePosts), map[string]interface{}{"userId": u.ID})
+	if result.Error != nil {
+		renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+		return
+	}
+	pages.Render(w, http.StatusOK, "user", struct {
+		webPage
+		Profile models.Profile
+		Posts   []models.Post
+		Names   map[int]string
+		More    bool
+	}{newWebPage(cfg, DB, r), profile[0], pp, map[int]string{u.ID: u.Name}, profile[0].PostCount > webProfilePosts})
+}
+
+//@Summary Switch language
+//@Description set the language of the web UI and go back to the page next; it is stored as the preference of a logged in user and in a cookie
+//@Accept x-www-form-urlencoded
+//@Produce html
+//@Param lang formData string true "language, en or ru"
+//@Param next formData string false "path of the page to go back to"
+//@Success 303
+//@Failure 400,403
+//@Failure default
+//@Router /web/language [post]
+func webLanguageHTTP(cfg *config.Config, DB *gorm.DB, pages *views.Manager, w http.ResponseWriter, r *http.Request) {
+	lang := r.PostFormValue("lang")
+	if !i18n.Supported(lang) {
+		renderError(cfg, DB, pages, http.StatusBadRequest, w, r)
+		return
+	}
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	if u.ID != 0 {
+		if !checkCSRF(cfg, r) {
+			renderError(cfg, DB, pages, http.StatusForbidden, w, r)
+			return
+		}
+		u.Language = lang
+		if result := u.UpdateLanguage(DB); result.Error != nil {
+			renderError(cfg, DB, pages, http.StatusInternalServerError, w, r)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: i18n.Cookie, Value: lang, Path: "/", MaxAge: 365 * 24 * 60 * 60,
+		HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	next := r.PostFormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/web/posts"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
@@ -0,0 +1,416 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"nx_trainee_forum/forum/application/config"
+	"nx_trainee_forum/forum/httphandlers/audit"
+	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/webhooks"
+	"regexp"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	deliveriesDefaultLimit = 50
+	deliveriesMaxLimit     = 500
+)
+
+func WebhooksHandler(cfg *config.Config, db *gorm.DB, dispatcher *webhooks.Dispatcher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		rPath := r.URL.Path
+		reWebhooks := regexp.MustCompile(`^\/webhooks(\/)??$`)
+		reWebhooksID := regexp.MustCompile(`^\/webhooks\/\d+(\/)??$`)
+		reWebhooksIDDeliveries := regexp.MustCompile(`^\/webhooks\/\d+\/deliveries(\/)??$`)
+		reWebhooksIDDeliveriesID := regexp.MustCompile(`^\/webhooks\/\d+\/deliveries\/\d+(\/)??$`)
+		reWebhooksIDDeliveriesIDRedeliver := regexp.MustCompile(`^\/webhooks\/\d+\/deliveries\/\d+\/redeliver(\/)??$`)
+
+		switch {
+		case reWebhooks.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list webhooks
+				listWebhooksHTTP(db, w, r)
+			case http.MethodPost: // create webhook in:json
+				createWebhookHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reWebhooksID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // get webhooks/{id}
+				getWebhookHTTP(db, w, r)
+			case http.MethodPut: // update webhooks/{id} in:json
+				updateWebhookHTTP(cfg, db, w, r)
+			case http.MethodDelete: // delete webhooks/{id}
+				deleteWebhookHTTP(cfg, db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reWebhooksIDDeliveries.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // list deliveries of webhook
+				listDeliveriesHTTP(db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reWebhooksIDDeliveriesID.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodGet: // get delivery of webhook
+				getDeliveryHTTP(db, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		case reWebhooksIDDeliveriesIDRedeliver.Match([]byte(rPath)):
+			switch r.Method {
+			case http.MethodPost: // send delivery again
+				redeliverHTTP(db, dispatcher, w, r)
+			default:
+				ResponseError(w, http.StatusMethodNotAllowed, "")
+				return
+			}
+		default:
+			ResponseError(w, http.StatusBadRequest, "")
+		}
+	})
+}
+
+type createWebhookStruct struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// webhookFieldErrors answers 400 for a webhook failing validation and reports
+// whether it did.
+func webhookFieldErrors(w http.ResponseWriter, err error) bool {
+	switch err {
+	case models.ErrInvalidWebhookURL:
+		ResponseFieldErrors(w, fieldErrors{"url": "must be an absolute http or https URL"})
+	case models.ErrInvalidWebhookEvent:
+		ResponseFieldErrors(w, fieldErrors{"events": "must be among " + fmt.Sprint(models.WebhookEvents)})
+	default:
+		return false
+	}
+	return true
+}
+
+// webhookByPath loads the webhook whose ID starts the request path.
+func webhookByPath(DB *gorm.DB, w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	id, err := strconv.Atoi(reNum.FindString(r.URL.Path))
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return models.Webhook{}, false
+	}
+	wpr := models.WebhookProcess{}
+	wh, result := wpr.GetWebhook(DB, map[string]interface{}{"id": id})
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusNotFound, "")
+		return wh, false
+	}
+	return wh, true
+}
+
+// deliveryByPath loads the delivery /webhooks/{id}/deliveries/{did} of the
+// request path.
+func deliveryByPath(DB *gorm.DB, w http.ResponseWriter, r *http.Request) (models.WebhookDelivery, bool) {
+	ids := reNum.FindAllString(r.URL.Path, 2)
+	if len(ids) != 2 {
+		ResponseError(w, http.StatusBadRequest, "")
+		return models.WebhookDelivery{}, false
+	}
+	whID, _ := strconv.Atoi(ids[0])
+	id, err := strconv.Atoi(ids[1])
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return models.WebhookDelivery{}, false
+	}
+	wpr := models.WebhookProcess{}
+	d, result := wpr.GetDelivery(DB, map[string]interface{}{"id": id, "webhookId": whID})
+	if result.Error != nil || result.RowsAffected == 0 {
+		ResponseError(w, http.StatusNotFound, "")
+		return d, false
+	}
+	return d, true
+}
+
+//@Summary List webhooks
+//@Description list webhooks; secrets are not shown (admin only)
+//@Produce json
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 403
+//@Failure default
+//@Router /webhooks [get]
+//@Security ApiKeyAuth
+func listWebhooksHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	wpr := models.WebhookProcess{}
+	whs, result := wpr.ListWebhooks(DB, map[string]interface{}{})
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	for i := range whs {
+		whs[i].Secret = ""
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.Webhooks{Webhooks: whs})
+	} else {
+		jsonWrite(w, whs)
+	}
+}
+
+//@Summary Show webhook
+//@Description get webhook by ID; the secret is not shown (admin only)
+//@Produce json
+//@Param id path integer true "Webhook ID"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,403,404
+//@Failure default
+//@Router /webhooks/{id} [get]
+//@Security ApiKeyAuth
+func getWebhookHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	wh, ok := webhookByPath(DB, w, r)
+	if !ok {
+		return
+	}
+	wh.Secret = ""
+	if responseXML(r) {
+		xmlWrite(w, wh)
+	} else {
+		jsonWrite(w, wh)
+	}
+}
+
+//@Summary Create webhook
+//@Description create webhook delivering the given events, all of them if none are given, to url (admin only); a secret is generated if omitted and shown only in this response
+//@Accept json
+//@Produce json
+//@Param RequestWebhook body createWebhookStruct true "JSON structure for creating webhook"
+//@Success 201
+//@Failure 400,403
+//@Failure default
+//@Router /webhooks [post]
+//@Security ApiKeyAuth
+func createWebhookHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req createWebhookStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	wh := models.Webhook{URL: req.URL, Secret: req.Secret, Events: req.Events, Active: true}
+	if req.Active != nil {
+		wh.Active = *req.Active
+	}
+	if wh.Secret == "" {
+		wh.Secret = webhooks.GenerateSecret()
+	}
+	wpr := models.WebhookProcess{}
+	result := wpr.CreateWebhook(DB, &wh)
+	if webhookFieldErrors(w, result.Error) {
+		return
+	}
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditWebhookCreate, ActorID: u.ID, TargetType: "webhook", TargetID: wh.ID,
+		Details: wh.URL})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	jsonWh, _ := json.MarshalIndent(wh, "", "  ")
+	fmt.Fprintln(w, string(jsonWh))
+}
+
+//@Summary Update webhook
+//@Description update webhook (admin only); omitted fields keep their values, the secret is replaced only if given
+//@Accept json
+//@Produce json
+//@Param id path integer true "Webhook ID"
+//@Param RequestWebhook body createWebhookStruct true "JSON structure for updating webhook"
+//@Success 200
+//@Failure 400,403,404
+//@Failure default
+//@Router /webhooks/{id} [put]
+//@Security ApiKeyAuth
+func updateWebhookHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	wh, ok := webhookByPath(DB, w, r)
+	if !ok {
+		return
+	}
+	reqBody, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	var req createWebhookStruct
+	if err = json.Unmarshal(reqBody, &req); err != nil {
+		ResponseError(w, http.StatusBadRequest, "")
+		return
+	}
+	if req.URL != "" {
+		wh.URL = req.URL
+	}
+	if req.Events != nil {
+		wh.Events = req.Events
+	}
+	if req.Active != nil {
+		wh.Active = *req.Active
+	}
+	wh.Secret = req.Secret
+	wpr := models.WebhookProcess{}
+	result := wpr.UpdateWebhook(DB, &wh)
+	if webhookFieldErrors(w, result.Error) {
+		return
+	}
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditWebhookUpdate, ActorID: u.ID, TargetType: "webhook", TargetID: wh.ID,
+		Details: wh.URL})
+	wh.Secret = ""
+	jsonWrite(w, wh)
+}
+
+//@Summary Delete webhook
+//@Description delete webhook by ID together with its deliveries (admin only)
+//@Param id path integer true "Webhook ID"
+//@Success 200
+//@Failure 400,403,404
+//@Failure default
+//@Router /webhooks/{id} [delete]
+//@Security ApiKeyAuth
+func deleteWebhookHTTP(cfg *config.Config, DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	u := authorization.GetCurrentUser(cfg, DB, r)
+	wh, ok := webhookByPath(DB, w, r)
+	if !ok {
+		return
+	}
+	wpr := models.WebhookProcess{}
+	if result := wpr.DeleteWebhook(DB, &wh); result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	audit.Record(DB, r, models.AuditEvent{Action: models.AuditWebhookDelete, ActorID: u.ID, TargetType: "webhook", TargetID: wh.ID,
+		Details: wh.URL})
+	w.WriteHeader(http.StatusOK)
+}
+
+//@Summary List webhook deliveries
+//@Description list deliveries of a webhook, newest first, with their status, attempts and last response (admin only)
+//@Produce json
+//@Param id path integer true "Webhook ID"
+//@Param limit query integer false "maximum number of deliveries, 50 by default"
+//@Param offset query integer false "number of deliveries to skip"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,403,404
+//@Failure default
+//@Router /webhooks/{id}/deliveries [get]
+//@Security ApiKeyAuth
+func listDeliveriesHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	wh, ok := webhookByPath(DB, w, r)
+	if !ok {
+		return
+	}
+	limit, offset := deliveriesDefaultLimit, 0
+	var err error
+	if v := r.FormValue("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+		if limit > deliveriesMaxLimit {
+			limit = deliveriesMaxLimit
+		}
+	}
+	if v := r.FormValue("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			ResponseError(w, http.StatusBadRequest, "")
+			return
+		}
+	}
+	wpr := models.WebhookProcess{}
+	dd, result := wpr.ListDeliveries(DB, wh.ID, limit, offset)
+	if result.Error != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, models.WebhookDeliveries{Deliveries: dd})
+	} else {
+		jsonWrite(w, dd)
+	}
+}
+
+//@Summary Show webhook delivery
+//@Description get delivery of a webhook by ID (admin only)
+//@Produce json
+//@Param id path integer true "Webhook ID"
+//@Param deliveryId path integer true "Delivery ID"
+//@Param xml query string false "show data like XML"
+//@Success 200
+//@Failure 400,403,404
+//@Failure default
+//@Router /webhooks/{id}/deliveries/{deliveryId} [get]
+//@Security ApiKeyAuth
+func getDeliveryHTTP(DB *gorm.DB, w http.ResponseWriter, r *http.Request) {
+	d, ok := deliveryByPath(DB, w, r)
+	if !ok {
+		return
+	}
+	if responseXML(r) {
+		xmlWrite(w, d)
+	} else {
+		jsonWrite(w, d)
+	}
+}
+
+//@Summary Redeliver webhook delivery
+//@Description send the payload of a delivery again as a new delivery (admin only)
+//@Produce json
+//@Param id path integer true "Webhook ID"
+//@Param deliveryId path integer true "Delivery ID"
+//@Success 202
+//@Failure 400,403,404
+//@Failure default
+//@Router /webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+//@Security ApiKeyAuth
+func redeliverHTTP(DB *gorm.DB, dispatcher *webhooks.Dispatcher, w http.ResponseWriter, r *http.Request) {
+	d, ok := deliveryByPath(DB, w, r)
+	if !ok {
+		return
+	}
+	d, err := dispatcher.Redeliver(d)
+	if err != nil {
+		ResponseError(w, http.StatusInternalServerError, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	jsonD, _ := json.MarshalIndent(d, "", "  ")
+	fmt.Fprintln(w, string(jsonD))
+}
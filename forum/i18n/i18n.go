@@ -0,0 +1,270 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language of messages in the code and of clients asking for
+// none of the shipped ones.
+const Default = "en"
+
+// Cookie is the name of the cookie keeping the language chosen in the web UI.
+const Cookie = "lang"
+
+// Catalogs are JSON bundles in locales/, one per language, mapping English
+// messages to their translation. A message used with a count maps to the
+// list of its plural forms in the order of pluralForm.
+//
+//go:embed locales/*.json
+var localesFS embed.FS
+
+var catalogs = mustLoad()
+
+type catalog struct {
+	messages map[string]string
+	plurals  map[string][]string
+	formats  []format //messages with verbs, to translate text formatted from them
+}
+
+// format matches text formatted from a message with %d, %s or %v verbs.
+type format struct {
+	message string
+	re      *regexp.Regexp
+	numeric []bool //which verbs are %d
+}
+
+var reVerb = regexp.MustCompile(`%[dsv]`)
+
+func mustLoad() map[string]*catalog {
+	files, err := localesFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	cc := make(map[string]*catalog)
+	for _, f := range files {
+		b, err := localesFS.ReadFile("locales/" + f.Name())
+		if err != nil {
+			panic(err)
+		}
+		raw := map[string]json.RawMessage{}
+		if err = json.Unmarshal(b, &raw); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", f.Name(), err))
+		}
+		c := &catalog{messages: make(map[string]string), plurals: make(map[string][]string)}
+		for msg, v := range raw {
+			var forms []string
+			if json.Unmarshal(v, &forms) == nil {
+				c.plurals[msg] = forms
+				continue
+			}
+			var s string
+			if err = json.Unmarshal(v, &s); err != nil {
+				panic(fmt.Sprintf("i18n: %s: %q: %v", f.Name(), msg, err))
+			}
+			c.messages[msg] = s
+			if reVerb.MatchString(msg) {
+				c.formats = append(c.formats, compileFormat(msg))
+			}
+		}
+		//longer formats first, so the most specific one matches
+		sort.Slice(c.formats, func(i, j int) bool { return len(c.formats[i].message) > len(c.formats[j].message) })
+		cc[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = c
+	}
+	if cc[Default] == nil {
+		cc[Default] = &catalog{messages: map[string]string{}, plurals: map[string][]string{}}
+	}
+	return cc
+}
+
+func compileFormat(msg string) format {
+	f := format{message: msg}
+	expr := "^"
+	last := 0
+	for _, loc := range reVerb.FindAllStringIndex(msg, -1) {
+		expr += regexp.QuoteMeta(msg[last:loc[0]])
+		if msg[loc[0]+1] == 'd' {
+			expr += `(-?\d+)`
+			f.numeric = append(f.numeric, true)
+		} else {
+			expr += `(.+?)`
+			f.numeric = append(f.numeric, false)
+		}
+		last = loc[1]
+	}
+	f.re = regexp.MustCompile(expr + regexp.QuoteMeta(msg[last:]) + "$")
+	return f
+}
+
+// Languages returns the shipped languages.
+func Languages() []string {
+	ll := make([]string, 0, len(catalogs))
+	for l := range catalogs {
+		ll = append(ll, l)
+	}
+	sort.Strings(ll)
+	return ll
+}
+
+// Name returns the name of lang in that language, as in "Русский".
+func Name(lang string) string {
+	return T(lang, "language name")
+}
+
+// Supported reports whether lang is shipped.
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Match returns the shipped language the Accept-Language header prefers,
+// or Default.
+func Match(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.IndexByte(tag, '-'); i > 0 {
+			tag = tag[:i]
+		}
+		q := 1.0
+		for _, p := range fields[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if Supported(tag) && q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
+
+// T translates message into lang and formats it with args. Without args,
+// a message formatted beforehand from a catalog entry with verbs is
+// translated too, so "must be at most 5 characters" is found under
+// "must be at most %d characters". Unknown messages are returned as they are.
+func T(lang, message string, args ...interface{}) string {
+	c, ok := catalogs[lang]
+	if !ok {
+		c = catalogs[Default]
+	}
+	tr, ok := c.messages[message]
+	if !ok && len(args) == 0 {
+		for _, f := range c.formats {
+			if m := f.re.FindStringSubmatch(message); m != nil {
+				tr, ok = c.messages[f.message], true
+				for i, s := range m[1:] {
+					if n, err := strconv.Atoi(s); err == nil && f.numeric[i] {
+						args = append(args, n)
+					} else {
+						args = append(args, s)
+					}
+				}
+				break
+			}
+		}
+	}
+	if !ok {
+		tr = message
+	}
+	if len(args) == 0 {
+		return tr
+	}
+	return fmt.Sprintf(tr, args...)
+}
+
+// N translates message, a message about n things, choosing the plural form
+// of lang, and formats it with n.
+func N(lang, message string, n int) string {
+	c, ok := catalogs[lang]
+	if !ok {
+		lang, c = Default, catalogs[Default]
+	}
+	tr := message
+	if forms := c.plurals[message]; len(forms) > 0 {
+		i := pluralForm(lang, n)
+		if i >= len(forms) {
+			i = len(forms) - 1
+		}
+		tr = forms[i]
+	}
+	return fmt.Sprintf(tr, n)
+}
+
+// pluralForm returns the index of the plural form of n in lang: one and
+// other in English; one, few and many in Russian.
+func pluralForm(lang string, n int) int {
+	if n < 0 {
+		n = -n
+	}
+	switch lang {
+	case "ru":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20):
+			return 1
+		}
+		return 2
+	}
+	if n == 1 {
+		return 0
+	}
+	return 1
+}
+
+type contextKey struct{}
+
+// WithLang returns ctx carrying the language of the request.
+func WithLang(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, contextKey{}, lang)
+}
+
+// FromContext returns the language of the request, or Default.
+func FromContext(ctx context.Context) string {
+	if lang, ok := ctx.Value(contextKey{}).(string); ok {
+		return lang
+	}
+	return Default
+}
+
+// responseWriter carries the language of the request to code that has only
+// the writer, such as error responses.
+type responseWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+// NewResponseWriter returns w carrying lang.
+func NewResponseWriter(w http.ResponseWriter, lang string) http.ResponseWriter {
+	return &responseWriter{ResponseWriter: w, lang: lang}
+}
+
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// LangOf returns the language carried by w, or Default.
+func LangOf(w http.ResponseWriter) string {
+	if lw, ok := w.(*responseWriter); ok {
+		return lw.lang
+	}
+	return Default
+}
@@ -0,0 +1,302 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/storage"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+)
+
+var ErrTooLarge = errors.New("image dimensions exceed the limit")
+
+const pollInterval = time.Minute
+
+// Limits bounds the images the processor accepts.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Check decodes only the header of an image and reports whether its
+// dimensions are within the limits. Data that is not a PNG, JPEG or GIF
+// image passes.
+func (l Limits) Check(r io.Reader) error {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil
+	}
+	if (l.MaxWidth > 0 && cfg.Width > l.MaxWidth) || (l.MaxHeight > 0 && cfg.Height > l.MaxHeight) {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// IsImage reports whether attachments of contentType are processed.
+func IsImage(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Processor strips metadata from uploaded images and makes their thumbnails
+// on a pool of background workers.
+type Processor struct {
+	db     *gorm.DB
+	store  storage.Storage
+	limits Limits
+	sizes  []int
+
+	jobs   chan int
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	queued map[int]bool //submitted and not processed yet
+	closed bool
+	stop   chan struct{}
+	poller sync.WaitGroup
+}
+
+// New starts workers goroutines processing queued attachments. Once queue
+// attachments are waiting, more are left for polling, which queues the
+// attachments still processing every pollInterval.
+func New(db *gorm.DB, store storage.Storage, limits Limits, sizes []int, workers, queue int) *Processor {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Processor{db: db, store: store, limits: limits, sizes: sizes, jobs: make(chan int, queue),
+		queued: map[int]bool{}, stop: make(chan struct{})}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	p.poller.Add(1)
+	go p.poll()
+	return p
+}
+
+// Sizes are the widths and heights thumbnails are bounded by.
+func (p *Processor) Sizes() []int {
+	return p.sizes
+}
+
+// Submit queues the attachment with the given ID without waiting. If the
+// queue is full, or after Close, the attachment stays processing and is left
+// for polling or for Resume on the next start.
+func (p *Processor) Submit(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.queued[id] {
+		return
+	}
+	select {
+	case p.jobs <- id:
+		p.queued[id] = true
+	default:
+		log.Printf("attachment %d: queue full, left for polling", id)
+	}
+}
+
+// Resume queues every attachment left unprocessed, e.g. by a restart or a
+// full queue.
+func (p *Processor) Resume() {
+	ids := []int{}
+	if err := p.db.Model(&models.Attachment{}).Where("status = ?", models.AttachmentProcessing).Order("id").Pluck("id", &ids).Error; err != nil {
+		log.Print(err)
+		return
+	}
+	for _, id := range ids {
+		p.Submit(id)
+	}
+}
+
+// Close waits for queued attachments to be processed and stops the workers.
+func (p *Processor) Close() {
+	close(p.stop)
+	p.poller.Wait()
+	p.mu.Lock()
+	p.closed = true
+	close(p.jobs)
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Processor) poll() {
+	defer p.poller.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.Resume()
+		}
+	}
+}
+
+func (p *Processor) work() {
+	defer p.wg.Done()
+	for id := range p.jobs {
+		if err := p.process(id); err != nil {
+			log.Printf("attachment %d: %v", id, err)
+			p.db.Model(&models.Attachment{}).Where("id = ?", id).Update("status", models.AttachmentFailed)
+		}
+		p.mu.Lock()
+		delete(p.queued, id)
+		p.mu.Unlock()
+	}
+}
+
+func (p *Processor) process(id int) error {
+	ctx := context.Background()
+	apr := models.AttachmentProcess{}
+	a, result := apr.GetAttachment(p.db, map[string]interface{}{"id": id})
+	if result.Error == gorm.ErrRecordNotFound {
+		return nil // deleted meanwhile
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if a.Status != models.AttachmentProcessing {
+		return nil
+	}
+	rc, err := p.store.Get(ctx, a.BlobKey())
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	if err = p.limits.Check(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	clean, img, err := strip(data)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(clean)
+	hash := hex.EncodeToString(sum[:])
+	if err = p.put(ctx, models.BlobKey(hash), clean, a.ContentType); err != nil {
+		return err
+	}
+	sizes := []int{}
+	for _, size := range p.sizes {
+		b := img.Bounds()
+		if size <= 0 || (b.Dx() <= size && b.Dy() <= size) {
+			continue
+		}
+		thumb, contentType, err := thumbnail(img, size, a.ContentType)
+		if err != nil {
+			return err
+		}
+		if err = p.put(ctx, models.ThumbnailKey(hash, size), thumb, contentType); err != nil {
+			return err
+		}
+		sizes = append(sizes, size)
+	}
+	oldHash := a.Hash
+	a.Hash, a.Size = hash, int64(len(clean))
+	if result = apr.FinishAttachment(p.db, &a, sizes); result.Error != nil {
+		return result.Error
+	}
+	if oldHash != hash {
+		free, err := apr.UnreferencedHashes(p.db, []string{oldHash})
+		if err == nil && len(free) > 0 {
+			err = p.store.Delete(ctx, models.BlobKey(oldHash))
+		}
+		if err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+func (p *Processor) put(ctx context.Context, key string, data []byte, contentType string) error {
+	exists, err := p.store.Exists(ctx, key)
+	if err != nil || exists {
+		return err
+	}
+	return p.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+}
+
+// strip re-encodes an image, which leaves EXIF, GPS and any other metadata
+// behind. JPEG images are turned upright first, as their orientation is
+// metadata too. The decoded (first) frame is returned for thumbnails.
+func strip(data []byte) ([]byte, image.Image, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		img = orient(img, jpegOrientation(data))
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		return buf.Bytes(), img, err
+	case "png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		err = png.Encode(&buf, img)
+		return buf.Bytes(), img, err
+	case "gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		err = gif.EncodeAll(&buf, g)
+		return buf.Bytes(), g.Image[0], err
+	}
+	return nil, nil, fmt.Errorf("unsupported image format %q", format)
+}
+
+// thumbnail scales img to fit into a size x size square. JPEG images stay
+// JPEG, others become PNG.
+func thumbnail(img image.Image, size int, contentType string) ([]byte, string, error) {
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = b.Dy() * size / b.Dx()
+	} else {
+		w = b.Dx() * size / b.Dy()
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err := png.Encode(&buf, dst)
+	return buf.Bytes(), "image/png", err
+}
@@ -0,0 +1,323 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"nx_trainee_forum/forum/models"
+
+	"gorm.io/gorm"
+)
+
+// PlaceholderProvider is the provider of users imported from JSONPlaceholder.
+// They have no credentials, so nobody can sign in as them.
+const PlaceholderProvider = "jsonplaceholder"
+
+// PlaceholderCommenter is the login of the user owning imported comments,
+// which JSONPlaceholder attributes to a name and an email only.
+const PlaceholderCommenter = "jsonplaceholder"
+
+const batchSize = 500
+
+// ErrIDTaken fails imports into a database whose rows have IDs of the dataset.
+var ErrIDTaken = errors.New("ID is taken by other data, import into an empty database")
+
+// Count is the outcome of importing one resource.
+type Count struct {
+	Resource string
+	Imported int64
+	Skipped  int64 //rows stored by an earlier import
+}
+
+func (c Count) String() string {
+	return fmt.Sprintf("%s: %d imported, %d skipped", c.Resource, c.Imported, c.Skipped)
+}
+
+type placeholderUser struct {
+	ID       int            `json:"id"`
+	Name     string         `json:"name"`
+	Username string         `json:"username"`
+	Email    string         `json:"email"`
+	Address  models.Address `json:"address"`
+	Phone    string         `json:"phone"`
+	Website  string         `json:"website"`
+	Company  models.Company `json:"company"`
+}
+
+// JSONPlaceholder loads the JSONPlaceholder dataset from users.json,
+// posts.json, comments.json, albums.json, photos.json and todos.json in dir,
+// keeping the IDs of the dataset. Missing files are left out, but rows must
+// refer to rows of the files given. Rows stored by an earlier import are
+// skipped, so importing twice changes nothing; if an ID is taken by other
+// data nothing is imported, as references would point at unrelated rows.
+// Nothing is imported if any row fails.
+func JSONPlaceholder(db *gorm.DB, dir string) ([]Count, error) {
+	var users []placeholderUser
+	var posts []models.Post
+	var comments []models.Comment
+	var albums []models.Album
+	var photos []models.Photo
+	var todos []models.Todo
+	found := 0
+	for _, f := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"users.json", &users}, {"posts.json", &posts}, {"comments.json", &comments},
+		{"albums.json", &albums}, {"photos.json", &photos}, {"todos.json", &todos},
+	} {
+		ok, err := readJSON(filepath.Join(dir, f.name), f.v)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found++
+		}
+	}
+	if found == 0 {
+		return nil, fmt.Errorf("no JSONPlaceholder files in %s", dir)
+	}
+
+	counts := []Count{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		//IDs of the dataset rows stored now or by an earlier import
+		userIDs, postIDs, albumIDs := map[int]bool{}, map[int]bool{}, map[int]bool{}
+		if users != nil {
+			uu := make([]models.User, len(users))
+			for i, pu := range users {
+				uu[i] = models.User{ID: pu.ID, Login: pu.Username, Provider: PlaceholderProvider, Name: pu.Name,
+					Email: pu.Email, ShowEmail: true, EmailNotifications: models.EmailOff, Phone: pu.Phone, Website: pu.Website}
+				uu[i].SetAddress(&pu.Address)
+				uu[i].SetCompany(&pu.Company)
+				userIDs[pu.ID] = true
+			}
+			keep, err := fresh(tx, "users", []string{"login", "provider"}, len(uu),
+				func(i int) int { return uu[i].ID },
+				func(i int) string { return key(uu[i].Login, PlaceholderProvider) }, nil)
+			if err != nil {
+				return err
+			}
+			rows := make([]models.User, 0, len(keep))
+			for _, i := range keep {
+				rows = append(rows, uu[i])
+			}
+			c, err := insert(tx, "users", rows, len(uu))
+			if err != nil {
+				return err
+			}
+			counts = append(counts, c)
+		}
+		if posts != nil {
+			keep, err := fresh(tx, "posts", []string{"userId", "title"}, len(posts),
+				func(i int) int { return posts[i].ID },
+				func(i int) string { return key(posts[i].UserID, posts[i].Title) },
+				func(i int) error { return ref("user", posts[i].UserID, userIDs) })
+			if err != nil {
+				return err
+			}
+			rows := make([]models.Post, 0, len(keep))
+			for _, i := range keep {
+				posts[i].Type = models.PostTypeDiscussion
+				rows = append(rows, posts[i])
+			}
+			for _, p := range posts {
+				postIDs[p.ID] = true
+			}
+			c, err := insert(tx, "posts", rows, len(posts))
+			if err != nil {
+				return err
+			}
+			counts = append(counts, c)
+		}
+		if comments != nil {
+			commenter, err := placeholderCommenter(tx)
+			if err != nil {
+				return err
+			}
+			keep, err := fresh(tx, "comments", []string{"postId", "email", "name"}, len(comments),
+				func(i int) int { return comments[i].ID },
+				func(i int) string { return key(comments[i].PostID, comments[i].Email, comments[i].Name) },
+				func(i int) error { return ref("post", comments[i].PostID, postIDs) })
+			if err != nil {
+				return err
+			}
+			rows := make([]models.Comment, 0, len(keep))
+			for _, i := range keep {
+				comments[i].UserID = commenter.ID
+				rows = append(rows, comments[i])
+			}
+			c, err := insert(tx, "comments", rows, len(comments))
+			if err != nil {
+				return err
+			}
+			counts = append(counts, c)
+		}
+		if albums != nil {
+			keep, err := fresh(tx, "albums", []string{"userId", "title"}, len(albums),
+				func(i int) int { return albums[i].ID },
+				func(i int) string { return key(albums[i].UserID, albums[i].Title) },
+				func(i int) error { return ref("user", albums[i].UserID, userIDs) })
+			if err != nil {
+				return err
+			}
+			rows := make([]models.Album, 0, len(keep))
+			for _, i := range keep {
+				rows = append(rows, albums[i])
+			}
+			for _, al := range albums {
+				albumIDs[al.ID] = true
+			}
+			c, err := insert(tx, "albums", rows, len(albums))
+			if err != nil {
+				return err
+			}
+			counts = append(counts, c)
+		}
+		if photos != nil {
+			keep, err := fresh(tx, "photos", []string{"albumId", "title"}, len(photos),
+				func(i int) int { return photos[i].ID },
+				func(i int) string { return key(photos[i].AlbumID, photos[i].Title) },
+				func(i int) error { return ref("album", photos[i].AlbumID, albumIDs) })
+			if err != nil {
+				return err
+			}
+			rows := make([]models.Photo, 0, len(keep))
+			for _, i := range keep {
+				rows = append(rows, photos[i])
+			}
+			c, err := insert(tx, "photos", rows, len(photos))
+			if err != nil {
+				return err
+			}
+			counts = append(counts, c)
+		}
+		if todos != nil {
+			keep, err := fresh(tx, "todos", []string{"userId", "title"}, len(todos),
+				func(i int) int { return todos[i].ID },
+				func(i int) string { return key(todos[i].UserID, todos[i].Title) },
+				func(i int) error { return ref("user", todos[i].UserID, userIDs) })
+			if err != nil {
+				return err
+			}
+			rows := make([]models.Todo, 0, len(keep))
+			for _, i := range keep {
+				rows = append(rows, todos[i])
+			}
+			c, err := insert(tx, "todos", rows, len(todos))
+			if err != nil {
+				return err
+			}
+			counts = append(counts, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// readJSON decodes the file name into v and reports whether it exists.
+func readJSON(name string, v interface{}) (bool, error) {
+	b, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return true, nil
+}
+
+// insert stores rows, a slice, with their IDs; n is the number of rows of
+// the resource in the dataset, those left out count as skipped.
+func insert(tx *gorm.DB, resource string, rows interface{}, n int) (Count, error) {
+	if reflect.ValueOf(rows).Len() == 0 {
+		return Count{Resource: resource, Skipped: int64(n)}, nil
+	}
+	result := tx.CreateInBatches(rows, batchSize)
+	if result.Error != nil {
+		return Count{}, fmt.Errorf("%s: %w", resource, result.Error)
+	}
+	return Count{Resource: resource, Imported: result.RowsAffected, Skipped: int64(n) - result.RowsAffected}, nil
+}
+
+// keySep separates the columns of a key.
+const keySep = "\x1f"
+
+// key joins the values identifying a row.
+func key(values ...interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, keySep)
+}
+
+// fresh returns the indexes of the n rows of resource to insert. A row whose
+// ID is stored with the same key, i.e. cols joined by keySep, was imported
+// before and is skipped; an ID stored with another key belongs to other data
+// and fails the import, as does a failing check of the row.
+func fresh(tx *gorm.DB, resource string, cols []string, n int, id func(i int) int, key func(i int) string, check func(i int) error) ([]int, error) {
+	keys := map[int]string{}
+	for start := 0; start < n; start += batchSize {
+		ids := []int{}
+		for i := start; i < n && i < start+batchSize; i++ {
+			ids = append(ids, id(i))
+		}
+		rows := []struct {
+			ID  int    `gorm:"column:id"`
+			Key string `gorm:"column:k"`
+		}{}
+		err := tx.Table(resource).Select("id, CONCAT_WS(?, "+strings.Join(cols, ", ")+") AS k", keySep).
+			Where("id IN ?", ids).Scan(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", resource, err)
+		}
+		for _, row := range rows {
+			keys[row.ID] = row.Key
+		}
+	}
+	keep := []int{}
+	for i := 0; i < n; i++ {
+		if check != nil {
+			if err := check(i); err != nil {
+				return nil, fmt.Errorf("%s %d: %w", resource, id(i), err)
+			}
+		}
+		stored, ok := keys[id(i)]
+		switch {
+		case !ok:
+			keep = append(keep, i)
+		case stored != key(i):
+			return nil, fmt.Errorf("%s %d: %w", resource, id(i), ErrIDTaken)
+		}
+	}
+	return keep, nil
+}
+
+// ref checks that a row refers to a row of the dataset.
+func ref(name string, id int, ids map[int]bool) error {
+	if !ids[id] {
+		return fmt.Errorf("unknown %s %d", name, id)
+	}
+	return nil
+}
+
+// placeholderCommenter returns the user owning imported comments, creating
+// it on first use. Its profile is private.
+func placeholderCommenter(tx *gorm.DB) (models.User, error) {
+	u := models.User{}
+	err := tx.Where(models.User{Login: PlaceholderCommenter, Provider: PlaceholderProvider}).
+		Attrs(models.User{Name: "JSONPlaceholder", ProfileVisibility: models.ProfilePrivate, EmailNotifications: models.EmailOff}).
+		FirstOrCreate(&u).Error
+	return u, err
+}
@@ -0,0 +1,94 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"nx_trainee_forum/forum/models"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// digestMaxItems is the number of notifications listed in a digest; the rest
+// are only counted.
+const digestMaxItems = 20
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = template.Must(template.ParseFS(templateFS, "templates/*.txt"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+)
+
+// item is a notification as shown in a message.
+type item struct {
+	Text string
+	URL  string
+	Time time.Time
+}
+
+// message is the data of the templates.
+type message struct {
+	Name        string
+	Items       []item
+	More        int
+	Unsubscribe string
+	Verify      string //link confirming the address
+}
+
+// describe words notification n for its recipient.
+func (m *Mailer) describe(n models.Notification) item {
+	it := item{URL: m.cfg.BaseURL + "/notifications", Time: n.CreatedAt.UTC()}
+	actor := "Someone"
+	var u models.User
+	if result := u.GetUser(m.db, map[string]interface{}{"id": n.ActorID}); result.Error == nil && u.Name != "" {
+		actor = u.Name
+	}
+	title := ""
+	if n.PostID != nil {
+		it.URL = m.cfg.BaseURL + "/posts/" + strconv.Itoa(*n.PostID)
+		ppr := models.PostProcess{}
+		if p, result := ppr.GetPost(m.db, map[string]interface{}{"id": *n.PostID}); result.Error == nil {
+			title = " “" + p.Title + "”"
+		}
+	}
+	post := func(prep string) string {
+		if title == "" {
+			return ""
+		}
+		return " " + prep + title
+	}
+	switch n.Type {
+	case models.NotificationComment:
+		it.Text = actor + " commented on your post" + title
+	case models.NotificationReply:
+		it.Text = actor + " replied to your comment" + post("on")
+	case models.NotificationMention:
+		it.Text = actor + " mentioned you" + post("in")
+	case models.NotificationAnswer:
+		it.Text = actor + " accepted your answer" + post("to")
+	default:
+		it.Text = actor + ": " + n.Details
+	}
+	return it
+}
+
+// compose renders template name for user u into a message to be queued.
+func (m *Mailer) compose(u models.User, name, subject string, data message) (*models.EmailMessage, error) {
+	data.Name = u.Name
+	data.Unsubscribe = m.unsubscribeURL(u.ID)
+	var text, html bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, err
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return nil, err
+	}
+	if len([]rune(subject)) > 200 {
+		subject = string([]rune(subject)[:200]) + "…"
+	}
+	return &models.EmailMessage{UserID: u.ID, To: u.Email, Subject: subject, Text: text.String(), HTML: html.String(),
+		Unsubscribe: data.Unsubscribe}, nil
+}
@@ -0,0 +1,424 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"nx_trainee_forum/forum/events"
+	"nx_trainee_forum/forum/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	pollInterval = time.Second
+	smtpTimeout  = 30 * time.Second
+)
+
+// Config holds the SMTP server and the delivery settings. TLS is "starttls",
+// "tls" (implicit TLS, usually port 465) or "none".
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLS      string
+
+	BaseURL     string //links in messages start with it
+	Key         string //signs unsubscribe and verification links
+	MaxAttempts int
+	Backoff     time.Duration //wait before the second attempt, doubled after every failure
+	DigestHour  int           //hour of the day (UTC) digests are sent at
+}
+
+// Mailer emails notifications to users, right away or as daily digests
+// depending on their preference. Messages are queued in the database and sent
+// in the background; failed ones are retried with exponential backoff. A
+// mailer without SMTP host does nothing.
+type Mailer struct {
+	db  *gorm.DB
+	cfg Config
+
+	lastDigest time.Time
+	wake       chan struct{}
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+func New(db *gorm.DB, cfg Config) *Mailer {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	m := &Mailer{
+		db:   db,
+		cfg:  cfg,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	if m.Enabled() {
+		m.wg.Add(1)
+		go m.work()
+	}
+	return m
+}
+
+// Enabled reports whether an SMTP server is configured.
+func (m *Mailer) Enabled() bool {
+	return m.cfg.Host != ""
+}
+
+// Close stops sending; queued messages are sent after the next start.
+func (m *Mailer) Close() {
+	close(m.stop)
+	m.wg.Wait()
+}
+
+// Wake makes the mailer look for due messages now.
+func (m *Mailer) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// UnsubscribeToken returns the token of the unsubscribe link of user userID.
+func UnsubscribeToken(key string, userID int) string {
+	id := strconv.Itoa(userID)
+	return id + "." + signature(key, "unsubscribe:"+id)
+}
+
+// ParseUnsubscribeToken returns the user an unsubscribe token was made for.
+func ParseUnsubscribeToken(key, token string) (int, bool) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(token[:i])
+	if err != nil || !hmac.Equal([]byte(token[i+1:]), []byte(signature(key, "unsubscribe:"+token[:i]))) {
+		return 0, false
+	}
+	return id, true
+}
+
+// VerifyToken returns the token of the link confirming that user userID
+// receives mail at email. It stops working once the address changes.
+func VerifyToken(key string, userID int, email string) string {
+	id := strconv.Itoa(userID)
+	return id + "." + signature(key, "verify:"+id+":"+email)
+}
+
+// ParseVerifyToken returns the user a verification token names; the token
+// is valid if CheckVerifyToken holds for the address of that user.
+func ParseVerifyToken(token string) (int, bool) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.Atoi(token[:i])
+	return id, err == nil
+}
+
+// CheckVerifyToken reports whether token confirms the address of u.
+func CheckVerifyToken(key, token string, u models.User) bool {
+	return u.Email != "" && hmac.Equal([]byte(token), []byte(VerifyToken(key, u.ID, u.Email)))
+}
+
+func signature(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (m *Mailer) unsubscribeURL(userID int) string {
+	return m.cfg.BaseURL + "/unsubscribe?token=" + UnsubscribeToken(m.cfg.Key, userID)
+}
+
+// Notify queues an email for a notification event if the recipient wants
+// them right away. It is meant to listen on the event bus.
+func (m *Mailer) Notify(e events.Event) {
+	n, ok := e.Data.(models.Notification)
+	if !m.Enabled() || e.Type != events.Notification || !ok {
+		return
+	}
+	var u models.User
+	if result := u.GetUser(m.db, map[string]interface{}{"id": n.UserID}); result.Error != nil {
+		return
+	}
+	if u.Email == "" || !u.EmailVerified || u.EmailNotifications != models.EmailImmediate {
+		return
+	}
+	it := m.describe(n)
+	msg, err := m.compose(u, "notification", it.Text, message{Items: []item{it}})
+	if err == nil {
+		err = m.queue(msg)
+	}
+	if err != nil {
+		log.Printf("mailer: notification %d: %v", n.ID, err)
+	}
+}
+
+// SendVerification queues the message asking user u to confirm their
+// address; nothing is mailed to an address before.
+func (m *Mailer) SendVerification(u models.User) error {
+	if !m.Enabled() || u.Email == "" {
+		return nil
+	}
+	msg, err := m.compose(u, "verify", "Confirm your email address",
+		message{Verify: m.cfg.BaseURL + "/email/verify?token=" + VerifyToken(m.cfg.Key, u.ID, u.Email)})
+	if err != nil {
+		return err
+	}
+	msg.Unsubscribe = "" //not a notification
+	return m.queue(msg)
+}
+
+// SendDigests queues digests of the unread notifications for the users who
+// did not get the digest due last before now, and returns how many were
+// queued.
+func (m *Mailer) SendDigests(now time.Time) (int, error) {
+	if !m.Enabled() {
+		return 0, nil
+	}
+	now = now.UTC()
+	due := time.Date(now.Year(), now.Month(), now.Day(), m.cfg.DigestHour, 0, 0, 0, time.UTC)
+	if due.After(now) {
+		due = due.AddDate(0, 0, -1)
+	}
+	epr := models.EmailProcess{}
+	uu, result := epr.DigestRecipients(m.db, due)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	npr := models.NotificationProcess{}
+	queued := 0
+	for _, u := range uu {
+		since := now.AddDate(0, 0, -1)
+		if u.DigestSentAt != nil {
+			since = *u.DigestSentAt
+		}
+		nn, result := npr.UnreadSince(m.db, u.ID, since, 500)
+		if result.Error != nil {
+			return queued, result.Error
+		}
+		if len(nn) > 0 {
+			data := message{}
+			for i, n := range nn {
+				if i == digestMaxItems {
+					data.More = len(nn) - i
+					break
+				}
+				data.Items = append(data.Items, m.describe(n))
+			}
+			subject := "1 new notification"
+			if len(nn) > 1 {
+				subject = fmt.Sprintf("%d new notifications", len(nn))
+			}
+			msg, err := m.compose(u, "digest", subject, data)
+			if err == nil {
+				err = m.queue(msg)
+			}
+			if err != nil {
+				return queued, err
+			}
+			queued++
+		}
+		u.DigestSentAt = &now
+		if result = u.UpdateDigestSentAt(m.db); result.Error != nil {
+			return queued, result.Error
+		}
+	}
+	if queued > 0 {
+		m.Wake()
+	}
+	return queued, nil
+}
+
+func (m *Mailer) queue(msg *models.EmailMessage) error {
+	epr := models.EmailProcess{}
+	if result := epr.QueueMessage(m.db, msg); result.Error != nil {
+		return result.Error
+	}
+	m.Wake()
+	return nil
+}
+
+func (m *Mailer) work() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		case <-m.wake:
+		}
+		if now := time.Now().UTC(); now.Hour() >= m.cfg.DigestHour && !sameDay(now, m.lastDigest) {
+			if _, err := m.SendDigests(now); err != nil {
+				log.Printf("mailer: digests: %v", err)
+			} else {
+				m.lastDigest = now
+			}
+		}
+		m.sendDue()
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay()
+}
+
+// sendDue sends the messages whose attempt is due.
+func (m *Mailer) sendDue() {
+	epr := models.EmailProcess{}
+	for {
+		mm, result := epr.DueMessages(m.db, time.Now(), 20)
+		if result.Error != nil {
+			log.Printf("mailer: %v", result.Error)
+			return
+		}
+		for i := range mm {
+			select {
+			case <-m.stop:
+				return
+			default:
+			}
+			m.attempt(&mm[i])
+		}
+		if len(mm) < 20 {
+			return
+		}
+	}
+}
+
+// attempt sends msg once and records the outcome.
+func (m *Mailer) attempt(msg *models.EmailMessage) {
+	msg.Attempts++
+	msg.Error = ""
+	err := m.send(msg)
+	now := time.Now()
+	switch {
+	case err == nil:
+		msg.Status, msg.NextAttemptAt, msg.SentAt = models.EmailSent, nil, &now
+	case msg.Attempts >= m.cfg.MaxAttempts:
+		msg.Status, msg.NextAttemptAt = models.EmailFailed, nil
+	default:
+		next := now.Add(m.cfg.Backoff << (msg.Attempts - 1))
+		msg.NextAttemptAt = &next
+	}
+	if err != nil {
+		msg.Error = err.Error()
+		if len(msg.Error) > 512 {
+			msg.Error = msg.Error[:512]
+		}
+	}
+	epr := models.EmailProcess{}
+	if result := epr.SaveAttempt(m.db, msg); result.Error != nil {
+		log.Printf("mailer: message %d: %v", msg.ID, result.Error)
+	}
+}
+
+// send delivers msg to the SMTP server.
+func (m *Mailer) send(msg *models.EmailMessage) error {
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	tlsCfg := &tls.Config{ServerName: m.cfg.Host}
+	var conn net.Conn
+	if m.cfg.TLS == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpTimeout}, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if m.cfg.TLS == "starttls" {
+		if err = c.StartTLS(tlsCfg); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = wc.Write(m.build(msg, from)); err != nil {
+		return err
+	}
+	if err = wc.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// build returns msg as a multipart/alternative MIME message with one-click
+// unsubscribe headers (RFC 8058).
+func (m *Mailer) build(msg *models.EmailMessage, from *mail.Address) []byte {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ typ, content string }{{"text/plain", msg.Text}, {"text/html", msg.HTML}} {
+		pw, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		qw := quotedprintable.NewWriter(pw)
+		qw.Write([]byte(part.content))
+		qw.Close()
+	}
+	mw.Close()
+
+	domain := "localhost"
+	if i := strings.LastIndexByte(from.Address, '@'); i >= 0 {
+		domain = from.Address[i+1:]
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%d.%d@%s>\r\n", msg.ID, msg.CreatedAt.UnixNano(), domain)
+	if msg.Unsubscribe != "" {
+		fmt.Fprintf(&b, "List-Unsubscribe: <%s>\r\n", msg.Unsubscribe)
+		b.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
@@ -38,6 +38,13 @@ func init() {
 // @in header
 // @name APIKey
 func main() {
+	//forum import jsonplaceholder <dir> and other commands run instead of the server
+	if len(os.Args) > 1 {
+		if err := application.Command(os.Args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	a = application.New()
 	docs.SwaggerInfo.BasePath = "/"
 	docs.SwaggerInfo.Schemes = []string{"http"}
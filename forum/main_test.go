@@ -1,18 +1,52 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"io/ioutil"
 	"log"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"net/url"
 	"nx_trainee_forum/forum/application"
+	"nx_trainee_forum/forum/assets"
 	"nx_trainee_forum/forum/httphandlers/authorization"
+	"nx_trainee_forum/forum/i18n"
+	"nx_trainee_forum/forum/importer"
+	"nx_trainee_forum/forum/mailer"
 	"nx_trainee_forum/forum/models"
+	"nx_trainee_forum/forum/static"
+	"nx_trainee_forum/forum/storage"
+	"nx_trainee_forum/forum/views"
+	"nx_trainee_forum/forum/webhooks"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
@@ -25,6 +59,7 @@ func TestMain(t *testing.M) {
 	clearTableUsers()
 	clearTablePosts()
 	clearTableComments()
+	clearTableAudit()
 	os.Exit(code)
 }
 
@@ -53,6 +88,15 @@ func clearTableComments() {
 	tx.Exec("ALTER TABLE comments AUTO_INCREMENT = 1")
 	tx.Commit()
 }
+func clearTableAudit() {
+	tx := a.DB.Begin()
+	tx.Exec("DELETE FROM audit_events")
+	tx.Exec("ALTER TABLE audit_events AUTO_INCREMENT = 1")
+	tx.Commit()
+}
+func setTestUserRole(role string) {
+	a.DB.Exec("UPDATE users SET role = ? WHERE login = ?", role, "test")
+}
 func execRequest(request *http.Request) *httptest.ResponseRecorder {
 	rr := httptest.NewRecorder()
 	a.Router.ServeHTTP(rr, request)
@@ -398,7 +442,7 @@ func TestGetComment(t *testing.T) {
 }
 func TestCreatePost(t *testing.T) {
 	clearTablePosts()
-	rBody := []byte(`{"title":"test","body":"test"}`)
+	rBody := []byte(`{"title":"test","body":"test","createdAt":"2001-01-01T00:00:00Z","updatedAt":"2099-01-01T00:00:00Z"}`)
 	request, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
 	request.Header.Add("APIKey", "test")
 	resp := execRequest(request)
@@ -417,12 +461,18 @@ func TestCreatePost(t *testing.T) {
 	if m["body"] != "test" {
 		t.Errorf("Expected post body to be 'test'. Got '%v'", m["body"])
 	}
+	//timestamps are the server's
+	var p models.Post
+	a.DB.First(&p, 1)
+	if time.Since(p.CreatedAt) > time.Minute || time.Since(p.UpdatedAt) > time.Minute {
+		t.Errorf("Expected createdAt and updatedAt of the client ignored. Got %v %v", p.CreatedAt, p.UpdatedAt)
+	}
 }
 func TestCreateComment(t *testing.T) {
 	clearTableComments()
 	clearTablePosts()
 	addPosts(1)
-	rBody := []byte(`{"name":"test","body":"test","email":"test@test.test","postId":1}`)
+	rBody := []byte(`{"name":"test","body":"test","email":"test@test.test","postId":1,"createdAt":"2001-01-01T00:00:00Z","updatedAt":"2099-01-01T00:00:00Z"}`)
 	request, _ := http.NewRequest(http.MethodPost, "/comments", bytes.NewBuffer(rBody))
 	request.Header.Add("APIKey", "test")
 	resp := execRequest(request)
@@ -447,6 +497,11 @@ func TestCreateComment(t *testing.T) {
 	if m["email"] != "test@test.test" {
 		t.Errorf("Expected comment email to be 'test'. Got '%v'", m["email"])
 	}
+	var c models.Comment
+	a.DB.First(&c, 1)
+	if time.Since(c.CreatedAt) > time.Minute || time.Since(c.UpdatedAt) > time.Minute {
+		t.Errorf("Expected createdAt and updatedAt of the client ignored. Got %v %v", c.CreatedAt, c.UpdatedAt)
+	}
 }
 
 func TestUpdatePost(t *testing.T) {
@@ -540,3 +595,2023 @@ func TestDeleteComment(t *testing.T) {
 		t.Errorf("Expected error JSON message. Got %s", resp.Body.String())
 	}
 }
+func TestAuditLog(t *testing.T) {
+	clearTableAudit()
+	clearTablePosts()
+	rBody := []byte(`{"title":"test","body":"test"}`)
+	request, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	//non-admin
+	setTestUserRole(models.RoleUser)
+	request, _ = http.NewRequest(http.MethodGet, "/audit", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusForbidden, resp.Code)
+	//admin
+	setTestUserRole(models.RoleAdmin)
+	defer setTestUserRole(models.RoleUser)
+	request, _ = http.NewRequest(http.MethodGet, "/audit?action=post.create", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var ee []models.AuditEvent
+	json.Unmarshal(resp.Body.Bytes(), &ee)
+	if len(ee) != 1 || ee[0].ActorID != 1 || ee[0].TargetID != 1 {
+		t.Errorf("Expected one post.create event by user 1 on post 1. Got %s", resp.Body.String())
+	}
+	//provider matches exactly, not as a pattern
+	a.DB.Create(&models.AuditEvent{CreatedAt: time.Now(), Action: models.AuditLoginSuccess, ActorID: 1, Provider: "google"})
+	a.DB.Create(&models.AuditEvent{CreatedAt: time.Now(), Action: models.AuditLoginFailure, Provider: "googlex", Details: "provider error"})
+	for provider, n := range map[string]int{"google": 1, "goo_le": 0, "%": 0} {
+		request, _ = http.NewRequest(http.MethodGet, "/audit?provider="+url.QueryEscape(provider), nil)
+		request.Header.Add("APIKey", "test")
+		resp = execRequest(request)
+		checkRespCode(t, http.StatusOK, resp.Code)
+		ee = nil
+		json.Unmarshal(resp.Body.Bytes(), &ee)
+		if len(ee) != n {
+			t.Errorf("Expected %d events of provider %q. Got %s", n, provider, resp.Body.String())
+		}
+	}
+	//export
+	request, _ = http.NewRequest(http.MethodGet, "/audit/export", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected application/x-ndjson. Got %s", ct)
+	}
+}
+func TestThreadedComments(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	addPosts(1)
+	addComments(1, 1)
+	rBody := []byte(`{"name":"test","body":"reply","email":"test@test.test","postId":1,"parentId":1}`)
+	request, _ := http.NewRequest(http.MethodPost, "/comments", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	//reply to comment of another post
+	rBody = []byte(`{"name":"test","body":"reply","email":"test@test.test","postId":2,"parentId":1}`)
+	request, _ = http.NewRequest(http.MethodPost, "/comments", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	//tree
+	request, _ = http.NewRequest(http.MethodGet, "/posts/1/comments?view=tree", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var tree []models.Comment
+	json.Unmarshal(resp.Body.Bytes(), &tree)
+	if len(tree) != 1 || len(tree[0].Replies) != 1 || tree[0].Replies[0].Depth != 1 {
+		t.Errorf("Expected one root comment with one reply. Got %s", resp.Body.String())
+	}
+	//deleting parent leaves tombstone
+	request, _ = http.NewRequest(http.MethodDelete, "/comments/1", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	request, _ = http.NewRequest(http.MethodGet, "/comments/1", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var tomb models.Comment
+	json.Unmarshal(resp.Body.Bytes(), &tomb)
+	if !tomb.Deleted || tomb.Body != "" {
+		t.Errorf("Expected tombstone of comment 1. Got %s", resp.Body.String())
+	}
+	//deleting the last reply prunes the tombstone
+	request, _ = http.NewRequest(http.MethodDelete, "/comments/2", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var n int64
+	if a.DB.Model(&models.Comment{}).Where("postId = ?", 1).Count(&n); n != 0 {
+		t.Errorf("Expected no comments left on post 1. Got %d", n)
+	}
+}
+func TestCategories(t *testing.T) {
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM categories")
+	a.DB.Exec("ALTER TABLE categories AUTO_INCREMENT = 1")
+	//only admins create categories
+	rBody := []byte(`{"name":"announcements","postRole":"admin"}`)
+	request, _ := http.NewRequest(http.MethodPost, "/categories", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusForbidden, resp.Code)
+	setTestUserRole(models.RoleAdmin)
+	request, _ = http.NewRequest(http.MethodPost, "/categories", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	setTestUserRole(models.RoleUser)
+	//read-only board
+	rBody = []byte(`{"title":"test","body":"test","categoryId":1}`)
+	request, _ = http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusForbidden, resp.Code)
+	//filter
+	addPosts(2)
+	a.DB.Exec("UPDATE posts SET categoryId = 1 WHERE id = 1")
+	request, _ = http.NewRequest(http.MethodGet, "/posts?categoryId=1", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var pp []models.Post
+	json.Unmarshal(resp.Body.Bytes(), &pp)
+	if len(pp) != 1 || pp[0].ID != 1 {
+		t.Errorf("Expected only post 1 in category 1. Got %s", resp.Body.String())
+	}
+	//no comments on posts of hidden boards
+	a.DB.Exec("UPDATE categories SET readRole = ? WHERE id = 1", models.RoleModerator)
+	defer a.DB.Exec("UPDATE categories SET readRole = '' WHERE id = 1")
+	rBody = []byte(`{"postId":1,"name":"test","email":"test@test.test","body":"hidden"}`)
+	request, _ = http.NewRequest(http.MethodPost, "/comments", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusNotFound, resp.Code)
+	//subcategories are as restricted as their parents
+	sub := models.Category{Name: "staff room", ParentID: &[]int{1}[0]}
+	a.DB.Create(&sub)
+	a.DB.Exec("UPDATE posts SET categoryId = ? WHERE id = 2", sub.ID)
+	request, _ = http.NewRequest(http.MethodGet, "/posts?categoryId="+strconv.Itoa(sub.ID), nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	pp = nil
+	if json.Unmarshal(resp.Body.Bytes(), &pp); len(pp) != 0 {
+		t.Errorf("Expected no posts of the nested restricted category. Got %s", resp.Body.String())
+	}
+	request, _ = http.NewRequest(http.MethodGet, "/categories/"+strconv.Itoa(sub.ID), nil)
+	request.Header.Add("APIKey", "test")
+	checkRespCode(t, http.StatusNotFound, execRequest(request).Code)
+	setTestUserRole(models.RoleModerator)
+	defer setTestUserRole(models.RoleUser)
+	request, _ = http.NewRequest(http.MethodGet, "/categories/"+strconv.Itoa(sub.ID), nil)
+	request.Header.Add("APIKey", "test")
+	checkRespCode(t, http.StatusOK, execRequest(request).Code)
+}
+func TestPostTags(t *testing.T) {
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM tags")
+	rBody := []byte(`{"title":"test","body":"test","tags":["Go","HTTP server","go"]}`)
+	request, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var p models.Post
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	if len(p.Tags) != 2 || p.Tags[0] != "go" || p.Tags[1] != "http-server" {
+		t.Errorf("Expected tags [go http-server]. Got %v", p.Tags)
+	}
+	//autocomplete
+	request, _ = http.NewRequest(http.MethodGet, "/tags?prefix=ht", nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var tt []models.TagCount
+	json.Unmarshal(resp.Body.Bytes(), &tt)
+	if len(tt) != 1 || tt[0].Slug != "http-server" || tt[0].Count != 1 {
+		t.Errorf("Expected [http-server:1]. Got %s", resp.Body.String())
+	}
+	//tags of posts in a restricted category are not counted
+	staff := models.Category{Name: "staff", ReadRole: models.RoleModerator}
+	a.DB.Create(&staff)
+	defer a.DB.Delete(&staff)
+	rBody = []byte(`{"title":"secret","body":"test","tags":["go","hiring"]}`)
+	request, _ = http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var secret models.Post
+	json.Unmarshal(resp.Body.Bytes(), &secret)
+	a.DB.Model(&secret).Update("categoryId", staff.ID)
+	request, _ = http.NewRequest(http.MethodGet, "/tags", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	tt = nil
+	json.Unmarshal(resp.Body.Bytes(), &tt)
+	if len(tt) != 2 || tt[0].Slug != "go" || tt[0].Count != 1 || tt[1].Slug != "http-server" {
+		t.Errorf("Expected [go:1 http-server:1]. Got %s", resp.Body.String())
+	}
+	a.DB.Delete(&secret)
+	//posts of tag
+	request, _ = http.NewRequest(http.MethodGet, "/tags/go/posts", nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var pp []models.Post
+	json.Unmarshal(resp.Body.Bytes(), &pp)
+	if len(pp) != 1 || pp[0].ID != p.ID {
+		t.Errorf("Expected post %d under tag go. Got %s", p.ID, resp.Body.String())
+	}
+	//merge requires moderator
+	rBody = []byte(`{"into":"go"}`)
+	request, _ = http.NewRequest(http.MethodPost, "/tags/http-server/merge", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusForbidden, resp.Code)
+	//the slug in the path is normalized like tags of posts
+	setTestUserRole(models.RoleModerator)
+	defer setTestUserRole(models.RoleUser)
+	rBody = []byte(`{"slug":"golang"}`)
+	request, _ = http.NewRequest(http.MethodPut, "/tags/Go", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	rBody = []byte(`{"into":"golang"}`)
+	request, _ = http.NewRequest(http.MethodPost, "/tags/HTTP-Server/merge", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+}
+func TestVotes(t *testing.T) {
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM votes")
+	addPosts(2)
+	rBody := []byte(`{"value":1}`)
+	request, _ := http.NewRequest(http.MethodPut, "/posts/2/vote", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	//repeated vote does not count twice
+	request, _ = http.NewRequest(http.MethodPut, "/posts/2/vote", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var m map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &m)
+	if m["score"] != 1.0 {
+		t.Errorf("Expected score 1. Got %v", m["score"])
+	}
+	//sort by score
+	request, _ = http.NewRequest(http.MethodGet, "/posts?sort=score", nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var pp []models.Post
+	json.Unmarshal(resp.Body.Bytes(), &pp)
+	if len(pp) != 2 || pp[0].ID != 2 || pp[0].Score != 1 {
+		t.Errorf("Expected post 2 with score 1 first. Got %s", resp.Body.String())
+	}
+	//retract
+	request, _ = http.NewRequest(http.MethodDelete, "/posts/2/vote", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &m)
+	if m["score"] != 0.0 {
+		t.Errorf("Expected score 0 after retracting. Got %v", m["score"])
+	}
+	//invalid value
+	rBody = []byte(`{"value":5}`)
+	request, _ = http.NewRequest(http.MethodPut, "/posts/2/vote", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+}
+func TestQuestions(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	addPosts(1)
+	rBody := []byte(`{"title":"question","body":"how?","type":"question"}`)
+	request, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	addComments(2, 2)
+	//discussion has no answers
+	rBody = []byte(`{"commentId":1}`)
+	request, _ = http.NewRequest(http.MethodPut, "/posts/1/answer", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	//unanswered
+	request, _ = http.NewRequest(http.MethodGet, "/posts?answered=false", nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var pp []models.Post
+	json.Unmarshal(resp.Body.Bytes(), &pp)
+	if len(pp) != 1 || pp[0].ID != 2 {
+		t.Errorf("Expected one unanswered question. Got %s", resp.Body.String())
+	}
+	//accept
+	rBody = []byte(`{"commentId":2}`)
+	request, _ = http.NewRequest(http.MethodPut, "/posts/2/answer", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	request, _ = http.NewRequest(http.MethodGet, "/posts?answered=true", nil)
+	resp = execRequest(request)
+	json.Unmarshal(resp.Body.Bytes(), &pp)
+	if len(pp) != 1 || pp[0].AcceptedID == nil || *pp[0].AcceptedID != 2 {
+		t.Errorf("Expected one answered question. Got %s", resp.Body.String())
+	}
+	//accepted answer first
+	request, _ = http.NewRequest(http.MethodGet, "/posts/2/comments", nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var cc []models.Comment
+	json.Unmarshal(resp.Body.Bytes(), &cc)
+	if len(cc) != 2 || cc[0].ID != 2 || !cc[0].Accepted {
+		t.Errorf("Expected accepted answer first. Got %s", resp.Body.String())
+	}
+	//deleting the answer clears it
+	request, _ = http.NewRequest(http.MethodDelete, "/comments/2", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	request, _ = http.NewRequest(http.MethodGet, "/posts?answered=false", nil)
+	resp = execRequest(request)
+	pp = nil
+	json.Unmarshal(resp.Body.Bytes(), &pp)
+	if len(pp) != 1 || pp[0].AcceptedID != nil {
+		t.Errorf("Expected question to be unanswered again. Got %s", resp.Body.String())
+	}
+}
+func TestReactions(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM reactions")
+	addPosts(1)
+	addComments(1, 1)
+	rBody := []byte(`{"emoji":"thanks"}`)
+	for i := 0; i < 2; i++ {
+		request, _ := http.NewRequest(http.MethodPost, "/posts/1/reactions", bytes.NewBuffer(rBody))
+		request.Header.Add("APIKey", "test")
+		resp := execRequest(request)
+		checkRespCode(t, http.StatusOK, resp.Code)
+	}
+	request, _ := http.NewRequest(http.MethodGet, "/posts/1", nil)
+	resp := execRequest(request)
+	var p models.Post
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	if len(p.Reactions) != 1 || p.Reactions[0].Emoji != "thanks" || p.Reactions[0].Count != 1 {
+		t.Errorf("Expected one thanks reaction. Got %s", resp.Body.String())
+	}
+	//not allowed reaction
+	rBody = []byte(`{"emoji":"unknown"}`)
+	request, _ = http.NewRequest(http.MethodPost, "/comments/1/reactions", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	//xml
+	request, _ = http.NewRequest(http.MethodGet, "/posts/1?xml", nil)
+	resp = execRequest(request)
+	p = models.Post{}
+	xml.Unmarshal(resp.Body.Bytes(), &p)
+	if len(p.Reactions) != 1 || p.Reactions[0].Count != 1 {
+		t.Errorf("Expected reactions in XML. Got %s", resp.Body.String())
+	}
+	//remove
+	request, _ = http.NewRequest(http.MethodDelete, "/posts/1/reactions?emoji=thanks", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var rc []models.ReactionCount
+	json.Unmarshal(resp.Body.Bytes(), &rc)
+	if rc == nil || len(rc) != 0 {
+		t.Errorf("Expected no reactions. Got %s", resp.Body.String())
+	}
+}
+func TestMarkdown(t *testing.T) {
+	clearTablePosts()
+	rBody := []byte(`{"title":"title","body":"**bold** <script>alert(1)</script>"}`)
+	request, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	request, _ = http.NewRequest(http.MethodGet, "/posts/1", nil)
+	resp = execRequest(request)
+	var p models.Post
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	if p.BodyHTML != "<p><strong>bold</strong> alert(1)</p>\n" {
+		t.Errorf("Expected sanitized bodyHtml. Got %q", p.BodyHTML)
+	}
+	//edit invalidates rendered body
+	rBody = []byte(`{"id":1,"body":"*new*"}`)
+	request, _ = http.NewRequest(http.MethodPut, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	request, _ = http.NewRequest(http.MethodGet, "/posts/1", nil)
+	resp = execRequest(request)
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	if p.BodyHTML != "<p><em>new</em></p>\n" {
+		t.Errorf("Expected re-rendered bodyHtml. Got %q", p.BodyHTML)
+	}
+	//preview
+	rBody = []byte("{\"body\":\"```go\\nfunc main() {}\\n```\"}")
+	request, _ = http.NewRequest(http.MethodPost, "/render/markdown", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var m map[string]string
+	json.Unmarshal(resp.Body.Bytes(), &m)
+	if !bytes.Contains([]byte(m["bodyHtml"]), []byte(`<span class="kd">func</span>`)) {
+		t.Errorf("Expected highlighted code. Got %s", resp.Body.String())
+	}
+}
+func TestBodyLength(t *testing.T) {
+	clearTablePosts()
+	long := strings.Repeat("я", 3000)
+	rBody, _ := json.Marshal(map[string]string{"title": "title", "body": long})
+	request, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	request, _ = http.NewRequest(http.MethodGet, "/posts/1", nil)
+	resp = execRequest(request)
+	var p models.Post
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	if p.Body != long {
+		t.Errorf("Expected long body to be stored unchanged. Got %d characters", len([]rune(p.Body)))
+	}
+	//over-length title
+	rBody, _ = json.Marshal(map[string]string{"title": strings.Repeat("t", a.Config.PostTitleMaxLength+1), "body": "body"})
+	request, _ = http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	var m struct {
+		Fields map[string]string
+	}
+	json.Unmarshal(resp.Body.Bytes(), &m)
+	if _, ok := m.Fields["title"]; !ok || len(m.Fields) != 1 {
+		t.Errorf("Expected field error for title. Got %s", resp.Body.String())
+	}
+}
+func uploadAttachment(path, name string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", name)
+	fw.Write(content)
+	mw.Close()
+	request, _ := http.NewRequest(http.MethodPost, path, &body)
+	request.Header.Add("APIKey", "test")
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+	return execRequest(request)
+}
+func TestAttachments(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM attachments")
+	addPosts(1)
+	addComments(1, 1)
+	content := []byte("panic: runtime error: index out of range")
+	resp := uploadAttachment("/posts/1/attachments", "../../panic.log", content)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var at models.Attachment
+	json.Unmarshal(resp.Body.Bytes(), &at)
+	if at.Name != "panic.log" || at.Size != int64(len(content)) || at.URL != "/attachments/1" {
+		t.Errorf("Unexpected attachment. Got %s", resp.Body.String())
+	}
+	//same content is stored once
+	resp = uploadAttachment("/comments/1/attachments", "copy.log", content)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var at2 models.Attachment
+	json.Unmarshal(resp.Body.Bytes(), &at2)
+	if at2.Hash != at.Hash {
+		t.Errorf("Expected equal hashes. Got %s and %s", at.Hash, at2.Hash)
+	}
+	//not allowed type
+	resp = uploadAttachment("/posts/1/attachments", "app.exe", []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00"))
+	checkRespCode(t, http.StatusUnsupportedMediaType, resp.Code)
+	//embedded in post
+	request, _ := http.NewRequest(http.MethodGet, "/posts/1", nil)
+	resp = execRequest(request)
+	var p models.Post
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	if len(p.Attachments) != 1 || p.Attachments[0].ID != at.ID {
+		t.Errorf("Expected one attachment in post. Got %s", resp.Body.String())
+	}
+	//download requires authentication
+	request, _ = http.NewRequest(http.MethodGet, at.URL, nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusNetworkAuthenticationRequired, resp.Code)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !bytes.Equal(resp.Body.Bytes(), content) || !strings.Contains(resp.Header().Get("Content-Disposition"), "panic.log") {
+		t.Errorf("Unexpected download %q %v", resp.Body.String(), resp.Header())
+	}
+	if resp.Header().Get("Content-Length") != strconv.Itoa(len(content)) {
+		t.Errorf("Expected Content-Length %d. Got %v", len(content), resp.Header())
+	}
+	request.Header.Set("If-None-Match", resp.Header().Get("ETag"))
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusNotModified, resp.Code)
+	if resp.Header().Get("Content-Disposition") != "" || resp.Body.Len() != 0 {
+		t.Errorf("Expected bare 304. Got %v", resp.Header())
+	}
+	//blob is kept while referenced
+	for i, id := range []int{at.ID, at2.ID} {
+		request, _ = http.NewRequest(http.MethodDelete, "/attachments/"+strconv.Itoa(id), nil)
+		request.Header.Add("APIKey", "test")
+		resp = execRequest(request)
+		checkRespCode(t, http.StatusOK, resp.Code)
+		exists, _ := a.Storage.Exists(context.Background(), models.BlobKey(at.Hash))
+		if exists != (i == 0) {
+			t.Errorf("Expected blob exists %v after deleting attachment %d", i == 0, id)
+		}
+	}
+}
+func TestImageAttachments(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM attachments")
+	addPosts(1)
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 600, 400)))
+	resp := uploadAttachment("/posts/1/attachments", "photo.png", img.Bytes())
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var at models.Attachment
+	json.Unmarshal(resp.Body.Bytes(), &at)
+	if at.Status != models.AttachmentProcessing {
+		t.Errorf("Expected status processing. Got %s", at.Status)
+	}
+	//wait for the workers
+	deadline := time.Now().Add(10 * time.Second)
+	for at.Status == models.AttachmentProcessing && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		a.DB.First(&at, at.ID)
+	}
+	if at.Status != models.AttachmentReady || len(at.Thumbnails) != 2 || !at.HasThumbnail(160) || !at.HasThumbnail(480) {
+		t.Fatalf("Expected ready image with thumbnails 160 and 480. Got %+v", at)
+	}
+	request, _ := http.NewRequest(http.MethodGet, "/attachments/"+strconv.Itoa(at.ID)+"/thumbnails/160", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	cfg, err := png.DecodeConfig(resp.Body)
+	if err != nil || cfg.Width != 160 || cfg.Height != 106 {
+		t.Errorf("Expected 160x106 thumbnail. Got %dx%d %v", cfg.Width, cfg.Height, err)
+	}
+	//no thumbnail of a size larger than the image
+	request, _ = http.NewRequest(http.MethodGet, "/attachments/"+strconv.Itoa(at.ID)+"/thumbnails/1024", nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusNotFound, resp.Code)
+	//image dimensions are limited
+	img.Reset()
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, a.Config.ImageMaxWidth+1, 1)))
+	resp = uploadAttachment("/posts/1/attachments", "wide.png", img.Bytes())
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	//EXIF with the GPS position is stripped
+	img.Reset()
+	jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 100, 100)), nil)
+	photo := withGPS(img.Bytes(), "SECRETGPS")
+	if !bytes.Contains(photo, []byte("SECRETGPS")) {
+		t.Fatal("Expected GPS data in the upload")
+	}
+	resp = uploadAttachment("/posts/1/attachments", "gps.jpg", photo)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	json.Unmarshal(resp.Body.Bytes(), &at)
+	deadline = time.Now().Add(10 * time.Second)
+	for at.Status == models.AttachmentProcessing && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		a.DB.First(&at, at.ID)
+	}
+	request, _ = http.NewRequest(http.MethodGet, "/attachments/"+strconv.Itoa(at.ID), nil)
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if stored := resp.Body.Bytes(); bytes.Contains(stored, []byte("Exif")) || bytes.Contains(stored, []byte("SECRETGPS")) {
+		t.Errorf("Expected stored image without EXIF")
+	}
+	if _, err := jpeg.Decode(resp.Body); err != nil {
+		t.Errorf("Expected JPEG image. Got %v", err)
+	}
+}
+
+// withGPS inserts an EXIF segment into a JPEG file whose GPS IFD holds the
+// map datum datum.
+func withGPS(data []byte, datum string) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	for _, v := range []interface{}{
+		uint32(8),                                                              //IFD0
+		uint16(1), uint16(0x8825), uint16(4), uint32(1), uint32(26), uint32(0), //GPSInfo pointer
+		uint16(1), uint16(0x0012), uint16(2), uint32(len(datum) + 1), uint32(44), uint32(0), //GPSMapDatum
+	} {
+		binary.Write(&tiff, binary.BigEndian, v)
+	}
+	tiff.WriteString(datum + "\x00")
+	var seg bytes.Buffer
+	seg.Write([]byte{0xFF, 0xE1})
+	binary.Write(&seg, binary.BigEndian, uint16(2+6+tiff.Len()))
+	seg.WriteString("Exif\x00\x00")
+	seg.Write(tiff.Bytes())
+	return append(append(append([]byte{}, data[:2]...), seg.Bytes()...), data[2:]...)
+}
+
+func TestMentions(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM notifications")
+	u := models.User{Login: "mentioned", Name: "mentioned", Provider: "test"}
+	a.DB.Where(models.User{Login: u.Login}).FirstOrCreate(&u)
+	rBody := []byte(`{"title":"title","body":"ping @mentioned, @test and @nobody; ` + "`@code`" + `"}`)
+	request, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp := execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var p models.Post
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	request, _ = http.NewRequest(http.MethodGet, "/users/"+strconv.Itoa(u.ID)+"/mentions", nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var mm []models.Mention
+	json.Unmarshal(resp.Body.Bytes(), &mm)
+	if len(mm) != 1 || mm[0].PostID != p.ID || mm[0].CommentID != nil || mm[0].AuthorID != 1 {
+		t.Errorf("Expected one mention in post %d. Got %s", p.ID, resp.Body.String())
+	}
+	//author does not mention themselves
+	request, _ = http.NewRequest(http.MethodGet, "/users/1/mentions", nil)
+	resp = execRequest(request)
+	if strings.TrimSpace(resp.Body.String()) != "[]" {
+		t.Errorf("Expected no mentions of the author. Got %s", resp.Body.String())
+	}
+	//the same mention in an edit is not notified again
+	rBody = []byte(`{"id":` + strconv.Itoa(p.ID) + `,"body":"@mentioned, again"}`)
+	request, _ = http.NewRequest(http.MethodPut, "/posts", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	//comments
+	rBody = []byte(`{"postId":` + strconv.Itoa(p.ID) + `,"name":"name","email":"test@example.com","body":"cc @MENTIONED"}`)
+	request, _ = http.NewRequest(http.MethodPost, "/comments", bytes.NewBuffer(rBody))
+	request.Header.Add("APIKey", "test")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	nn := waitNotifications(u.ID, 2)
+	if len(nn) != 2 || nn[0].Type != models.NotificationMention || nn[0].CommentID != nil || nn[1].CommentID == nil || nn[1].ActorID != 1 {
+		t.Errorf("Expected mention notifications for the post and the comment. Got %+v", nn)
+	}
+	a.DB.Delete(&u)
+}
+
+// waitNotifications polls until user userID has count notifications, which
+// are created in the background, and returns them oldest first.
+func waitNotifications(userID, count int) []models.Notification {
+	var nn []models.Notification
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		nn = nil
+		a.DB.Where("userId = ?", userID).Order("id").Find(&nn)
+		if len(nn) >= count || time.Now().After(deadline) {
+			return nn
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+func TestNotifications(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM notifications")
+	other := models.User{Login: "other", Name: "other", Provider: "test", APIKey: authorization.CalculateSignature("other", a.Config.HASHKey)}
+	a.DB.Where(models.User{Login: other.Login}).Assign(models.User{APIKey: other.APIKey}).FirstOrCreate(&other)
+	send := func(method, path, key, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		request.Header.Add("APIKey", key)
+		return execRequest(request)
+	}
+	resp := send(http.MethodPost, "/posts", "test", `{"title":"how?","body":"body","type":"question"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	//comment on my post
+	resp = send(http.MethodPost, "/comments", "other", `{"postId":1,"name":"name","email":"other@example.com","body":"answer"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	resp = send(http.MethodPost, "/comments", "test", `{"postId":1,"parentId":1,"name":"name","email":"test@example.com","body":"thanks"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	//reply to my comment, once although the post is mine too
+	resp = send(http.MethodPost, "/comments", "other", `{"postId":1,"parentId":2,"name":"name","email":"other@example.com","body":"welcome"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	nn := waitNotifications(1, 2)
+	if len(nn) != 2 || nn[0].Type != models.NotificationComment || nn[1].Type != models.NotificationReply || *nn[1].CommentID != 3 {
+		t.Errorf("Expected comment and reply notifications. Got %+v", nn)
+	}
+	//accepted answer
+	resp = send(http.MethodPut, "/posts/1/answer", "test", `{"commentId":1}`)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	nn = waitNotifications(other.ID, 2)
+	if len(nn) != 2 || nn[0].Type != models.NotificationReply || nn[1].Type != models.NotificationAnswer || nn[1].ActorID != 1 {
+		t.Errorf("Expected reply and answer notifications. Got %+v", nn)
+	}
+	//inbox
+	resp = send(http.MethodGet, "/notifications", "test", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var inbox models.Notifications
+	json.Unmarshal(resp.Body.Bytes(), &inbox)
+	if inbox.Unread != 2 || len(inbox.Notifications) != 2 || inbox.Notifications[0].Type != models.NotificationReply {
+		t.Errorf("Expected 2 unread notifications, newest first. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPost, "/notifications/"+strconv.Itoa(inbox.Notifications[0].ID)+"/read", "test", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	resp = send(http.MethodGet, "/notifications/count", "test", "")
+	if strings.Join(strings.Fields(resp.Body.String()), "") != `{"unread":1}` {
+		t.Errorf("Expected 1 unread notification. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodGet, "/notifications?unread", "test", "")
+	inbox = models.Notifications{}
+	json.Unmarshal(resp.Body.Bytes(), &inbox)
+	if len(inbox.Notifications) != 1 || inbox.Notifications[0].Type != models.NotificationComment {
+		t.Errorf("Expected only the unread notification. Got %s", resp.Body.String())
+	}
+	//notifications of other users are not found
+	resp = send(http.MethodPost, "/notifications/"+strconv.Itoa(nn[0].ID)+"/read", "test", "")
+	checkRespCode(t, http.StatusNotFound, resp.Code)
+	resp = send(http.MethodPost, "/notifications/read", "test", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	resp = send(http.MethodGet, "/notifications/count", "test", "")
+	if strings.Join(strings.Fields(resp.Body.String()), "") != `{"unread":0}` {
+		t.Errorf("Expected no unread notifications. Got %s", resp.Body.String())
+	}
+	//inbox requires authentication
+	request, _ := http.NewRequest(http.MethodGet, "/notifications", nil)
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusNetworkAuthenticationRequired, resp.Code)
+	a.DB.Delete(&other)
+}
+
+// readEvent reads the next Server-Sent Event, skipping comments.
+func readEvent(br *bufio.Reader) (id, typ, data string, err error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return id, typ, data, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && typ != "":
+			return id, typ, data, nil
+		case strings.HasPrefix(line, "id: "):
+			id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			typ = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			data = line[6:]
+		}
+	}
+}
+func TestStream(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	addPosts(2)
+	srv := httptest.NewServer(a.Router)
+	defer srv.Close()
+	client := http.Client{Timeout: 5 * time.Second}
+	subscribe := func(lastID string) (*http.Response, *bufio.Reader) {
+		request, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream?postId=1", nil)
+		request.Header.Add("APIKey", "test")
+		if lastID != "" {
+			request.Header.Add("Last-Event-ID", lastID)
+		}
+		resp, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected event stream. Got %s", resp.Header.Get("Content-Type"))
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+	comment := func(postID int) {
+		rBody := []byte(`{"postId":` + strconv.Itoa(postID) + `,"name":"name","email":"test@example.com","body":"body"}`)
+		request, _ := http.NewRequest(http.MethodPost, "/comments", bytes.NewBuffer(rBody))
+		request.Header.Add("APIKey", "test")
+		checkRespCode(t, http.StatusCreated, execRequest(request).Code)
+	}
+	resp, br := subscribe("")
+	comment(2) //other post
+	comment(1)
+	id, typ, data, err := readEvent(br)
+	var e map[string]interface{}
+	json.Unmarshal([]byte(data), &e)
+	if err != nil || typ != "comment.created" || e["postId"] != float64(1) || e["commentId"] != float64(2) {
+		t.Fatalf("Expected comment.created of post 1. Got %q %q %v", typ, data, err)
+	}
+	resp.Body.Close()
+	//resume after the last received event
+	comment(1)
+	resp, br = subscribe(id)
+	defer resp.Body.Close()
+	_, typ, data, err = readEvent(br)
+	e = nil
+	json.Unmarshal([]byte(data), &e)
+	if err != nil || typ != "comment.created" || e["commentId"] != float64(3) {
+		t.Errorf("Expected missed comment.created. Got %q %q %v", typ, data, err)
+	}
+	//unknown event IDs ask the client to reload
+	resp2, br2 := subscribe("unknown-1")
+	defer resp2.Body.Close()
+	if _, typ, _, err = readEvent(br2); typ != "reset" {
+		t.Errorf("Expected reset. Got %q %v", typ, err)
+	}
+}
+
+// waitDelivery polls until delivery id reaches status, or gives up after 5
+// seconds, and returns it.
+func waitDelivery(id int, status string) models.WebhookDelivery {
+	var d models.WebhookDelivery
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.DB.First(&d, id)
+		if d.Status == status || time.Now().After(deadline) {
+			return d
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+func TestWebhooks(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM webhooks")
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 10)
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- received{r.Header, body}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		request.Header.Add("APIKey", "test")
+		return execRequest(request)
+	}
+	//only admins manage webhooks
+	setTestUserRole(models.RoleUser)
+	resp := send(http.MethodPost, "/webhooks", `{"url":"`+receiver.URL+`"}`)
+	checkRespCode(t, http.StatusForbidden, resp.Code)
+	setTestUserRole(models.RoleAdmin)
+	defer setTestUserRole(models.RoleUser)
+	resp = send(http.MethodPost, "/webhooks", `{"url":"ftp://example.com"}`)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	resp = send(http.MethodPost, "/webhooks", `{"url":"`+receiver.URL+`","events":["post.deleted"],"secret":"s3cret"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	resp = send(http.MethodPost, "/webhooks", `{"url":"`+receiver.URL+`","events":["post.created"]}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var wh models.Webhook
+	json.Unmarshal(resp.Body.Bytes(), &wh)
+	if wh.Secret == "" || len(wh.Events) != 1 || !wh.Active {
+		t.Fatalf("Expected active webhook with generated secret. Got %s", resp.Body.String())
+	}
+	//secrets are shown only on creation
+	resp = send(http.MethodGet, "/webhooks", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if strings.Contains(resp.Body.String(), wh.Secret) || strings.Contains(resp.Body.String(), "s3cret") {
+		t.Errorf("Expected no secrets in the list. Got %s", resp.Body.String())
+	}
+	//failed delivery is retried
+	resp = send(http.MethodPost, "/posts", `{"title":"hooked","body":"body"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var got received
+	select {
+	case got = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected delivery")
+	}
+	deliveryID, _ := strconv.Atoi(got.header.Get("X-Forum-Delivery"))
+	if got.header.Get("X-Forum-Event") != "post.created" || got.header.Get(webhooks.SignatureHeader) != webhooks.Sign(wh.Secret, got.body) {
+		t.Errorf("Expected signed post.created. Got %v", got.header)
+	}
+	var payload webhooks.Payload
+	json.Unmarshal(got.body, &payload)
+	if payload.Event != "post.created" || payload.Post == nil || payload.Post.Title != "hooked" {
+		t.Errorf("Expected created post in payload. Got %s", got.body)
+	}
+	d := waitDelivery(deliveryID, models.DeliveryPending)
+	if d.Attempts != 1 || d.ResponseCode != http.StatusInternalServerError || d.NextAttemptAt == nil {
+		t.Fatalf("Expected retry to be scheduled. Got %+v", d)
+	}
+	a.DB.Model(&d).Update("nextAttemptAt", time.Now())
+	a.Webhooks.Wake()
+	select {
+	case got = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected retry")
+	}
+	d = waitDelivery(deliveryID, models.DeliverySucceeded)
+	if d.Attempts != 2 || d.DeliveredAt == nil {
+		t.Errorf("Expected delivery to succeed on retry. Got %+v", d)
+	}
+	//delivery log and redelivery
+	resp = send(http.MethodGet, "/webhooks/"+strconv.Itoa(wh.ID)+"/deliveries", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var dd []models.WebhookDelivery
+	json.Unmarshal(resp.Body.Bytes(), &dd)
+	if len(dd) != 1 || dd[0].Status != models.DeliverySucceeded {
+		t.Errorf("Expected one succeeded delivery. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPost, "/webhooks/"+strconv.Itoa(wh.ID)+"/deliveries/"+strconv.Itoa(deliveryID)+"/redeliver", "")
+	checkRespCode(t, http.StatusAccepted, resp.Code)
+	select {
+	case got = <-requests:
+		if got.header.Get("X-Forum-Delivery") == strconv.Itoa(deliveryID) || got.header.Get("X-Forum-Event") != "post.created" {
+			t.Errorf("Expected post.created as new delivery. Got %v", got.header)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected redelivery")
+	}
+	//inactive webhooks get nothing
+	resp = send(http.MethodPut, "/webhooks/"+strconv.Itoa(wh.ID), `{"active":false}`)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	resp = send(http.MethodPost, "/posts", `{"title":"quiet","body":"body"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	select {
+	case got = <-requests:
+		t.Errorf("Expected no delivery. Got %v", got.header)
+	case <-time.After(300 * time.Millisecond):
+	}
+	//queued deliveries of a deactivated webhook are cancelled
+	resp = send(http.MethodPost, "/webhooks/"+strconv.Itoa(wh.ID)+"/deliveries/"+strconv.Itoa(deliveryID)+"/redeliver", "")
+	checkRespCode(t, http.StatusAccepted, resp.Code)
+	var queued models.WebhookDelivery
+	json.Unmarshal(resp.Body.Bytes(), &queued)
+	if d = waitDelivery(queued.ID, models.DeliveryCancelled); d.Status != models.DeliveryCancelled || d.Attempts != 0 {
+		t.Errorf("Expected cancelled delivery. Got %+v", d)
+	}
+	select {
+	case got = <-requests:
+		t.Errorf("Expected no delivery. Got %v", got.header)
+	default:
+	}
+	resp = send(http.MethodDelete, "/webhooks/"+strconv.Itoa(wh.ID), "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	a.DB.Exec("DELETE FROM webhooks")
+}
+
+// smtpSink is an SMTP server collecting the messages it is sent. It rejects
+// the first reject senders with a temporary error.
+type smtpSink struct {
+	ln       net.Listener
+	messages chan *mail.Message
+	reject   int32
+}
+
+func newSMTPSink() *smtpSink {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &smtpSink{ln: ln, messages: make(chan *mail.Message, 10)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(textproto.NewConn(c))
+		}
+	}()
+	return s
+}
+func (s *smtpSink) serve(c *textproto.Conn) {
+	defer c.Close()
+	c.PrintfLine("220 localhost ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil || line == "" {
+			return
+		}
+		switch strings.ToUpper(strings.Fields(line)[0]) {
+		case "EHLO", "HELO":
+			c.PrintfLine("250 localhost")
+		case "MAIL":
+			if atomic.AddInt32(&s.reject, -1) >= 0 {
+				c.PrintfLine("451 try again later")
+			} else {
+				c.PrintfLine("250 ok")
+			}
+		case "DATA":
+			c.PrintfLine("354 go ahead")
+			data, _ := c.ReadDotBytes()
+			if msg, err := mail.ReadMessage(bytes.NewReader(data)); err == nil {
+				s.messages <- msg
+			}
+			c.PrintfLine("250 ok")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("250 ok")
+		}
+	}
+}
+func TestEmailNotifications(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Exec("DELETE FROM email_messages")
+	a.DB.Exec("DELETE FROM notifications")
+	other := models.User{Login: "other", Name: "other", Provider: "test", APIKey: authorization.CalculateSignature("other", a.Config.HASHKey)}
+	a.DB.Where(models.User{Login: other.Login}).Assign(models.User{APIKey: other.APIKey}).FirstOrCreate(&other)
+	defer a.DB.Delete(&other)
+	send := func(method, path, key, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		request.Header.Add("APIKey", key)
+		return execRequest(request)
+	}
+	sink := newSMTPSink()
+	defer sink.ln.Close()
+	host, port, _ := net.SplitHostPort(sink.ln.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	m := mailer.New(a.DB, mailer.Config{Host: host, Port: portNum, From: "Forum <forum@example.com>", TLS: "none",
+		BaseURL: "http://forum.test", Key: a.Config.HASHKey, MaxAttempts: 3, Backoff: time.Hour,
+		DigestHour: 24}) //never reached, digests are sent by the test
+	defer m.Close()
+	a.Events.Listen(m.Notify)
+	receive := func() *mail.Message {
+		select {
+		case msg := <-sink.messages:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected email")
+		}
+		return nil
+	}
+	subject := func(msg *mail.Message) string {
+		s, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		return s
+	}
+	//settings
+	resp := send(http.MethodPut, "/notifications/email", "test", `{"email":"not an address","emailNotifications":"weekly"}`)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	resp = send(http.MethodPut, "/notifications/email", "test", `{"email":"test@example.com","emailNotifications":"immediate"}`)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	defer a.DB.Exec("UPDATE users SET email = '', emailVerified = false, emailNotifications = ?, digestSentAt = NULL WHERE login = ?", models.EmailOff, "test")
+	if !strings.Contains(resp.Body.String(), `"emailVerified": false`) {
+		t.Errorf("Expected unverified address. Got %s", resp.Body.String())
+	}
+	//nothing is mailed before the address is confirmed
+	var tu models.User
+	tu.GetUser(a.DB, map[string]interface{}{"login": "test"})
+	resp = send(http.MethodGet, "/email/verify?token="+mailer.VerifyToken(a.Config.HASHKey, tu.ID, "someone@example.com"), "", "")
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	resp = send(http.MethodGet, "/email/verify?token="+mailer.VerifyToken(a.Config.HASHKey, tu.ID, "test@example.com"), "", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	resp = send(http.MethodGet, "/notifications/email", "test", "")
+	if !strings.Contains(resp.Body.String(), `"emailNotifications": "immediate"`) || !strings.Contains(resp.Body.String(), `"emailVerified": true`) {
+		t.Errorf("Expected immediate emails. Got %s", resp.Body.String())
+	}
+	//immediate email, sent again after a temporary failure
+	atomic.StoreInt32(&sink.reject, 1)
+	resp = send(http.MethodPost, "/posts", "test", `{"title":"mailed","body":"body"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	resp = send(http.MethodPost, "/comments", "other", `{"postId":1,"name":"name","email":"other@example.com","body":"hi"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var queued models.EmailMessage
+	deadline := time.Now().Add(5 * time.Second)
+	for queued.Attempts == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		a.DB.Where("userId = ?", 1).First(&queued)
+	}
+	if queued.Status != models.EmailPending || queued.Attempts != 1 || queued.Error == "" {
+		t.Fatalf("Expected failed attempt to be retried. Got %+v", queued)
+	}
+	a.DB.Model(&queued).Update("nextAttemptAt", time.Now())
+	m.Wake()
+	msg := receive()
+	if msg.Header.Get("To") != "test@example.com" || subject(msg) != "other commented on your post “mailed”" {
+		t.Errorf("Expected comment notification. Got %v %q", msg.Header, subject(msg))
+	}
+	unsubscribe := strings.Trim(msg.Header.Get("List-Unsubscribe"), "<>")
+	if !strings.HasPrefix(unsubscribe, "http://forum.test/unsubscribe?token=") || msg.Header.Get("List-Unsubscribe-Post") == "" {
+		t.Errorf("Expected one-click unsubscribe. Got %v", msg.Header)
+	}
+	body, _ := ioutil.ReadAll(msg.Body)
+	if !strings.Contains(string(body), "text/html") || !strings.Contains(string(body), "text/plain") {
+		t.Errorf("Expected HTML and text bodies. Got %s", body)
+	}
+	//digest
+	resp = send(http.MethodPut, "/notifications/email", "test", `{"emailNotifications":"digest"}`)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	resp = send(http.MethodPost, "/comments", "other", `{"postId":1,"name":"name","email":"other@example.com","body":"again"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	waitNotifications(1, 2)
+	select {
+	case msg = <-sink.messages:
+		t.Errorf("Expected no immediate email. Got %q", subject(msg))
+	case <-time.After(300 * time.Millisecond):
+	}
+	if n, err := m.SendDigests(time.Now()); n != 1 || err != nil {
+		t.Fatalf("Expected 1 digest. Got %d %v", n, err)
+	}
+	msg = receive()
+	if subject(msg) != "2 new notifications" {
+		t.Errorf("Expected digest of 2 notifications. Got %q", subject(msg))
+	}
+	if n, _ := m.SendDigests(time.Now()); n != 0 {
+		t.Errorf("Expected digest once a day. Got %d", n)
+	}
+	//unsubscribe
+	resp = send(http.MethodPost, "/unsubscribe?token=1.forged", "", "")
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	//opening the link only asks for confirmation
+	resp = send(http.MethodGet, strings.TrimPrefix(unsubscribe, "http://forum.test"), "", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var u models.User
+	u.GetUser(a.DB, map[string]interface{}{"login": "test"})
+	if u.EmailNotifications == models.EmailOff || !strings.Contains(resp.Body.String(), `method="post"`) {
+		t.Errorf("Expected confirmation form. Got %s %s", u.EmailNotifications, resp.Body.String())
+	}
+	resp = send(http.MethodPost, strings.TrimPrefix(unsubscribe, "http://forum.test"), "", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	u.GetUser(a.DB, map[string]interface{}{"login": "test"})
+	if u.EmailNotifications != models.EmailOff {
+		t.Errorf("Expected emails off. Got %s", u.EmailNotifications)
+	}
+	a.DB.Exec("DELETE FROM email_messages")
+	a.DB.Exec("DELETE FROM notifications")
+}
+
+func TestFeeds(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	addPosts(2)
+	addComments(3, 1)
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			request.Header.Add(header[i], header[i+1])
+		}
+		return execRequest(request)
+	}
+	var atom struct {
+		Updated string `xml:"updated"`
+		Entries []struct {
+			ID      string `xml:"id"`
+			Title   string `xml:"title"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	resp := get("/feeds/posts.atom")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.HasPrefix(resp.Header().Get("Content-Type"), "application/atom+xml") {
+		t.Errorf("Expected Atom. Got %s", resp.Header().Get("Content-Type"))
+	}
+	if err := xml.Unmarshal(resp.Body.Bytes(), &atom); err != nil || len(atom.Entries) != 2 || !strings.HasSuffix(atom.Entries[0].ID, "/posts/2") {
+		t.Fatalf("Expected 2 posts, newest first. Got %v %s", err, resp.Body.String())
+	}
+	//conditional GET
+	lastModified := resp.Header().Get("Last-Modified")
+	resp = get("/feeds/posts.atom", "If-Modified-Since", lastModified)
+	checkRespCode(t, http.StatusNotModified, resp.Code)
+	request, _ := http.NewRequest(http.MethodPut, "/posts", bytes.NewBufferString(`{"id":1,"title":"edited"}`))
+	request.Header.Add("APIKey", "test")
+	checkRespCode(t, http.StatusOK, execRequest(request).Code)
+	a.DB.Exec("UPDATE posts SET updatedAt = ? WHERE id = 1", time.Now().Add(time.Hour)) //past the second of Last-Modified
+	resp = get("/feeds/posts.atom", "If-Modified-Since", lastModified)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	atom.Entries = nil
+	xml.Unmarshal(resp.Body.Bytes(), &atom)
+	if len(atom.Entries) != 2 || atom.Entries[1].Title != "edited" || atom.Updated != atom.Entries[1].Updated {
+		t.Errorf("Expected feed updated with the edited post. Got %s", resp.Body.String())
+	}
+	//RSS
+	resp = get("/feeds/posts.rss")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var rss struct {
+		Items []struct {
+			Title string `xml:"title"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(resp.Body.Bytes(), &rss); err != nil || len(rss.Items) != 2 {
+		t.Errorf("Expected 2 RSS items. Got %v %s", err, resp.Body.String())
+	}
+	//per user, per category and comments
+	resp = get("/feeds/users/1/posts.atom")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	resp = get("/feeds/users/999/posts.rss")
+	checkRespCode(t, http.StatusNotFound, resp.Code)
+	resp = get("/feeds/categories/999/posts.atom")
+	checkRespCode(t, http.StatusNotFound, resp.Code)
+	resp = get("/feeds/posts/1/comments.atom")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	atom.Entries = nil
+	if err := xml.Unmarshal(resp.Body.Bytes(), &atom); err != nil || len(atom.Entries) != 3 {
+		t.Errorf("Expected 3 comments. Got %v %s", err, resp.Body.String())
+	}
+	resp = get("/feeds/posts/999/comments.atom")
+	checkRespCode(t, http.StatusNotFound, resp.Code)
+	request, _ = http.NewRequest(http.MethodPost, "/feeds/posts.atom", nil)
+	request.Header.Add("APIKey", "test")
+	checkRespCode(t, http.StatusMethodNotAllowed, execRequest(request).Code)
+	//feeds depend on the viewer, and deletions change their validators
+	a.DB.Exec("UPDATE posts SET updatedAt = ? WHERE id = 1", time.Now().Add(-time.Hour))
+	resp = get("/feeds/posts.atom")
+	if resp.Header().Get("Vary") != "Cookie, APIKey" || !strings.HasPrefix(resp.Header().Get("Cache-Control"), "private") {
+		t.Errorf("Expected a private feed. Got %v", resp.Header())
+	}
+	etag := resp.Header().Get("ETag")
+	checkRespCode(t, http.StatusNotModified, get("/feeds/posts.atom", "If-None-Match", etag).Code)
+	a.DB.Exec("DELETE FROM posts WHERE id = 2")
+	resp = get("/feeds/posts.atom", "If-None-Match", etag)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if last, err := http.ParseTime(resp.Header().Get("Last-Modified")); err != nil || time.Since(last) > time.Minute {
+		t.Errorf("Expected Last-Modified of the deletion rather than of the remaining post. Got %s", resp.Header().Get("Last-Modified"))
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	addPosts(2)
+	addComments(1, 1)
+	//the test user logged in through the browser
+	a.DB.Exec("UPDATE users SET access_token = ? WHERE login = ?", authorization.CalculateSignature("session", a.Config.HASHKey), "test")
+	csrf := authorization.CalculateSignature("csrf:session", a.Config.HASHKey)
+	send := func(method, path string, form url.Values, session bool) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if session {
+			request.AddCookie(&http.Cookie{Name: "UAAT", Value: "session"})
+		}
+		return execRequest(request)
+	}
+	resp := send(http.MethodGet, "/web/posts", nil, false)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.Contains(resp.Body.String(), `href="/web/posts/2"`) || strings.Contains(resp.Body.String(), "New post") {
+		t.Errorf("Expected post list for a guest. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodGet, "/web/posts?page=0", nil, false).Code)
+	resp = send(http.MethodGet, "/web/posts/1", nil, true)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.Contains(resp.Body.String(), `id="comment-1"`) || !strings.Contains(resp.Body.String(), `name="csrf"`) {
+		t.Errorf("Expected post with comments and comment form. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/web/posts/999", nil, false).Code)
+	//forms need a session and its CSRF token
+	resp = send(http.MethodGet, "/web/posts/new", nil, false)
+	checkRespCode(t, http.StatusSeeOther, resp.Code)
+	checkRespCode(t, http.StatusOK, send(http.MethodGet, "/web/posts/new", nil, true).Code)
+	form := url.Values{"title": {"from the web"}, "body": {"hello"}, "tags": {"Go, web"}, "type": {"question"}}
+	checkRespCode(t, http.StatusForbidden, send(http.MethodPost, "/web/posts/new", form, true).Code)
+	form.Set("csrf", csrf)
+	checkRespCode(t, http.StatusSeeOther, send(http.MethodPost, "/web/posts/new", form, false).Code)
+	resp = send(http.MethodPost, "/web/posts/new", form, true)
+	checkRespCode(t, http.StatusSeeOther, resp.Code)
+	if resp.Header().Get("Location") != "/web/posts/3" {
+		t.Fatalf("Expected redirect to the new post. Got %s", resp.Header().Get("Location"))
+	}
+	var p models.Post
+	a.DB.First(&p, 3)
+	if p.Title != "from the web" || p.Type != models.PostTypeQuestion || p.UserID != 1 {
+		t.Errorf("Expected post created by the test user. Got %+v", p)
+	}
+	form.Set("title", "")
+	resp = send(http.MethodPost, "/web/posts/3/edit", form, true)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	if !strings.Contains(resp.Body.String(), "Title and body are required.") {
+		t.Errorf("Expected form with errors. Got %s", resp.Body.String())
+	}
+	form.Set("title", "edited on the web")
+	checkRespCode(t, http.StatusSeeOther, send(http.MethodPost, "/web/posts/3/edit", form, true).Code)
+	a.DB.First(&p, 3)
+	if p.Title != "edited on the web" {
+		t.Errorf("Expected edited title. Got %s", p.Title)
+	}
+	a.DB.Exec("UPDATE posts SET userId = 999 WHERE id = 2")
+	checkRespCode(t, http.StatusForbidden, send(http.MethodGet, "/web/posts/2/edit", nil, true).Code)
+	//comments, without the private address prefilled
+	a.DB.Exec("UPDATE users SET email = ?, showEmail = false WHERE login = ?", "private@example.com", "test")
+	resp = send(http.MethodGet, "/web/posts/3", nil, true)
+	a.DB.Exec("UPDATE users SET email = '' WHERE login = ?", "test")
+	if strings.Contains(resp.Body.String(), "private@example.com") {
+		t.Errorf("Expected the private address not to be prefilled")
+	}
+	comment := url.Values{"name": {"test"}, "email": {"test@example.com"}, "body": {"from the web"}}
+	checkRespCode(t, http.StatusForbidden, send(http.MethodPost, "/web/posts/3/comments", comment, true).Code)
+	comment.Set("csrf", csrf)
+	resp = send(http.MethodPost, "/web/posts/3/comments", comment, true)
+	checkRespCode(t, http.StatusSeeOther, resp.Code)
+	if resp.Header().Get("Location") != "/web/posts/3#comment-2" {
+		t.Errorf("Expected redirect to the comment. Got %s", resp.Header().Get("Location"))
+	}
+	var n int64
+	a.DB.Model(&models.AuditEvent{}).Where("action IN ?", []string{models.AuditPostCreate, models.AuditPostUpdate, models.AuditCommentCreate}).Where("targetId IN ?", []int{2, 3}).Count(&n)
+	if n < 3 {
+		t.Errorf("Expected web submissions in the audit log. Got %d", n)
+	}
+	//profile
+	resp = send(http.MethodGet, "/web/users/1", nil, false)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.Contains(resp.Body.String(), "edited on the web") {
+		t.Errorf("Expected profile with latest posts. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/web/users/999", nil, false).Code)
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/no/such/page", nil, false).Code)
+	checkRespCode(t, http.StatusOK, send(http.MethodGet, "/", nil, true).Code)
+}
+
+func TestViews(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("layouts/main.html", `{{define "layout"}}<main>{{template "content" .}}</main>{{end}}`)
+	write("partials/count.html", `{{define "count"}}{{pluralize . "post" "posts"}}{{end}}`)
+	write("page.html", `{{define "content"}}{{template "count" .}}{{end}}`)
+	write("500.html", `{{define "layout"}}broken{{end}}`)
+	render := func(m *views.Manager, name string, data interface{}) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		m.Render(rr, http.StatusOK, name, data)
+		return rr
+	}
+	m, err := views.New(os.DirFS(dir), false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := render(m, "page", 1); resp.Code != http.StatusOK || resp.Body.String() != "<main>1 post</main>" {
+		t.Errorf("Expected page in layout. Got %d %s", resp.Code, resp.Body.String())
+	}
+	//errors render the 500 page
+	if resp := render(m, "page", "x"); resp.Code != http.StatusInternalServerError || resp.Body.String() != "broken" {
+		t.Errorf("Expected 500 page. Got %d %s", resp.Code, resp.Body.String())
+	}
+	checkRespCode(t, http.StatusInternalServerError, render(m, "missing", nil).Code)
+	//changes show only in dev mode
+	dev, err := views.New(os.DirFS(dir), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("page.html", `{{define "content"}}{{template "count" .}}!{{end}}`)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(filepath.Join(dir, "page.html"), later, later)
+	if resp := render(dev, "page", 2); resp.Body.String() != "<main>2 posts!</main>" {
+		t.Errorf("Expected reloaded page. Got %s", resp.Body.String())
+	}
+	if resp := render(m, "page", 2); resp.Body.String() != "<main>2 posts</main>" {
+		t.Errorf("Expected cached page. Got %s", resp.Body.String())
+	}
+	if _, err = views.New(os.DirFS(t.TempDir()), false, nil); err == nil {
+		t.Error("Expected error without layouts")
+	}
+}
+
+func TestAssets(t *testing.T) {
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			request.Header.Add(header[i], header[i+1])
+		}
+		return execRequest(request)
+	}
+	//pages link hashed URLs of the embedded files
+	hashed := a.Assets.Path("css/highlight.css")
+	if !regexp.MustCompile(`^/public/css/highlight\.[0-9a-f]{10}\.css$`).MatchString(hashed) {
+		t.Fatalf("Expected hashed URL. Got %s", hashed)
+	}
+	if resp := get("/"); !strings.Contains(resp.Body.String(), hashed) {
+		t.Errorf("Expected page linking %s. Got %s", hashed, resp.Body.String())
+	}
+	resp := get(hashed)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if resp.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" || !strings.HasPrefix(resp.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("Expected immutable CSS. Got %v", resp.Header())
+	}
+	resp = get("/public/css/highlight.0123456789.css")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if resp.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Expected an old hash revalidated. Got %s", resp.Header().Get("Cache-Control"))
+	}
+	resp = get("/public/css/highlight.css", "Accept-Encoding", "gzip, br")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if resp.Header().Get("Content-Encoding") != "gzip" || resp.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("Expected gzipped CSS. Got %v", resp.Header())
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := ioutil.ReadAll(zr)
+	if !bytes.Equal(plain, get("/public/css/highlight.css").Body.Bytes()) {
+		t.Error("Expected gzipped content equal to the plain one")
+	}
+	checkRespCode(t, http.StatusNotModified, get(hashed, "If-None-Match", get(hashed).Header().Get("ETag")).Code)
+	checkRespCode(t, http.StatusNotFound, get("/public/css").Code)
+	checkRespCode(t, http.StatusNotFound, get("/public/static.go").Code)
+	checkRespCode(t, http.StatusNotFound, get("/public/css/highlight.css.gz").Code)
+	if _, err := fs.Stat(static.FS, "css/highlight.css.gz"); err != nil {
+		t.Errorf("Expected gzip copy made by go generate. Got %v", err)
+	}
+	//files on disk override the embedded ones, brotli is served from .br files
+	var stale bytes.Buffer
+	zw := gzip.NewWriter(&stale)
+	zw.Write([]byte("pre{}"))
+	zw.Close()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "css", "highlight.css"), []byte("body{}"), 0644)
+	s, err := assets.New(assets.Overlay(dir, fstest.MapFS{
+		"css/highlight.css":    {Data: []byte("pre{}")},
+		"css/highlight.css.gz": {Data: stale.Bytes()},
+		"js/app.js":            {Data: []byte("init()")},
+		"js/app.js.br":         {Data: []byte("brotli")},
+	}), "/public/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(path, encoding string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept-Encoding", encoding)
+		rr := httptest.NewRecorder()
+		s.ServeHTTP(rr, request)
+		return rr
+	}
+	if resp := serve("/public/css/highlight.css", ""); resp.Body.String() != "body{}" {
+		t.Errorf("Expected file from disk. Got %s", resp.Body.String())
+	}
+	if resp := serve("/public/css/highlight.css", "gzip"); resp.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected gzipped CSS. Got %v", resp.Header())
+	} else if zr, err := gzip.NewReader(resp.Body); err != nil {
+		t.Error(err)
+	} else if plain, _ := ioutil.ReadAll(zr); string(plain) != "body{}" {
+		t.Errorf("Expected the stale gzip copy ignored. Got %s", plain)
+	}
+	if resp := serve(s.Path("js/app.js"), "gzip, br"); resp.Body.String() != "brotli" || resp.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("Expected brotli. Got %v %s", resp.Header(), resp.Body.String())
+	}
+	if resp := serve("/public/js/app.js", "br;q=0"); resp.Body.String() != "init()" {
+		t.Errorf("Expected plain file. Got %s", resp.Body.String())
+	}
+}
+
+func TestI18n(t *testing.T) {
+	if got := i18n.N("ru", "%d comment", 22); got != "22 комментария" {
+		t.Errorf("Expected Russian plural. Got %s", got)
+	}
+	if got := i18n.T("ru", "must be at most 100 characters"); got != "должно быть не длиннее 100 символов" {
+		t.Errorf("Expected formatted message translated. Got %s", got)
+	}
+	send := func(method, path, apiKey, lang, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			request.Header.Add("APIKey", apiKey)
+		}
+		request.Header.Set("Accept-Language", lang)
+		return execRequest(request)
+	}
+	errorOf := func(resp *httptest.ResponseRecorder) string {
+		var m map[string]string
+		json.Unmarshal(resp.Body.Bytes(), &m)
+		return m["error"]
+	}
+	//Accept-Language, then the lang parameter
+	resp := send(http.MethodGet, "/posts/999", "", "de, ru-RU;q=0.8, en;q=0.5", "")
+	checkRespCode(t, http.StatusNotFound, resp.Code)
+	if errorOf(resp) != "Не найдено" || resp.Header().Get("Content-Language") != "ru" {
+		t.Errorf("Expected Russian error. Got %s %s", resp.Header().Get("Content-Language"), resp.Body.String())
+	}
+	resp = send(http.MethodGet, "/posts/999?lang=en", "", "ru", "")
+	if errorOf(resp) != "Not Found" {
+		t.Errorf("Expected English error. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPost, "/posts", "", "ru", "{}")
+	checkRespCode(t, http.StatusNetworkAuthenticationRequired, resp.Code)
+	if errorOf(resp) != "Требуется вход в систему" {
+		t.Errorf("Expected Russian error of the middleware. Got %s", resp.Body.String())
+	}
+	//preference of the user
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodPut, "/language", "test", "", `{"language":"xx"}`).Code)
+	checkRespCode(t, http.StatusOK, send(http.MethodPut, "/language", "test", "", `{"language":"ru"}`).Code)
+	defer a.DB.Exec("UPDATE users SET language = '' WHERE login = ?", "test")
+	resp = send(http.MethodGet, "/language", "test", "en", "")
+	if !strings.Contains(resp.Body.String(), `"language": "ru"`) {
+		t.Errorf("Expected preferred language. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPut, "/posts", "test", "en", `{"id":1,"title":"`+strings.Repeat("x", 1000)+`"}`)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	if errorOf(resp) != "некорректные поля" {
+		t.Errorf("Expected Russian field errors. Got %s", resp.Body.String())
+	}
+	//pages and the language switcher
+	resp = send(http.MethodGet, "/web/posts", "", "ru", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.Contains(resp.Body.String(), `<html lang="ru">`) || !strings.Contains(resp.Body.String(), "Посты") {
+		t.Errorf("Expected Russian page. Got %s", resp.Body.String())
+	}
+	request, _ := http.NewRequest(http.MethodPost, "/web/language", strings.NewReader(url.Values{"lang": {"ru"}, "next": {"/web/posts?page=1"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusSeeOther, resp.Code)
+	if resp.Header().Get("Location") != "/web/posts?page=1" || !strings.Contains(resp.Header().Get("Set-Cookie"), "lang=ru") {
+		t.Errorf("Expected language cookie and redirect back. Got %v", resp.Header())
+	}
+	request, _ = http.NewRequest(http.MethodGet, "/web/posts", nil)
+	request.AddCookie(&http.Cookie{Name: "lang", Value: "ru"})
+	if resp = execRequest(request); !strings.Contains(resp.Body.String(), `<html lang="ru">`) {
+		t.Errorf("Expected Russian page from the cookie. Got %s", resp.Body.String())
+	}
+}
+
+func TestUsers(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	addPosts(2)
+	addComments(3, 1)
+	other := models.User{Login: "other", Name: "other", Provider: "test", APIKey: authorization.CalculateSignature("other", a.Config.HASHKey)}
+	a.DB.Where(models.User{Login: other.Login}).Assign(models.User{APIKey: other.APIKey}).FirstOrCreate(&other)
+	defer a.DB.Delete(&other)
+	a.DB.Exec("UPDATE users SET email = ?, access_token = ? WHERE login = ?", "test@example.com", "secret-token", "test")
+	defer a.DB.Exec("UPDATE users SET name = 'test', bio = '', email = '', access_token = '', profileVisibility = ?, showEmail = ?, avatarId = NULL WHERE login = ?",
+		models.ProfilePublic, false, "test")
+	send := func(method, path, apiKey, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			request.Header.Add("APIKey", apiKey)
+		}
+		return execRequest(request)
+	}
+	//own profile
+	checkRespCode(t, http.StatusNetworkAuthenticationRequired, send(http.MethodGet, "/me", "", "").Code)
+	resp := send(http.MethodPut, "/me", "test", `{"name":" ","profileVisibility":"friends"}`)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	var fe struct{ Fields map[string]string }
+	json.Unmarshal(resp.Body.Bytes(), &fe)
+	if len(fe.Fields) != 2 {
+		t.Errorf("Expected errors for name and profileVisibility. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPut, "/me", "test", `{"name":"Tester","bio":"Writes **tests**","profileVisibility":"members"}`)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var me map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &me)
+	if me["name"] != "Tester" || me["email"] != "test@example.com" || me["profileVisibility"] != "members" || me["postCount"] != 2.0 {
+		t.Errorf("Expected own profile with settings. Got %s", resp.Body.String())
+	}
+	//privacy
+	resp = send(http.MethodGet, "/users", "", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if strings.Contains(resp.Body.String(), "Tester") || !strings.Contains(resp.Body.String(), `"login": "other"`) {
+		t.Errorf("Expected members-only profile hidden from guests. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/users/1", "", "").Code)
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/users/1/posts", "", "").Code)
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/web/users/1", "", "").Code)
+	resp = send(http.MethodGet, "/users/1", "other", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var p models.Profile
+	json.Unmarshal(resp.Body.Bytes(), &p)
+	if p.Name != "Tester" || p.Bio != "Writes **tests**" || p.Email != "" || p.PostCount != 2 || p.CommentCount != 3 {
+		t.Errorf("Expected profile without email. Got %s", resp.Body.String())
+	}
+	send(http.MethodPut, "/me", "test", `{"profileVisibility":"private","showEmail":true}`)
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/users/1", "other", "").Code)
+	setTestUserRole(models.RoleModerator)
+	checkRespCode(t, http.StatusOK, send(http.MethodGet, "/users/1", "test", "").Code)
+	setTestUserRole(models.RoleUser)
+	send(http.MethodPut, "/me", "test", `{"profileVisibility":"public"}`)
+	resp = send(http.MethodGet, "/users/1", "", "")
+	if !strings.Contains(resp.Body.String(), "test@example.com") {
+		t.Errorf("Expected shown email. Got %s", resp.Body.String())
+	}
+	//activity
+	resp = send(http.MethodGet, "/users/1/posts?limit=1", "", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var pp []models.Post
+	if json.Unmarshal(resp.Body.Bytes(), &pp); len(pp) != 1 || pp[0].ID != 2 {
+		t.Errorf("Expected the newest post. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodGet, "/users/1/comments", "", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var cc []models.Comment
+	if json.Unmarshal(resp.Body.Bytes(), &cc); len(cc) != 3 {
+		t.Errorf("Expected 3 comments. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodGet, "/users/1/comments?offset=-1", "", "").Code)
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/users/999", "", "").Code)
+	//secrets never leak
+	for _, path := range []string{"/users", "/users/1", "/users?xml=true", "/users/1?xml=true", "/me", "/me?xml=true"} {
+		body := send(http.MethodGet, path, "test", "").Body.String()
+		for _, secret := range []string{"secret-token", authorization.CalculateSignature("test", a.Config.HASHKey), "apikey", "provider", "accessToken", "access_token"} {
+			if strings.Contains(strings.ToLower(body), strings.ToLower(secret)) {
+				t.Errorf("Expected no %s in %s. Got %s", secret, path, body)
+			}
+		}
+	}
+	//avatar
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 300, 300)))
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "me.png")
+	fw.Write(img.Bytes())
+	mw.Close()
+	request, _ := http.NewRequest(http.MethodPut, "/me/avatar", &body)
+	request.Header.Add("APIKey", "test")
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+	resp = execRequest(request)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.Contains(resp.Body.String(), `"url": "/users/1/avatar"`) {
+		t.Errorf("Expected avatar URL. Got %s", resp.Body.String())
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for resp = send(http.MethodGet, "/users/1/avatar", "", ""); resp.Code == http.StatusConflict && time.Now().Before(deadline); resp = send(http.MethodGet, "/users/1/avatar", "", "") {
+		time.Sleep(50 * time.Millisecond)
+	}
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if resp.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Expected PNG avatar. Got %s", resp.Header().Get("Content-Type"))
+	}
+	checkRespCode(t, http.StatusOK, send(http.MethodGet, "/users/1/avatar/160", "", "").Code)
+	checkRespCode(t, http.StatusOK, send(http.MethodDelete, "/me/avatar", "test", "").Code)
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/users/1/avatar", "", "").Code)
+	var n int64
+	a.DB.Model(&models.Attachment{}).Where("userId = ? AND postId IS NULL AND commentId IS NULL", 1).Count(&n)
+	if n != 0 {
+		t.Errorf("Expected removed avatar attachment. Got %d", n)
+	}
+}
+
+func TestJSONPlaceholder(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	defer a.DB.Exec("DELETE FROM users WHERE provider = ?", "jsonplaceholder")
+	defer a.DB.Exec("DELETE FROM albums WHERE userId = 1")
+	defer a.DB.Exec("DELETE FROM todos WHERE userId = 1")
+	dir := t.TempDir()
+	files := map[string]string{
+		"users.json": `[{"id":101,"name":"Leanne Graham","username":"Bret","email":"Sincere@april.biz",
+			"address":{"street":"Kulas Light","suite":"Apt. 556","city":"Gwenborough","zipcode":"92998-3874","geo":{"lat":"-37.3159","lng":"81.1496"}},
+			"phone":"1-770-736-8031 x56442","website":"hildegard.org","company":{"name":"Romaguera-Crona","catchPhrase":"Multi-layered client-server neural-net","bs":"harness real-time e-markets"}}]`,
+		"posts.json":    `[{"userId":101,"id":101,"title":"sunt aut facere","body":"quia et suscipit\nsuscipit recusandae"}]`,
+		"comments.json": `[{"postId":101,"id":101,"name":"id labore ex et quam laborum","email":"Eliseo@gardner.biz","body":"laudantium enim quasi"}]`,
+		"albums.json":   `[{"userId":101,"id":101,"title":"quidem molestiae enim"}]`,
+		"photos.json": `[{"albumId":101,"id":101,"title":"accusamus beatae","url":"https://via.placeholder.com/600/92c952","thumbnailUrl":"https://via.placeholder.com/150/92c952"},
+			{"albumId":101,"id":102,"title":"reprehenderit est","url":"https://via.placeholder.com/600/771796","thumbnailUrl":"https://via.placeholder.com/150/771796"}]`,
+		"todos.json": `[{"userId":101,"id":101,"title":"delectus aut autem","completed":false},{"userId":101,"id":102,"title":"fugiat veniam minus","completed":true}]`,
+	}
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	}
+	var out bytes.Buffer
+	if err := application.Command([]string{"import", "jsonplaceholder", dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "users: 1 imported, 0 skipped") || !strings.Contains(out.String(), "photos: 2 imported, 0 skipped") {
+		t.Errorf("Expected import report. Got %s", out.String())
+	}
+	out.Reset()
+	application.Command([]string{"import", "jsonplaceholder", dir}, &out)
+	if !strings.Contains(out.String(), "todos: 0 imported, 2 skipped") {
+		t.Errorf("Expected a second import to skip everything. Got %s", out.String())
+	}
+	//IDs taken by other data fail the import instead of linking to it
+	clash := t.TempDir()
+	ioutil.WriteFile(filepath.Join(clash, "users.json"), []byte(files["users.json"]), 0644)
+	ioutil.WriteFile(filepath.Join(clash, "posts.json"), []byte(`[{"userId":101,"id":101,"title":"another post","body":"body"}]`), 0644)
+	ioutil.WriteFile(filepath.Join(clash, "todos.json"), []byte(`[{"userId":999,"id":103,"title":"orphan","completed":false}]`), 0644)
+	if err := application.Command([]string{"import", "jsonplaceholder", clash}, &out); !errors.Is(err, importer.ErrIDTaken) {
+		t.Errorf("Expected taken ID. Got %v", err)
+	}
+	os.Remove(filepath.Join(clash, "posts.json"))
+	if err := application.Command([]string{"import", "jsonplaceholder", clash}, &out); err == nil || !strings.Contains(err.Error(), "unknown user 999") {
+		t.Errorf("Expected unknown user. Got %v", err)
+	}
+	if err := application.Command([]string{"import", "jsonplaceholder", t.TempDir()}, &out); err == nil {
+		t.Errorf("Expected an error for a directory without dataset files")
+	}
+	if err := application.Command([]string{"import"}, &out); err != application.ErrUsage {
+		t.Errorf("Expected usage error. Got %v", err)
+	}
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Add("APIKey", "test")
+		return execRequest(request)
+	}
+	//resources in JSONPlaceholder shape
+	resp := send(http.MethodGet, "/users/101", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	var u struct {
+		Username string
+		Email    string
+		Address  models.Address
+		Company  models.Company
+		Phone    string
+	}
+	json.Unmarshal(resp.Body.Bytes(), &u)
+	if u.Username != "Bret" || u.Email != "Sincere@april.biz" || u.Address.Geo.Lat != "-37.3159" || u.Company.BS != "harness real-time e-markets" || u.Phone == "" {
+		t.Errorf("Expected JSONPlaceholder user. Got %s", resp.Body.String())
+	}
+	for path, want := range map[string]int{
+		"/users/101/posts": 1, "/users/101/albums": 1, "/users/101/todos": 2, "/users/101/todos?completed=true": 1,
+		"/albums?userId=101": 1, "/albums/101/photos": 2, "/photos?albumId=101&limit=1": 1, "/todos?userId=101&completed=false": 1,
+		"/comments?postId=101": 1, "/posts/101/comments": 1,
+	} {
+		resp = send(http.MethodGet, path, "")
+		checkRespCode(t, http.StatusOK, resp.Code)
+		var list []map[string]interface{}
+		if json.Unmarshal(resp.Body.Bytes(), &list); len(list) != want {
+			t.Errorf("Expected %d items from %s. Got %s", want, path, resp.Body.String())
+		}
+	}
+	resp = send(http.MethodGet, "/photos/102", "")
+	if !strings.Contains(resp.Body.String(), `"thumbnailUrl": "https://via.placeholder.com/150/771796"`) {
+		t.Errorf("Expected photo. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/todos/999", "").Code)
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodGet, "/todos?completed=maybe", "").Code)
+	//private profiles keep their albums and todos
+	a.DB.Exec("UPDATE users SET profileVisibility = ? WHERE id = 101", models.ProfilePrivate)
+	for path, want := range map[string]int{"/todos?userId=101": 0, "/todos": 0, "/albums?userId=101": 0, "/photos?albumId=101": 0} {
+		resp = send(http.MethodGet, path, "")
+		var list []map[string]interface{}
+		if json.Unmarshal(resp.Body.Bytes(), &list); len(list) != want {
+			t.Errorf("Expected %d items from %s. Got %s", want, path, resp.Body.String())
+		}
+	}
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/todos/101", "").Code)
+	checkRespCode(t, http.StatusNotFound, send(http.MethodGet, "/albums/101/photos", "").Code)
+	a.DB.Exec("UPDATE users SET profileVisibility = ? WHERE id = 101", models.ProfilePublic)
+	//changes by the owner only
+	checkRespCode(t, http.StatusForbidden, send(http.MethodPut, "/todos", `{"id":101,"completed":true}`).Code)
+	checkRespCode(t, http.StatusForbidden, send(http.MethodPost, "/photos", `{"albumId":101,"title":"mine","url":"https://example.com/1.png","thumbnailUrl":"https://example.com/1s.png"}`).Code)
+	resp = send(http.MethodPost, "/todos", `{"title":"write tests"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var todo models.Todo
+	json.Unmarshal(resp.Body.Bytes(), &todo)
+	resp = send(http.MethodPut, "/todos", `{"id":`+strconv.Itoa(todo.ID)+`,"completed":true}`)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if json.Unmarshal(resp.Body.Bytes(), &todo); !todo.Completed || todo.Title != "write tests" || todo.UserID != 1 {
+		t.Errorf("Expected completed todo. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPost, "/albums", `{"title":"holidays"}`)
+	checkRespCode(t, http.StatusCreated, resp.Code)
+	var album models.Album
+	json.Unmarshal(resp.Body.Bytes(), &album)
+	resp = send(http.MethodPost, "/photos", `{"albumId":`+strconv.Itoa(album.ID)+`,"title":"beach","url":"beach.png","thumbnailUrl":"https://example.com/s.png"}`)
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	if !strings.Contains(resp.Body.String(), `"url"`) {
+		t.Errorf("Expected url field error. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusCreated, send(http.MethodPost, "/photos", `{"albumId":`+strconv.Itoa(album.ID)+`,"title":"beach","url":"https://example.com/b.png","thumbnailUrl":"https://example.com/s.png"}`).Code)
+	checkRespCode(t, http.StatusOK, send(http.MethodDelete, "/albums/"+strconv.Itoa(album.ID), "").Code)
+	var n int64
+	a.DB.Model(&models.Photo{}).Where("albumId = ?", album.ID).Count(&n)
+	if n != 0 {
+		t.Errorf("Expected photos removed with the album. Got %d", n)
+	}
+	//contact details of the own profile
+	defer a.DB.Exec("UPDATE users SET phone = '', website = '', city = '', companyName = '' WHERE login = ?", "test")
+	resp = send(http.MethodPut, "/me", `{"phone":"555-0100","address":{"city":"Springfield"},"company":{"name":"ACME"}}`)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.Contains(resp.Body.String(), `"city": "Springfield"`) || !strings.Contains(resp.Body.String(), `"phone": "555-0100"`) {
+		t.Errorf("Expected contact details. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPut, "/me", `{"address":{}}`)
+	if strings.Contains(resp.Body.String(), `"address"`) || !strings.Contains(resp.Body.String(), `"company"`) {
+		t.Errorf("Expected address cleared and company kept. Got %s", resp.Body.String())
+	}
+}
+
+func TestArchive(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Add("APIKey", "test")
+		return execRequest(request)
+	}
+	checkRespCode(t, http.StatusCreated, send(http.MethodPost, "/posts", `{"title":"archived","body":"original body","type":"question","tags":["go"]}`).Code)
+	for _, c := range []string{`"body":"answer"`, `"body":"reply","parentId":1`, `"body":"doomed"`, `"body":"orphaned reply","parentId":3`} {
+		checkRespCode(t, http.StatusCreated, send(http.MethodPost, "/comments", `{"postId":1,"name":"test","email":"test@test.test",`+c+`}`).Code)
+	}
+	checkRespCode(t, http.StatusOK, send(http.MethodPut, "/posts/1/answer", `{"commentId":1}`).Code)
+	checkRespCode(t, http.StatusOK, send(http.MethodDelete, "/comments/3", "").Code)
+	//admin only
+	checkRespCode(t, http.StatusForbidden, send(http.MethodGet, "/export", "").Code)
+	setTestUserRole(models.RoleAdmin)
+	defer setTestUserRole(models.RoleUser)
+	//export without credentials
+	resp := send(http.MethodGet, "/export", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	ndjson := resp.Body.String()
+	if ct := resp.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Expected application/x-ndjson. Got %s", ct)
+	}
+	if strings.Contains(ndjson, authorization.CalculateSignature("test", a.Config.HASHKey)) || strings.Contains(ndjson, "apikey") {
+		t.Errorf("Expected no API keys in export. Got %s", ndjson)
+	}
+	if strings.Count(ndjson, `"type":"comment"`) != 4 || !strings.Contains(ndjson, `"tags":["go"]`) || !strings.Contains(ndjson, `"acceptedCommentId":1`) {
+		t.Errorf("Expected post and comments in export. Got %s", ndjson)
+	}
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodGet, "/export?format=csv", "").Code)
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodGet, "/export?resource=votes", "").Code)
+	resp = send(http.MethodGet, "/export?format=csv&resource=posts", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if !strings.HasPrefix(resp.Body.String(), "id,userId,categoryId,type,title,body,tags,") {
+		t.Errorf("Expected CSV header. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodGet, "/export?format=json&resource=posts,comments", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	jsonArchive := resp.Body.String()
+	var archived struct {
+		Version  int
+		Posts    []map[string]interface{}
+		Comments []map[string]interface{}
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &archived); err != nil || archived.Version != 1 || len(archived.Posts) != 1 || len(archived.Comments) != 4 {
+		t.Errorf("Expected JSON archive. Got %v %s", err, jsonArchive)
+	}
+	//conflicts with the stored rows
+	type report struct {
+		Error  string
+		Counts map[string]struct{ Created, Updated, Skipped int }
+		Errors []struct {
+			Resource string
+			Line     int
+			Error    string
+		}
+	}
+	var rep report
+	resp = send(http.MethodPost, "/import?dryRun=true", ndjson)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if json.Unmarshal(resp.Body.Bytes(), &rep); rep.Counts["posts"].Skipped != 1 || rep.Counts["comments"].Skipped != 4 || rep.Counts["users"].Created != 0 {
+		t.Errorf("Expected everything skipped. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusConflict, send(http.MethodPost, "/import?conflict=fail", ndjson).Code)
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodPost, "/import?conflict=merge", ndjson).Code)
+	resp = send(http.MethodPost, "/import?conflict=overwrite", strings.Replace(ndjson, "original body", "changed body", 1))
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if json.Unmarshal(resp.Body.Bytes(), &rep); rep.Counts["posts"].Updated != 1 {
+		t.Errorf("Expected post overwritten. Got %s", resp.Body.String())
+	}
+	var p models.Post
+	a.DB.Where("title = ?", "archived").First(&p)
+	if p.Body != "changed body" {
+		t.Errorf("Expected changed body. Got %s", p.Body)
+	}
+	//invalid records import nothing
+	resp = send(http.MethodPost, "/import", `{"type":"post","record":{"id":9,"userId":999,"title":"orphan","body":"lost"}}`+"\nnot json\n"+`{"type":"error","error":"lost connection"}`+"\n")
+	checkRespCode(t, http.StatusBadRequest, resp.Code)
+	if json.Unmarshal(resp.Body.Bytes(), &rep); len(rep.Errors) != 3 || rep.Errors[0].Error != "unknown user" || rep.Errors[1].Line != 2 ||
+		!strings.Contains(rep.Errors[2].Error, "lost connection") {
+		t.Errorf("Expected three invalid records. Got %s", resp.Body.String())
+	}
+	var n int64
+	if a.DB.Model(&models.Post{}).Where("title = ?", "orphan").Count(&n); n != 0 {
+		t.Errorf("Expected nothing imported")
+	}
+	//references to stored rows only on request
+	var tester models.User
+	a.DB.Where("login = ?", "test").First(&tester)
+	orphan := fmt.Sprintf(`{"type":"post","record":{"id":9,"userId":%d,"title":"orphan","body":"lost"}}`, tester.ID)
+	checkRespCode(t, http.StatusBadRequest, send(http.MethodPost, "/import?dryRun=true", orphan).Code)
+	checkRespCode(t, http.StatusOK, send(http.MethodPost, "/import?dryRun=true&storedRefs=true", orphan).Code)
+	//restore into an empty forum with new IDs
+	clearTableComments()
+	clearTablePosts()
+	addPosts(1)
+	resp = send(http.MethodPost, "/import?format=json", jsonArchive)
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if json.Unmarshal(resp.Body.Bytes(), &rep); rep.Counts["posts"].Created != 1 || rep.Counts["comments"].Created != 4 {
+		t.Errorf("Expected post and comments created. Got %s", resp.Body.String())
+	}
+	a.DB.Where("title = ?", "archived").First(&p)
+	var answer, tombstone models.Comment
+	a.DB.Where("postId = ? AND body = ?", p.ID, "answer").First(&answer)
+	a.DB.Where("postId = ? AND deleted = ?", p.ID, true).First(&tombstone)
+	if p.ID != 2 || p.Type != models.PostTypeQuestion || p.AcceptedID == nil || *p.AcceptedID != answer.ID {
+		t.Errorf("Expected question with remapped answer. Got %+v", p)
+	}
+	if a.DB.Model(&models.Comment{}).Where("parentId = ? AND body = ?", tombstone.ID, "orphaned reply").Count(&n); tombstone.ID == 0 || n != 1 {
+		t.Errorf("Expected tombstone keeping its reply")
+	}
+	resp = send(http.MethodGet, "/posts/2", "")
+	if !strings.Contains(resp.Body.String(), `"go"`) {
+		t.Errorf("Expected tags restored. Got %s", resp.Body.String())
+	}
+	//command line
+	file := filepath.Join(t.TempDir(), "users.csv")
+	var out bytes.Buffer
+	if err := application.Command([]string{"export", "-resource", "users", "csv", file}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := application.Command([]string{"import", "-dry-run", "-resource", "users", "csv", file}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "users: 0 created, 0 updated") || !strings.Contains(out.String(), "dry run") {
+		t.Errorf("Expected dry run report. Got %s", out.String())
+	}
+	if err := application.Command([]string{"export", "xml", "-"}, &out); err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
+
+func TestPersonalData(t *testing.T) {
+	clearTableComments()
+	clearTablePosts()
+	key := authorization.CalculateSignature("personal", a.Config.HASHKey)
+	u := models.User{Login: "personal", Name: "personal", Provider: "test", Email: "personal@test.test", APIKey: key}
+	a.DB.Where(models.User{Login: u.Login}).Assign(models.User{APIKey: key}).FirstOrCreate(&u)
+	a.DB.Model(&u).Select("DeleteAt").Updates(models.User{})
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Add("APIKey", "personal")
+		return execRequest(request)
+	}
+	checkRespCode(t, http.StatusCreated, send(http.MethodPost, "/posts", `{"title":"mine","body":"my post"}`).Code)
+	checkRespCode(t, http.StatusCreated, send(http.MethodPost, "/comments", `{"postId":1,"name":"personal","email":"personal@test.test","body":"my comment"}`).Code)
+	a.DB.Create(&models.AuditEvent{CreatedAt: time.Now(), Action: models.AuditLoginSuccess, ActorID: u.ID, TargetType: "user", TargetID: u.ID})
+	//export
+	resp := send(http.MethodGet, "/me/export", "")
+	checkRespCode(t, http.StatusOK, resp.Code)
+	if cd := resp.Header().Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+		t.Errorf("Expected attachment. Got %s", cd)
+	}
+	var exported struct {
+		Users    []map[string]interface{}
+		Posts    []map[string]interface{}
+		Comments []map[string]interface{}
+		Sessions []models.AuditEvent
+		Audit    []models.AuditEvent
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &exported); err != nil || len(exported.Users) != 1 || len(exported.Posts) != 1 ||
+		len(exported.Comments) != 1 || len(exported.Sessions) != 1 || len(exported.Audit) < 2 {
+		t.Errorf("Expected profile, post, comment, session and audit events. Got %v %s", err, resp.Body.String())
+	}
+	if strings.Contains(resp.Body.String(), key) {
+		t.Errorf("Expected no API key in export")
+	}
+	//request, cancel and request again
+	resp = send(http.MethodGet, "/me/delete", "")
+	if !strings.Contains(resp.Body.String(), `"deleteAt": null`) {
+		t.Errorf("Expected no pending deletion. Got %s", resp.Body.String())
+	}
+	resp = send(http.MethodPost, "/me/delete", "")
+	checkRespCode(t, http.StatusAccepted, resp.Code)
+	if !strings.Contains(resp.Body.String(), `"policy": "anonymize"`) {
+		t.Errorf("Expected deletion policy. Got %s", resp.Body.String())
+	}
+	checkRespCode(t, http.StatusNetworkAuthenticationRequired, send(http.MethodGet, "/me", "").Code)
+	a.DB.Model(&u).Update("apikey", key)
+	checkRespCode(t, http.StatusOK, send(http.MethodDelete, "/me/delete", "").Code)
+	if n, _ := a.Accounts.DeleteDue(time.Now().AddDate(0, 0, a.Config.DeletionGraceDays+1)); n != 0 {
+		t.Errorf("Expected cancelled deletion. Got %d deleted", n)
+	}
+	checkRespCode(t, http.StatusAccepted, send(http.MethodPost, "/me/delete", "").Code)
+	//nothing happens before the grace period ends
+	if n, _ := a.Accounts.DeleteDue(time.Now()); n != 0 {
+		t.Errorf("Expected no deletion during the grace period. Got %d", n)
+	}
+	n, err := a.Accounts.DeleteDue(time.Now().AddDate(0, 0, a.Config.DeletionGraceDays+1))
+	if err != nil || n != 1 {
+		t.Fatalf("Expected the account deleted. Got %d %v", n, err)
+	}
+	var deleted models.User
+	a.DB.First(&deleted, u.ID)
+	if deleted.Login != "deleted-"+strconv.Itoa(u.ID) || deleted.Email != "" || deleted.DeleteAt != nil {
+		t.Errorf("Expected anonymized user. Got %+v", deleted)
+	}
+	var c models.Comment
+	a.DB.Where("userId = ?", u.ID).First(&c)
+	if c.Body != "my comment" || c.Email != "" {
+		t.Errorf("Expected anonymized comment. Got %+v", c)
+	}
+	var events int64
+	if a.DB.Model(&models.AuditEvent{}).Where("action = ? AND targetId = ?", models.AuditUserDelete, u.ID).Count(&events); events != 1 {
+		t.Errorf("Expected deletion in the audit log")
+	}
+	clearTableComments()
+	clearTablePosts()
+	a.DB.Delete(&deleted)
+}
+
+func TestS3Storage(t *testing.T) {
+	ts := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	defer ts.Close()
+	ctx := context.Background()
+	s, err := storage.NewS3(ctx, storage.S3Cfg{Endpoint: strings.TrimPrefix(ts.URL, "http://"), AccessKey: "key",
+		SecretKey: "secret", Bucket: "forum", Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(ctx, "sha256/ab/abc", strings.NewReader("blob"), 4, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := s.Get(ctx, "sha256/ab/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(b) != "blob" {
+		t.Errorf("Expected blob. Got %q", b)
+	}
+	s.Delete(ctx, "sha256/ab/abc")
+	if _, err = s.Get(ctx, "sha256/ab/abc"); err != storage.ErrNotFound {
+		t.Errorf("Expected ErrNotFound. Got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package markdown
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"regexp"
+	"strconv"
+	"sync"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+)
+
+// DefaultCacheSize is the number of rendered documents kept by the package
+// level renderer.
+const DefaultCacheSize = 4096
+
+// HighlightStyle is the chroma style the classes of highlighted code refer to;
+// the matching stylesheet is static/css/highlight.css.
+const HighlightStyle = "github"
+
+var defaultRenderer = New(DefaultCacheSize)
+
+// Renderer turns CommonMark into sanitized HTML. Fenced code blocks are
+// highlighted on the server with CSS classes. Rendered documents are cached
+// by key until they are invalidated or pushed out by newer ones.
+type Renderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	sum  [sha256.Size]byte
+	html string
+}
+
+func New(cacheSize int) *Renderer {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^[\w\- ]+$`)).OnElements("pre", "code", "span")
+	return &Renderer{
+		md: goldmark.New(goldmark.WithExtensions(
+			highlighting.NewHighlighting(
+				highlighting.WithStyle(HighlightStyle),
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+		)),
+		policy:  policy,
+		size:    cacheSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Render converts src to sanitized HTML without touching the cache.
+func (rn *Renderer) Render(src string) string {
+	if src == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := rn.md.Convert([]byte(src), &buf); err != nil {
+		return rn.policy.Sanitize("<p>" + src + "</p>")
+	}
+	return rn.policy.Sanitize(buf.String())
+}
+
+// RenderCached renders src stored under key. The cached HTML is used only
+// while it was made from the same source.
+func (rn *Renderer) RenderCached(key, src string) string {
+	sum := sha256.Sum256([]byte(src))
+	rn.mu.Lock()
+	if el, ok := rn.entries[key]; ok && el.Value.(*entry).sum == sum {
+		rn.order.MoveToFront(el)
+		html := el.Value.(*entry).html
+		rn.mu.Unlock()
+		return html
+	}
+	rn.mu.Unlock()
+
+	html := rn.Render(src)
+
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if el, ok := rn.entries[key]; ok {
+		el.Value = &entry{key: key, sum: sum, html: html}
+		rn.order.MoveToFront(el)
+		return html
+	}
+	rn.entries[key] = rn.order.PushFront(&entry{key: key, sum: sum, html: html})
+	for rn.size > 0 && rn.order.Len() > rn.size {
+		last := rn.order.Back()
+		rn.order.Remove(last)
+		delete(rn.entries, last.Value.(*entry).key)
+	}
+	return html
+}
+
+// Invalidate drops the cached document stored under key.
+func (rn *Renderer) Invalidate(key string) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if el, ok := rn.entries[key]; ok {
+		rn.order.Remove(el)
+		delete(rn.entries, key)
+	}
+}
+
+func Render(src string) string {
+	return defaultRenderer.Render(src)
+}
+
+func RenderCached(key, src string) string {
+	return defaultRenderer.RenderCached(key, src)
+}
+
+func Invalidate(key string) {
+	defaultRenderer.Invalidate(key)
+}
+
+// PostKey and CommentKey build cache keys of post and comment bodies.
+func PostKey(id int) string {
+	return "post:" + strconv.Itoa(id)
+}
+
+func CommentKey(id int) string {
+	return "comment:" + strconv.Itoa(id)
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/xml"
+
+	"gorm.io/gorm"
+)
+
+type Albums struct { //structure for response array of albums in xml format
+	XMLName xml.Name `xml:"albums" json:"-" gorm:"-"`
+	Albums  []Album  `xml:"album"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Album groups photos of a user, as albums of JSONPlaceholder.
+type Album struct {
+	XMLName xml.Name `xml:"album" json:"-" gorm:"-"`
+	UserID  int      `json:"userId" xml:"userId" gorm:"column:userId;index"`
+	ID      int      `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	Title   string   `json:"title" xml:"title" gorm:"column:title;type:VARCHAR(512)"`
+	Photos  []Photo  `xml:"-" json:"-" gorm:"foreignKey:AlbumID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type AlbumProcess struct{}
+
+func (apr *AlbumProcess) GetAlbum(db *gorm.DB, param map[string]interface{}) (Album, *gorm.DB) {
+	a := Album{}
+	tx := db.Where(param).First(&a)
+	return a, tx
+}
+
+func (apr *AlbumProcess) ListAlbums(db *gorm.DB, param map[string]interface{}) ([]Album, *gorm.DB) {
+	aa := []Album{}
+	tx := db.Where(param).Order("id").Find(&aa)
+	return aa, tx
+}
+
+func (apr *AlbumProcess) CreateAlbum(db *gorm.DB, a *Album) *gorm.DB {
+	return db.Select("UserID", "Title").Create(&a)
+}
+
+func (apr *AlbumProcess) UpdateAlbum(db *gorm.DB, a *Album) *gorm.DB {
+	return db.Model(&a).Select("Title").Updates(a)
+}
+
+// DeleteAlbum removes the album with its photos.
+func (apr *AlbumProcess) DeleteAlbum(db *gorm.DB, a *Album) *gorm.DB {
+	return db.Delete(&a)
+}
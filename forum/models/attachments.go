@@ -0,0 +1,236 @@
+package models
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AttachmentReady      = "ready"
+	AttachmentProcessing = "processing" //images wait for metadata stripping and thumbnails
+	AttachmentFailed     = "failed"
+)
+
+type Attachments struct { //structure for response array of attachments in xml format
+	XMLName     xml.Name     `xml:"attachments" json:"-" gorm:"-"`
+	Attachments []Attachment `xml:"attachment"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Attachment is a file uploaded to a post or a comment. Blobs are stored by
+// content hash, so uploading the same file twice stores it once.
+type Attachment struct {
+	XMLName     xml.Name  `xml:"attachment" json:"-" gorm:"-"`
+	ID          int       `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	PostID      *int      `json:"postId,omitempty" xml:"postId,omitempty" gorm:"column:postId;index"`
+	CommentID   *int      `json:"commentId,omitempty" xml:"commentId,omitempty" gorm:"column:commentId;index"`
+	UserID      int       `json:"userId" xml:"userId" gorm:"column:userId"`
+	Name        string    `json:"name" xml:"name" gorm:"column:name;type:VARCHAR(256)"`
+	ContentType string    `json:"contentType" xml:"contentType" gorm:"column:contentType;type:VARCHAR(128)"`
+	Size        int64     `json:"size" xml:"size" gorm:"column:size"`
+	Hash        string    `json:"sha256" xml:"sha256" gorm:"column:hash;type:CHAR(64);index"`
+	Status      string    `json:"status" xml:"status" gorm:"column:status;type:VARCHAR(16);default:ready;index"`
+	URL         string    `json:"url" xml:"url" gorm:"-"`
+	CreatedAt   time.Time `json:"createdAt" xml:"createdAt" gorm:"column:createdAt"`
+
+	ThumbnailSizes string      `json:"-" xml:"-" gorm:"column:thumbnails;type:VARCHAR(64)"`
+	Thumbnails     []Thumbnail `json:"thumbnails,omitempty" xml:"thumbnails>thumbnail,omitempty" gorm:"-"`
+}
+
+type Thumbnail struct {
+	Size int    `json:"size" xml:"size"`
+	URL  string `json:"url" xml:"url"`
+}
+
+// BlobKey is the storage key of the attachment's content.
+func (a *Attachment) BlobKey() string {
+	return BlobKey(a.Hash)
+}
+
+// BlobKey is the storage key of content with the given SHA-256 hex digest.
+func BlobKey(hash string) string {
+	if len(hash) < 2 {
+		return "sha256/" + hash
+	}
+	return "sha256/" + hash[:2] + "/" + hash
+}
+
+// ThumbnailKey is the storage key of the thumbnail of content with the given
+// SHA-256 hex digest, bounded by size.
+func ThumbnailKey(hash string, size int) string {
+	return "thumbnails/" + strings.TrimPrefix(BlobKey(hash), "sha256/") + "-" + strconv.Itoa(size)
+}
+
+// ThumbnailType is the content type of the attachment's thumbnails.
+func (a *Attachment) ThumbnailType() string {
+	if a.ContentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// HasThumbnail reports whether a thumbnail bounded by size was made.
+func (a *Attachment) HasThumbnail(size int) bool {
+	for _, t := range a.Thumbnails {
+		if t.Size == size {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.setURL()
+	return nil
+}
+
+func (a *Attachment) setURL() {
+	a.URL = "/attachments/" + strconv.Itoa(a.ID)
+	a.Thumbnails = nil
+	for _, s := range strings.Split(a.ThumbnailSizes, ",") {
+		if size, err := strconv.Atoi(s); err == nil {
+			a.Thumbnails = append(a.Thumbnails, Thumbnail{Size: size, URL: a.URL + "/thumbnails/" + s})
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type AttachmentProcess struct{}
+
+func (apr *AttachmentProcess) GetAttachment(db *gorm.DB, param map[string]interface{}) (Attachment, *gorm.DB) {
+	a := Attachment{}
+	tx := db.Where(param).First(&a)
+	return a, tx
+}
+
+func (apr *AttachmentProcess) ListAttachments(db *gorm.DB, param map[string]interface{}) ([]Attachment, *gorm.DB) {
+	aa := []Attachment{}
+	tx := db.Where(param).Order("id").Find(&aa)
+	return aa, tx
+}
+
+func (apr *AttachmentProcess) CreateAttachment(db *gorm.DB, a *Attachment) *gorm.DB {
+	a.CreatedAt = time.Now()
+	if a.Status == "" {
+		a.Status = AttachmentReady
+	}
+	tx := db.Select("PostID", "CommentID", "UserID", "Name", "ContentType", "Size", "Hash", "Status", "CreatedAt").Create(a)
+	a.setURL()
+	return tx
+}
+
+// FinishAttachment stores the cleaned content of a processed image and the
+// sizes of its thumbnails, making it available for download.
+func (apr *AttachmentProcess) FinishAttachment(db *gorm.DB, a *Attachment, sizes []int) *gorm.DB {
+	ss := make([]string, len(sizes))
+	for i, size := range sizes {
+		ss[i] = strconv.Itoa(size)
+	}
+	a.ThumbnailSizes = strings.Join(ss, ",")
+	a.Status = AttachmentReady
+	tx := db.Model(&Attachment{}).Where("id = ?", a.ID).Updates(map[string]interface{}{
+		"hash": a.Hash, "size": a.Size, "status": a.Status, "thumbnails": a.ThumbnailSizes,
+	})
+	a.setURL()
+	return tx
+}
+
+func (apr *AttachmentProcess) DeleteAttachment(db *gorm.DB, a *Attachment) *gorm.DB {
+	return db.Delete(a)
+}
+
+// PostHashes returns the blob hashes of the attachments of post postID and
+// of its comments, which go away together with the post.
+func (apr *AttachmentProcess) PostHashes(db *gorm.DB, postID int) ([]string, error) {
+	hashes := []string{}
+	err := db.Model(&Attachment{}).
+		Where("postId = ? OR commentId IN (?)", postID, db.Model(&Comment{}).Select("id").Where("postId = ?", postID)).
+		Distinct().Pluck("hash", &hashes).Error
+	return hashes, err
+}
+
+// CommentHashes returns the blob hashes of the attachments of comment
+// commentID.
+func (apr *AttachmentProcess) CommentHashes(db *gorm.DB, commentID int) ([]string, error) {
+	hashes := []string{}
+	err := db.Model(&Attachment{}).Where("commentId = ?", commentID).Distinct().Pluck("hash", &hashes).Error
+	return hashes, err
+}
+
+// UnreferencedHashes returns those of hashes no attachment refers to any
+// more, whose blobs can be removed from storage.
+func (apr *AttachmentProcess) UnreferencedHashes(db *gorm.DB, hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	used := []string{}
+	err := db.Model(&Attachment{}).Where("hash IN ?", hashes).Distinct().Pluck("hash", &used).Error
+	if err != nil {
+		return nil, err
+	}
+	inUse := make(map[string]bool, len(used))
+	for _, h := range used {
+		inUse[h] = true
+	}
+	free := []string{}
+	for _, h := range hashes {
+		if !inUse[h] {
+			free = append(free, h)
+		}
+	}
+	return free, nil
+}
+
+// loadPostAttachments fills Attachments of every post in pp with a single
+// query.
+func loadPostAttachments(db *gorm.DB, pp []Post) error {
+	if len(pp) == 0 {
+		return nil
+	}
+	ids := make([]int, len(pp))
+	index := make(map[int]int, len(pp))
+	for i := range pp {
+		ids[i] = pp[i].ID
+		index[pp[i].ID] = i
+		pp[i].Attachments = []Attachment{}
+	}
+	aa := []Attachment{}
+	err := db.Session(&gorm.Session{NewDB: true}).Where("postId IN ?", ids).Order("id").Find(&aa).Error
+	if err != nil {
+		return err
+	}
+	for _, a := range aa {
+		i := index[*a.PostID]
+		pp[i].Attachments = append(pp[i].Attachments, a)
+	}
+	return nil
+}
+
+// loadCommentAttachments fills Attachments of every comment in cc with a
+// single query.
+func loadCommentAttachments(db *gorm.DB, cc []Comment) error {
+	if len(cc) == 0 {
+		return nil
+	}
+	ids := make([]int, len(cc))
+	index := make(map[int]int, len(cc))
+	for i := range cc {
+		ids[i] = cc[i].ID
+		index[cc[i].ID] = i
+		cc[i].Attachments = []Attachment{}
+	}
+	aa := []Attachment{}
+	err := db.Session(&gorm.Session{NewDB: true}).Where("commentId IN ?", ids).Order("id").Find(&aa).Error
+	if err != nil {
+		return err
+	}
+	for _, a := range aa {
+		i := index[*a.CommentID]
+		cc[i].Attachments = append(cc[i].Attachments, a)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"encoding/xml"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditLoginSuccess  = "login.success"
+	AuditLoginFailure  = "login.failure"
+	AuditLogout        = "logout"
+	AuditAPIKeyIssue   = "apikey.issue"
+	AuditAPIKeyRevoke  = "apikey.revoke"
+	AuditPostCreate    = "post.create"
+	AuditPostUpdate    = "post.update"
+	AuditPostDelete    = "post.delete"
+	AuditCommentCreate = "comment.create"
+	AuditCommentUpdate = "comment.update"
+	AuditCommentDelete = "comment.delete"
+	AuditRoleChange    = "user.role"
+
+	AuditCategoryCreate = "category.create"
+	AuditCategoryUpdate = "category.update"
+	AuditCategoryDelete = "category.delete"
+	AuditTagRename      = "tag.rename"
+	AuditTagMerge       = "tag.merge"
+	AuditPostAnswer     = "post.answer"
+	AuditWebhookCreate  = "webhook.create"
+	AuditWebhookUpdate  = "webhook.update"
+	AuditWebhookDelete  = "webhook.delete"
+	AuditDataExport     = "data.export"
+	AuditDataImport     = "data.import"
+	AuditUserExport     = "user.export"
+	AuditDeleteRequest  = "user.delete.request"
+	AuditDeleteCancel   = "user.delete.cancel"
+	AuditUserDelete     = "user.delete"
+)
+
+var ErrAuditAppendOnly = errors.New("audit log is append-only")
+
+type AuditEvents struct { //structure for response array of audit events in xml format
+	XMLName xml.Name     `xml:"events" json:"-" gorm:"-"`
+	Events  []AuditEvent `xml:"event"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type AuditEvent struct {
+	XMLName    xml.Name  `xml:"event" json:"-" gorm:"-"`
+	ID         int       `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	CreatedAt  time.Time `json:"time" xml:"time" gorm:"column:created_at;index"`
+	Action     string    `json:"action" xml:"action" gorm:"column:action;type:VARCHAR(32);index"`
+	ActorID    int       `json:"actorId" xml:"actorId" gorm:"column:actorId;index"`
+	TargetType string    `json:"targetType,omitempty" xml:"targetType,omitempty" gorm:"column:targetType;type:VARCHAR(32)"`
+	TargetID   int       `json:"targetId,omitempty" xml:"targetId,omitempty" gorm:"column:targetId"`
+	IP         string    `json:"ip" xml:"ip" gorm:"column:ip;type:VARCHAR(64)"`
+	Provider   string    `json:"provider,omitempty" xml:"provider,omitempty" gorm:"column:provider;type:VARCHAR(32);index"`
+	Details    string    `json:"details,omitempty" xml:"details,omitempty" gorm:"column:details;type:VARCHAR(256)"`
+}
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+// AuditFilter narrows a query over the audit log. Params holds exact-match
+// columns and From/To bound the event time.
+type AuditFilter struct {
+	Params map[string]interface{}
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+func (f AuditFilter) apply(db *gorm.DB) *gorm.DB {
+	tx := db.Model(&AuditEvent{}).Where(f.Params)
+	if !f.From.IsZero() {
+		tx = tx.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		tx = tx.Where("created_at < ?", f.To)
+	}
+	if f.Limit > 0 {
+		tx = tx.Limit(f.Limit)
+	}
+	if f.Offset > 0 {
+		tx = tx.Offset(f.Offset)
+	}
+	return tx.Order("id")
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type AuditProcess struct{}
+
+func (apr *AuditProcess) WriteEvent(db *gorm.DB, e *AuditEvent) *gorm.DB {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	return db.Select("CreatedAt", "Action", "ActorID", "TargetType", "TargetID", "IP", "Provider", "Details").Create(e)
+}
+
+func (apr *AuditProcess) ListEvents(db *gorm.DB, f AuditFilter) ([]AuditEvent, *gorm.DB) {
+	ee := []AuditEvent{}
+	tx := f.apply(db).Find(&ee)
+	return ee, tx
+}
+
+// EachEvent streams matching events to fn row by row, so that exports do not
+// have to hold the whole log in memory.
+func (apr *AuditProcess) EachEvent(db *gorm.DB, f AuditFilter, fn func(AuditEvent) error) error {
+	rows, err := f.apply(db).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEvent
+		if err := db.ScanRows(rows, &e); err != nil {
+			return err
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
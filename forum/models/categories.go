@@ -0,0 +1,205 @@
+package models
+
+import (
+	"encoding/xml"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidCategory = errors.New("category not found or would create a cycle")
+
+type Categories struct { //structure for response array of categories in xml format
+	XMLName    xml.Name   `xml:"categories" json:"-" gorm:"-"`
+	Categories []Category `xml:"category"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Category groups posts into boards. ReadRole and PostRole hold the lowest
+// role allowed to read the board and to start posts in it; empty means
+// everybody, so an announcement board has PostRole "moderator" or "admin".
+type Category struct {
+	XMLName     xml.Name   `xml:"category" json:"-" gorm:"-"`
+	ID          int        `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	ParentID    *int       `json:"parentId,omitempty" xml:"parentId,omitempty" gorm:"column:parentId;index"`
+	Name        string     `json:"name" xml:"name" gorm:"column:name;type:VARCHAR(128)"`
+	Description string     `json:"description" xml:"description" gorm:"column:description;type:VARCHAR(1024)"`
+	Position    int        `json:"position" xml:"position" gorm:"column:position"`
+	ReadRole    string     `json:"readRole,omitempty" xml:"readRole,omitempty" gorm:"column:readRole;type:VARCHAR(16)"`
+	PostRole    string     `json:"postRole,omitempty" xml:"postRole,omitempty" gorm:"column:postRole;type:VARCHAR(16)"`
+	Children    []Category `json:"children,omitempty" xml:"children>category,omitempty" gorm:"-"`
+	Posts       []Post     `xml:"-" json:"-" gorm:"foreignKey:CategoryID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL"`
+}
+
+// CanRead checks the ReadRole of c alone; subcategories are also restricted
+// by their ancestors, see CategoryProcess.CanRead.
+func (c *Category) CanRead(u User) bool {
+	return c.ReadRole == "" || u.HasRole(c.ReadRole)
+}
+
+func (c *Category) CanPost(u User) bool {
+	return c.CanRead(u) && (c.PostRole == "" || u.HasRole(c.PostRole))
+}
+
+// CategoryTree nests a flat list of categories under their parents.
+func CategoryTree(cc []Category) []Category {
+	children := make(map[int][]int)
+	index := make(map[int]int, len(cc))
+	for i, c := range cc {
+		index[c.ID] = i
+	}
+	roots := []int{}
+	for i, c := range cc {
+		if c.ParentID != nil {
+			if _, ok := index[*c.ParentID]; ok {
+				children[*c.ParentID] = append(children[*c.ParentID], i)
+				continue
+			}
+		}
+		roots = append(roots, i)
+	}
+	var build func(i int) Category
+	build = func(i int) Category {
+		c := cc[i]
+		for _, j := range children[c.ID] {
+			c.Children = append(c.Children, build(j))
+		}
+		return c
+	}
+	tree := make([]Category, 0, len(roots))
+	for _, i := range roots {
+		tree = append(tree, build(i))
+	}
+	return tree
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type CategoryProcess struct{}
+
+func (cpr *CategoryProcess) GetCategory(db *gorm.DB, param map[string]interface{}) (Category, *gorm.DB) {
+	c := Category{}
+	tx := db.Where(param).First(&c)
+	return c, tx
+}
+
+func (cpr *CategoryProcess) ListCategories(db *gorm.DB, param map[string]interface{}) ([]Category, *gorm.DB) {
+	cc := []Category{}
+	tx := db.Where(param).Order("position").Order("name").Find(&cc)
+	return cc, tx
+}
+
+// HiddenCategoryIDs returns the categories u is not allowed to read: those
+// whose ReadRole, or the ReadRole of a category above them, u lacks.
+func (cpr *CategoryProcess) HiddenCategoryIDs(db *gorm.DB, u User) ([]int, *gorm.DB) {
+	cc := []Category{}
+	tx := db.Find(&cc)
+	byID := make(map[int]Category, len(cc))
+	for _, c := range cc {
+		byID[c.ID] = c
+	}
+	hidden := func(c Category) bool {
+		//checkParent keeps the chain free of cycles, len(cc) bounds it anyway
+		for i := 0; i <= len(cc); i++ {
+			if !c.CanRead(u) {
+				return true
+			}
+			if c.ParentID == nil {
+				return false
+			}
+			parent, ok := byID[*c.ParentID]
+			if !ok {
+				return false
+			}
+			c = parent
+		}
+		return false
+	}
+	ids := []int{}
+	for _, c := range cc {
+		if hidden(c) {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids, tx
+}
+
+// CanRead reports whether u may read c and every category above it, so a
+// subcategory of a restricted board is as restricted as the board.
+func (cpr *CategoryProcess) CanRead(db *gorm.DB, c Category, u User) bool {
+	for seen := map[int]bool{}; !seen[c.ID]; {
+		if !c.CanRead(u) {
+			return false
+		}
+		if c.ParentID == nil {
+			return true
+		}
+		seen[c.ID] = true
+		parent := Category{}
+		if err := db.Where("id = ?", *c.ParentID).First(&parent).Error; err != nil {
+			return false
+		}
+		c = parent
+	}
+	return false
+}
+
+// CanPost reports whether u may read c, as CanRead does, and start posts in
+// it.
+func (cpr *CategoryProcess) CanPost(db *gorm.DB, c Category, u User) bool {
+	return cpr.CanRead(db, c, u) && c.CanPost(u)
+}
+
+// checkParent rejects unknown parents and parents that are c itself or one of
+// its descendants.
+func (cpr *CategoryProcess) checkParent(db *gorm.DB, c *Category) error {
+	for parentID := c.ParentID; parentID != nil; {
+		if c.ID != 0 && *parentID == c.ID {
+			return ErrInvalidCategory
+		}
+		parent := Category{}
+		if err := db.Where("id = ?", *parentID).First(&parent).Error; err != nil {
+			return ErrInvalidCategory
+		}
+		parentID = parent.ParentID
+	}
+	return nil
+}
+
+func validCategoryRoles(c *Category) bool {
+	return (c.ReadRole == "" || ValidRole(c.ReadRole)) && (c.PostRole == "" || ValidRole(c.PostRole))
+}
+
+func (cpr *CategoryProcess) CreateCategory(db *gorm.DB, c *Category) *gorm.DB {
+	if !validCategoryRoles(c) {
+		return &gorm.DB{Error: gorm.ErrInvalidValue}
+	}
+	if err := cpr.checkParent(db, c); err != nil {
+		return &gorm.DB{Error: err}
+	}
+	return db.Select("ParentID", "Name", "Description", "Position", "ReadRole", "PostRole").Create(&c)
+}
+
+func (cpr *CategoryProcess) UpdateCategory(db *gorm.DB, c *Category) *gorm.DB {
+	if !validCategoryRoles(c) {
+		return &gorm.DB{Error: gorm.ErrInvalidValue}
+	}
+	if err := cpr.checkParent(db, c); err != nil {
+		return &gorm.DB{Error: err}
+	}
+	return db.Model(&c).Select("ParentID", "Name", "Description", "Position", "ReadRole", "PostRole").Updates(c)
+}
+
+// DeleteCategory removes a category, moving its subcategories up to its
+// parent. Posts of the category are left without one.
+func (cpr *CategoryProcess) DeleteCategory(db *gorm.DB, c *Category) *gorm.DB {
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		result = tx.Model(&Category{}).Where("parentId = ?", c.ID).Update("parentId", c.ParentID)
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Delete(&c)
+		return result.Error
+	})
+	return result
+}
@@ -2,9 +2,18 @@ package models
 
 import (
 	"encoding/xml"
+	"errors"
+	"nx_trainee_forum/forum/markdown"
 	"regexp"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidParent = errors.New("parent comment not found in this post")
+	ErrMaxDepth      = errors.New("maximum reply depth exceeded")
 )
 
 type Comments struct { //structure for response array of comments in xml format
@@ -14,26 +23,117 @@ type Comments struct { //structure for response array of comments in xml format
 
 ////////////////////////////////////////////////////////////////////////////////////////////////
 type Comment struct {
-	PostID int    `json:"postId" gorm:"column:postId"`
-	UserID int    `json:"userId" gorm:"column:userId"`
-	ID     int    `json:"id" gorm:"column:id;primaryKey"`
-	Name   string `json:"name" gorm:"column:name;type:VARCHAR(256)"`
-	Email  string `json:"email" gorm:"column:email;type:VARCHAR(256)"`
-	Body   string `json:"body" gorm:"column:body;type:VARCHAR(256)"`
+	PostID      int             `json:"postId" gorm:"column:postId"`
+	UserID      int             `json:"userId" gorm:"column:userId"`
+	ID          int             `json:"id" gorm:"column:id;primaryKey"`
+	ParentID    *int            `json:"parentId,omitempty" xml:"parentId,omitempty" gorm:"column:parentId;index"`
+	Depth       int             `json:"depth" gorm:"column:depth"`
+	Deleted     bool            `json:"deleted,omitempty" xml:"deleted,omitempty" gorm:"column:deleted"`
+	Name        string          `json:"name" gorm:"column:name;type:VARCHAR(512)"`
+	Email       string          `json:"email" gorm:"column:email;type:VARCHAR(256)"`
+	Body        string          `json:"body" gorm:"column:body;type:MEDIUMTEXT"`
+	BodyHTML    string          `json:"bodyHtml" xml:"bodyHtml" gorm:"-"`
+	Accepted    bool            `json:"accepted,omitempty" xml:"accepted,omitempty" gorm:"-"`
+	Reactions   []ReactionCount `json:"reactions" xml:"reactions>reaction" gorm:"-"`
+	Attachments []Attachment    `json:"attachments" xml:"attachments>attachment" gorm:"foreignKey:CommentID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Score       int             `json:"score" xml:"score" gorm:"column:score;default:0;index"`
+	CreatedAt   time.Time       `json:"createdAt" xml:"createdAt" gorm:"column:createdAt;type:DATETIME(3);default:CURRENT_TIMESTAMP(3)"`
+	UpdatedAt   time.Time       `json:"updatedAt" xml:"updatedAt" gorm:"column:updatedAt;type:DATETIME(3);default:CURRENT_TIMESTAMP(3)"`
+	Replies     []Comment       `json:"replies,omitempty" xml:"replies>comment,omitempty" gorm:"-"`
+	// Mentions holds the mentions added by the last create or update.
+	Mentions      []Mention      `xml:"-" json:"-" gorm:"foreignKey:CommentID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Notifications []Notification `xml:"-" json:"-" gorm:"foreignKey:CommentID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// CommentTree nests a flat list of comments under their parents. Comments
+// whose parent is not in the list are returned as roots.
+func CommentTree(cc []Comment) []Comment {
+	children := make(map[int][]int)
+	index := make(map[int]int, len(cc))
+	for i, c := range cc {
+		index[c.ID] = i
+	}
+	roots := []int{}
+	for i, c := range cc {
+		if c.ParentID != nil {
+			if _, ok := index[*c.ParentID]; ok {
+				children[*c.ParentID] = append(children[*c.ParentID], i)
+				continue
+			}
+		}
+		roots = append(roots, i)
+	}
+	var build func(i int) Comment
+	build = func(i int) Comment {
+		c := cc[i]
+		for _, j := range children[c.ID] {
+			c.Replies = append(c.Replies, build(j))
+		}
+		return c
+	}
+	tree := make([]Comment, 0, len(roots))
+	for _, i := range roots {
+		tree = append(tree, build(i))
+	}
+	return tree
+}
+
+// renderCommentBodies fills BodyHTML of every comment in cc from the markdown
+// cache.
+func renderCommentBodies(cc []Comment) {
+	for i := range cc {
+		cc[i].BodyHTML = markdown.RenderCached(markdown.CommentKey(cc[i].ID), cc[i].Body)
+	}
+}
+
+// AcceptedFirst flags the comment acceptedID as accepted and moves it to the
+// front of cc, keeping the order of the others.
+func AcceptedFirst(cc []Comment, acceptedID *int) []Comment {
+	if acceptedID == nil {
+		return cc
+	}
+	for i := range cc {
+		if cc[i].ID == *acceptedID {
+			c := cc[i]
+			c.Accepted = true
+			copy(cc[1:i+1], cc[:i])
+			cc[0] = c
+			break
+		}
+	}
+	return cc
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////
-type CommentProcess struct{}
+type CommentProcess struct {
+	MaxDepth int //replies deeper than MaxDepth are rejected; 0 means no limit
+}
 
 func (cpr *CommentProcess) GetComment(db *gorm.DB, param map[string]interface{}) (Comment, *gorm.DB) {
 	c := Comment{}
 	tx := db.Where(param).First(&c)
+	if tx.Error == nil {
+		cc := []Comment{c}
+		tx.Error = loadCommentReactions(db, cc)
+		if tx.Error == nil {
+			tx.Error = loadCommentAttachments(db, cc)
+		}
+		renderCommentBodies(cc)
+		c = cc[0]
+	}
 	return c, tx
 }
 
 func (cpr *CommentProcess) ListComments(db *gorm.DB, param map[string]interface{}) ([]Comment, *gorm.DB) {
 	cc := []Comment{}
 	tx := db.Where(param).Find(&cc)
+	if tx.Error == nil {
+		tx.Error = loadCommentReactions(db, cc)
+	}
+	if tx.Error == nil {
+		tx.Error = loadCommentAttachments(db, cc)
+	}
+	renderCommentBodies(cc)
 	return cc, tx
 }
 
@@ -42,15 +142,140 @@ func (cpr *CommentProcess) CreateComment(db *gorm.DB, c *Comment) *gorm.DB {
 	if c.Email != "" && !reEmail.Match([]byte(c.Email)) {
 		return &gorm.DB{Error: gorm.ErrInvalidValue}
 	}
-	return db.Select("PostID", "UserID", "Name", "Email", "Body").Create(&c)
+	c.Depth = 0
+	if c.ParentID != nil {
+		parent := Comment{}
+		tx := db.Where(map[string]interface{}{"id": *c.ParentID, "postId": c.PostID, "deleted": false}).First(&parent)
+		if tx.Error != nil {
+			return &gorm.DB{Error: ErrInvalidParent}
+		}
+		c.Depth = parent.Depth + 1
+		if cpr.MaxDepth > 0 && c.Depth > cpr.MaxDepth {
+			return &gorm.DB{Error: ErrMaxDepth}
+		}
+	}
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		result = tx.Select("PostID", "UserID", "ParentID", "Depth", "Name", "Email", "Body", "CreatedAt", "UpdatedAt").Create(&c)
+		if result.Error != nil {
+			return result.Error
+		}
+		mpr := MentionProcess{}
+		c.Mentions, result.Error = mpr.SetMentions(tx, c.UserID, c.PostID, &c.ID, c.Body)
+		return result.Error
+	})
+	if result.Error == nil {
+		c.BodyHTML = markdown.RenderCached(markdown.CommentKey(c.ID), c.Body)
+	}
+	return result
 }
 func (cpr *CommentProcess) UpdateComment(db *gorm.DB, c *Comment) *gorm.DB {
 	reEmail := regexp.MustCompile(`^[^@]+@[^@]+\.\w{1,5}$`)
 	if c.Email != "" && !reEmail.Match([]byte(c.Email)) {
 		return &gorm.DB{Error: gorm.ErrInvalidValue}
 	}
-	return db.Model(&c).Updates(Comment{Name: c.Name, Email: c.Email, Body: c.Body})
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		result = tx.Model(&c).Updates(Comment{Name: c.Name, Email: c.Email, Body: c.Body})
+		if result.Error != nil || c.Body == "" {
+			return result.Error
+		}
+		mpr := MentionProcess{}
+		c.Mentions, result.Error = mpr.SetMentions(tx, c.UserID, c.PostID, &c.ID, c.Body)
+		return result.Error
+	})
+	markdown.Invalidate(markdown.CommentKey(c.ID))
+	if result.Error == nil && c.Body != "" {
+		c.BodyHTML = markdown.RenderCached(markdown.CommentKey(c.ID), c.Body)
+	}
+	return result
 }
+
+// DeleteComment removes a comment. A comment that still has replies is kept
+// as a tombstone with its content cleared, so the thread stays connected;
+// tombstones left without replies are removed along the way.
 func (cpr *CommentProcess) DeleteComment(db *gorm.DB, c *Comment) *gorm.DB {
-	return db.Where("userId = ?", c.UserID).Delete(&c)
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		fail := func(err error) error {
+			result.Error = err
+			return err
+		}
+		//the locked row keeps replies from being added while they are counted
+		cur := Comment{}
+		result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", c.ID).First(&cur)
+		if result.Error != nil {
+			return result.Error
+		}
+		var replies int64
+		if err := tx.Model(&Comment{}).Where("parentId = ?", c.ID).Count(&replies).Error; err != nil {
+			return fail(err)
+		}
+		if replies > 0 {
+			result = tx.Model(&c).Where("userId = ?", c.UserID).Updates(map[string]interface{}{
+				"deleted": true, "name": "", "email": "", "body": "",
+			})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			if err := unacceptComment(tx, c.ID); err != nil {
+				return fail(err)
+			}
+			if err := tx.Session(&gorm.Session{NewDB: true}).Where("commentId = ?", c.ID).Delete(&Attachment{}).Error; err != nil {
+				return fail(err)
+			}
+			mpr := MentionProcess{}
+			if err := mpr.DeleteCommentMentions(tx.Session(&gorm.Session{NewDB: true}), c.ID).Error; err != nil {
+				return fail(err)
+			}
+			return nil
+		}
+		result = tx.Where("userId = ?", c.UserID).Delete(&c)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := unacceptComment(tx, c.ID); err != nil {
+			return fail(err)
+		}
+		removed := []int{c.ID}
+		for cur.ParentID != nil {
+			parent := Comment{}
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND deleted = ?", *cur.ParentID, true).First(&parent).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				break
+			}
+			if err != nil {
+				return fail(err)
+			}
+			if err = tx.Model(&Comment{}).Where("parentId = ?", parent.ID).Count(&replies).Error; err != nil {
+				return fail(err)
+			}
+			if replies > 0 {
+				break
+			}
+			if err = tx.Delete(&parent).Error; err != nil {
+				return fail(err)
+			}
+			removed = append(removed, parent.ID)
+			cur = parent
+		}
+		vpr := VoteProcess{}
+		if err := vpr.DeleteVotes(tx, TargetComment, removed).Error; err != nil {
+			return fail(err)
+		}
+		rpr := ReactionProcess{}
+		if err := rpr.DeleteReactions(tx, TargetComment, removed).Error; err != nil {
+			return fail(err)
+		}
+		return nil
+	})
+	markdown.Invalidate(markdown.CommentKey(c.ID))
+	return result
+}
+
+// unacceptComment clears the accepted answer of the question answered by
+// comment id.
+func unacceptComment(db *gorm.DB, id int) error {
+	return db.Session(&gorm.Session{NewDB: true}).Model(&Post{}).
+		Where("acceptedCommentId = ?", id).Update("acceptedCommentId", nil).Error
 }
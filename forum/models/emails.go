@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailFailed  = "failed"
+)
+
+// EmailMessage is an email queued for user UserID with the outcome of the
+// last attempt to send it.
+type EmailMessage struct {
+	ID            int        `gorm:"column:id;primaryKey"`
+	UserID        int        `gorm:"column:userId;index"`
+	To            string     `gorm:"column:recipient;type:VARCHAR(320)"`
+	Subject       string     `gorm:"column:subject;type:VARCHAR(256)"`
+	Text          string     `gorm:"column:text;type:MEDIUMTEXT"`
+	HTML          string     `gorm:"column:html;type:MEDIUMTEXT"`
+	Unsubscribe   string     `gorm:"column:unsubscribe;type:VARCHAR(2048)"`
+	Status        string     `gorm:"column:status;type:VARCHAR(16);default:pending;index:idx_email_messages_due,priority:1"`
+	Attempts      int        `gorm:"column:attempts;default:0"`
+	Error         string     `gorm:"column:error;type:VARCHAR(512)"`
+	NextAttemptAt *time.Time `gorm:"column:nextAttemptAt;index:idx_email_messages_due,priority:2"`
+	SentAt        *time.Time `gorm:"column:sentAt"`
+	CreatedAt     time.Time  `gorm:"column:createdAt"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type EmailProcess struct{}
+
+// QueueMessage queues m to be sent right away.
+func (epr *EmailProcess) QueueMessage(db *gorm.DB, m *EmailMessage) *gorm.DB {
+	now := time.Now()
+	m.Status, m.Attempts, m.CreatedAt, m.NextAttemptAt = EmailPending, 0, now, &now
+	return db.Select("UserID", "To", "Subject", "Text", "HTML", "Unsubscribe", "Status", "Attempts", "NextAttemptAt", "CreatedAt").Create(m)
+}
+
+// DueMessages returns up to limit pending messages whose next attempt is due,
+// oldest first.
+func (epr *EmailProcess) DueMessages(db *gorm.DB, now time.Time, limit int) ([]EmailMessage, *gorm.DB) {
+	mm := []EmailMessage{}
+	tx := db.Where("status = ? AND nextAttemptAt <= ?", EmailPending, now).Order("nextAttemptAt, id").Limit(limit).Find(&mm)
+	return mm, tx
+}
+
+// SaveAttempt stores the outcome of an attempt to send m.
+func (epr *EmailProcess) SaveAttempt(db *gorm.DB, m *EmailMessage) *gorm.DB {
+	return db.Model(m).Select("Status", "Attempts", "Error", "NextAttemptAt", "SentAt").Updates(m)
+}
+
+// DigestRecipients returns the users with a verified address receiving digests whose
+// last digest was sent before the given time.
+func (epr *EmailProcess) DigestRecipients(db *gorm.DB, before time.Time) ([]User, *gorm.DB) {
+	uu := []User{}
+	tx := db.Where("emailNotifications = ? AND email <> '' AND emailVerified = ? AND (digestSentAt IS NULL OR digestSentAt < ?)", EmailDigest, true, before).
+		Order("id").Find(&uu)
+	return uu, tx
+}
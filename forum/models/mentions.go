@@ -0,0 +1,135 @@
+package models
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MaxMentions bounds the users one post or comment may mention, which keeps
+// the notifications a single body fans out to in check.
+const MaxMentions = 20
+
+var (
+	reMention   = regexp.MustCompile(`(?:^|[^\w@/])@(\w[\w.\-]*)`)
+	reCodeBlock = regexp.MustCompile("(?s)```.*?(```|$)|~~~.*?(~~~|$)")
+	reCodeSpan  = regexp.MustCompile("`[^`\n]*`")
+)
+
+type Mentions struct { //structure for response array of mentions in xml format
+	XMLName  xml.Name  `xml:"mentions" json:"-" gorm:"-"`
+	Mentions []Mention `xml:"mention"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Mention records that a post, or a comment when CommentID is set, refers to
+// a user by @login.
+type Mention struct {
+	XMLName   xml.Name  `xml:"mention" json:"-" gorm:"-"`
+	ID        int       `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	UserID    int       `json:"userId" xml:"userId" gorm:"column:userId;index"`
+	AuthorID  int       `json:"authorId" xml:"authorId" gorm:"column:authorId"`
+	PostID    int       `json:"postId" xml:"postId" gorm:"column:postId;index"`
+	CommentID *int      `json:"commentId,omitempty" xml:"commentId,omitempty" gorm:"column:commentId;index"`
+	CreatedAt time.Time `json:"createdAt" xml:"createdAt" gorm:"column:createdAt;index"`
+}
+
+// ParseMentions returns the logins mentioned in a markdown body, in order of
+// appearance and without duplicates. Code blocks and code spans are skipped,
+// as are e-mail addresses.
+func ParseMentions(body string) []string {
+	body = reCodeBlock.ReplaceAllString(body, " ")
+	body = reCodeSpan.ReplaceAllString(body, " ")
+	logins := []string{}
+	seen := make(map[string]bool)
+	for _, m := range reMention.FindAllStringSubmatch(body, -1) {
+		login := strings.TrimRight(m[1], ".-")
+		if login == "" || seen[strings.ToLower(login)] {
+			continue
+		}
+		seen[strings.ToLower(login)] = true
+		logins = append(logins, login)
+		if len(logins) == MaxMentions {
+			break
+		}
+	}
+	return logins
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type MentionProcess struct{}
+
+// SetMentions resolves the logins mentioned in body and stores them for the
+// post postID, or its comment commentID. Mentions the body no longer contains
+// are removed; the author never mentions themselves. The mentions that did not
+// exist before are returned.
+func (mpr *MentionProcess) SetMentions(db *gorm.DB, authorID, postID int, commentID *int, body string) ([]Mention, error) {
+	db = db.Session(&gorm.Session{NewDB: true})
+	userIDs := []int{}
+	if logins := ParseMentions(body); len(logins) > 0 {
+		err := db.Model(&User{}).Where("login IN ? AND id <> ?", logins, authorID).Pluck("id", &userIDs).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	target := func() *gorm.DB {
+		tx := db.Where("postId = ?", postID)
+		if commentID != nil {
+			return tx.Where("commentId = ?", *commentID)
+		}
+		return tx.Where("commentId IS NULL")
+	}
+	existing := []int{}
+	if err := target().Model(&Mention{}).Pluck("userId", &existing).Error; err != nil {
+		return nil, err
+	}
+	mentioned := make(map[int]bool, len(existing))
+	for _, id := range existing {
+		mentioned[id] = true
+	}
+	keep := make(map[int]bool, len(userIDs))
+	created := []Mention{}
+	now := time.Now()
+	for _, id := range userIDs {
+		keep[id] = true
+		if !mentioned[id] {
+			created = append(created, Mention{UserID: id, AuthorID: authorID, PostID: postID, CommentID: commentID, CreatedAt: now})
+		}
+	}
+	gone := []int{}
+	for _, id := range existing {
+		if !keep[id] {
+			gone = append(gone, id)
+		}
+	}
+	if len(gone) > 0 {
+		if err := target().Where("userId IN ?", gone).Delete(&Mention{}).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(created) > 0 {
+		if err := db.Create(&created).Error; err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// ListUserMentions returns where user userID was mentioned, newest first.
+// posts scopes the posts the reader may see.
+func (mpr *MentionProcess) ListUserMentions(db *gorm.DB, posts *gorm.DB, userID, limit int) ([]Mention, *gorm.DB) {
+	mm := []Mention{}
+	tx := db.Where("userId = ?", userID).
+		Where("postId IN (?)", posts.Model(&Post{}).Select("id")).
+		Order("createdAt DESC, id DESC").Limit(limit).Find(&mm)
+	return mm, tx
+}
+
+// DeleteCommentMentions removes the mentions of comment commentID, e.g. when
+// its body is erased.
+func (mpr *MentionProcess) DeleteCommentMentions(db *gorm.DB, commentID int) *gorm.DB {
+	return db.Where("commentId = ?", commentID).Delete(&Mention{})
+}
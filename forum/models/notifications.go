@@ -0,0 +1,93 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	NotificationComment    = "comment"    //a comment on the user's post
+	NotificationReply      = "reply"      //a reply to the user's comment
+	NotificationMention    = "mention"    //the user was mentioned by @login
+	NotificationAnswer     = "answer"     //the user's comment was accepted as the answer
+	NotificationModeration = "moderation" //a moderator or an admin acted on the user or their content
+)
+
+type Notifications struct { //structure for response of notifications with the unread count
+	XMLName       xml.Name       `xml:"notifications" json:"-" gorm:"-"`
+	Unread        int64          `json:"unread" xml:"unread,attr"`
+	Notifications []Notification `json:"notifications" xml:"notification"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Notification tells user UserID that ActorID did something concerning them.
+type Notification struct {
+	XMLName   xml.Name  `xml:"notification" json:"-" gorm:"-"`
+	ID        int       `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	UserID    int       `json:"userId" xml:"userId" gorm:"column:userId;index:idx_notifications_user,priority:1"`
+	Type      string    `json:"type" xml:"type" gorm:"column:type;type:VARCHAR(32)"`
+	ActorID   int       `json:"actorId" xml:"actorId" gorm:"column:actorId"`
+	PostID    *int      `json:"postId,omitempty" xml:"postId,omitempty" gorm:"column:postId;index"`
+	CommentID *int      `json:"commentId,omitempty" xml:"commentId,omitempty" gorm:"column:commentId;index"`
+	Details   string    `json:"details,omitempty" xml:"details,omitempty" gorm:"column:details;type:VARCHAR(256)"`
+	Read      bool      `json:"read" xml:"read" gorm:"column:isRead;default:false;index:idx_notifications_user,priority:2"`
+	CreatedAt time.Time `json:"createdAt" xml:"createdAt" gorm:"column:createdAt;type:DATETIME(3)"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type NotificationProcess struct{}
+
+func (npr *NotificationProcess) CreateNotifications(db *gorm.DB, nn []Notification) *gorm.DB {
+	if len(nn) == 0 {
+		return db
+	}
+	now := time.Now()
+	for i := range nn {
+		if nn[i].CreatedAt.IsZero() {
+			nn[i].CreatedAt = now
+		}
+	}
+	return db.Select("UserID", "Type", "ActorID", "PostID", "CommentID", "Details", "CreatedAt").Create(&nn)
+}
+
+// ListNotifications returns the notifications of user userID, newest first.
+func (npr *NotificationProcess) ListNotifications(db *gorm.DB, userID int, unreadOnly bool, limit, offset int) ([]Notification, *gorm.DB) {
+	nn := []Notification{}
+	tx := db.Where("userId = ?", userID)
+	if unreadOnly {
+		tx = tx.Where("isRead = ?", false)
+	}
+	tx = tx.Order("createdAt DESC, id DESC").Limit(limit).Offset(offset).Find(&nn)
+	return nn, tx
+}
+
+// UnreadSince returns the unread notifications of user userID created after
+// since, oldest first.
+func (npr *NotificationProcess) UnreadSince(db *gorm.DB, userID int, since time.Time, limit int) ([]Notification, *gorm.DB) {
+	nn := []Notification{}
+	tx := db.Where("userId = ? AND isRead = ? AND createdAt > ?", userID, false, since).Order("id").Limit(limit).Find(&nn)
+	return nn, tx
+}
+
+func (npr *NotificationProcess) CountUnread(db *gorm.DB, userID int) (int64, *gorm.DB) {
+	var count int64
+	tx := db.Model(&Notification{}).Where("userId = ? AND isRead = ?", userID, false).Count(&count)
+	return count, tx
+}
+
+// MarkRead marks notification id of user userID read. It fails with
+// gorm.ErrRecordNotFound if the user has no such notification.
+func (npr *NotificationProcess) MarkRead(db *gorm.DB, userID, id int) *gorm.DB {
+	n := Notification{}
+	tx := db.Where("id = ? AND userId = ?", id, userID).First(&n)
+	if tx.Error != nil || n.Read {
+		return tx
+	}
+	return db.Session(&gorm.Session{NewDB: true}).Model(&n).Update("isRead", true)
+}
+
+func (npr *NotificationProcess) MarkAllRead(db *gorm.DB, userID int) *gorm.DB {
+	return db.Model(&Notification{}).Where("userId = ? AND isRead = ?", userID, false).Update("isRead", true)
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/xml"
+
+	"gorm.io/gorm"
+)
+
+type Photos struct { //structure for response array of photos in xml format
+	XMLName xml.Name `xml:"photos" json:"-" gorm:"-"`
+	Photos  []Photo  `xml:"photo"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Photo is a link to an image in an album, as photos of JSONPlaceholder; the
+// image itself is not stored.
+type Photo struct {
+	XMLName      xml.Name `xml:"photo" json:"-" gorm:"-"`
+	AlbumID      int      `json:"albumId" xml:"albumId" gorm:"column:albumId;index"`
+	ID           int      `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	Title        string   `json:"title" xml:"title" gorm:"column:title;type:VARCHAR(512)"`
+	URL          string   `json:"url" xml:"url" gorm:"column:url;type:VARCHAR(2048)"`
+	ThumbnailURL string   `json:"thumbnailUrl" xml:"thumbnailUrl" gorm:"column:thumbnailUrl;type:VARCHAR(2048)"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type PhotoProcess struct{}
+
+func (ppr *PhotoProcess) GetPhoto(db *gorm.DB, param map[string]interface{}) (Photo, *gorm.DB) {
+	p := Photo{}
+	tx := db.Where(param).First(&p)
+	return p, tx
+}
+
+func (ppr *PhotoProcess) ListPhotos(db *gorm.DB, param map[string]interface{}) ([]Photo, *gorm.DB) {
+	pp := []Photo{}
+	tx := db.Where(param).Order("id").Find(&pp)
+	return pp, tx
+}
+
+func (ppr *PhotoProcess) CreatePhoto(db *gorm.DB, p *Photo) *gorm.DB {
+	return db.Select("AlbumID", "Title", "URL", "ThumbnailURL").Create(&p)
+}
+
+// UpdatePhoto stores the title and links of p and moves it to p.AlbumID.
+func (ppr *PhotoProcess) UpdatePhoto(db *gorm.DB, p *Photo) *gorm.DB {
+	return db.Model(&p).Select("AlbumID", "Title", "URL", "ThumbnailURL").Updates(p)
+}
+
+func (ppr *PhotoProcess) DeletePhoto(db *gorm.DB, p *Photo) *gorm.DB {
+	return db.Delete(&p)
+}
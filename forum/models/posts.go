@@ -2,10 +2,24 @@ package models
 
 import (
 	"encoding/xml"
+	"errors"
+	"nx_trainee_forum/forum/markdown"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+const (
+	PostTypeDiscussion = "discussion"
+	PostTypeQuestion   = "question"
+)
+
+var (
+	ErrInvalidPostType = errors.New("unknown post type")
+	ErrNotQuestion     = errors.New("post is not a question")
+	ErrInvalidAnswer   = errors.New("answer must be a top-level comment of the question")
+)
+
 type Posts struct { //structure for response array of posts in xml format
 	XMLName xml.Name `xml:"posts" json:"-" gorm:"-"`
 	Posts   []Post   `xml:"post"`
@@ -13,11 +27,36 @@ type Posts struct { //structure for response array of posts in xml format
 
 /////////////////////////////////////////////////////////////////////////////////////////
 type Post struct {
-	UserID   int       `json:"userId" gorm:"column:userId"`
-	ID       int       `json:"id" gorm:"column:id;primaryKey"`
-	Title    string    `json:"title" gorm:"column:title;type:VARCHAR(256)"`
-	Body     string    `json:"body" gorm:"column:body;type:VARCHAR(256)"`
-	Comments []Comment `xml:"-" json:"-" gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	UserID      int             `json:"userId" gorm:"column:userId"`
+	ID          int             `json:"id" gorm:"column:id;primaryKey"`
+	CategoryID  *int            `json:"categoryId,omitempty" xml:"categoryId,omitempty" gorm:"column:categoryId;index"`
+	Title       string          `json:"title" gorm:"column:title;type:VARCHAR(512)"`
+	Body        string          `json:"body" gorm:"column:body;type:MEDIUMTEXT"`
+	BodyHTML    string          `json:"bodyHtml" xml:"bodyHtml" gorm:"-"`
+	Type        string          `json:"type" xml:"type" gorm:"column:type;type:VARCHAR(16);default:discussion;index"`
+	AcceptedID  *int            `json:"acceptedCommentId,omitempty" xml:"acceptedCommentId,omitempty" gorm:"column:acceptedCommentId;index"`
+	Tags        []string        `json:"tags" xml:"tags>tag" gorm:"-"`
+	Reactions   []ReactionCount `json:"reactions" xml:"reactions>reaction" gorm:"-"`
+	Attachments []Attachment    `json:"attachments" xml:"attachments>attachment" gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Score       int             `json:"score" xml:"score" gorm:"column:score;default:0;index"`
+	CreatedAt   time.Time       `json:"createdAt" xml:"createdAt" gorm:"column:createdAt;type:DATETIME(3);default:CURRENT_TIMESTAMP(3)"`
+	UpdatedAt   time.Time       `json:"updatedAt" xml:"updatedAt" gorm:"column:updatedAt;type:DATETIME(3);default:CURRENT_TIMESTAMP(3)"`
+	Comments    []Comment       `xml:"-" json:"-" gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	PostTags    []PostTag       `xml:"-" json:"-" gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// Mentions holds the mentions added by the last create or update.
+	Mentions      []Mention      `xml:"-" json:"-" gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Notifications []Notification `xml:"-" json:"-" gorm:"foreignKey:PostID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// renderPostBodies fills BodyHTML of every post in pp from the markdown cache.
+func renderPostBodies(pp []Post) {
+	for i := range pp {
+		pp[i].BodyHTML = markdown.RenderCached(markdown.PostKey(pp[i].ID), pp[i].Body)
+	}
+}
+
+func ValidPostType(t string) bool {
+	return t == PostTypeDiscussion || t == PostTypeQuestion
 }
 
 /////////////////////////////////////////////////////////////////////////////////////////
@@ -26,23 +65,174 @@ type PostProcess struct{}
 func (ppr *PostProcess) GetPost(db *gorm.DB, param map[string]interface{}) (Post, *gorm.DB) {
 	p := Post{}
 	tx := db.Where(param).First(&p)
+	if tx.Error == nil {
+		pp := []Post{p}
+		tx.Error = loadPostTags(db, pp)
+		if tx.Error == nil {
+			tx.Error = loadPostReactions(db, pp)
+		}
+		if tx.Error == nil {
+			tx.Error = loadPostAttachments(db, pp)
+		}
+		renderPostBodies(pp)
+		p = pp[0]
+	}
 	return p, tx
 }
 
 func (ppr *PostProcess) ListPosts(db *gorm.DB, param map[string]interface{}) ([]Post, *gorm.DB) {
 	pp := []Post{}
 	tx := db.Where(param).Find(&pp)
+	if tx.Error == nil {
+		tx.Error = loadPostTags(db, pp)
+	}
+	if tx.Error == nil {
+		tx.Error = loadPostReactions(db, pp)
+	}
+	if tx.Error == nil {
+		tx.Error = loadPostAttachments(db, pp)
+	}
+	renderPostBodies(pp)
 	return pp, tx
 }
 
+// ListTaggedPosts lists posts carrying the tag slug.
+func (ppr *PostProcess) ListTaggedPosts(db *gorm.DB, slug string) ([]Post, *gorm.DB) {
+	tx := db.Select("posts.*").
+		Joins("JOIN post_tags ON post_tags.postId = posts.id").
+		Joins("JOIN tags ON tags.id = post_tags.tagId").
+		Where("tags.slug = ?", slug)
+	return ppr.ListPosts(tx, map[string]interface{}{})
+}
+
 func (ppr *PostProcess) CreatePost(db *gorm.DB, p *Post) *gorm.DB {
-	return db.Select("UserID", "Title", "Body").Create(&p)
+	if p.Type == "" {
+		p.Type = PostTypeDiscussion
+	}
+	if !ValidPostType(p.Type) {
+		return &gorm.DB{Error: ErrInvalidPostType}
+	}
+	slugs, err := NormalizeTags(p.Tags)
+	if err != nil {
+		return &gorm.DB{Error: err}
+	}
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		result = tx.Select("UserID", "CategoryID", "Type", "Title", "Body", "CreatedAt", "UpdatedAt").Create(&p)
+		if result.Error != nil {
+			return result.Error
+		}
+		tpr := TagProcess{}
+		if result.Error = tpr.SetPostTags(tx, p.ID, slugs); result.Error != nil {
+			return result.Error
+		}
+		mpr := MentionProcess{}
+		p.Mentions, result.Error = mpr.SetMentions(tx, p.UserID, p.ID, nil, p.Body)
+		return result.Error
+	})
+	p.Tags = slugs
+	//a failed insert leaves no post to cache the body of
+	if result.Error == nil {
+		p.BodyHTML = markdown.RenderCached(markdown.PostKey(p.ID), p.Body)
+	}
+	return result
 }
 
+// UpdatePost updates the non-empty fields of p. Tags are replaced only when
+// p.Tags is not nil, so an empty list clears them. Turning a question into a
+// discussion drops its accepted answer.
 func (ppr *PostProcess) UpdatePost(db *gorm.DB, p *Post) *gorm.DB {
-	return db.Model(&p).Updates(Post{Title: p.Title, Body: p.Body})
+	if p.Type != "" && !ValidPostType(p.Type) {
+		return &gorm.DB{Error: ErrInvalidPostType}
+	}
+	var slugs []string
+	if p.Tags != nil {
+		var err error
+		if slugs, err = NormalizeTags(p.Tags); err != nil {
+			return &gorm.DB{Error: err}
+		}
+	}
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		result = tx.Model(&p).Updates(Post{CategoryID: p.CategoryID, Type: p.Type, Title: p.Title, Body: p.Body})
+		if result.Error != nil {
+			return result.Error
+		}
+		if p.Type == PostTypeDiscussion {
+			if err := tx.Model(&Post{}).Where("id = ?", p.ID).Update("acceptedCommentId", nil).Error; err != nil {
+				result.Error = err
+				return err
+			}
+		}
+		if p.Body != "" {
+			mpr := MentionProcess{}
+			if p.Mentions, result.Error = mpr.SetMentions(tx, p.UserID, p.ID, nil, p.Body); result.Error != nil {
+				return result.Error
+			}
+		}
+		if slugs == nil {
+			return result.Error
+		}
+		tpr := TagProcess{}
+		result.Error = tpr.SetPostTags(tx, p.ID, slugs)
+		return result.Error
+	})
+	markdown.Invalidate(markdown.PostKey(p.ID))
+	if result.Error == nil && p.Body != "" {
+		p.BodyHTML = markdown.RenderCached(markdown.PostKey(p.ID), p.Body)
+	}
+	if slugs != nil {
+		p.Tags = slugs
+	}
+	return result
+}
+
+// AcceptAnswer marks the comment commentID as the answer to the question p;
+// nil clears the accepted answer. Only undeleted top-level comments of the
+// post can be accepted.
+func (ppr *PostProcess) AcceptAnswer(db *gorm.DB, p *Post, commentID *int) *gorm.DB {
+	if p.Type != PostTypeQuestion {
+		return &gorm.DB{Error: ErrNotQuestion}
+	}
+	if commentID != nil {
+		c := Comment{}
+		tx := db.Where("id = ? AND postId = ? AND deleted = ? AND parentId IS NULL", *commentID, p.ID, false).First(&c)
+		if tx.Error != nil {
+			return &gorm.DB{Error: ErrInvalidAnswer}
+		}
+	}
+	p.AcceptedID = commentID
+	return db.Model(&Post{}).Where("id = ?", p.ID).Update("acceptedCommentId", commentID)
 }
 
 func (ppr *PostProcess) DeletePost(db *gorm.DB, p *Post) *gorm.DB {
-	return db.Where("userId = ?", p.UserID).Delete(&p)
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		commentIDs := []int{}
+		tx.Model(&Comment{}).Where("postId = ?", p.ID).Pluck("id", &commentIDs)
+		result = tx.Where("userId = ?", p.UserID).Delete(&p)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		markdown.Invalidate(markdown.PostKey(p.ID))
+		for _, id := range commentIDs {
+			markdown.Invalidate(markdown.CommentKey(id))
+		}
+		vpr := VoteProcess{}
+		if err := vpr.DeleteVotes(tx, TargetPost, []int{p.ID}).Error; err != nil {
+			return err
+		}
+		rpr := ReactionProcess{}
+		if err := rpr.DeleteReactions(tx, TargetPost, []int{p.ID}).Error; err != nil {
+			return err
+		}
+		if len(commentIDs) > 0 {
+			if err := vpr.DeleteVotes(tx, TargetComment, commentIDs).Error; err != nil {
+				return err
+			}
+			return rpr.DeleteReactions(tx, TargetComment, commentIDs).Error
+		}
+		return nil
+	})
+	return result
 }
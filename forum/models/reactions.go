@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidReaction = errors.New("reaction is not allowed")
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type Reaction struct {
+	UserID     int       `json:"userId" gorm:"column:userId;primaryKey"`
+	TargetType string    `json:"targetType" gorm:"column:targetType;type:VARCHAR(16);primaryKey"`
+	TargetID   int       `json:"targetId" gorm:"column:targetId;primaryKey;index"`
+	Emoji      string    `json:"emoji" gorm:"column:emoji;type:VARCHAR(32);primaryKey"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"column:createdAt"`
+}
+
+type ReactionCount struct {
+	Emoji string `json:"emoji" xml:"emoji"`
+	Count int    `json:"count" xml:"count"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type ReactionProcess struct {
+	Allowed []string //reactions users may add; empty allows none
+}
+
+func (rpr *ReactionProcess) allowed(emoji string) bool {
+	for _, a := range rpr.Allowed {
+		if a == emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// AddReaction stores the reaction unless the user already left the same one
+// on the target.
+func (rpr *ReactionProcess) AddReaction(db *gorm.DB, r *Reaction) *gorm.DB {
+	if !rpr.allowed(r.Emoji) {
+		return &gorm.DB{Error: ErrInvalidReaction}
+	}
+	r.CreatedAt = time.Now()
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(r)
+}
+
+func (rpr *ReactionProcess) RemoveReaction(db *gorm.DB, r *Reaction) *gorm.DB {
+	return db.Where("userId = ? AND targetType = ? AND targetId = ? AND emoji = ?", r.UserID, r.TargetType, r.TargetID, r.Emoji).
+		Delete(&Reaction{})
+}
+
+// CountReactions returns the reaction counts of every target in ids, keyed by
+// target ID.
+func (rpr *ReactionProcess) CountReactions(db *gorm.DB, targetType string, ids []int) (map[int][]ReactionCount, error) {
+	counts := make(map[int][]ReactionCount, len(ids))
+	if len(ids) == 0 {
+		return counts, nil
+	}
+	rows := []struct {
+		TargetID int    `gorm:"column:targetId"`
+		Emoji    string `gorm:"column:emoji"`
+		Count    int    `gorm:"column:count"`
+	}{}
+	err := db.Session(&gorm.Session{NewDB: true}).Model(&Reaction{}).
+		Select("targetId, emoji, COUNT(*) AS count").
+		Where("targetType = ? AND targetId IN ?", targetType, ids).
+		Group("targetId, emoji").Order("count DESC").Order("emoji").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.TargetID] = append(counts[row.TargetID], ReactionCount{Emoji: row.Emoji, Count: row.Count})
+	}
+	return counts, nil
+}
+
+// DeleteReactions drops every reaction on the given targets.
+func (rpr *ReactionProcess) DeleteReactions(db *gorm.DB, targetType string, ids []int) *gorm.DB {
+	return db.Where("targetType = ? AND targetId IN ?", targetType, ids).Delete(&Reaction{})
+}
+
+// loadPostReactions fills Reactions of every post in pp with a single query.
+func loadPostReactions(db *gorm.DB, pp []Post) error {
+	ids := make([]int, len(pp))
+	for i := range pp {
+		ids[i] = pp[i].ID
+	}
+	rpr := ReactionProcess{}
+	counts, err := rpr.CountReactions(db, TargetPost, ids)
+	if err != nil {
+		return err
+	}
+	for i := range pp {
+		pp[i].Reactions = counts[pp[i].ID]
+		if pp[i].Reactions == nil {
+			pp[i].Reactions = []ReactionCount{}
+		}
+	}
+	return nil
+}
+
+// loadCommentReactions fills Reactions of every comment in cc with a single
+// query.
+func loadCommentReactions(db *gorm.DB, cc []Comment) error {
+	ids := make([]int, len(cc))
+	for i := range cc {
+		ids[i] = cc[i].ID
+	}
+	rpr := ReactionProcess{}
+	counts, err := rpr.CountReactions(db, TargetComment, ids)
+	if err != nil {
+		return err
+	}
+	for i := range cc {
+		cc[i].Reactions = counts[cc[i].ID]
+		if cc[i].Reactions == nil {
+			cc[i].Reactions = []ReactionCount{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxTagsPerPost = 5
+	MaxTagLength   = 32
+)
+
+var (
+	ErrTooManyTags = errors.New("too many tags")
+	ErrTagExists   = errors.New("tag already exists")
+)
+
+type Tags struct { //structure for response array of tags in xml format
+	XMLName xml.Name   `xml:"tags" json:"-" gorm:"-"`
+	Tags    []TagCount `xml:"tag"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type Tag struct {
+	ID       int       `json:"id" gorm:"column:id;primaryKey"`
+	Slug     string    `json:"slug" gorm:"column:slug;type:VARCHAR(64);unique"`
+	PostTags []PostTag `xml:"-" json:"-" gorm:"foreignKey:TagID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// PostTag is the join table between posts and tags.
+type PostTag struct {
+	PostID int `gorm:"column:postId;primaryKey"`
+	TagID  int `gorm:"column:tagId;primaryKey;index"`
+}
+
+type TagCount struct {
+	Slug  string `json:"slug" xml:"slug"`
+	Count int    `json:"count" xml:"count"`
+}
+
+// NormalizeTag turns free-form input into a slug: lowercase letters and
+// digits, with every other run of characters replaced by a single dash.
+func NormalizeTag(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '+' || r == '#' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	slug := []rune(b.String())
+	if len(slug) > MaxTagLength {
+		slug = slug[:MaxTagLength]
+	}
+	return strings.TrimRight(string(slug), "-")
+}
+
+// NormalizeTags normalizes tags, dropping empty and duplicate slugs.
+func NormalizeTags(tags []string) ([]string, error) {
+	slugs := []string{}
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		slug := NormalizeTag(t)
+		if slug == "" || seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		slugs = append(slugs, slug)
+	}
+	if len(slugs) > MaxTagsPerPost {
+		return nil, ErrTooManyTags
+	}
+	return slugs, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type TagProcess struct{}
+
+// ListTags returns tags with the number of posts using them, most used first.
+// Posts in the hidden categories are not counted, and tags used by no other
+// post are left out.
+func (tpr *TagProcess) ListTags(db *gorm.DB, prefix string, limit int, hidden []int) ([]TagCount, *gorm.DB) {
+	tt := []TagCount{}
+	tx := db.Table("tags").
+		Select("tags.slug AS slug, COUNT(post_tags.postId) AS count").
+		Joins("JOIN post_tags ON post_tags.tagId = tags.id").
+		Joins("JOIN posts ON posts.id = post_tags.postId").
+		Group("tags.id").Order("count DESC").Order("slug")
+	if len(hidden) > 0 {
+		tx = tx.Where("(posts.categoryId IS NULL OR posts.categoryId NOT IN ?)", hidden)
+	}
+	if prefix != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)
+		tx = tx.Where("tags.slug LIKE ?", escaped+"%")
+	}
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	tx = tx.Scan(&tt)
+	return tt, tx
+}
+
+func (tpr *TagProcess) GetTag(db *gorm.DB, param map[string]interface{}) (Tag, *gorm.DB) {
+	t := Tag{}
+	tx := db.Where(param).First(&t)
+	return t, tx
+}
+
+// RenameTag changes the slug of t. Renaming onto an existing slug fails with
+// ErrTagExists; MergeTags is meant for that.
+func (tpr *TagProcess) RenameTag(db *gorm.DB, t *Tag, slug string) *gorm.DB {
+	var n int64
+	if tx := db.Model(&Tag{}).Where("slug = ? AND id <> ?", slug, t.ID).Count(&n); tx.Error != nil {
+		return tx
+	}
+	if n > 0 {
+		return &gorm.DB{Error: ErrTagExists}
+	}
+	t.Slug = slug
+	return db.Model(&t).Updates(Tag{Slug: slug})
+}
+
+// MergeTags moves every post of from to into and deletes from.
+func (tpr *TagProcess) MergeTags(db *gorm.DB, from, into *Tag) *gorm.DB {
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		result = tx.Exec("INSERT IGNORE INTO post_tags (postId, tagId) SELECT postId, ? FROM post_tags WHERE tagId = ?", into.ID, from.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Delete(&from)
+		return result.Error
+	})
+	return result
+}
+
+// SetPostTags replaces the tags of post postID with slugs, creating missing tags.
+func (tpr *TagProcess) SetPostTags(db *gorm.DB, postID int, slugs []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("postId = ?", postID).Delete(&PostTag{}).Error; err != nil {
+			return err
+		}
+		for _, slug := range slugs {
+			t := Tag{Slug: slug}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Select("Slug").Create(&t).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("slug = ?", slug).First(&t).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&PostTag{PostID: postID, TagID: t.ID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// loadPostTags fills Tags of every post in pp with a single query.
+func loadPostTags(db *gorm.DB, pp []Post) error {
+	if len(pp) == 0 {
+		return nil
+	}
+	ids := make([]int, len(pp))
+	index := make(map[int]int, len(pp))
+	for i := range pp {
+		ids[i] = pp[i].ID
+		index[pp[i].ID] = i
+		pp[i].Tags = []string{}
+	}
+	rows := []struct {
+		PostID int    `gorm:"column:postId"`
+		Slug   string `gorm:"column:slug"`
+	}{}
+	err := db.Session(&gorm.Session{NewDB: true}).Table("post_tags").
+		Select("post_tags.postId, tags.slug").
+		Joins("JOIN tags ON tags.id = post_tags.tagId").
+		Where("post_tags.postId IN ?", ids).Order("tags.slug").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		i := index[row.PostID]
+		pp[i].Tags = append(pp[i].Tags, row.Slug)
+	}
+	return nil
+}
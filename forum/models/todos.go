@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/xml"
+
+	"gorm.io/gorm"
+)
+
+type Todos struct { //structure for response array of todos in xml format
+	XMLName xml.Name `xml:"todos" json:"-" gorm:"-"`
+	Todos   []Todo   `xml:"todo"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Todo is a task of a user, as todos of JSONPlaceholder.
+type Todo struct {
+	XMLName   xml.Name `xml:"todo" json:"-" gorm:"-"`
+	UserID    int      `json:"userId" xml:"userId" gorm:"column:userId;index"`
+	ID        int      `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	Title     string   `json:"title" xml:"title" gorm:"column:title;type:VARCHAR(512)"`
+	Completed bool     `json:"completed" xml:"completed" gorm:"column:completed"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type TodoProcess struct{}
+
+func (tpr *TodoProcess) GetTodo(db *gorm.DB, param map[string]interface{}) (Todo, *gorm.DB) {
+	t := Todo{}
+	tx := db.Where(param).First(&t)
+	return t, tx
+}
+
+func (tpr *TodoProcess) ListTodos(db *gorm.DB, param map[string]interface{}) ([]Todo, *gorm.DB) {
+	tt := []Todo{}
+	tx := db.Where(param).Order("id").Find(&tt)
+	return tt, tx
+}
+
+func (tpr *TodoProcess) CreateTodo(db *gorm.DB, t *Todo) *gorm.DB {
+	return db.Select("UserID", "Title", "Completed").Create(&t)
+}
+
+// UpdateTodo stores the title and state of t; false is stored too.
+func (tpr *TodoProcess) UpdateTodo(db *gorm.DB, t *Todo) *gorm.DB {
+	return db.Model(&t).Select("Title", "Completed").Updates(t)
+}
+
+func (tpr *TodoProcess) DeleteTodo(db *gorm.DB, t *Todo) *gorm.DB {
+	return db.Delete(&t)
+}
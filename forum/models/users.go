@@ -2,27 +2,274 @@ package models
 
 import (
 	"encoding/xml"
+	"strconv"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+const (
+	RoleUser      = "user"
+	RoleMentor    = "mentor"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Email notification preferences.
+const (
+	EmailImmediate = "immediate" //a message for every notification
+	EmailDigest    = "digest"    //a daily message with the unread notifications
+	EmailOff       = "off"
+)
+
+// Profile visibility settings.
+const (
+	ProfilePublic  = "public"  //everyone, including guests
+	ProfileMembers = "members" //logged in users
+	ProfilePrivate = "private" //the user and moderators only
+)
+
+var roleRank = map[string]int{RoleUser: 0, RoleMentor: 1, RoleModerator: 2, RoleAdmin: 3}
+
+type Users struct { //structure for response array of users in xml format
+	XMLName xml.Name `xml:"users" json:"-" gorm:"-"`
+	Users   []User   `xml:"user"`
+}
+
 type User struct {
-	XMLName     xml.Name  `xml:"user" json:"-" gorm:"-"`
-	ID          int       `json:"id" xml:"id" gorm:"column:id;primaryKey"`
-	Login       string    `json:"login" xml:"login" gorm:"column:login;unique"`
-	Provider    string    `json:"-" xml:"-" gorm:"column:provider"`
-	Name        string    `json:"name" xml:"name" gorm:"column:name"`
-	AccessToken string    `json:"-" xml:"-" gorm:"column:access_token"`
-	APIKey      string    `json:"-" xml:"-" gorm:"column:apikey"`
-	Posts       []Post    `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
-	Comments    []Comment `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	XMLName     xml.Name `xml:"user" json:"-" gorm:"-"`
+	ID          int      `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	Login       string   `json:"login" xml:"login" gorm:"column:login;unique"`
+	Provider    string   `json:"-" xml:"-" gorm:"column:provider"`
+	Name        string   `json:"name" xml:"name" gorm:"column:name"`
+	Role        string   `json:"role" xml:"role" gorm:"column:role;type:VARCHAR(16);default:user"`
+	AccessToken string   `json:"-" xml:"-" gorm:"column:access_token"`
+	APIKey      string   `json:"-" xml:"-" gorm:"column:apikey"`
+
+	Email              string     `json:"-" xml:"-" gorm:"column:email;type:VARCHAR(320)"`
+	EmailVerified      bool       `json:"-" xml:"-" gorm:"column:emailVerified"` //the user confirmed the address by the link mailed to it
+	EmailNotifications string     `json:"-" xml:"-" gorm:"column:emailNotifications;type:VARCHAR(16);default:off"`
+	DigestSentAt       *time.Time `json:"-" xml:"-" gorm:"column:digestSentAt"`
+	Language           string     `json:"-" xml:"-" gorm:"column:language;type:VARCHAR(8)"` //preferred UI language, empty to negotiate
+
+	Bio               string `json:"-" xml:"-" gorm:"column:bio;type:TEXT"`
+	AvatarID          *int   `json:"-" xml:"-" gorm:"column:avatarId"` //attachment without post or comment
+	ProfileVisibility string `json:"-" xml:"-" gorm:"column:profileVisibility;type:VARCHAR(16);default:public"`
+	ShowEmail         bool   `json:"-" xml:"-" gorm:"column:showEmail"`
+
+	DeleteAt *time.Time `json:"-" xml:"-" gorm:"column:deleteAt;index"` //requested deletion of the account, once the grace period ends
+
+	//contact details shown in the profile when set, as in JSONPlaceholder users
+	Phone       string `json:"-" xml:"-" gorm:"column:phone;type:VARCHAR(64)"`
+	Website     string `json:"-" xml:"-" gorm:"column:website;type:VARCHAR(256)"`
+	Street      string `json:"-" xml:"-" gorm:"column:street;type:VARCHAR(256)"`
+	Suite       string `json:"-" xml:"-" gorm:"column:suite;type:VARCHAR(256)"`
+	City        string `json:"-" xml:"-" gorm:"column:city;type:VARCHAR(256)"`
+	Zipcode     string `json:"-" xml:"-" gorm:"column:zipcode;type:VARCHAR(64)"`
+	Lat         string `json:"-" xml:"-" gorm:"column:lat;type:VARCHAR(64)"`
+	Lng         string `json:"-" xml:"-" gorm:"column:lng;type:VARCHAR(64)"`
+	CompanyName string `json:"-" xml:"-" gorm:"column:companyName;type:VARCHAR(256)"`
+	CatchPhrase string `json:"-" xml:"-" gorm:"column:catchPhrase;type:VARCHAR(256)"`
+	BS          string `json:"-" xml:"-" gorm:"column:bs;type:VARCHAR(256)"`
+
+	Posts    []Post    `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Comments []Comment `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Mentions      []Mention      `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Notifications []Notification `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	EmailMessages []EmailMessage `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+
+	Albums []Album `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Todos  []Todo  `xml:"-" json:"-" gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// Profile is what others see of a user; Email is set only if the user shows
+// it. Username, Address, Phone, Website and Company complete the user shape
+// of JSONPlaceholder.
+type Profile struct {
+	XMLName      xml.Name `xml:"user" json:"-"`
+	ID           int      `json:"id" xml:"id"`
+	Login        string   `json:"login" xml:"login"`
+	Username     string   `json:"username" xml:"username"` //same as Login
+	Name         string   `json:"name" xml:"name"`
+	Role         string   `json:"role" xml:"role"`
+	Bio          string   `json:"bio" xml:"bio"`
+	Email        string   `json:"email,omitempty" xml:"email,omitempty"`
+	Address      *Address `json:"address,omitempty" xml:"address,omitempty"`
+	Phone        string   `json:"phone,omitempty" xml:"phone,omitempty"`
+	Website      string   `json:"website,omitempty" xml:"website,omitempty"`
+	Company      *Company `json:"company,omitempty" xml:"company,omitempty"`
+	Avatar       *Avatar  `json:"avatar,omitempty" xml:"avatar,omitempty"`
+	PostCount    int64    `json:"postCount" xml:"postCount"`
+	CommentCount int64    `json:"commentCount" xml:"commentCount"`
+}
+
+type Address struct {
+	Street  string `json:"street" xml:"street"`
+	Suite   string `json:"suite" xml:"suite"`
+	City    string `json:"city" xml:"city"`
+	Zipcode string `json:"zipcode" xml:"zipcode"`
+	Geo     Geo    `json:"geo" xml:"geo"`
+}
+
+type Geo struct {
+	Lat string `json:"lat" xml:"lat"`
+	Lng string `json:"lng" xml:"lng"`
+}
+
+type Company struct {
+	Name        string `json:"name" xml:"name"`
+	CatchPhrase string `json:"catchPhrase" xml:"catchPhrase"`
+	BS          string `json:"bs" xml:"bs"`
+}
+
+type Profiles struct { //structure for response array of profiles in xml format
+	XMLName  xml.Name  `xml:"users" json:"-"`
+	Profiles []Profile `xml:"user"`
+}
+
+type Avatar struct {
+	URL        string      `json:"url" xml:"url"`
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty" xml:"thumbnails>thumbnail,omitempty"`
+}
+
+// Profile returns the profile of the user without the counts.
+func (u *User) Profile() Profile {
+	p := Profile{ID: u.ID, Login: u.Login, Username: u.Login, Name: u.Name, Role: u.Role, Bio: u.Bio,
+		Address: u.Address(), Phone: u.Phone, Website: u.Website, Company: u.Company()}
+	if u.ShowEmail {
+		p.Email = u.Email
+	}
+	if u.AvatarID != nil {
+		p.Avatar = &Avatar{URL: "/users/" + strconv.Itoa(u.ID) + "/avatar"}
+	}
+	return p
+}
+
+// Address returns the postal address of the user, or nil if none is set.
+func (u *User) Address() *Address {
+	a := Address{Street: u.Street, Suite: u.Suite, City: u.City, Zipcode: u.Zipcode, Geo: Geo{Lat: u.Lat, Lng: u.Lng}}
+	if a == (Address{}) {
+		return nil
+	}
+	return &a
+}
+
+// SetAddress replaces the postal address of the user.
+func (u *User) SetAddress(a *Address) {
+	u.Street, u.Suite, u.City, u.Zipcode, u.Lat, u.Lng = a.Street, a.Suite, a.City, a.Zipcode, a.Geo.Lat, a.Geo.Lng
+}
+
+// Company returns the employer of the user, or nil if none is set.
+func (u *User) Company() *Company {
+	c := Company{Name: u.CompanyName, CatchPhrase: u.CatchPhrase, BS: u.BS}
+	if c == (Company{}) {
+		return nil
+	}
+	return &c
+}
+
+// SetCompany replaces the employer of the user.
+func (u *User) SetCompany(c *Company) {
+	u.CompanyName, u.CatchPhrase, u.BS = c.Name, c.CatchPhrase, c.BS
+}
+
+// ProfileVisibleTo reports whether viewer, possibly a guest, may see the
+// profile and activity lists of the user.
+func (u *User) ProfileVisibleTo(viewer User) bool {
+	switch {
+	case u.ID == viewer.ID, viewer.HasRole(RoleModerator):
+		return true
+	case u.ProfileVisibility == ProfileMembers:
+		return viewer.ID != 0
+	case u.ProfileVisibility == ProfilePrivate:
+		return false
+	}
+	return true
+}
+
+// HiddenVisibilities returns the profile visibilities of the users whose
+// profiles viewer may not see, as ProfileVisibleTo decides for others.
+func HiddenVisibilities(viewer User) []string {
+	switch {
+	case viewer.HasRole(RoleModerator):
+		return nil
+	case viewer.ID == 0:
+		return []string{ProfileMembers, ProfilePrivate}
+	}
+	return []string{ProfilePrivate}
+}
+
+func ValidProfileVisibility(v string) bool {
+	return v == ProfilePublic || v == ProfileMembers || v == ProfilePrivate
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type UserProcess struct{}
+
+// ListProfiles returns the users whose profiles viewer may see, by ID.
+func (upr *UserProcess) ListProfiles(db *gorm.DB, viewer User) ([]User, *gorm.DB) {
+	uu := []User{}
+	switch {
+	case viewer.HasRole(RoleModerator):
+	case viewer.ID != 0:
+		db = db.Where("profileVisibility IN ? OR id = ?", []string{ProfilePublic, ProfileMembers}, viewer.ID)
+	default:
+		db = db.Where("profileVisibility = ?", ProfilePublic)
+	}
+	tx := db.Order("id").Find(&uu)
+	return uu, tx
+}
+
+// DueDeletions returns the users whose deletion is due at now.
+func (upr *UserProcess) DueDeletions(db *gorm.DB, now time.Time) ([]User, *gorm.DB) {
+	uu := []User{}
+	tx := db.Where("deleteAt <= ?", now).Order("id").Find(&uu)
+	return uu, tx
+}
+
+// CountActivity fills the post and comment counts of pp, profiles of users.
+// Posts are counted in posts, a scope of the posts table, and comments that
+// are not deleted in comments, a scope of the comments table.
+func (upr *UserProcess) CountActivity(posts, comments *gorm.DB, pp []Profile) error {
+	if len(pp) == 0 {
+		return nil
+	}
+	ids := make([]int, len(pp))
+	index := make(map[int]int, len(pp))
+	for i := range pp {
+		ids[i] = pp[i].ID
+		index[pp[i].ID] = i
+	}
+	type count struct {
+		UserID int   `gorm:"column:userId"`
+		N      int64 `gorm:"column:n"`
+	}
+	var cc []count
+	err := posts.Model(&Post{}).Select("userId, COUNT(*) AS n").Where("userId IN ?", ids).Group("userId").Scan(&cc).Error
+	if err != nil {
+		return err
+	}
+	for _, c := range cc {
+		pp[index[c.UserID]].PostCount = c.N
+	}
+	cc = nil
+	err = comments.Model(&Comment{}).Select("userId, COUNT(*) AS n").Where("userId IN ? AND deleted = ?", ids, false).
+		Group("userId").Scan(&cc).Error
+	if err != nil {
+		return err
+	}
+	for _, c := range cc {
+		pp[index[c.UserID]].CommentCount = c.N
+	}
+	return nil
 }
 
 func (u *User) GetUser(db *gorm.DB, params map[string]interface{}) *gorm.DB {
 	return db.Where(params).First(&u)
 }
 func (u *User) CreateUser(db *gorm.DB) *gorm.DB {
-	return db.Select("Login", "Provider", "Name", "AccessToken", "APIKey").Create(&u)
+	return db.Select("Login", "Provider", "Name", "Email", "AccessToken", "APIKey").Create(&u)
 }
 func (u *User) UpdateAccessToken(db *gorm.DB) *gorm.DB {
 	return db.Model(&u).Updates(User{AccessToken: u.AccessToken})
@@ -30,3 +277,73 @@ func (u *User) UpdateAccessToken(db *gorm.DB) *gorm.DB {
 func (u *User) UpdAPIKey(db *gorm.DB) *gorm.DB {
 	return db.Model(&u).Updates(User{APIKey: u.APIKey})
 }
+
+func (u *User) UpdateRole(db *gorm.DB) *gorm.DB {
+	return db.Model(&u).Updates(User{Role: u.Role})
+}
+
+// UpdateEmailSettings stores the email address, whether it is verified and
+// the notification preference; an empty address is stored too.
+func (u *User) UpdateEmailSettings(db *gorm.DB) *gorm.DB {
+	return db.Model(&u).Select("Email", "EmailVerified", "EmailNotifications").Updates(u)
+}
+
+// UpdateProfile stores the display name, bio, contact details and privacy
+// settings.
+func (u *User) UpdateProfile(db *gorm.DB) *gorm.DB {
+	return db.Model(&u).Select("Name", "Bio", "ProfileVisibility", "ShowEmail", "Phone", "Website",
+		"Street", "Suite", "City", "Zipcode", "Lat", "Lng", "CompanyName", "CatchPhrase", "BS").Updates(u)
+}
+
+// UpdateAvatar stores the avatar attachment; nil removes it.
+func (u *User) UpdateAvatar(db *gorm.DB) *gorm.DB {
+	return db.Model(&u).Select("AvatarID").Updates(u)
+}
+
+// UpdateLanguage stores the preferred language; an empty one is stored too.
+func (u *User) UpdateLanguage(db *gorm.DB) *gorm.DB {
+	return db.Model(&u).Select("Language").Updates(u)
+}
+
+// UpdateDeletion stores the scheduled deletion, nil when cancelled, and the
+// credentials, which a deletion request revokes.
+func (u *User) UpdateDeletion(db *gorm.DB) *gorm.DB {
+	return db.Model(&u).Select("DeleteAt", "AccessToken", "APIKey").Updates(u)
+}
+
+// Anonymize replaces everything stored about the user with the anonymous
+// account deleted-{id}, which cannot sign in. The ID stays, so posts,
+// comments and the audit log keep referring to it.
+func (u *User) Anonymize(db *gorm.DB) *gorm.DB {
+	*u = User{ID: u.ID, Login: "deleted-" + strconv.Itoa(u.ID), Name: "Deleted user", Role: RoleUser,
+		EmailNotifications: EmailOff, ProfileVisibility: ProfilePrivate}
+	return db.Model(&u).Select("Login", "Provider", "Name", "Role", "AccessToken", "APIKey", "Email", "EmailVerified", "EmailNotifications",
+		"DigestSentAt", "Language", "Bio", "AvatarID", "ProfileVisibility", "ShowEmail", "Phone", "Website", "Street",
+		"Suite", "City", "Zipcode", "Lat", "Lng", "CompanyName", "CatchPhrase", "BS", "DeleteAt").Updates(u)
+}
+
+func (u *User) UpdateDigestSentAt(db *gorm.DB) *gorm.DB {
+	return db.Model(&u).Select("DigestSentAt").Updates(u)
+}
+
+func ValidEmailPreference(p string) bool {
+	return p == EmailImmediate || p == EmailDigest || p == EmailOff
+}
+
+// HasRole reports whether the user's role is the given one or ranks above it,
+// so an admin passes every check a moderator does.
+func (u *User) HasRole(role string) bool {
+	if u.ID == 0 {
+		return false
+	}
+	own, ok := roleRank[u.Role]
+	if !ok {
+		own = roleRank[RoleUser]
+	}
+	return own >= roleRank[role]
+}
+
+func ValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
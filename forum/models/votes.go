@@ -0,0 +1,98 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Target types of votes and reactions.
+const (
+	TargetPost    = "post"
+	TargetComment = "comment"
+)
+
+var ErrInvalidVote = errors.New("vote value must be -1, 0 or 1")
+
+// HotOrder ranks rows of table by score decayed with age in hours, so that
+// fresh, well-voted entries come first.
+func HotOrder(table string) string {
+	return fmt.Sprintf("(%[1]s.score + 1) / POW(TIMESTAMPDIFF(MINUTE, %[1]s.createdAt, NOW()) / 60 + 2, 1.8) DESC", table)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type Vote struct {
+	UserID     int       `json:"userId" gorm:"column:userId;primaryKey"`
+	TargetType string    `json:"targetType" gorm:"column:targetType;type:VARCHAR(16);primaryKey"`
+	TargetID   int       `json:"targetId" gorm:"column:targetId;primaryKey;index"`
+	Value      int       `json:"value" gorm:"column:value"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"column:createdAt"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type VoteProcess struct{}
+
+func voteTable(targetType string) (interface{}, bool) {
+	switch targetType {
+	case TargetPost:
+		return &Post{}, true
+	case TargetComment:
+		return &Comment{}, true
+	}
+	return nil, false
+}
+
+// CastVote records v, replacing an earlier vote of the same user on the same
+// target; a zero value retracts it. The target's score is adjusted in the
+// same transaction and returned.
+func (vpr *VoteProcess) CastVote(db *gorm.DB, v *Vote) (int, *gorm.DB) {
+	if v.Value < -1 || v.Value > 1 {
+		return 0, &gorm.DB{Error: ErrInvalidVote}
+	}
+	model, ok := voteTable(v.TargetType)
+	if !ok {
+		return 0, &gorm.DB{Error: gorm.ErrInvalidValue}
+	}
+	var score int
+	var result *gorm.DB
+	db.Transaction(func(tx *gorm.DB) error {
+		old := Vote{}
+		result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("userId = ? AND targetType = ? AND targetId = ?", v.UserID, v.TargetType, v.TargetID).
+			Limit(1).Find(&old)
+		if result.Error != nil {
+			return result.Error
+		}
+		delta := v.Value - old.Value
+		switch {
+		case v.Value == 0:
+			result = tx.Where("userId = ? AND targetType = ? AND targetId = ?", v.UserID, v.TargetType, v.TargetID).Delete(&Vote{})
+		case old.Value == 0:
+			v.CreatedAt = time.Now()
+			result = tx.Create(v)
+		default:
+			result = tx.Model(&Vote{}).Where("userId = ? AND targetType = ? AND targetId = ?", v.UserID, v.TargetType, v.TargetID).
+				Update("value", v.Value)
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if delta != 0 {
+			result = tx.Model(model).Where("id = ?", v.TargetID).UpdateColumn("score", gorm.Expr("score + ?", delta))
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		result = tx.Model(model).Select("score").Where("id = ?", v.TargetID).Scan(&score)
+		return result.Error
+	})
+	return score, result
+}
+
+// DeleteVotes drops every vote on the given targets.
+func (vpr *VoteProcess) DeleteVotes(db *gorm.DB, targetType string, ids []int) *gorm.DB {
+	return db.Where("targetType = ? AND targetId IN ?", targetType, ids).Delete(&Vote{})
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+	DeliveryCancelled = "cancelled" //the webhook was deactivated before the delivery was sent
+)
+
+// WebhookEvents are the event types webhooks may subscribe to.
+var WebhookEvents = []string{"post.created", "post.updated", "post.deleted", "comment.created", "comment.updated", "comment.deleted"}
+
+var (
+	ErrInvalidWebhookURL   = errors.New("webhook URL must be an absolute http or https URL")
+	ErrInvalidWebhookEvent = errors.New("unknown webhook event")
+)
+
+type Webhooks struct { //structure for response array of webhooks in xml format
+	XMLName  xml.Name  `xml:"webhooks" json:"-" gorm:"-"`
+	Webhooks []Webhook `xml:"webhook"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+// Webhook delivers the events it subscribes to, signed with Secret, to URL.
+type Webhook struct {
+	XMLName    xml.Name          `xml:"webhook" json:"-" gorm:"-"`
+	ID         int               `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	URL        string            `json:"url" xml:"url" gorm:"column:url;type:VARCHAR(2048)"`
+	Secret     string            `json:"secret,omitempty" xml:"-" gorm:"column:secret;type:VARCHAR(256)"`
+	EventList  string            `json:"-" xml:"-" gorm:"column:events;type:VARCHAR(512)"`
+	Events     []string          `json:"events" xml:"events>event" gorm:"-"`
+	Active     bool              `json:"active" xml:"active" gorm:"column:active;default:true"`
+	CreatedAt  time.Time         `json:"createdAt" xml:"createdAt" gorm:"column:createdAt"`
+	Deliveries []WebhookDelivery `xml:"-" json:"-" gorm:"foreignKey:WebhookID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+func (wh *Webhook) AfterFind(tx *gorm.DB) error {
+	wh.Events = nil
+	if wh.EventList != "" {
+		wh.Events = strings.Split(wh.EventList, ",")
+	}
+	return nil
+}
+
+// Subscribes reports whether the webhook delivers events of type typ.
+func (wh *Webhook) Subscribes(typ string) bool {
+	for _, e := range wh.Events {
+		if e == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the URL and the events and normalizes the event list; no
+// events mean all of them.
+func (wh *Webhook) validate() error {
+	u, err := url.Parse(wh.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	if len(wh.Events) == 0 {
+		wh.Events = append([]string(nil), WebhookEvents...)
+	}
+	seen := make(map[string]bool)
+	events := []string{}
+	for _, e := range wh.Events {
+		e = strings.TrimSpace(e)
+		valid := false
+		for _, known := range WebhookEvents {
+			valid = valid || e == known
+		}
+		if !valid {
+			return ErrInvalidWebhookEvent
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	wh.Events = events
+	wh.EventList = strings.Join(events, ",")
+	return nil
+}
+
+type WebhookDeliveries struct { //structure for response array of deliveries in xml format
+	XMLName    xml.Name          `xml:"deliveries" json:"-" gorm:"-"`
+	Deliveries []WebhookDelivery `xml:"delivery"`
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook, with the
+// outcome of the last attempt.
+type WebhookDelivery struct {
+	XMLName       xml.Name   `xml:"delivery" json:"-" gorm:"-"`
+	ID            int        `json:"id" xml:"id" gorm:"column:id;primaryKey"`
+	WebhookID     int        `json:"webhookId" xml:"webhookId" gorm:"column:webhookId;index"`
+	Event         string     `json:"event" xml:"event" gorm:"column:event;type:VARCHAR(32)"`
+	Payload       string     `json:"payload" xml:"payload" gorm:"column:payload;type:MEDIUMTEXT"`
+	Status        string     `json:"status" xml:"status" gorm:"column:status;type:VARCHAR(16);default:pending;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts      int        `json:"attempts" xml:"attempts" gorm:"column:attempts;default:0"`
+	ResponseCode  int        `json:"responseCode,omitempty" xml:"responseCode,omitempty" gorm:"column:responseCode"`
+	Error         string     `json:"error,omitempty" xml:"error,omitempty" gorm:"column:error;type:VARCHAR(512)"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty" xml:"nextAttemptAt,omitempty" gorm:"column:nextAttemptAt;index:idx_webhook_deliveries_due,priority:2"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty" xml:"deliveredAt,omitempty" gorm:"column:deliveredAt"`
+	CreatedAt     time.Time  `json:"createdAt" xml:"createdAt" gorm:"column:createdAt"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////
+type WebhookProcess struct{}
+
+func (wpr *WebhookProcess) GetWebhook(db *gorm.DB, param map[string]interface{}) (Webhook, *gorm.DB) {
+	wh := Webhook{}
+	tx := db.Where(param).First(&wh)
+	return wh, tx
+}
+
+func (wpr *WebhookProcess) ListWebhooks(db *gorm.DB, param map[string]interface{}) ([]Webhook, *gorm.DB) {
+	whs := []Webhook{}
+	tx := db.Where(param).Order("id").Find(&whs)
+	return whs, tx
+}
+
+func (wpr *WebhookProcess) CreateWebhook(db *gorm.DB, wh *Webhook) *gorm.DB {
+	if err := wh.validate(); err != nil {
+		return &gorm.DB{Error: err}
+	}
+	wh.CreatedAt = time.Now()
+	return db.Select("URL", "Secret", "EventList", "Active", "CreatedAt").Create(wh)
+}
+
+// UpdateWebhook replaces the URL, events and active flag of the webhook; the
+// secret is changed only if a new one is given.
+func (wpr *WebhookProcess) UpdateWebhook(db *gorm.DB, wh *Webhook) *gorm.DB {
+	if err := wh.validate(); err != nil {
+		return &gorm.DB{Error: err}
+	}
+	fields := []string{"URL", "EventList", "Active"}
+	if wh.Secret != "" {
+		fields = append(fields, "Secret")
+	}
+	return db.Model(wh).Select(fields).Updates(wh)
+}
+
+func (wpr *WebhookProcess) DeleteWebhook(db *gorm.DB, wh *Webhook) *gorm.DB {
+	return db.Delete(wh)
+}
+
+func (wpr *WebhookProcess) GetDelivery(db *gorm.DB, param map[string]interface{}) (WebhookDelivery, *gorm.DB) {
+	d := WebhookDelivery{}
+	tx := db.Where(param).First(&d)
+	return d, tx
+}
+
+// ListDeliveries returns the deliveries of webhook webhookID, newest first.
+func (wpr *WebhookProcess) ListDeliveries(db *gorm.DB, webhookID, limit, offset int) ([]WebhookDelivery, *gorm.DB) {
+	dd := []WebhookDelivery{}
+	tx := db.Where("webhookId = ?", webhookID).Order("id DESC").Limit(limit).Offset(offset).Find(&dd)
+	return dd, tx
+}
+
+// CreateDeliveries queues deliveries to be attempted right away.
+func (wpr *WebhookProcess) CreateDeliveries(db *gorm.DB, dd []WebhookDelivery) *gorm.DB {
+	if len(dd) == 0 {
+		return db
+	}
+	now := time.Now()
+	for i := range dd {
+		dd[i].Status, dd[i].Attempts, dd[i].CreatedAt = DeliveryPending, 0, now
+		dd[i].NextAttemptAt = &now
+	}
+	return db.Select("WebhookID", "Event", "Payload", "Status", "Attempts", "NextAttemptAt", "CreatedAt").Create(&dd)
+}
+
+// DueDeliveries returns up to limit pending deliveries whose next attempt is
+// due, oldest first.
+func (wpr *WebhookProcess) DueDeliveries(db *gorm.DB, now time.Time, limit int) ([]WebhookDelivery, *gorm.DB) {
+	dd := []WebhookDelivery{}
+	tx := db.Where("status = ? AND nextAttemptAt <= ?", DeliveryPending, now).Order("nextAttemptAt, id").Limit(limit).Find(&dd)
+	return dd, tx
+}
+
+// SaveAttempt stores the outcome of an attempt to deliver d.
+func (wpr *WebhookProcess) SaveAttempt(db *gorm.DB, d *WebhookDelivery) *gorm.DB {
+	return db.Model(d).Select("Status", "Attempts", "ResponseCode", "Error", "NextAttemptAt", "DeliveredAt").Updates(d)
+}
@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"log"
+	"nx_trainee_forum/forum/events"
+	"nx_trainee_forum/forum/models"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of events. The lifecycle of posts and comments is published on the
+// event bus under the same names.
+const (
+	PostCreated    = events.PostCreated
+	PostUpdated    = events.PostUpdated
+	PostDeleted    = events.PostDeleted
+	CommentCreated = events.CommentCreated
+	CommentUpdated = events.CommentUpdated
+	CommentDeleted = events.CommentDeleted
+	AnswerAccepted = "answer.accepted"
+	Moderation     = "moderation"
+)
+
+// Event is something a user did that may concern other users.
+type Event struct {
+	Kind       string
+	ActorID    int
+	PostID     int
+	CommentID  *int
+	CategoryID *int
+	// UserID is the user a moderation action was taken against.
+	UserID  int
+	Details string
+	// Mentions are the mentions the post or comment gained.
+	Mentions []models.Mention
+}
+
+// Notifier turns events into notifications in the background, so that the
+// requests publishing them do not wait for the fan-out. Events and the
+// notifications made of them are then published on the bus.
+type Notifier struct {
+	db     *gorm.DB
+	bus    *events.Bus
+	events chan Event
+	wg     sync.WaitGroup
+}
+
+// New starts the notifier; once queue events are waiting, Publish drops
+// further ones.
+func New(db *gorm.DB, bus *events.Bus, queue int) *Notifier {
+	n := &Notifier{db: db, bus: bus, events: make(chan Event, queue)}
+	n.wg.Add(1)
+	go n.work()
+	return n
+}
+
+// Publish queues e without waiting, so that a busy notifier never slows the
+// request down; if the queue is full, e is dropped and logged.
+func (n *Notifier) Publish(e Event) {
+	select {
+	case n.events <- e:
+	default:
+		log.Printf("notify: queue full, dropped %s of post %d by user %d", e.Kind, e.PostID, e.ActorID)
+	}
+}
+
+// Close waits for published events to be handled and stops the notifier.
+func (n *Notifier) Close() {
+	close(n.events)
+	n.wg.Wait()
+}
+
+func (n *Notifier) work() {
+	defer n.wg.Done()
+	npr := models.NotificationProcess{}
+	for e := range n.events {
+		nn, err := n.notifications(e)
+		if err == nil {
+			err = npr.CreateNotifications(n.db, nn).Error
+		}
+		if err != nil {
+			log.Printf("notify: %s: %v", e.Kind, err)
+			nn = nil
+		}
+		n.broadcast(e)
+		for _, nt := range nn {
+			n.bus.Publish(events.Event{Type: events.Notification, ActorID: nt.ActorID, PostID: e.PostID,
+				CommentID: nt.CommentID, UserID: nt.UserID, Data: nt})
+		}
+	}
+}
+
+// broadcast publishes the change of a post or comment e stands for.
+func (n *Notifier) broadcast(e Event) {
+	typ := e.Kind
+	switch e.Kind {
+	case PostCreated, PostUpdated, PostDeleted, CommentCreated, CommentUpdated, CommentDeleted:
+	case AnswerAccepted:
+		typ, e.CommentID = PostUpdated, nil
+	default:
+		return
+	}
+	if e.CategoryID == nil && e.Kind != PostDeleted {
+		p := models.Post{}
+		if err := n.db.Select("categoryId").Where("id = ?", e.PostID).Take(&p).Error; err != nil {
+			return // deleted meanwhile
+		}
+		e.CategoryID = p.CategoryID
+	}
+	n.bus.Publish(events.Event{Type: typ, ActorID: e.ActorID, PostID: e.PostID, CommentID: e.CommentID, CategoryID: e.CategoryID})
+}
+
+// notifications resolves who is notified of e. Every user gets at most one
+// notification per event and never one of their own actions; a reply wins
+// over a mention, which wins over a comment on the user's post.
+func (n *Notifier) notifications(e Event) ([]models.Notification, error) {
+	var postID *int
+	if e.PostID != 0 {
+		id := e.PostID
+		postID = &id
+	}
+	nn := []models.Notification{}
+	seen := map[int]bool{e.ActorID: true}
+	add := func(userID int, typ string) {
+		if userID == 0 || seen[userID] {
+			return
+		}
+		seen[userID] = true
+		nn = append(nn, models.Notification{UserID: userID, Type: typ, ActorID: e.ActorID,
+			PostID: postID, CommentID: e.CommentID, Details: e.Details})
+	}
+	switch e.Kind {
+	case CommentCreated:
+		cpr := models.CommentProcess{}
+		c, result := cpr.GetComment(n.db, map[string]interface{}{"id": *e.CommentID})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if c.ParentID != nil {
+			parent, result := cpr.GetComment(n.db, map[string]interface{}{"id": *c.ParentID})
+			if result.Error == nil && !parent.Deleted {
+				add(parent.UserID, models.NotificationReply)
+			}
+		}
+		for _, m := range e.Mentions {
+			add(m.UserID, models.NotificationMention)
+		}
+		ppr := models.PostProcess{}
+		p, result := ppr.GetPost(n.db, map[string]interface{}{"id": c.PostID})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		add(p.UserID, models.NotificationComment)
+	case PostCreated, PostUpdated, CommentUpdated:
+		for _, m := range e.Mentions {
+			add(m.UserID, models.NotificationMention)
+		}
+	case AnswerAccepted:
+		cpr := models.CommentProcess{}
+		c, result := cpr.GetComment(n.db, map[string]interface{}{"id": *e.CommentID})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		add(c.UserID, models.NotificationAnswer)
+	case Moderation:
+		add(e.UserID, models.NotificationModeration)
+	}
+	return nn, nil
+}
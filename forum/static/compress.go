@@ -0,0 +1,43 @@
+//go:build ignore
+
+// compress writes a gzip copy next to every text file under the embedded
+// directories, for the assets server to send as is. Run by go generate.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var compressible = map[string]bool{".css": true, ".js": true, ".map": true, ".svg": true, ".json": true, ".txt": true, ".html": true}
+
+func main() {
+	for _, dir := range []string{"bootstrap", "css", "image", "js"} {
+		err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !compressible[filepath.Ext(name)] {
+				return err
+			}
+			content, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			var b bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&b, gzip.BestCompression) //no name or time, so output is reproducible
+			zw.Write(content)
+			if err = zw.Close(); err != nil {
+				return err
+			}
+			if b.Len() >= len(content) {
+				return os.RemoveAll(name + ".gz")
+			}
+			return os.WriteFile(name+".gz", b.Bytes(), 0644)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
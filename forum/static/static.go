@@ -0,0 +1,11 @@
+package static
+
+import "embed"
+
+// FS holds the files served under /public: Bootstrap, scripts, stylesheets
+// and images, with the gzip copies of text files made by go generate.
+//
+//go:embed bootstrap css image js
+var FS embed.FS
+
+//go:generate go run compress.go
@@ -0,0 +1,8 @@
+package templates
+
+import "embed"
+
+// FS holds the layouts, partials and pages of the web UI.
+//
+//go:embed *.html layouts partials
+var FS embed.FS
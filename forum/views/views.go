@@ -0,0 +1,186 @@
+package views
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"nx_trainee_forum/forum/markdown"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Manager renders the pages of the web UI. The templates file system holds
+// layouts in layouts/, partials shared by pages in partials/ and one file per
+// page; every page is executed through the template "layout" with the
+// layouts and partials parsed along. Templates are parsed once, or again
+// whenever a file changes in dev mode.
+type Manager struct {
+	fsys  fs.FS
+	dev   bool
+	funcs template.FuncMap
+
+	mu      sync.RWMutex
+	pages   map[string]*template.Template
+	version string //modification times of the parsed files, in dev mode
+}
+
+// New parses the templates of fsys. funcs are added to Funcs.
+func New(fsys fs.FS, dev bool, funcs template.FuncMap) (*Manager, error) {
+	m := &Manager{fsys: fsys, dev: dev, funcs: funcs}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Funcs are available to every template.
+var Funcs = template.FuncMap{
+	"safe":      func(s string) template.HTML { return template.HTML(s) }, //HTML already sanitized by the model layer
+	"markdown":  func(s string) template.HTML { return template.HTML(markdown.Render(s)) },
+	"date":      func(t time.Time, layout string) string { return t.UTC().Format(layout) },
+	"datetime":  func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04") },
+	"pluralize": pluralize,
+	"inc":       func(i int) int { return i + 1 },
+	"dec":       func(i int) int { return i - 1 },
+	"deref": func(i *int) int {
+		if i == nil {
+			return 0
+		}
+		return *i
+	},
+	"join": strings.Join,
+}
+
+// pluralize returns the count n with the singular or plural noun, as in
+// "1 post" or "3 posts".
+func pluralize(n interface{}, singular, plural string) (string, error) {
+	v := reflect.ValueOf(n)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Int() == 1 || v.Int() == -1 {
+			return fmt.Sprintf("%d %s", v.Int(), singular), nil
+		}
+		return fmt.Sprintf("%d %s", v.Int(), plural), nil
+	}
+	return "", fmt.Errorf("pluralize: %T is not an integer", n)
+}
+
+func (m *Manager) load() error {
+	shared := []string{}
+	for _, pattern := range []string{"layouts/*.html", "partials/*.html"} {
+		ff, err := fs.Glob(m.fsys, pattern)
+		if err != nil {
+			return err
+		}
+		shared = append(shared, ff...)
+	}
+	if len(shared) == 0 {
+		return errors.New("views: no layouts")
+	}
+	base, err := template.New("").Funcs(Funcs).Funcs(m.funcs).ParseFS(m.fsys, shared...)
+	if err != nil {
+		return err
+	}
+	ff, err := fs.Glob(m.fsys, "*.html")
+	if err != nil {
+		return err
+	}
+	pages := make(map[string]*template.Template, len(ff))
+	for _, f := range ff {
+		t, err := template.Must(base.Clone()).ParseFS(m.fsys, f)
+		if err != nil {
+			return err
+		}
+		pages[strings.TrimSuffix(f, ".html")] = t
+	}
+	version, err := m.modified()
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.pages, m.version = pages, version
+	m.mu.Unlock()
+	return nil
+}
+
+// modified sums up the names and modification times of the template files,
+// so it changes when one is edited, added or removed.
+func (m *Manager) modified() (string, error) {
+	if !m.dev {
+		return "", nil
+	}
+	var b strings.Builder
+	err := fs.WalkDir(m.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || path.Ext(name) != ".html" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s %d\n", name, info.ModTime().UnixNano())
+		return nil
+	})
+	return b.String(), err
+}
+
+// reload parses the templates again if a file changed since the last parse.
+// A template that fails to parse keeps the previous ones in use.
+func (m *Manager) reload() {
+	version, err := m.modified()
+	m.mu.RLock()
+	changed := version != m.version
+	m.mu.RUnlock()
+	if err == nil && changed {
+		err = m.load()
+	}
+	if err != nil {
+		log.Printf("views: %v", err)
+	}
+}
+
+// Render writes page name with status. If the page does not exist or fails
+// to execute, the error is logged and the 500 page is written instead.
+func (m *Manager) Render(w http.ResponseWriter, status int, name string, data interface{}) {
+	if m.dev {
+		m.reload()
+	}
+	m.mu.RLock()
+	t, ok := m.pages[name]
+	m.mu.RUnlock()
+	var b bytes.Buffer
+	err := fmt.Errorf("views: no page %q", name)
+	if ok {
+		err = t.ExecuteTemplate(&b, "layout", data)
+	}
+	if err != nil {
+		log.Printf("views: %s: %v", name, err)
+		m.internalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+}
+
+// internalError writes the 500 page, or plain text if even that fails.
+func (m *Manager) internalError(w http.ResponseWriter) {
+	m.mu.RLock()
+	t, ok := m.pages["500"]
+	m.mu.RUnlock()
+	var b bytes.Buffer
+	if !ok || t.ExecuteTemplate(&b, "layout", nil) != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,282 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"nx_trainee_forum/forum/events"
+	"nx_trainee_forum/forum/models"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the request
+// body keyed with the webhook secret.
+const SignatureHeader = "X-Forum-Signature-256"
+
+// pollInterval is how often due retries are looked for.
+const pollInterval = time.Second
+
+// maxBackoff bounds the wait between two attempts however many there are.
+const maxBackoff = 24 * time.Hour
+
+// Config tunes the deliveries.
+type Config struct {
+	MaxAttempts int
+	Backoff     time.Duration //wait before the second attempt, doubled after every failure
+	Timeout     time.Duration
+}
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Event      string          `json:"event"`
+	EventID    string          `json:"eventId"`
+	Time       time.Time       `json:"time"`
+	ActorID    int             `json:"actorId,omitempty"`
+	PostID     int             `json:"postId,omitempty"`
+	CommentID  *int            `json:"commentId,omitempty"`
+	CategoryID *int            `json:"categoryId,omitempty"`
+	Post       *models.Post    `json:"post,omitempty"`
+	Comment    *models.Comment `json:"comment,omitempty"`
+}
+
+// Sign returns the signature of body with secret as sent in SignatureHeader.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher queues deliveries for post and comment events in the database
+// and sends them in the background, retrying failed ones with exponential
+// backoff. Queued deliveries survive restarts.
+type Dispatcher struct {
+	db     *gorm.DB
+	cfg    Config
+	client *http.Client
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New(db *gorm.DB, cfg Config) *Dispatcher {
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	d := &Dispatcher{
+		db:     db,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	d.wg.Add(1)
+	go d.work()
+	return d
+}
+
+// Close stops sending; pending deliveries are sent after the next start.
+func (d *Dispatcher) Close() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// Wake makes the dispatcher look for due deliveries now.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue queues deliveries of e to the active webhooks subscribing to it. It
+// is meant to listen on the event bus.
+func (d *Dispatcher) Enqueue(e events.Event) {
+	wpr := models.WebhookProcess{}
+	whs, result := wpr.ListWebhooks(d.db, map[string]interface{}{"active": true})
+	if result.Error != nil {
+		log.Printf("webhooks: %s: %v", e.Type, result.Error)
+		return
+	}
+	dd := []models.WebhookDelivery{}
+	var body []byte
+	for _, wh := range whs {
+		if !wh.Subscribes(e.Type) {
+			continue
+		}
+		if body == nil {
+			body, _ = json.Marshal(d.payload(e))
+		}
+		dd = append(dd, models.WebhookDelivery{WebhookID: wh.ID, Event: e.Type, Payload: string(body)})
+	}
+	if result = wpr.CreateDeliveries(d.db, dd); result.Error != nil {
+		log.Printf("webhooks: %s: %v", e.Type, result.Error)
+		return
+	}
+	if len(dd) > 0 {
+		d.Wake()
+	}
+}
+
+// payload describes e together with the post or comment as it is now.
+func (d *Dispatcher) payload(e events.Event) Payload {
+	p := Payload{Event: e.Type, EventID: e.ID, Time: time.Now().UTC(), ActorID: e.ActorID, PostID: e.PostID,
+		CommentID: e.CommentID, CategoryID: e.CategoryID}
+	switch e.Type {
+	case events.PostCreated, events.PostUpdated:
+		ppr := models.PostProcess{}
+		if post, result := ppr.GetPost(d.db, map[string]interface{}{"id": e.PostID}); result.Error == nil {
+			p.Post = &post
+		}
+	case events.CommentCreated, events.CommentUpdated:
+		cpr := models.CommentProcess{}
+		if c, result := cpr.GetComment(d.db, map[string]interface{}{"id": *e.CommentID}); result.Error == nil {
+			p.Comment = &c
+		}
+	}
+	return p
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+		d.sendDue()
+	}
+}
+
+// sendDue sends the deliveries whose attempt is due.
+func (d *Dispatcher) sendDue() {
+	wpr := models.WebhookProcess{}
+	for {
+		dd, result := wpr.DueDeliveries(d.db, time.Now(), 20)
+		if result.Error != nil {
+			log.Printf("webhooks: %v", result.Error)
+			return
+		}
+		for i := range dd {
+			select {
+			case <-d.stop:
+				return
+			default:
+			}
+			d.attempt(&dd[i])
+		}
+		if len(dd) < 20 {
+			return
+		}
+	}
+}
+
+// attempt sends delivery dl once and records the outcome.
+func (d *Dispatcher) attempt(dl *models.WebhookDelivery) {
+	wpr := models.WebhookProcess{}
+	wh, result := wpr.GetWebhook(d.db, map[string]interface{}{"id": dl.WebhookID})
+	if result.Error != nil {
+		return // deleted together with its deliveries
+	}
+	if !wh.Active {
+		dl.Status, dl.NextAttemptAt = models.DeliveryCancelled, nil
+		if result = wpr.SaveAttempt(d.db, dl); result.Error != nil {
+			log.Printf("webhooks: delivery %d: %v", dl.ID, result.Error)
+		}
+		return
+	}
+	dl.Attempts++
+	dl.ResponseCode, dl.Error = 0, ""
+	code, err := d.send(&wh, dl)
+	now := time.Now()
+	dl.ResponseCode = code
+	switch {
+	case err == nil:
+		dl.Status, dl.NextAttemptAt, dl.DeliveredAt = models.DeliverySucceeded, nil, &now
+	case dl.Attempts >= d.cfg.MaxAttempts:
+		dl.Status, dl.NextAttemptAt = models.DeliveryFailed, nil
+	default:
+		next := now.Add(backoff(d.cfg.Backoff, dl.Attempts))
+		dl.NextAttemptAt = &next
+	}
+	if err != nil {
+		dl.Error = err.Error()
+		if len(dl.Error) > 512 {
+			dl.Error = dl.Error[:512]
+		}
+	}
+	if result = wpr.SaveAttempt(d.db, dl); result.Error != nil {
+		log.Printf("webhooks: delivery %d: %v", dl.ID, result.Error)
+	}
+}
+
+// backoff returns the wait after attempt number attempts failed: base,
+// doubled after every further attempt, up to maxBackoff.
+func backoff(base time.Duration, attempts int) time.Duration {
+	wait := base
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait
+}
+
+// send posts the payload of dl to the webhook. Any status but 2xx fails.
+func (d *Dispatcher) send(wh *models.Webhook, dl *models.WebhookDelivery) (int, error) {
+	body := []byte(dl.Payload)
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "nx_trainee_forum-webhooks")
+	req.Header.Set("X-Forum-Event", dl.Event)
+	req.Header.Set("X-Forum-Delivery", strconv.Itoa(dl.ID))
+	req.Header.Set(SignatureHeader, Sign(wh.Secret, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// GenerateSecret returns a random secret for a webhook created without one.
+func GenerateSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Redeliver queues dl to be sent again as a new delivery.
+func (d *Dispatcher) Redeliver(dl models.WebhookDelivery) (models.WebhookDelivery, error) {
+	wpr := models.WebhookProcess{}
+	dd := []models.WebhookDelivery{{WebhookID: dl.WebhookID, Event: dl.Event, Payload: dl.Payload}}
+	if result := wpr.CreateDeliveries(d.db, dd); result.Error != nil {
+		return models.WebhookDelivery{}, result.Error
+	}
+	d.Wake()
+	return dd[0], nil
+}